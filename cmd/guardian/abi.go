@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/end-r/guardian/ast"
+)
+
+// describer is implemented by VMs which can describe a contract's external
+// interface, such as the ethereum ABI
+type describer interface {
+	ABI(*ast.ContractDeclarationNode) ([]byte, error)
+}
+
+func runABI(args []string) int {
+	fs := flag.NewFlagSet("abi", flag.ExitOnError)
+	vmName := fs.String("vm", "evm", "target virtual machine ("+vmNames()+")")
+	out := fs.String("o", "", "write the interface to this file rather than stdout")
+	fs.Parse(args)
+
+	vm, pkg, code := loadPackage(fs, *vmName, false)
+	if code != 0 {
+		return code
+	}
+	d, ok := vm.(describer)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "guardian abi: vm %q does not describe contract interfaces\n", *vmName)
+		return 2
+	}
+	// interfaces are keyed by contract name
+	abis := make(map[string]json.RawMessage)
+	for _, scope := range pkg.Scopes() {
+		for _, c := range findContracts(scope) {
+			data, err := d.ABI(c)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "guardian abi: %s: %s\n", c.Identifier, err)
+				return 1
+			}
+			abis[c.Identifier] = data
+		}
+	}
+	return writeJSON("abi", abis, *out)
+}
+
+// writeJSON writes an indented JSON report to a file, or to stdout if no
+// file is given
+func writeJSON(command string, v interface{}, out string) int {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "guardian %s: %s\n", command, err)
+		return 1
+	}
+	data = append(data, '\n')
+	if out == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := ioutil.WriteFile(out, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "guardian: %s\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/end-r/guardian"
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/util"
+	"github.com/end-r/guardian/validator"
+	"github.com/end-r/vmgen"
+)
+
+// assembler is implemented by VMs which can lower their bytecode to raw bytes
+type assembler interface {
+	Assemble(vmgen.Bytecode) ([]byte, util.Errors)
+}
+
+// optimizer is implemented by VMs which can optimize the code they generate,
+// at levels from zero, which leaves it as it is
+type optimizer interface {
+	Optimized(level int) validator.VM
+}
+
+// compiler is implemented by VMs which generate separate creation and runtime
+// code for each contract
+type compiler interface {
+	Compile(n *ast.ContractDeclarationNode, runtime bool) ([]byte, util.Errors)
+}
+
+func runBuild(args []string) int {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	vmName := fs.String("vm", "evm", "target virtual machine ("+vmNames()+")")
+	out := fs.String("o", "", "write bytecode to this file rather than stdout")
+	runtime := fs.Bool("runtime", false, "output the runtime code of each contract rather than its creation code")
+	level := fs.Int("O", 0, optimizationUsage)
+	fs.Parse(args)
+
+	vm, pkg, code := loadPackage(fs, *vmName, false)
+	if code != 0 {
+		return code
+	}
+	if vm, code = optimize(fs, vm, *level); code != 0 {
+		return code
+	}
+	// each contract is output on its own line, prefixed by its name
+	var output string
+	if c, ok := vm.(compiler); ok {
+		for _, scope := range pkg.Scopes() {
+			for _, contract := range findContracts(scope) {
+				raw, errs := c.Compile(contract, *runtime)
+				if errs != nil {
+					return report(errs.WithStage(util.Generation))
+				}
+				output += fmt.Sprintf("%s: %s\n", contract.Identifier, hex.EncodeToString(raw))
+			}
+		}
+	} else {
+		for _, scope := range pkg.Scopes() {
+			for _, contract := range findContracts(scope) {
+				bytecode, errs := vm.Traverse(contract)
+				if errs != nil {
+					return report(errs.WithStage(util.Generation))
+				}
+				generated := bytecode.Format()
+				if a, ok := vm.(assembler); ok {
+					raw, errs := a.Assemble(bytecode)
+					if errs != nil {
+						return report(errs.WithStage(util.Generation))
+					}
+					generated = hex.EncodeToString(raw)
+				}
+				output += fmt.Sprintf("%s: %s\n", contract.Identifier, generated)
+			}
+		}
+	}
+	if *out == "" {
+		fmt.Print(output)
+		return 0
+	}
+	if err := ioutil.WriteFile(*out, []byte(output), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "guardian: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func findContracts(scope *ast.ScopeNode) []*ast.ContractDeclarationNode {
+	var contracts []*ast.ContractDeclarationNode
+	if scope == nil || scope.Declarations == nil {
+		return contracts
+	}
+	for _, d := range scope.Declarations.Array() {
+		if c, ok := d.(*ast.ContractDeclarationNode); ok {
+			contracts = append(contracts, c)
+		}
+	}
+	return contracts
+}
+
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	vmName := fs.String("vm", "evm", "target virtual machine ("+vmNames()+")")
+	fs.Parse(args)
+
+	_, _, code := loadPackage(fs, *vmName, false)
+	return code
+}
+
+// loadPackage parses and validates the package directory named by the
+// flag set's only argument, returning a non-zero exit code on failure
+// test files are only part of the package when it is loaded for tests
+func loadPackage(fs *flag.FlagSet, vmName string, tests bool) (validator.VM, *validator.TypeScope, int) {
+	if fs.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "guardian %s: expected a single package directory\n", fs.Name())
+		return nil, nil, 2
+	}
+	dir := "."
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+	vm, err := resolveVM(vmName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "guardian %s: %s\n", fs.Name(), err)
+		return nil, nil, 2
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "guardian %s: %s is not a directory\n", fs.Name(), dir)
+		return nil, nil, 1
+	}
+	pkg, errs := validator.ValidatePackageFiles(vm, dir, func(name string) bool {
+		return tests || !strings.HasSuffix(name, testSuffix)
+	})
+	if errs != nil {
+		return nil, nil, report(errs)
+	}
+	if p, ok := vm.(guardian.Packager); ok {
+		vm = p.InPackage(pkg.Scopes())
+	}
+	return vm, pkg, 0
+}
+
+const optimizationUsage = "optimization level, from 0 (none) to 4 (every pass)"
+
+// optimize returns a VM which optimizes the code it generates at a level, if
+// the level isn't zero
+func optimize(fs *flag.FlagSet, vm validator.VM, level int) (validator.VM, int) {
+	if level == 0 {
+		return vm, 0
+	}
+	if level < 0 {
+		fmt.Fprintf(os.Stderr, "guardian %s: invalid optimization level %d\n", fs.Name(), level)
+		return nil, 2
+	}
+	o, ok := vm.(optimizer)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "guardian %s: vm does not optimize code\n", fs.Name())
+		return nil, 2
+	}
+	return o.Optimized(level), 0
+}
+
+// report prints errors grouped under the stage which produced them
+func report(errs util.Errors) int {
+	for _, stage := range []util.Stage{util.Lexing, util.Parsing, util.Validation, util.Generation} {
+		if staged := errs.FromStage(stage); staged != nil {
+			fmt.Fprintf(os.Stderr, "%s failed:\n%s", stage, staged.Format())
+		}
+	}
+	return 1
+}
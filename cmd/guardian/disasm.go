@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/util"
+)
+
+// disassembler is implemented by VMs which can list the instructions of raw
+// bytecode
+type disassembler interface {
+	Disassembly(raw []byte) string
+}
+
+// lister is implemented by VMs which can list the code generated for a
+// contract alongside the source lines it was generated from
+type lister interface {
+	Listing(n *ast.ContractDeclarationNode, runtime bool, files map[string][]byte) (string, util.Errors)
+}
+
+func runDisasm(args []string) int {
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	vmName := fs.String("vm", "evm", "target virtual machine ("+vmNames()+")")
+	out := fs.String("o", "", "write the listing to this file rather than stdout")
+	runtime := fs.Bool("runtime", false, "list the runtime code of each contract rather than its creation code")
+	code := fs.String("code", "", "disassemble this hex-encoded bytecode rather than a package directory")
+	level := fs.Int("O", 0, optimizationUsage)
+	fs.Parse(args)
+
+	var output string
+	if *code != "" {
+		vm, err := resolveVM(*vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "guardian disasm: %s\n", err)
+			return 2
+		}
+		d, ok := vm.(disassembler)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "guardian disasm: vm %q does not disassemble bytecode\n", *vmName)
+			return 2
+		}
+		raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(*code), "0x"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "guardian disasm: %s\n", err)
+			return 1
+		}
+		output = d.Disassembly(raw)
+	} else {
+		vm, pkg, status := loadPackage(fs, *vmName, false)
+		if status != 0 {
+			return status
+		}
+		if vm, status = optimize(fs, vm, *level); status != 0 {
+			return status
+		}
+		l, ok := vm.(lister)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "guardian disasm: vm %q does not list contracts\n", *vmName)
+			return 2
+		}
+		// each contract is listed after its name
+		for _, scope := range pkg.Scopes() {
+			for _, contract := range findContracts(scope) {
+				listing, errs := l.Listing(contract, *runtime, nil)
+				if errs != nil {
+					return report(errs.WithStage(util.Generation))
+				}
+				output += fmt.Sprintf("%s:\n%s", contract.Identifier, listing)
+			}
+		}
+	}
+	if *out == "" {
+		fmt.Print(output)
+		return 0
+	}
+	if err := ioutil.WriteFile(*out, []byte(output), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "guardian: %s\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/end-r/guardian/format"
+)
+
+func runFmt(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the result to the source file rather than stdout")
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	code := 0
+	for _, path := range paths {
+		files, err := sourceFiles(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "guardian fmt: %s\n", err)
+			code = 1
+			continue
+		}
+		for _, file := range files {
+			if !formatFile(file, *write) {
+				code = 1
+			}
+		}
+	}
+	return code
+}
+
+// sourceFiles expands a directory into the guardian files it contains
+func sourceFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	names, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, n := range names {
+		if !n.IsDir() && strings.HasSuffix(n.Name(), ".grd") {
+			files = append(files, filepath.Join(path, n.Name()))
+		}
+	}
+	return files, nil
+}
+
+func formatFile(file string, write bool) bool {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "guardian fmt: %s\n", err)
+		return false
+	}
+	out, errs := format.Bytes(src)
+	if errs != nil {
+		for i := range errs {
+			errs[i].Location.Filename = file
+		}
+		fmt.Fprint(os.Stderr, errs.Format())
+		return false
+	}
+	if !write {
+		os.Stdout.Write(out)
+		return true
+	}
+	if bytes.Equal(src, out) {
+		return true
+	}
+	if err := ioutil.WriteFile(file, out, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "guardian fmt: %s\n", err)
+		return false
+	}
+	return true
+}
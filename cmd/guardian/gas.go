@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
+)
+
+// gasEstimator is implemented by VMs which can estimate the gas used by each
+// externally callable function of a contract
+type gasEstimator interface {
+	GasEstimates(*ast.ContractDeclarationNode) ([]byte, error)
+}
+
+// gasChecker is implemented by VMs which can check whether a contract's
+// functions use more gas than the estimates of a previous version
+type gasChecker interface {
+	CheckGasEstimates([]byte, *ast.ContractDeclarationNode) ([]string, error)
+}
+
+func runGas(args []string) int {
+	fs := flag.NewFlagSet("gas", flag.ExitOnError)
+	vmName := fs.String("vm", "evm", "target virtual machine ("+vmNames()+")")
+	out := fs.String("o", "", "write the estimates to this file rather than stdout")
+	check := fs.String("check", "", "check the estimates against a previous estimates file rather than writing them")
+	level := fs.Int("O", 0, optimizationUsage)
+	fs.Parse(args)
+
+	vm, pkg, code := loadPackage(fs, *vmName, false)
+	if code != 0 {
+		return code
+	}
+	if vm, code = optimize(fs, vm, *level); code != 0 {
+		return code
+	}
+	if *check != "" {
+		return checkGasEstimates(vm, pkg, *check)
+	}
+	g, ok := vm.(gasEstimator)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "guardian gas: vm %q does not estimate gas\n", *vmName)
+		return 2
+	}
+	// estimates are keyed by contract name
+	estimates := make(map[string]json.RawMessage)
+	for _, scope := range pkg.Scopes() {
+		for _, c := range findContracts(scope) {
+			data, err := g.GasEstimates(c)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "guardian gas: %s: %s\n", c.Identifier, err)
+				return 1
+			}
+			estimates[c.Identifier] = data
+		}
+	}
+	return writeJSON("gas", estimates, *out)
+}
+
+// checkGasEstimates reports every function which may use more gas than it
+// did in a previous estimates file, as written by guardian gas
+// contracts and functions which have been added or removed are ignored
+func checkGasEstimates(vm validator.VM, pkg *validator.TypeScope, file string) int {
+	c, ok := vm.(gasChecker)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "guardian gas: vm does not check gas estimates\n")
+		return 2
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "guardian: %s\n", err)
+		return 1
+	}
+	var previous map[string]json.RawMessage
+	if err := json.Unmarshal(data, &previous); err != nil {
+		fmt.Fprintf(os.Stderr, "guardian gas: %s: %s\n", file, err)
+		return 1
+	}
+	regressed := false
+	for _, scope := range pkg.Scopes() {
+		for _, contract := range findContracts(scope) {
+			estimates, ok := previous[contract.Identifier]
+			if !ok {
+				continue
+			}
+			messages, err := c.CheckGasEstimates(estimates, contract)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "guardian gas: %s: %s\n", contract.Identifier, err)
+				return 1
+			}
+			for _, m := range messages {
+				fmt.Printf("%s: %s\n", contract.Identifier, m)
+				regressed = true
+			}
+		}
+	}
+	if regressed {
+		return 1
+	}
+	return 0
+}
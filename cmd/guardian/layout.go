@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
+)
+
+// layouter is implemented by VMs which can describe where a contract's
+// fields are held in storage
+type layouter interface {
+	StorageLayout(*ast.ContractDeclarationNode) ([]byte, error)
+}
+
+// layoutChecker is implemented by VMs which can check whether a contract's
+// storage is compatible with the layout of a previous version
+type layoutChecker interface {
+	CheckStorageLayout([]byte, *ast.ContractDeclarationNode) ([]string, error)
+}
+
+func runLayout(args []string) int {
+	fs := flag.NewFlagSet("layout", flag.ExitOnError)
+	vmName := fs.String("vm", "evm", "target virtual machine ("+vmNames()+")")
+	out := fs.String("o", "", "write the layout to this file rather than stdout")
+	check := fs.String("check", "", "check the layout against a previous layout file rather than describing it")
+	fs.Parse(args)
+
+	vm, pkg, code := loadPackage(fs, *vmName, false)
+	if code != 0 {
+		return code
+	}
+	if *check != "" {
+		return checkLayouts(vm, pkg, *check)
+	}
+	l, ok := vm.(layouter)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "guardian layout: vm %q does not describe storage layouts\n", *vmName)
+		return 2
+	}
+	// layouts are keyed by contract name
+	layouts := make(map[string]json.RawMessage)
+	for _, scope := range pkg.Scopes() {
+		for _, c := range findContracts(scope) {
+			data, err := l.StorageLayout(c)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "guardian layout: %s: %s\n", c.Identifier, err)
+				return 1
+			}
+			layouts[c.Identifier] = data
+		}
+	}
+	return writeJSON("layout", layouts, *out)
+}
+
+// checkLayouts reports every contract whose storage is incompatible with a
+// previous layout file, as written by guardian layout
+func checkLayouts(vm validator.VM, pkg *validator.TypeScope, file string) int {
+	c, ok := vm.(layoutChecker)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "guardian layout: vm does not check storage layouts\n")
+		return 2
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "guardian: %s\n", err)
+		return 1
+	}
+	var previous map[string]json.RawMessage
+	if err := json.Unmarshal(data, &previous); err != nil {
+		fmt.Fprintf(os.Stderr, "guardian layout: %s: %s\n", file, err)
+		return 1
+	}
+	incompatible := false
+	found := make(map[string]bool)
+	for _, scope := range pkg.Scopes() {
+		for _, contract := range findContracts(scope) {
+			layout, ok := previous[contract.Identifier]
+			if !ok {
+				continue
+			}
+			found[contract.Identifier] = true
+			messages, err := c.CheckStorageLayout(layout, contract)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "guardian layout: %s: %s\n", contract.Identifier, err)
+				return 1
+			}
+			for _, m := range messages {
+				fmt.Printf("%s: %s\n", contract.Identifier, m)
+				incompatible = true
+			}
+		}
+	}
+	var names []string
+	for name := range previous {
+		if !found[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s: contract was removed\n", name)
+		incompatible = true
+	}
+	if incompatible {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usage = `Guardian is a tool for managing Guardian source code.
+
+Usage:
+
+	guardian <command> [arguments]
+
+The commands are:
+
+	build   compile a package directory to bytecode
+	check   type-check a package directory
+	test    run the test functions in a package directory
+	fmt     format Guardian source files
+	abi     describe the interface of each contract in a package directory
+	layout  describe the storage layout of each contract in a package directory
+	disasm  list the instructions of each contract in a package directory
+	srcmap  map the instructions of each contract in a package directory to their source
+	gas     estimate the gas used by each function of each contract in a package directory
+`
+
+type command struct {
+	name string
+	run  func(args []string) int
+}
+
+var commands = []command{
+	{"build", runBuild},
+	{"check", runCheck},
+	{"test", runTest},
+	{"fmt", runFmt},
+	{"abi", runABI},
+	{"layout", runLayout},
+	{"disasm", runDisasm},
+	{"srcmap", runSourceMap},
+	{"gas", runGas},
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name == name {
+			os.Exit(c.run(flag.Args()[1:]))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "guardian: unknown command %q\n", name)
+	flag.Usage()
+	os.Exit(2)
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/parser"
+)
+
+func TestResolveVM(t *testing.T) {
+	_, err := resolveVM("evm")
+	goutil.AssertNow(t, err == nil, "evm should resolve")
+	_, err = resolveVM("firevm")
+	goutil.AssertNow(t, err != nil, "firevm should not yet be supported")
+	_, err = resolveVM("jvm")
+	goutil.AssertNow(t, err != nil, "unknown vm should not resolve")
+}
+
+func TestFindTests(t *testing.T) {
+	scope, errs := parser.ParseString(`
+		test func TestTopLevel() {}
+
+		func helper() {}
+
+		contract Calculator {
+			test func TestInContract() {}
+		}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	tests := findTests(scope)
+	goutil.AssertLength(t, len(tests), 2)
+}
+
+func TestLoadPackage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	goutil.AssertNow(t, err == nil, "failed to create directory")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "counter.grd"), []byte(`
+		contract Counter {
+			var count uint
+		}
+	`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "counter_test.grd"), []byte(`
+		test func TestCount() {}
+	`), 0644)
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.Parse([]string{dir})
+	_, pkg, code := loadPackage(fs, "evm", true)
+	goutil.AssertNow(t, code == 0, "package should be valid")
+	goutil.AssertLength(t, len(pkg.Scopes()), 2)
+	// test files are left out of everything but tests
+	_, pkg, code = loadPackage(fs, "evm", false)
+	goutil.AssertNow(t, code == 0, "package should be valid")
+	goutil.AssertLength(t, len(pkg.Scopes()), 1)
+}
+
+func TestFindContracts(t *testing.T) {
+	scope, errs := parser.ParseString(`
+		contract Token {}
+
+		class Wallet {}
+
+		contract Exchange {}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	contracts := findContracts(scope)
+	goutil.AssertLength(t, len(contracts), 2)
+}
+
+func TestRunLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	goutil.AssertNow(t, err == nil, "failed to create directory")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "counter.grd"), []byte(`
+		contract Counter {
+			var count uint
+		}
+	`), 0644)
+	out := filepath.Join(dir, "layout.json")
+	goutil.AssertNow(t, runLayout([]string{"-o", out, dir}) == 0, "layout should succeed")
+	data, err := ioutil.ReadFile(out)
+	goutil.AssertNow(t, err == nil, "layout should be written")
+	var layouts map[string]struct {
+		Storage []struct {
+			Label string
+			Slot  uint
+		}
+	}
+	goutil.AssertNow(t, json.Unmarshal(data, &layouts) == nil, string(data))
+	counter := layouts["Counter"]
+	goutil.AssertNow(t, len(counter.Storage) == 1, string(data))
+	goutil.Assert(t, counter.Storage[0].Label == "count", "wrong label")
+}
+
+func TestRunLayoutCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	goutil.AssertNow(t, err == nil, "failed to create directory")
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "counter.grd")
+	ioutil.WriteFile(source, []byte(`
+		contract Counter {
+			var count uint
+		}
+	`), 0644)
+	previous := filepath.Join(dir, "layout.json")
+	goutil.AssertNow(t, runLayout([]string{"-o", previous, dir}) == 0, "layout should succeed")
+	// appending fields is compatible
+	ioutil.WriteFile(source, []byte(`
+		contract Counter {
+			var count uint
+			var owner address
+		}
+	`), 0644)
+	goutil.Assert(t, runLayout([]string{"-check", previous, dir}) == 0, "appended fields should be compatible")
+	// inserting them is not
+	ioutil.WriteFile(source, []byte(`
+		contract Counter {
+			var owner address
+			var count uint
+		}
+	`), 0644)
+	goutil.Assert(t, runLayout([]string{"-check", previous, dir}) == 1, "inserted fields should be incompatible")
+}
+
+func TestRunBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	goutil.AssertNow(t, err == nil, "failed to create directory")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "counter.grd"), []byte(`
+		contract Counter {
+			var count uint
+			external func get() uint {
+				return count
+			}
+		}
+	`), 0644)
+	creation := filepath.Join(dir, "creation.hex")
+	goutil.AssertNow(t, runBuild([]string{"-o", creation, dir}) == 0, "build should succeed")
+	runtime := filepath.Join(dir, "runtime.hex")
+	goutil.AssertNow(t, runBuild([]string{"-runtime", "-o", runtime, dir}) == 0, "build should succeed")
+	c, err := ioutil.ReadFile(creation)
+	goutil.AssertNow(t, err == nil, "creation code should be written")
+	r, err := ioutil.ReadFile(runtime)
+	goutil.AssertNow(t, err == nil, "runtime code should be written")
+	goutil.AssertNow(t, strings.HasPrefix(string(c), "Counter: "), string(c))
+	goutil.AssertNow(t, strings.HasPrefix(string(r), "Counter: "), string(r))
+	// the creation code is followed by the runtime code
+	code := strings.TrimSpace(strings.TrimPrefix(string(r), "Counter: "))
+	goutil.Assert(t, strings.HasSuffix(strings.TrimSpace(string(c)), code), "runtime code should follow the creation code")
+	goutil.Assert(t, len(strings.TrimSpace(string(c))) > len(strings.TrimSpace(string(r))), "creation code should be longer")
+}
+
+func TestRunBuildOptimized(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	goutil.AssertNow(t, err == nil, "failed to create directory")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "counter.grd"), []byte(`
+		contract Counter {
+			var count uint
+			external func get() uint {
+				return count + 2 * 3
+			}
+		}
+	`), 0644)
+	plain := filepath.Join(dir, "plain.hex")
+	goutil.AssertNow(t, runBuild([]string{"-o", plain, dir}) == 0, "build should succeed")
+	optimized := filepath.Join(dir, "optimized.hex")
+	goutil.AssertNow(t, runBuild([]string{"-O", "4", "-o", optimized, dir}) == 0, "optimized build should succeed")
+	p, err := ioutil.ReadFile(plain)
+	goutil.AssertNow(t, err == nil, "code should be written")
+	o, err := ioutil.ReadFile(optimized)
+	goutil.AssertNow(t, err == nil, "optimized code should be written")
+	goutil.Assert(t, len(o) < len(p), "optimized code should be smaller")
+	goutil.Assert(t, runBuild([]string{"-O", "-1", dir}) == 2, "negative levels should be rejected")
+}
+
+func TestRunDisasm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	goutil.AssertNow(t, err == nil, "failed to create directory")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "counter.grd"), []byte(`
+		contract Counter {
+			var count uint
+			external func get() uint {
+				return count
+			}
+		}
+	`), 0644)
+	listing := filepath.Join(dir, "counter.asm")
+	goutil.AssertNow(t, runDisasm([]string{"-runtime", "-o", listing, dir}) == 0, "disasm should succeed")
+	l, err := ioutil.ReadFile(listing)
+	goutil.AssertNow(t, err == nil, "listing should be written")
+	goutil.Assert(t, strings.HasPrefix(string(l), "Counter:\n0000 | PUSH1 0x80\n"), string(l))
+	goutil.Assert(t, strings.Contains(string(l), "counter.grd:5: return count\n"), "listing should contain source lines")
+
+	code := filepath.Join(dir, "code.asm")
+	goutil.AssertNow(t, runDisasm([]string{"-code", "0x600100", "-o", code}) == 0, "disasm should succeed")
+	c, err := ioutil.ReadFile(code)
+	goutil.AssertNow(t, err == nil, "listing should be written")
+	goutil.Assert(t, string(c) == "0000 | PUSH1 0x01\n0002 | STOP\n", string(c))
+}
+
+func TestRunSourceMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	goutil.AssertNow(t, err == nil, "failed to create directory")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "counter.grd")
+	ioutil.WriteFile(file, []byte(`
+		contract Counter {
+			var count uint
+			external func get() uint {
+				return count
+			}
+		}
+	`), 0644)
+	out := filepath.Join(dir, "srcmap.json")
+	goutil.AssertNow(t, runSourceMap([]string{"-o", out, dir}) == 0, "srcmap should succeed")
+	data, err := ioutil.ReadFile(out)
+	goutil.AssertNow(t, err == nil, "source maps should be written")
+	var maps map[string]struct {
+		Sources []string
+		Runtime string `json:"srcmap-runtime"`
+	}
+	goutil.AssertNow(t, json.Unmarshal(data, &maps) == nil, string(data))
+	counter := maps["Counter"]
+	goutil.AssertNow(t, len(counter.Sources) == 1, string(data))
+	goutil.Assert(t, counter.Sources[0] == file, counter.Sources[0])
+	goutil.Assert(t, strings.HasPrefix(counter.Runtime, "-1:-1:-1:-;"), counter.Runtime)
+}
+
+func TestRunGas(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	goutil.AssertNow(t, err == nil, "failed to create directory")
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "counter.grd")
+	ioutil.WriteFile(source, []byte(`
+		contract Counter {
+			var count uint
+			external func get() uint {
+				return count
+			}
+		}
+	`), 0644)
+	previous := filepath.Join(dir, "gas.json")
+	goutil.AssertNow(t, runGas([]string{"-o", previous, dir}) == 0, "gas should succeed")
+	data, err := ioutil.ReadFile(previous)
+	goutil.AssertNow(t, err == nil, "estimates should be written")
+	var estimates map[string][]struct {
+		Signature string
+		Min, Max  uint64
+	}
+	goutil.AssertNow(t, json.Unmarshal(data, &estimates) == nil, string(data))
+	counter := estimates["Counter"]
+	goutil.AssertNow(t, len(counter) == 1, string(data))
+	goutil.Assert(t, counter[0].Signature == "get()", counter[0].Signature)
+	goutil.Assert(t, counter[0].Min > 0 && counter[0].Min == counter[0].Max, string(data))
+	goutil.Assert(t, runGas([]string{"-check", previous, dir}) == 0, "unchanged functions should pass")
+	// writing to storage costs more
+	ioutil.WriteFile(source, []byte(`
+		contract Counter {
+			var count uint
+			external func get() uint {
+				count = 1
+				return count
+			}
+		}
+	`), 0644)
+	var status int
+	output := captureOutput(t, func() {
+		status = runGas([]string{"-check", previous, dir})
+	})
+	goutil.Assert(t, status == 1, "costlier functions should fail the check")
+	goutil.Assert(t, strings.HasPrefix(output, "Counter: get() min gas rose from "), output)
+}
+
+// captureOutput returns everything a function writes to stdout
+func captureOutput(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	goutil.AssertNow(t, err == nil, "failed to create pipe")
+	stdout := os.Stdout
+	os.Stdout = w
+	f()
+	os.Stdout = stdout
+	w.Close()
+	data, _ := ioutil.ReadAll(r)
+	return string(data)
+}
+
+func TestRunTest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	goutil.AssertNow(t, err == nil, "failed to create directory")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "counter.grd"), []byte(`
+		contract Counter {
+			var count = 3
+
+			test func NotRun() {
+				assert(count == 4)
+			}
+		}
+	`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "counter_test.grd"), []byte(`
+		test func Sum() {
+			a = 2
+			assert(a + 1 == 3)
+		}
+
+		test func Difference() {
+			require(5 == 2 + 2)
+		}
+	`), 0644)
+	var code int
+	out := captureOutput(t, func() { code = runTest([]string{dir}) })
+	goutil.Assert(t, code == 1, "failing tests should fail")
+	goutil.Assert(t, strings.Contains(out, "--- PASS: Sum ("), out)
+	goutil.Assert(t, strings.Contains(out, "--- FAIL: Difference ("), out)
+	goutil.Assert(t, strings.Contains(out, "    counter_test.grd:8: require(5 == 2 + 2) failed\n"), out)
+	goutil.Assert(t, !strings.Contains(out, "NotRun"), "only tests in test files should run")
+	goutil.Assert(t, strings.Contains(out, "FAIL\t"+dir+"\t"), out)
+
+	out = captureOutput(t, func() { code = runTest([]string{"-run", "^Sum$", dir}) })
+	goutil.Assert(t, code == 0, "passing tests should pass")
+	goutil.Assert(t, !strings.Contains(out, "Difference"), "filtered tests should not run")
+	goutil.Assert(t, strings.Contains(out, "ok  \t"+dir+"\t"), out)
+}
+
+func TestRunTestJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guardian")
+	goutil.AssertNow(t, err == nil, "failed to create directory")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "counter_test.grd"), []byte(`
+		contract Counter {
+			var count = 3
+
+			test func Initial() {
+				assert(count == 4)
+			}
+		}
+	`), 0644)
+	var code int
+	out := captureOutput(t, func() { code = runTest([]string{"-json", dir}) })
+	goutil.Assert(t, code == 1, "failing tests should fail")
+	var actions []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var e testEvent
+		goutil.AssertNow(t, json.Unmarshal([]byte(line), &e) == nil, line)
+		if e.Test == "Counter.Initial" && e.Action != "output" {
+			actions = append(actions, e.Action)
+		}
+		if e.Action == "output" && strings.Contains(e.Output, "failed") {
+			goutil.Assert(t, e.Output == "    counter_test.grd:6: assert(count == 4) failed\n", e.Output)
+		}
+	}
+	goutil.Assert(t, strings.Join(actions, ",") == "run,fail", strings.Join(actions, ","))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/end-r/guardian/ast"
+)
+
+// sourceMapper is implemented by VMs which can map the instructions of a
+// contract to the source they were generated from
+type sourceMapper interface {
+	SourceMap(*ast.ContractDeclarationNode) ([]byte, error)
+}
+
+func runSourceMap(args []string) int {
+	fs := flag.NewFlagSet("srcmap", flag.ExitOnError)
+	vmName := fs.String("vm", "evm", "target virtual machine ("+vmNames()+")")
+	out := fs.String("o", "", "write the source maps to this file rather than stdout")
+	level := fs.Int("O", 0, optimizationUsage)
+	fs.Parse(args)
+
+	vm, pkg, code := loadPackage(fs, *vmName, false)
+	if code != 0 {
+		return code
+	}
+	if vm, code = optimize(fs, vm, *level); code != 0 {
+		return code
+	}
+	m, ok := vm.(sourceMapper)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "guardian srcmap: vm %q does not generate source maps\n", *vmName)
+		return 2
+	}
+	// source maps are keyed by contract name
+	maps := make(map[string]json.RawMessage)
+	for _, scope := range pkg.Scopes() {
+		for _, c := range findContracts(scope) {
+			data, err := m.SourceMap(c)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "guardian srcmap: %s: %s\n", c.Identifier, err)
+				return 1
+			}
+			maps[c.Identifier] = data
+		}
+	}
+	return writeJSON("srcmap", maps, *out)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/util"
+)
+
+// tester is implemented by VMs which can run test functions in process: it
+// returns why a test failed, or nothing if it passed
+type tester interface {
+	RunTest(contract *ast.ContractDeclarationNode, test *ast.FuncDeclarationNode, files map[string][]byte) (string, util.Errors)
+}
+
+const testSuffix = "_test.grd"
+
+// testFunc is a test function, and the contract which declares it if there
+// is one
+type testFunc struct {
+	contract *ast.ContractDeclarationNode
+	function *ast.FuncDeclarationNode
+}
+
+// tests in contracts are named after their contract
+func (t testFunc) name() string {
+	if t.contract != nil {
+		return t.contract.Identifier + "." + t.function.Signature.Identifier
+	}
+	return t.function.Signature.Identifier
+}
+
+// testEvent is a line of JSON output, in the format of go test -json
+type testEvent struct {
+	Time    time.Time `json:",omitempty"`
+	Action  string
+	Package string  `json:",omitempty"`
+	Test    string  `json:",omitempty"`
+	Elapsed float64 `json:",omitempty"`
+	Output  string  `json:",omitempty"`
+}
+
+func runTest(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	vmName := fs.String("vm", "evm", "target virtual machine ("+vmNames()+")")
+	run := fs.String("run", "", "run only the tests whose names match this regular expression")
+	asJSON := fs.Bool("json", false, "output a JSON event for each test")
+	fs.Parse(args)
+
+	filter, err := regexp.Compile(*run)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "guardian test: invalid -run expression: %s\n", err)
+		return 2
+	}
+	vm, pkg, code := loadPackage(fs, *vmName, true)
+	if code != 0 {
+		return code
+	}
+	t, ok := vm.(tester)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "guardian test: vm %q cannot run tests\n", *vmName)
+		return 2
+	}
+	dir := "."
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+	var tests []testFunc
+	for _, scope := range pkg.Scopes() {
+		for _, test := range findTests(scope) {
+			// only tests in test files are run
+			if strings.HasSuffix(test.function.Start().Filename, testSuffix) {
+				tests = append(tests, test)
+			}
+		}
+	}
+	// tests are run in the order in which they are declared in each file
+	sort.SliceStable(tests, func(i, j int) bool {
+		a, b := tests[i].function.Start(), tests[j].function.Start()
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Offset < b.Offset
+	})
+	out := newTestOutput(dir, *asJSON)
+	if len(tests) == 0 {
+		out.noTests()
+		return 0
+	}
+	start := time.Now()
+	failed := false
+	for _, test := range tests {
+		name := test.name()
+		if !filter.MatchString(name) {
+			continue
+		}
+		out.run(name)
+		began := time.Now()
+		failure, errs := t.RunTest(test.contract, test.function, nil)
+		if errs != nil {
+			failure = strings.TrimSpace(errs.Format())
+		}
+		if failure != "" {
+			failed = true
+		}
+		out.result(name, failure, time.Since(began))
+	}
+	out.summary(failed, time.Since(start))
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// testOutput reports the progress of tests in the style of go test, either
+// as text or as JSON events
+type testOutput struct {
+	dir  string
+	json *json.Encoder
+}
+
+func newTestOutput(dir string, asJSON bool) *testOutput {
+	out := &testOutput{dir: dir}
+	if asJSON {
+		out.json = json.NewEncoder(os.Stdout)
+	}
+	return out
+}
+
+func (o *testOutput) event(action, test string, elapsed time.Duration, output string) {
+	e := testEvent{
+		Time:    time.Now(),
+		Action:  action,
+		Package: o.dir,
+		Test:    test,
+		Output:  output,
+	}
+	if action == "pass" || action == "fail" {
+		e.Elapsed = elapsed.Seconds()
+	}
+	o.json.Encode(e)
+}
+
+func (o *testOutput) noTests() {
+	if o.json != nil {
+		o.event("output", "", 0, "?   \t"+o.dir+"\t[no test files]\n")
+		o.event("skip", "", 0, "")
+		return
+	}
+	fmt.Printf("?   \t%s\t[no test files]\n", o.dir)
+}
+
+func (o *testOutput) run(name string) {
+	if o.json != nil {
+		o.event("run", name, 0, "")
+	}
+}
+
+func (o *testOutput) result(name, failure string, elapsed time.Duration) {
+	status, action := "PASS", "pass"
+	if failure != "" {
+		status, action = "FAIL", "fail"
+	}
+	lines := []string{fmt.Sprintf("--- %s: %s (%.2fs)\n", status, name, elapsed.Seconds())}
+	if failure != "" {
+		for _, l := range strings.Split(failure, "\n") {
+			lines = append(lines, "    "+l+"\n")
+		}
+	}
+	if o.json == nil {
+		fmt.Print(strings.Join(lines, ""))
+		return
+	}
+	for _, l := range lines {
+		o.event("output", name, 0, l)
+	}
+	o.event(action, name, elapsed, "")
+}
+
+func (o *testOutput) summary(failed bool, elapsed time.Duration) {
+	status, action := "PASS", "pass"
+	line := fmt.Sprintf("ok  \t%s\t%.3fs\n", o.dir, elapsed.Seconds())
+	if failed {
+		status, action = "FAIL", "fail"
+		line = fmt.Sprintf("FAIL\t%s\t%.3fs\n", o.dir, elapsed.Seconds())
+	}
+	if o.json == nil {
+		fmt.Print(status + "\n" + line)
+		return
+	}
+	o.event("output", "", 0, status+"\n")
+	o.event("output", "", 0, line)
+	o.event(action, "", elapsed, "")
+}
+
+// findTests returns every function in the scope marked with the test
+// modifier, including those declared inside contracts and classes
+func findTests(scope *ast.ScopeNode) []testFunc {
+	var tests []testFunc
+	if scope == nil || scope.Declarations == nil {
+		return tests
+	}
+	for _, d := range scope.Declarations.Array() {
+		switch n := d.(type) {
+		case *ast.FuncDeclarationNode:
+			if n.Modifiers.HasModifier("test") {
+				tests = append(tests, testFunc{function: n})
+			}
+		case *ast.ContractDeclarationNode:
+			for _, t := range findTests(n.Body) {
+				if t.contract == nil {
+					t.contract = n
+				}
+				tests = append(tests, t)
+			}
+		case *ast.ClassDeclarationNode:
+			// classes aren't deployed, so their tests are run on their own
+			tests = append(tests, findTests(n.Body)...)
+		}
+	}
+	return tests
+}
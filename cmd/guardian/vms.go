@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/end-r/guardian/validator"
+	"github.com/end-r/guardian/vm/evm"
+)
+
+// vms maps each -vm name to a constructor
+// a nil constructor marks a VM which cannot yet be targeted
+var vms = map[string]func() validator.VM{
+	"evm":    func() validator.VM { return evm.NewVM() },
+	"firevm": nil,
+}
+
+func vmNames() string {
+	var names []string
+	for name := range vms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func resolveVM(name string) (validator.VM, error) {
+	create, ok := vms[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown vm %q (available: %s)", name, vmNames())
+	}
+	if create == nil {
+		return nil, fmt.Errorf("vm %q is not yet supported", name)
+	}
+	return create(), nil
+}
@@ -0,0 +1,175 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/end-r/guardian/lexer"
+	"github.com/end-r/guardian/token"
+	"github.com/end-r/guardian/util"
+)
+
+// the formatter works directly on the token stream rather than the AST
+// so that comments and blank lines survive formatting
+
+const indent = "    "
+
+// Bytes formats Guardian source code
+func Bytes(src []byte) ([]byte, util.Errors) {
+	l := lexer.Lex("input", src)
+	if l.Errors != nil {
+		return nil, l.Errors
+	}
+	f := &formatter{lexer: l}
+	f.format()
+	return f.out.Bytes(), nil
+}
+
+// String ...
+func String(src string) (string, util.Errors) {
+	out, errs := Bytes([]byte(src))
+	return string(out), errs
+}
+
+type formatter struct {
+	lexer *lexer.Lexer
+	out   bytes.Buffer
+	depth int
+	// lines which are currently open inside brackets, not braces
+	brackets int
+}
+
+// splits the token stream into lines, dropping all newline tokens
+// line comments consume their newline, and so also end a line
+func (f *formatter) lines() [][]token.Token {
+	var lines [][]token.Token
+	var current []token.Token
+	for _, t := range f.lexer.Tokens {
+		if t.Type == token.NewLine {
+			lines = append(lines, current)
+			current = nil
+			continue
+		}
+		current = append(current, t)
+		if t.Type == token.LineComment {
+			lines = append(lines, current)
+			current = nil
+		}
+	}
+	return append(lines, current)
+}
+
+func (f *formatter) format() {
+	blank := 0
+	written := false
+	for _, line := range f.lines() {
+		if len(line) == 0 {
+			blank++
+			continue
+		}
+		if written && blank > 0 && !closes(line[0]) && !f.justOpened() {
+			// collapse runs of blank lines into one
+			f.out.WriteByte('\n')
+		}
+		blank = 0
+		f.writeLine(line)
+		written = true
+	}
+}
+
+func (f *formatter) justOpened() bool {
+	b := bytes.TrimRight(f.out.Bytes(), "\n")
+	return len(b) > 0 && b[len(b)-1] == '{'
+}
+
+func closes(t token.Token) bool {
+	switch t.Type {
+	case token.CloseBrace, token.CloseBracket, token.CloseSquare:
+		return true
+	}
+	return false
+}
+
+func (f *formatter) writeLine(line []token.Token) {
+	level := f.depth + f.brackets
+	if closes(line[0]) {
+		level--
+	}
+	if line[0].Type == token.Case || line[0].Type == token.Default {
+		// cases line up with their switch
+		level--
+	}
+	for i := 0; i < level; i++ {
+		f.out.WriteString(indent)
+	}
+	for i, t := range line {
+		if i > 0 && f.spaced(line, i) {
+			f.out.WriteByte(' ')
+		}
+		f.out.WriteString(strings.TrimRight(t.String(f.lexer), "\r\n"))
+		switch t.Type {
+		case token.OpenBrace:
+			f.depth++
+		case token.CloseBrace:
+			f.depth--
+		case token.OpenBracket, token.OpenSquare:
+			f.brackets++
+		case token.CloseBracket, token.CloseSquare:
+			f.brackets--
+		}
+	}
+	f.out.WriteByte('\n')
+}
+
+// operators which are always binary, and so always surrounded by spaces
+var binaryOperators = []token.Type{
+	token.Eql, token.Neq, token.Leq, token.Geq, token.Define,
+	token.LogicalAnd, token.LogicalOr, token.Add, token.Mul, token.Div,
+	token.Mod, token.Exp, token.Shl, token.Shr, token.And, token.Or,
+	token.Xor, token.Assign, token.AddAssign, token.SubAssign,
+	token.MulAssign, token.ExpAssign, token.DivAssign, token.ModAssign,
+	token.AndAssign, token.OrAssign, token.XorAssign, token.ShlAssign,
+	token.ShrAssign,
+}
+
+func isOneOf(t token.Type, types ...token.Type) bool {
+	for _, typ := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func isOperand(t token.Type) bool {
+	return isOneOf(t, token.Identifier, token.Integer, token.Float,
+		token.String, token.Character, token.CloseBracket, token.CloseSquare,
+		token.True, token.False)
+}
+
+// spaced reports whether a space should separate a token from its predecessor
+func (f *formatter) spaced(line []token.Token, i int) bool {
+	prev, next := line[i-1], line[i]
+	switch {
+	case isOneOf(next.Type, token.Comma, token.Semicolon, token.Dot,
+		token.CloseBracket, token.CloseSquare, token.Increment,
+		token.Decrement, token.Colon):
+		return false
+	case isOneOf(prev.Type, token.OpenBracket, token.OpenSquare, token.Dot,
+		token.At, token.Not):
+		return false
+	case prev.Type == token.Comma:
+		return true
+	case next.Type == token.OpenBrace || next.Type == token.LineComment:
+		return true
+	case isOneOf(prev.Type, binaryOperators...) || isOneOf(next.Type, binaryOperators...):
+		return true
+	case next.Type == token.Sub:
+		return isOperand(prev.Type)
+	case prev.Type == token.Sub:
+		// unary minus stays attached to its operand
+		return i > 1 && isOperand(line[i-2].Type)
+	}
+	// otherwise respect whether the author separated the tokens
+	return next.Start.Offset != prev.End.Offset
+}
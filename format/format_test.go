@@ -0,0 +1,57 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/end-r/goutil"
+)
+
+func checkFormat(t *testing.T, src, expected string) {
+	out, errs := String(src)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	goutil.Assert(t, out == expected, "wrong format:\n"+out)
+}
+
+func TestFormatIndentation(t *testing.T) {
+	checkFormat(t, `contract Dog {
+var name string
+        func bark() {
+  x = 5
+}
+}
+`, `contract Dog {
+    var name string
+    func bark() {
+        x = 5
+    }
+}
+`)
+}
+
+func TestFormatSpacing(t *testing.T) {
+	checkFormat(t, "x=a+b*c\ny = add( a ,b )\nz = -1\n", "x = a + b * c\ny = add(a, b)\nz = -1\n")
+}
+
+func TestFormatBinaryMinus(t *testing.T) {
+	checkFormat(t, "x = a-b\n", "x = a - b\n")
+}
+
+func TestFormatBlankLines(t *testing.T) {
+	checkFormat(t, "var a int\n\n\n\nvar b int\n", "var a int\n\nvar b int\n")
+}
+
+func TestFormatComments(t *testing.T) {
+	checkFormat(t, "// a comment\nvar a int // trailing\n", "// a comment\nvar a int // trailing\n")
+}
+
+func TestFormatSwitch(t *testing.T) {
+	checkFormat(t, `switch x {
+case 1:
+break
+}
+`, `switch x {
+case 1:
+    break
+}
+`)
+}
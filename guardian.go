@@ -1,62 +1,115 @@
 package guardian
 
 import (
-	"fmt"
-
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/lexer"
+	"github.com/end-r/guardian/parser"
 	"github.com/end-r/guardian/util"
+	"github.com/end-r/guardian/validator"
+	"github.com/end-r/vmgen"
 )
 
-func reportErrors(category string, errs util.Errors) {
-	msg := fmt.Sprintf("%s Errors\n", category)
-	msg += errs.Format()
-	fmt.Println(msg)
+// CompileBytes lexes, parses, validates and generates bytecode for a single file
+func CompileBytes(vm validator.VM, bytes []byte) (vmgen.Bytecode, util.Errors) {
+	return compile(vm, lexer.Lex("input", bytes))
 }
 
-/*
-// CompileBytes ...
-func CompileBytes(vm validator.VM, bytes []byte) vmgen.Bytecode {
-	l := lexer.LexString(string(bytes))
-
-	if l.Errors != nil {
-		reportErrors("Lexing", l.Errors)
-	}
+// CompileString ...
+func CompileString(vm validator.VM, data string) (vmgen.Bytecode, util.Errors) {
+	return CompileBytes(vm, []byte(data))
+}
 
-	p := parser.Parse(l)
+// CompileFile ...
+func CompileFile(vm validator.VM, path string) (vmgen.Bytecode, util.Errors) {
+	return compile(vm, lexer.LexFile(path))
+}
 
-	if p.Errors != nil {
-		reportErrors("Parsing", p.Errors)
-	}
+// A Contract is the code generated for one contract of a package
+type Contract struct {
+	Name string
+	Code vmgen.Bytecode
+}
 
-	errs := validator.ValidateString()
+// A Packager is a VM which can call the functions declared in any file of a
+// package
+type Packager interface {
+	InPackage(scopes []*ast.ScopeNode) validator.VM
+}
 
-	if errs != nil {
-		reportErrors("Type Validation", errs)
+// CompileFilesData compiles several files as members of the same package
+// each contract they declare is generated as a program of its own, in the
+// order of the files
+func CompileFilesData(vm validator.VM, data [][]byte) ([]Contract, util.Errors) {
+	var lexers []*lexer.Lexer
+	for _, d := range data {
+		lexers = append(lexers, lexer.Lex("input", d))
 	}
-
-	bytecode, errs := vm.Traverse(ast)
-
+	scopes, errs := validate(vm, lexers...)
 	if errs != nil {
-		reportErrors("Bytecode Generation", errs)
+		return nil, errs
+	}
+	if p, ok := vm.(Packager); ok {
+		vm = p.InPackage(scopes)
+	}
+	var contracts []Contract
+	for _, scope := range scopes {
+		if scope.Declarations == nil {
+			continue
+		}
+		for _, d := range scope.Declarations.Array() {
+			c, ok := d.(*ast.ContractDeclarationNode)
+			if !ok {
+				continue
+			}
+			code, es := vm.Traverse(c)
+			if es != nil {
+				errs = append(errs, es.WithStage(util.Generation)...)
+			}
+			contracts = append(contracts, Contract{Name: c.Identifier, Code: code})
+		}
 	}
-	return bytecode
+	return contracts, errs
 }
 
-// CompileString ...
-func CompileString(vm validator.VM, data string) vmgen.Bytecode {
-	return CompileBytes(vm, []byte(data))
+func compile(vm validator.VM, l *lexer.Lexer) (code vmgen.Bytecode, errs util.Errors) {
+	scopes, errs := validate(vm, l)
+	if errs != nil {
+		return code, errs
+	}
+	code, errs = vm.Traverse(scopes[0])
+	return code, errs.WithStage(util.Generation)
 }
 
-func CompileFilesData(vm validator.VM, data [][]byte) (vmgen.Bytecode, util.Errors) {
+// each stage only runs if all previous stages were error-free
+// every error returned is tagged with the stage which produced it
+func validate(vm validator.VM, lexers ...*lexer.Lexer) (scopes []*ast.ScopeNode, errs util.Errors) {
+	for _, l := range lexers {
+		if l.Errors != nil {
+			errs = append(errs, l.Errors.WithStage(util.Lexing)...)
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
 
-}
-*/
+	for _, l := range lexers {
+		scope, es := parser.Parse(l)
+		if es != nil {
+			errs = append(errs, es.WithStage(util.Parsing)...)
+		}
+		scopes = append(scopes, scope)
+	}
+	if errs != nil {
+		return nil, errs
+	}
 
-/* EVM ...
-func EVM() Traverser {
-	return evm.NewTraverser()
+	if len(scopes) == 1 {
+		errs = validator.Validate(vm, scopes[0], nil)
+	} else {
+		errs = validator.ValidatePackageScopes(vm, scopes)
+	}
+	if errs != nil {
+		return nil, errs.WithStage(util.Validation)
+	}
+	return scopes, nil
 }
-
-// FireVM ...
-func FireVM() Traverser {
-	return firevm.NewTraverser()
-}*/
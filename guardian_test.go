@@ -1,15 +1,69 @@
 package guardian
 
-import "testing"
+import (
+	"testing"
 
-func TestCompileString(t *testing.T) {
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/util"
+	"github.com/end-r/guardian/vm/evm"
+)
 
+func TestCompileString(t *testing.T) {
+	_, errs := CompileString(evm.NewVM(), `
+		contract Counter {
+			var count uint
+		}
+	`)
+	goutil.Assert(t, errs == nil, errs.Format())
 }
 
 func TestCompileBytes(t *testing.T) {
+	_, errs := CompileBytes(evm.NewVM(), []byte(`var x missing`))
+	goutil.AssertNow(t, len(errs) > 0, "expected validation errors")
+	goutil.Assert(t, len(errs.FromStage(util.Validation)) == len(errs), "wrong stage")
+}
 
+func TestCompileBytesParseErrors(t *testing.T) {
+	_, errs := CompileBytes(evm.NewVM(), []byte(`contract Counter {`))
+	goutil.AssertNow(t, len(errs) > 0, "expected parse errors")
+	goutil.Assert(t, len(errs.FromStage(util.Parsing)) == len(errs), "wrong stage")
 }
 
 func TestCompileFile(t *testing.T) {
+	_, errs := CompileFile(evm.NewVM(), "missing.grd")
+	goutil.AssertNow(t, len(errs) == 1, "expected one lexing error")
+	goutil.Assert(t, errs[0].Stage == util.Lexing, "wrong stage")
+}
+
+func TestCompileFilesData(t *testing.T) {
+	contracts, errs := CompileFilesData(evm.NewVM(), [][]byte{
+		[]byte(`
+			contract A {
+				var a uint
+				external func get() uint {
+					return a + one()
+				}
+			}
+		`),
+		[]byte(`
+			var b uint
+			contract B {
+				external func get() uint {
+					return one()
+				}
+			}
 
+			internal func one() uint {
+				return 1
+			}
+		`),
+	})
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	goutil.AssertNow(t, len(contracts) == 2, "wrong number of contracts")
+	goutil.Assert(t, contracts[0].Name == "A" && contracts[1].Name == "B", "wrong contract names")
+	a, errs := evm.NewVM().Assemble(contracts[0].Code)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	b, errs := evm.NewVM().Assemble(contracts[1].Code)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	goutil.Assert(t, len(a) > 0 && len(b) > 0, "each contract should be generated on its own")
 }
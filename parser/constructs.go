@@ -37,3 +37,12 @@ func getPrimaryConstructs() []construct {
 	}
 	return standards
 }
+
+// constructs which precede a declaration, rather than consuming its modifiers
+func (c construct) declaresModifiers() bool {
+	switch c.name {
+	case "ignored", "modifiers", "annotations", "group", "new line":
+		return true
+	}
+	return false
+}
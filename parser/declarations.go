@@ -4,6 +4,7 @@ import (
 	"strconv"
 
 	"github.com/end-r/guardian/token"
+	"github.com/end-r/guardian/typing"
 
 	"github.com/end-r/guardian/ast"
 )
@@ -449,7 +450,8 @@ func (p *Parser) parseIndividualParameter() *ast.ExplicitVarDeclarationNode {
 		dType = p.parseType()
 		break
 	}
-	mods := p.getModifiers()
+	// parameters only take their own modifiers
+	var mods typing.Modifiers
 	for _, m := range possibleMods {
 		mods.AddModifier(m)
 	}
@@ -558,7 +560,7 @@ func parseFuncDeclaration(p *Parser) {
 		Final:     p.getLastTokenLocation(),
 		Signature: signature,
 		Generics:  generics,
-		Modifiers: p.getModifiers(),
+		Modifiers: mods,
 		Body:      body,
 	}
 
@@ -590,7 +592,8 @@ func parseLifecycleDeclaration(p *Parser) {
 		node.Parameters = params
 	}
 
-	p.scope.AddDeclaration("lifecycle", &node)
+	// contracts may declare several lifecycles, so each needs a distinct key
+	p.scope.AddDeclaration("lifecycle "+strconv.Itoa(int(start.Offset)), &node)
 }
 
 func parseTypeDeclaration(p *Parser) {
@@ -182,7 +182,8 @@ func (p *Parser) isRecursiveModifier() bool {
 	if p.hasTokens(1) {
 		switch p.current().Type {
 		case token.Func, token.Var, token.Const, token.Enum,
-			token.Interface, token.Contract, token.Class, token.Event:
+			token.Interface, token.Contract, token.Class, token.Event,
+			token.Constructor, token.Destructor, token.Fallback:
 			return true
 		case token.Identifier:
 			p.next()
@@ -201,10 +202,11 @@ func isModifier(p *Parser) bool {
 	// have to deal with nested calls
 	// assert(now(assert(now())))
 	return p.preserveState(func(p *Parser) bool {
-		if !p.parseOptional(token.Identifier) {
+		// test is a keyword, but may still be used as a modifier
+		if !p.parseOptional(token.Identifier, token.Test) {
 			return false
 		}
-		for p.parseOptional(token.Identifier) {
+		for p.parseOptional(token.Identifier, token.Test) {
 		}
 		return p.isRecursiveModifier()
 	})
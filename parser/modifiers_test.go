@@ -63,3 +63,31 @@ func TestGroupedClassMultiLevelModifiers(t *testing.T) {
 	c := n.(*ast.ClassDeclarationNode)
 	goutil.AssertLength(t, len(c.Modifiers.Modifiers), 2)
 }
+
+func TestFuncTestModifier(t *testing.T) {
+	a, errs := ParseString(`
+		test func TestAddition() {
+
+		}
+	`)
+	goutil.AssertNow(t, a != nil, "ast is nil")
+	goutil.AssertNow(t, len(errs) == 0, errs.Format())
+	goutil.AssertLength(t, a.Declarations.Length(), 1)
+	n := a.Declarations.Next()
+	f := n.(*ast.FuncDeclarationNode)
+	goutil.AssertNow(t, f.Modifiers.HasModifier("test"), "missing test modifier")
+}
+
+func TestLifecycleModifiers(t *testing.T) {
+	a, errs := ParseString(`
+		payable constructor(supply uint) {
+
+		}
+	`)
+	goutil.AssertNow(t, a != nil, "ast is nil")
+	goutil.AssertNow(t, len(errs) == 0, errs.Format())
+	goutil.AssertLength(t, a.Declarations.Length(), 1)
+	n := a.Declarations.Next()
+	l := n.(*ast.LifecycleDeclarationNode)
+	goutil.AssertNow(t, l.Modifiers.HasModifier("payable"), "missing payable modifier")
+}
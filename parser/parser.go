@@ -15,17 +15,19 @@ import (
 
 // Parser ...
 type Parser struct {
-	scope            *ast.ScopeNode
-	Expression       ast.ExpressionNode
-	modifiers        [][]string
-	lastModifiers    []string
-	annotations      []*typing.Annotation
-	index            int
-	errs             util.Errors
-	line             int
-	simple           bool
-	seenCastOperator bool
-	lexer            *lexer.Lexer
+	scope         *ast.ScopeNode
+	Expression    ast.ExpressionNode
+	modifiers     [][]string
+	lastModifiers []string
+	// the ungrouped modifiers of each construct currently being parsed
+	declarationModifiers [][]string
+	annotations          []*typing.Annotation
+	index                int
+	errs                 util.Errors
+	line                 int
+	simple               bool
+	seenCastOperator     bool
+	lexer                *lexer.Lexer
 }
 
 func createParser(data string) *Parser {
@@ -178,8 +180,16 @@ func parseNewLine(p *Parser) {
 
 func (p *Parser) parseModifierList() []string {
 	var mods []string
-	for p.hasTokens(1) && p.current().Type == token.Identifier {
-		mods = append(mods, p.parseIdentifier())
+	for p.hasTokens(1) {
+		switch p.current().Type {
+		case token.Identifier:
+			mods = append(mods, p.parseIdentifier())
+		case token.Test:
+			mods = append(mods, "test")
+			p.next()
+		default:
+			return mods
+		}
 	}
 	return mods
 }
@@ -210,8 +220,10 @@ func parseGroup(p *Parser) {
 
 func (p *Parser) getModifiers() typing.Modifiers {
 	var mods []string
-	for _, m := range p.lastModifiers {
-		mods = append(mods, m)
+	if len(p.declarationModifiers) > 0 {
+		for _, m := range p.declarationModifiers[len(p.declarationModifiers)-1] {
+			mods = append(mods, m)
+		}
 	}
 	for _, ml := range p.modifiers {
 		for _, m := range ml {
@@ -264,7 +276,11 @@ func (p *Parser) parseNextConstruct() {
 	for _, c := range getPrimaryConstructs() {
 		if c.is(p) {
 			//fmt.Printf("FOUND: %s at index %d on line %d\n", c.name, p.getCurrentTokenLocation().Offset, p.getCurrentTokenLocation().Line)
-			c.parse(p)
+			if c.declaresModifiers() {
+				c.parse(p)
+			} else {
+				p.parseWithModifiers(c)
+			}
 			p.parseOptional(token.Semicolon)
 			found = true
 			break
@@ -286,6 +302,15 @@ func (p *Parser) parseNextConstruct() {
 	}
 }
 
+// ungrouped modifiers only apply to the construct which immediately follows
+// them, and not to any constructs nested within it
+func (p *Parser) parseWithModifiers(c construct) {
+	p.declarationModifiers = append(p.declarationModifiers, p.lastModifiers)
+	p.lastModifiers = nil
+	c.parse(p)
+	p.declarationModifiers = p.declarationModifiers[:len(p.declarationModifiers)-1]
+}
+
 func (p *Parser) parsePossibleSequentialExpression(expr ast.ExpressionNode) {
 	// short circuits
 	if p.isNextToken(token.Comma) {
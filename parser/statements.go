@@ -14,10 +14,11 @@ func parseReturnStatement(p *Parser) {
 	start := p.getCurrentTokenLocation()
 
 	p.parseRequired(token.Return)
+	results := p.parseExpressionList()
 	node := ast.ReturnStatementNode{
 		Begin:   start,
 		Final:   p.getLastTokenLocation(),
-		Results: p.parseExpressionList(),
+		Results: results,
 	}
 	p.scope.AddSequential(&node)
 }
@@ -267,9 +268,9 @@ func parseFlowStatement(p *Parser) {
 	node := ast.FlowStatementNode{
 		Token: p.current().Type,
 		Begin: p.getCurrentTokenLocation(),
-		Final: p.getLastTokenLocation(),
 	}
 	p.next()
+	node.Final = p.getLastTokenLocation()
 	p.scope.AddSequential(&node)
 }
 
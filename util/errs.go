@@ -2,10 +2,21 @@ package util
 
 import "fmt"
 
+// Stage identifies the part of the compiler which produced an error
+type Stage string
+
+const (
+	Lexing     Stage = "Lexing"
+	Parsing    Stage = "Parsing"
+	Validation Stage = "Type Validation"
+	Generation Stage = "Bytecode Generation"
+)
+
 // Error ...
 type Error struct {
 	Location Location
 	Message  string
+	Stage    Stage
 }
 
 // Errors ...
@@ -20,3 +31,24 @@ func (e Errors) Format() string {
 	}
 	return whole
 }
+
+// WithStage tags every untagged error with the given stage
+func (e Errors) WithStage(stage Stage) Errors {
+	for i := range e {
+		if e[i].Stage == "" {
+			e[i].Stage = stage
+		}
+	}
+	return e
+}
+
+// FromStage returns all errors produced by the given stage
+func (e Errors) FromStage(stage Stage) Errors {
+	var errs Errors
+	for _, err := range e {
+		if err.Stage == stage {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
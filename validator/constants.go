@@ -0,0 +1,277 @@
+package validator
+
+import (
+	"math/big"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/token"
+	"github.com/end-r/guardian/typing"
+	"github.com/end-r/guardian/util"
+)
+
+// the largest exponent or shift which will be folded: anything larger
+// overflows every type, and is left for the VM to evaluate
+const maxConstantShift = 1 << 16
+
+func (v *Validator) declareConstant(name string, node *ast.ExplicitVarDeclarationNode) {
+	if v.scope.constants == nil {
+		v.scope.constants = make(map[string]*ast.ExplicitVarDeclarationNode)
+	}
+	v.scope.constants[name] = node
+}
+
+// findConstant returns the declaration of a constant, or nil if the name
+// refers to anything else (following the lookup order of isVarVisible)
+func (v *Validator) findConstant(name string) *ast.ExplicitVarDeclarationNode {
+	if v.builtinScope != nil {
+		if _, ok := v.builtinScope.variables[name]; ok {
+			return v.builtinScope.constants[name]
+		}
+	}
+	for ts := v.scope; ts != nil; ts = ts.parent {
+		if _, ok := ts.variables[name]; ok {
+			return ts.constants[name]
+		}
+	}
+	return nil
+}
+
+// evaluate returns the value of an integer constant expression
+// constants are folded when they are declared, so references to them
+// are already literals
+func (v *Validator) evaluate(e ast.ExpressionNode) (*big.Int, bool) {
+	switch n := e.(type) {
+	case *ast.LiteralNode:
+		if n.LiteralType != token.Integer {
+			return nil, false
+		}
+		return new(big.Int).SetString(n.Data, 0)
+	case *ast.IdentifierNode:
+		if len(n.Parameters) > 0 {
+			return nil, false
+		}
+		if decl := v.findConstant(n.Name); decl != nil {
+			if value, ok := decl.Value.(*ast.LiteralNode); ok {
+				return v.evaluate(value)
+			}
+		}
+		return nil, false
+	case *ast.UnaryExpressionNode:
+		x, ok := v.evaluate(n.Operand)
+		if !ok {
+			return nil, false
+		}
+		switch n.Operator {
+		case token.Add:
+			return x, true
+		case token.Sub:
+			return new(big.Int).Neg(x), true
+		case token.Not:
+			return new(big.Int).Not(x), true
+		}
+		return nil, false
+	case *ast.BinaryExpressionNode:
+		x, ok := v.evaluate(n.Left)
+		if !ok {
+			return nil, false
+		}
+		y, ok := v.evaluate(n.Right)
+		if !ok {
+			return nil, false
+		}
+		return evaluateBinary(n.Operator, x, y)
+	}
+	return nil, false
+}
+
+func evaluateBinary(op token.Type, x, y *big.Int) (*big.Int, bool) {
+	z := new(big.Int)
+	switch op {
+	case token.Add:
+		return z.Add(x, y), true
+	case token.Sub:
+		return z.Sub(x, y), true
+	case token.Mul:
+		return z.Mul(x, y), true
+	case token.Div:
+		if y.Sign() == 0 {
+			return nil, false
+		}
+		return z.Quo(x, y), true
+	case token.Mod:
+		if y.Sign() == 0 {
+			return nil, false
+		}
+		return z.Rem(x, y), true
+	case token.Exp:
+		if y.Sign() < 0 || (y.Cmp(big.NewInt(maxConstantShift)) > 0 && x.CmpAbs(big.NewInt(1)) > 0) {
+			return nil, false
+		}
+		return z.Exp(x, y, nil), true
+	case token.Shl:
+		if y.Sign() < 0 || y.Cmp(big.NewInt(maxConstantShift)) > 0 {
+			return nil, false
+		}
+		return z.Lsh(x, uint(y.Uint64())), true
+	case token.Shr:
+		if y.Sign() < 0 {
+			return nil, false
+		}
+		if y.Cmp(big.NewInt(maxConstantShift)) > 0 {
+			// every bit has been shifted out
+			if x.Sign() < 0 {
+				return z.SetInt64(-1), true
+			}
+			return z, true
+		}
+		return z.Rsh(x, uint(y.Uint64())), true
+	case token.And:
+		return z.And(x, y), true
+	case token.Or:
+		return z.Or(x, y), true
+	case token.Xor:
+		return z.Xor(x, y), true
+	}
+	return nil, false
+}
+
+// foldConstants replaces each constant expression within e with a literal
+// of its value, checking that the value fits in the target type
+// if there is no numeric target, the value must fit in the largest integer
+func (v *Validator) foldConstants(e ast.ExpressionNode, target typing.Type) ast.ExpressionNode {
+	if e == nil {
+		return e
+	}
+	if value, ok := v.evaluate(e); ok {
+		v.checkConstant(e.Start(), value, target)
+		if _, ok := e.(*ast.LiteralNode); ok {
+			// keep the literal as it was written
+			return e
+		}
+		return &ast.LiteralNode{
+			Begin:       e.Start(),
+			Final:       e.End(),
+			Data:        value.String(),
+			LiteralType: token.Integer,
+			Resolved:    e.ResolvedType(),
+		}
+	}
+	v.foldChildren(e)
+	return e
+}
+
+// foldExpressions folds each expression, checking it against the type in
+// the same position of targets if there is one for every expression
+func (v *Validator) foldExpressions(exprs []ast.ExpressionNode, targets *typing.Tuple) {
+	for i, e := range exprs {
+		var target typing.Type
+		if targets != nil && len(targets.Types) == len(exprs) {
+			target = targets.Types[i]
+		}
+		exprs[i] = v.foldConstants(e, target)
+	}
+}
+
+// foldChildren folds the constant expressions within e, but not e itself
+func (v *Validator) foldChildren(e ast.ExpressionNode) {
+	switch n := e.(type) {
+	case *ast.BinaryExpressionNode:
+		if n.Operator == token.Div || n.Operator == token.Mod {
+			if y, ok := v.evaluate(n.Right); ok && y.Sign() == 0 {
+				v.addError(n.Right.Start(), errDivisionByZero)
+			}
+		}
+		n.Left = v.foldConstants(n.Left, nil)
+		n.Right = v.foldConstants(n.Right, nil)
+	case *ast.UnaryExpressionNode:
+		n.Operand = v.foldConstants(n.Operand, nil)
+	case *ast.CallExpressionNode:
+		var params []typing.Type
+		if f, ok := n.Call.ResolvedType().(*typing.Func); ok && f.Params != nil && len(f.Generics) == 0 {
+			params = f.Params.Types
+		}
+		for i, arg := range n.Arguments {
+			var target typing.Type
+			if i < len(params) {
+				target = params[i]
+			}
+			n.Arguments[i] = v.foldConstants(arg, target)
+		}
+		v.foldChildren(n.Call)
+	case *ast.IndexExpressionNode:
+		v.foldChildren(n.Expression)
+		n.Index = v.foldConstants(n.Index, nil)
+	case *ast.SliceExpressionNode:
+		v.foldChildren(n.Expression)
+		n.Low = v.foldConstants(n.Low, nil)
+		n.High = v.foldConstants(n.High, nil)
+		n.Max = v.foldConstants(n.Max, nil)
+	case *ast.ArrayLiteralNode:
+		for i, d := range n.Data {
+			n.Data[i] = v.foldConstants(d, nil)
+		}
+	case *ast.MapLiteralNode:
+		for k, d := range n.Data {
+			n.Data[k] = v.foldConstants(d, nil)
+		}
+	case *ast.CompositeLiteralNode:
+		for k, f := range n.Fields {
+			n.Fields[k] = v.foldConstants(f, nil)
+		}
+	case *ast.ReferenceNode:
+		v.foldChildren(n.Parent)
+	case *ast.KeywordNode:
+		for i, arg := range n.Arguments {
+			n.Arguments[i] = v.foldConstants(arg, nil)
+		}
+	}
+}
+
+// checkConstant reports a constant which cannot be represented by its
+// target type
+func (v *Validator) checkConstant(loc util.Location, value *big.Int, target typing.Type) {
+	var min, max *big.Int
+	if n, ok := typing.ResolveUnderlying(target).(*typing.NumericType); ok {
+		if !n.Integer {
+			return
+		}
+		min, max = integerBounds(n.BitSize, n.Signed)
+	} else {
+		// untyped constants may use the range of either signedness
+		largest := v.largestIntegerSize()
+		if largest == 0 {
+			return
+		}
+		min, _ = integerBounds(largest, true)
+		_, max = integerBounds(largest, false)
+		target = nil
+	}
+	if value.Cmp(min) < 0 || value.Cmp(max) > 0 {
+		name := "any integer type"
+		if target != nil {
+			name = typing.WriteType(target)
+		}
+		v.addError(loc, errConstantOverflow, value.String(), name)
+	}
+}
+
+func (v *Validator) largestIntegerSize() int {
+	largest := 0
+	for _, typ := range v.primitives {
+		if n, ok := typ.(*typing.NumericType); ok && n.Integer && n.BitSize > largest {
+			largest = n.BitSize
+		}
+	}
+	return largest
+}
+
+// integerBounds returns the smallest and largest values of an integer type
+func integerBounds(bits int, signed bool) (min, max *big.Int) {
+	if signed {
+		max = new(big.Int).Lsh(big.NewInt(1), uint(bits-1))
+		min = new(big.Int).Neg(max)
+		return min, max.Sub(max, big.NewInt(1))
+	}
+	max = new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	return new(big.Int), max.Sub(max, big.NewInt(1))
+}
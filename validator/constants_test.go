@@ -0,0 +1,125 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/parser"
+	"github.com/end-r/guardian/token"
+
+	"github.com/end-r/goutil"
+)
+
+func assertFolded(t *testing.T, e ast.ExpressionNode, value string) {
+	l, ok := e.(*ast.LiteralNode)
+	goutil.AssertNow(t, ok, "expression not folded")
+	goutil.AssertNow(t, l.LiteralType == token.Integer, "wrong literal type")
+	goutil.AssertNow(t, l.Data == value, "wrong value: "+l.Data)
+}
+
+func TestFoldBuiltinConstant(t *testing.T) {
+	scope, _ := parser.ParseString(`x = ether`)
+	errs := Validate(NewTestVM(), scope, nil)
+	goutil.AssertNow(t, len(errs) == 0, errs.Format())
+	a := scope.Sequence[0].(*ast.AssignmentStatementNode)
+	assertFolded(t, a.Right[0], "1000000000000000000")
+}
+
+func TestFoldConstantChain(t *testing.T) {
+	scope, _ := parser.ParseString(`
+		const (
+			c = b * 2
+			b = a + 1
+			a = 1 << 8
+		)
+		var x = c % 100
+	`)
+	errs := Validate(NewTestVM(), scope, nil)
+	goutil.AssertNow(t, len(errs) == 0, errs.Format())
+	c := scope.GetDeclaration("c").(*ast.ExplicitVarDeclarationNode)
+	assertFolded(t, c.Value, "514")
+	x := scope.GetDeclaration("x").(*ast.ExplicitVarDeclarationNode)
+	assertFolded(t, x.Value, "14")
+}
+
+func TestFoldNestedConstants(t *testing.T) {
+	scope, _ := parser.ParseString(`
+		func f(a int) int {
+			return a * (kwei + 24)
+		}
+	`)
+	errs := Validate(NewTestVM(), scope, nil)
+	goutil.AssertNow(t, len(errs) == 0, errs.Format())
+	f := scope.GetDeclaration("f").(*ast.FuncDeclarationNode)
+	r := f.Body.Sequence[0].(*ast.ReturnStatementNode)
+	b, ok := r.Results[0].(*ast.BinaryExpressionNode)
+	goutil.AssertNow(t, ok, "product should not be folded")
+	assertFolded(t, b.Right, "1024")
+}
+
+func TestFoldNonConstantVariable(t *testing.T) {
+	scope, _ := parser.ParseString(`
+		var a = 5
+		var x = a * 2
+	`)
+	errs := Validate(NewTestVM(), scope, nil)
+	goutil.AssertNow(t, len(errs) == 0, errs.Format())
+	x := scope.GetDeclaration("x").(*ast.ExplicitVarDeclarationNode)
+	_, ok := x.Value.(*ast.BinaryExpressionNode)
+	goutil.AssertNow(t, ok, "variables should not be folded")
+}
+
+func TestConstantOverflowDeclaredType(t *testing.T) {
+	scope, _ := parser.ParseString(`
+		var a uint8 = 255
+		var b uint8 = 255 + 1
+		var c int8 = 0 - 128
+		var d int8 = 0 - 129
+	`)
+	errs := Validate(NewTestVM(), scope, nil)
+	goutil.AssertNow(t, len(errs) == 2, errs.Format())
+}
+
+func TestConstantOverflowAssignment(t *testing.T) {
+	scope, _ := parser.ParseString(`
+		var a uint16
+		a = kwei * 66
+	`)
+	errs := Validate(NewTestVM(), scope, nil)
+	goutil.AssertNow(t, len(errs) == 1, errs.Format())
+}
+
+func TestConstantOverflowArgument(t *testing.T) {
+	scope, _ := parser.ParseString(`
+		func f(a int8) {
+
+		}
+		f(1 << 7)
+	`)
+	errs := Validate(NewTestVM(), scope, nil)
+	goutil.AssertNow(t, len(errs) == 1, errs.Format())
+}
+
+func TestConstantOverflowUntyped(t *testing.T) {
+	scope, _ := parser.ParseString(`
+		const (
+			max = (1 << 256) - 1
+			min = 0 - (1 << 255)
+			over = 1 << 256
+			under = min - 1
+		)
+	`)
+	errs := Validate(NewTestVM(), scope, nil)
+	goutil.AssertNow(t, len(errs) == 2, errs.Format())
+}
+
+func TestConstantDivisionByZero(t *testing.T) {
+	scope, _ := parser.ParseString(`
+		const zero = 0
+		var a = 5
+		var b = 5 / zero
+		var c = a % (wei - 1)
+	`)
+	errs := Validate(NewTestVM(), scope, nil)
+	goutil.AssertNow(t, len(errs) == 2, errs.Format())
+}
@@ -223,14 +223,24 @@ func (v *Validator) validateVarDeclaration(node *ast.ExplicitVarDeclarationNode)
 	var typ typing.Type
 	if node.DeclaredType == nil {
 		typ = v.resolveExpression(node.Value)
+		if node.Value != nil {
+			node.Value = v.foldConstants(node.Value, nil)
+		}
 	} else {
 		typ = v.validateType(node.DeclaredType)
+		if node.Value != nil {
+			v.resolveExpression(node.Value)
+			node.Value = v.foldConstants(node.Value, typ)
+		}
 	}
 
 	typ.SetModifiers(&node.Modifiers)
 
 	for _, id := range node.Identifiers {
 		v.declareVar(node.Start(), id, typ)
+		if node.IsConstant {
+			v.declareConstant(id, node)
+		}
 		//fmt.Printf("Declared: %s as %s\n", id, WriteType(typ))
 	}
 	node.Resolved = typ
@@ -635,8 +645,9 @@ func (v *Validator) validateFuncDeclaration(node *ast.FuncDeclarationNode) {
 	for _, node := range node.Signature.Parameters {
 		switch p := node.(type) {
 		case *ast.ExplicitVarDeclarationNode:
+			typ := v.validateType(p.DeclaredType)
+			p.Resolved = typ
 			for _, id := range p.Identifiers {
-				typ := v.validateType(p.DeclaredType)
 				v.declareVar(p.Start(), id, typ)
 				params = append(params, typ)
 			}
@@ -649,6 +660,7 @@ func (v *Validator) validateFuncDeclaration(node *ast.FuncDeclarationNode) {
 		switch p := r.(type) {
 		case *ast.ExplicitVarDeclarationNode:
 			typ := v.validateType(p.DeclaredType)
+			p.Resolved = typ
 			for _, id := range p.Identifiers {
 				v.declareVar(p.Start(), id, typ)
 				results = append(results, typ)
@@ -707,6 +719,16 @@ func (v *Validator) validateModifiers(node ast.Node, modifiers []string) {
 		}
 	}
 
+	// only functions can be run as tests
+	if isTest(modifiers) && node.Type() != ast.FuncDeclaration {
+		v.addError(node.Start(), errInvalidTestDeclaration)
+	}
+
+	// builtins and tests are exempt from vm-specific modifier requirements
+	if v.inBuiltins || isTest(modifiers) {
+		return
+	}
+
 	for _, mg := range v.modifierGroups {
 		if mg.requiredOn(node.Type()) {
 			if mg.selected == nil {
@@ -716,6 +738,28 @@ func (v *Validator) validateModifiers(node ast.Node, modifiers []string) {
 	}
 }
 
+func isTest(modifiers []string) bool {
+	for _, mod := range modifiers {
+		if mod == "test" {
+			return true
+		}
+	}
+	return false
+}
+
+// events are limited by the number of topics a log can hold after the
+// signature
+const maxIndexedParameters = 3
+
+func isIndexed(modifiers []string) bool {
+	for _, mod := range modifiers {
+		if mod == "indexed" {
+			return true
+		}
+	}
+	return false
+}
+
 func (v *Validator) processModifier(node ast.Node, n, c token.Type) token.Type {
 	if c == -1 {
 		return n
@@ -731,13 +775,23 @@ func (v *Validator) validateEventDeclaration(node *ast.EventDeclarationNode) {
 
 	v.validateModifiers(node, node.Modifiers.Modifiers)
 
+	all := isIndexed(node.Modifiers.Modifiers)
+	indexed := 0
 	var params []typing.Type
 	for _, n := range node.Parameters {
+		v.validateModifiers(n, n.Modifiers.Modifiers)
 		typ := v.validateType(n.DeclaredType)
+		n.Resolved = typ
 		for _ = range n.Identifiers {
 			params = append(params, typ)
+			if all || isIndexed(n.Modifiers.Modifiers) {
+				indexed++
+			}
 		}
 	}
+	if indexed > maxIndexedParameters {
+		v.addError(node.Start(), errTooManyIndexedParameters, node.Identifier, indexed, maxIndexedParameters)
+	}
 
 	generics := v.validateGenerics(node.Generics)
 
@@ -762,11 +816,14 @@ func (v *Validator) validateTypeDeclaration(node *ast.TypeDeclarationNode) {
 
 func (v *Validator) validateLifecycleDeclaration(node *ast.LifecycleDeclarationNode) {
 
+	v.validateModifiers(node, node.Modifiers.Modifiers)
+
 	v.openScope(nil, nil)
 	// TODO: enforce location
 	var types []typing.Type
 	for _, p := range node.Parameters {
 		typ := v.validateType(p.DeclaredType)
+		p.Resolved = typ
 		for _, i := range p.Identifiers {
 			v.declareVar(p.Start(), i, typ)
 			types = append(types, typ)
@@ -610,3 +610,29 @@ func TestAccessBaseContractProperty(t *testing.T) {
 	`)
 	goutil.AssertNow(t, len(errs) == 0, errs.Format())
 }
+
+func TestValidateModifiersTestFunc(t *testing.T) {
+	_, errs := ValidateString(NewTestVM(), "test func TestAddition() {}")
+	goutil.AssertNow(t, errs == nil, errs.Format())
+}
+
+func TestValidateEventDeclIndexedParameters(t *testing.T) {
+	scope, _ := parser.ParseString("event Dog(indexed a int, indexed b int, indexed c int, d string)")
+	goutil.AssertNow(t, scope != nil, "scope should not be nil")
+	errs := Validate(NewTestVM(), scope, nil)
+	goutil.AssertNow(t, len(errs) == 0, errs.Format())
+}
+
+func TestValidateEventDeclTooManyIndexedParameters(t *testing.T) {
+	scope, _ := parser.ParseString("event Dog(indexed a int, indexed b int, indexed c int, indexed d int)")
+	goutil.AssertNow(t, scope != nil, "scope should not be nil")
+	errs := Validate(NewTestVM(), scope, nil)
+	goutil.AssertNow(t, len(errs) == 1, errs.Format())
+}
+
+func TestValidateIndexedEventDeclTooManyParameters(t *testing.T) {
+	scope, _ := parser.ParseString("indexed event Dog(a, b int, c, d string)")
+	goutil.AssertNow(t, scope != nil, "scope should not be nil")
+	errs := Validate(NewTestVM(), scope, nil)
+	goutil.AssertNow(t, len(errs) == 1, errs.Format())
+}
@@ -58,5 +58,9 @@ const (
 	errInvalidMapKey                     = "Cannot use type %s as map key"
 	errMultipleCast                      = "Cannot cast more than one value"
 	errUnknownModifier                   = "Unknown modifier %s"
+	errInvalidTestDeclaration            = "Only functions can be marked test"
 	errInvalidSwitchTarget               = "Invalid switch target: expected %s, found %s"
+	errTooManyIndexedParameters          = "Event %s has %d indexed parameters, the maximum is %d"
+	errConstantOverflow                  = "Constant %s overflows %s"
+	errDivisionByZero                    = "Division by zero"
 )
@@ -28,6 +28,7 @@ type Validator struct {
 	operators       OperatorMap
 	modifierGroups  []*ModifierGroup
 	finishedImports bool
+	inBuiltins      bool
 	baseContract    *typing.Contract
 	// for passing to imported files
 	// don't access properties through this
@@ -42,6 +43,12 @@ type TypeScope struct {
 	lifecycles typing.LifecycleMap
 	variables  typing.TypeMap
 	types      typing.TypeMap
+	constants  map[string]*ast.ExplicitVarDeclarationNode
+}
+
+// Scopes returns the file scopes which make up this package
+func (ts *TypeScope) Scopes() []*ast.ScopeNode {
+	return ts.scopes
 }
 
 // ValidateExpression ...
@@ -83,6 +90,13 @@ func ValidateScopes(vm VM, pkgScope *TypeScope) (errors util.Errors) {
 	return v.errs
 }
 
+// ValidatePackageScopes validates a set of parsed files as a single package
+func ValidatePackageScopes(vm VM, scopes []*ast.ScopeNode) util.Errors {
+	pkgScope := new(TypeScope)
+	pkgScope.scopes = scopes
+	return ValidateScopes(vm, pkgScope)
+}
+
 func ValidateFileData(vm VM, data []string) (errors util.Errors) {
 	pkgScope := new(TypeScope)
 	pkgScope.scopes = make([]*ast.ScopeNode, 0)
@@ -99,6 +113,12 @@ func ValidateFileData(vm VM, data []string) (errors util.Errors) {
 
 // ValidatePackage ...
 func ValidatePackage(vm VM, path string) (*TypeScope, util.Errors) {
+	return ValidatePackageFiles(vm, path, func(string) bool { return true })
+}
+
+// ValidatePackageFiles validates the guardian files in a directory which
+// include accepts as a single package
+func ValidatePackageFiles(vm VM, path string, include func(name string) bool) (*TypeScope, util.Errors) {
 	// open directory
 	// for all files in directory
 	// 1. enforce that they are from the s
@@ -113,14 +133,14 @@ func ValidatePackage(vm VM, path string) (*TypeScope, util.Errors) {
 	var errors util.Errors
 	pkgScope.scopes = make([]*ast.ScopeNode, 0)
 	for _, name := range list {
-		if isGuardianFile(name) {
+		if isGuardianFile(name) && include(name) {
 			s, errs := parser.ParseFile(fmt.Sprintf("%s/%s", path, name))
 			pkgScope.scopes = append(pkgScope.scopes, s)
-			errors = append(errors, errs...)
+			errors = append(errors, errs.WithStage(util.Parsing)...)
 		}
 	}
 	if errors == nil {
-		errors = append(errors, ValidateScopes(vm, pkgScope)...)
+		errors = append(errors, ValidateScopes(vm, pkgScope).WithStage(util.Validation)...)
 	}
 	return pkgScope, errors
 
@@ -276,6 +296,14 @@ func (v *Validator) validateSequence(scope *ast.ScopeNode) {
 func (v *Validator) validate(node ast.Node) {
 	if node.Type() == ast.CallExpression {
 		v.resolveCallExpression(node.(*ast.CallExpressionNode))
+		v.foldChildren(node.(*ast.CallExpressionNode))
+	} else if r, ok := node.(*ast.ReferenceNode); ok {
+		// calls to the functions of other contracts are resolved so that
+		// they can be generated
+		switch typing.ResolveUnderlying(v.resolveExpression(r.Parent)).(type) {
+		case *typing.Contract, *typing.Interface:
+			v.resolveExpression(r)
+		}
 	} else {
 		v.validateStatement(node)
 	}
@@ -295,7 +323,10 @@ func (v *Validator) importVM(vm VM) {
 
 	v.primitives[vm.BooleanName()] = typing.Boolean()
 
+	// builtins are exempt from vm-specific modifier requirements
+	v.inBuiltins = true
 	v.validateScope(nil, vm.Builtins())
+	v.inBuiltins = false
 
 	v.builtinScope = v.scope
 
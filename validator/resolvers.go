@@ -365,12 +365,19 @@ func (v *Validator) replaceGeneric(t typing.Type, genDecs typing.TypeMap) typing
 		}
 		return t
 	case *typing.Array:
-		a.Value = v.replaceGeneric(a.Value, genDecs)
-		return a
+		// the declaration is shared by every call, so it is copied
+		return &typing.Array{
+			Mods:     a.Mods,
+			Length:   a.Length,
+			Value:    v.replaceGeneric(a.Value, genDecs),
+			Variable: a.Variable,
+		}
 	case *typing.Map:
-		a.Key = v.replaceGeneric(a.Key, genDecs)
-		a.Value = v.replaceGeneric(a.Value, genDecs)
-		break
+		return &typing.Map{
+			Mods:  a.Mods,
+			Key:   v.replaceGeneric(a.Key, genDecs),
+			Value: v.replaceGeneric(a.Value, genDecs),
+		}
 	case *typing.Func:
 		for i, p := range a.Params.Types {
 			a.Params.Types[i] = v.replaceGeneric(p, genDecs)
@@ -386,6 +393,13 @@ func (v *Validator) resolveCallExpression(n *ast.CallExpressionNode) typing.Type
 
 	// attempt to resolve as if cast
 	if left, ok := v.resolveAsPlainType(n.Call); ok {
+		// the callee of a cast resolves to the type it produces
+		switch c := n.Call.(type) {
+		case *ast.IdentifierNode:
+			c.Resolved = left
+		case *ast.ReferenceNode:
+			c.Resolved = left
+		}
 		if len(n.Arguments) > 1 {
 			v.addError(n.Call.Start(), errMultipleCast)
 			n.Resolved = left
@@ -444,11 +458,20 @@ func (v *Validator) resolveCallExpression(n *ast.CallExpressionNode) typing.Type
 			}
 		}
 
-		for i, r := range a.Results.Types {
-			a.Results.Types[i] = v.replaceGeneric(r, genDecs)
+		results := a.Results
+		if len(genDecs) > 0 {
+			results = typing.NewTuple()
+			for _, r := range a.Results.Types {
+				results.Types = append(results.Types, v.replaceGeneric(r, genDecs))
+			}
+		}
+		if len(results.Types) == 1 {
+			// calls with a single result can be used as a single value
+			n.Resolved = results.Types[0]
+			return n.Resolved
 		}
-		n.Resolved = a.Results
-		return a.Results
+		n.Resolved = results
+		return results
 	case *typing.Event:
 		if !typing.AssignableTo(a.Parameters, args, false) {
 			v.addError(n.Start(), errInvalidFuncCall, typing.WriteType(args), typing.WriteType(a))
@@ -503,7 +526,20 @@ func (v *Validator) determineType(t typing.Type, parent, exp ast.ExpressionNode)
 	case *ast.CallExpressionNode:
 		switch f := t.(type) {
 		case *typing.Func:
-			return f.Results
+			// calls through a reference are checked in the same way as
+			// other calls
+			args := v.ExpressionTuple(a.Arguments)
+			if !typing.AssignableTo(f.Params, args, false) {
+				v.addError(a.Start(), errInvalidFuncCall, typing.WriteType(args), typing.WriteType(f))
+			}
+			if i, ok := a.Call.(*ast.IdentifierNode); ok {
+				i.Resolved = f
+			}
+			a.Resolved = f.Results
+			if f.Results != nil && len(f.Results.Types) == 1 {
+				a.Resolved = f.Results.Types[0]
+			}
+			return a.Resolved
 		}
 		break
 	case *ast.IndexExpressionNode:
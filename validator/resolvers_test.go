@@ -35,10 +35,9 @@ func TestResolveCallExpression(t *testing.T) {
 	p := parser.ParseExpression("hello(5, 5)")
 	goutil.AssertNow(t, p.Type() == ast.CallExpression, "wrong expression type")
 	a := p.(*ast.CallExpressionNode)
-	resolved, ok := v.resolveExpression(a).(*typing.Tuple)
-	goutil.Assert(t, ok, "wrong base type")
-	goutil.Assert(t, len(resolved.Types) == 1, "wrong type length")
-	goutil.Assert(t, fn.Results.Compare(resolved), "should be equal")
+	// a single result is resolved as a single value
+	resolved := v.resolveExpression(a)
+	goutil.Assert(t, fn.Results.Types[0].Compare(resolved), "should be equal")
 }
 
 func TestResolveArrayLiteralExpression(t *testing.T) {
@@ -487,3 +486,32 @@ func TestValidateSimpleCast(t *testing.T) {
 	goutil.AssertNow(t, exp != nil, "exp isn't nil")
 	goutil.AssertNow(t, len(errs) == 0, errs.Format())
 }
+
+func TestInterfaceCallResolution(t *testing.T) {
+	_, errs := ValidateString(NewTestVM(), `
+		interface Token {
+			send(to address, amount uint) bool
+		}
+		contract Vault {
+			func pay(token, to address, amount uint) bool {
+				Token(token).send(to, amount)
+				return Token(token).send(to, amount)
+			}
+		}
+	`)
+	goutil.AssertNow(t, len(errs) == 0, errs.Format())
+}
+
+func TestInvalidInterfaceCallArguments(t *testing.T) {
+	_, errs := ValidateString(NewTestVM(), `
+		interface Token {
+			send(to address, amount uint) bool
+		}
+		contract Vault {
+			func pay(token address, amount uint) {
+				Token(token).send(amount, amount)
+			}
+		}
+	`)
+	goutil.AssertNow(t, len(errs) == 1, errs.Format())
+}
@@ -53,6 +53,11 @@ func (v *Validator) validateAssignment(node *ast.AssignmentStatementNode) {
 
 	leftTuple := v.ExpressionTuple(node.Left)
 	rightTuple := v.ExpressionTuple(node.Right)
+
+	for _, l := range node.Left {
+		v.foldChildren(l)
+	}
+	v.foldExpressions(node.Right, leftTuple)
 	if len(leftTuple.Types) > len(rightTuple.Types) && len(rightTuple.Types) == 1 {
 		right := rightTuple.Types[0]
 
@@ -141,6 +146,7 @@ func (v *Validator) validateIfStatement(node *ast.IfStatementNode) {
 	for _, cond := range node.Conditions {
 		// condition must be of type bool
 		v.requireType(cond.Condition.Start(), typing.Boolean(), v.resolveExpression(cond.Condition))
+		cond.Condition = v.foldConstants(cond.Condition, nil)
 		v.validateScope(node, cond.Body)
 	}
 
@@ -158,6 +164,7 @@ func (v *Validator) validateSwitchStatement(node *ast.SwitchStatementNode) {
 
 	if node.Target != nil {
 		switchType = v.resolveExpression(node.Target)
+		node.Target = v.foldConstants(node.Target, nil)
 	}
 
 	// target must be matched by all cases
@@ -170,12 +177,12 @@ func (v *Validator) validateSwitchStatement(node *ast.SwitchStatementNode) {
 }
 
 func (v *Validator) validateCaseStatement(switchType typing.Type, clause *ast.CaseStatementNode) {
-	for _, expr := range clause.Expressions {
+	for i, expr := range clause.Expressions {
 		t := v.resolveExpression(expr)
 		if !v.vm.Assignable(v, switchType, t, expr) {
 			v.addError(clause.Start(), errInvalidSwitchTarget, typing.WriteType(switchType), typing.WriteType(t))
 		}
-
+		clause.Expressions[i] = v.foldConstants(expr, switchType)
 	}
 	v.validateScope(clause, clause.Block)
 }
@@ -187,6 +194,7 @@ func (v *Validator) validateReturnStatement(node *ast.ReturnStatementNode) {
 			case *ast.FuncDeclarationNode:
 				results := a.Resolved.(*typing.Func).Results
 				returned := v.ExpressionTuple(node.Results)
+				v.foldExpressions(node.Results, results)
 				if (results == nil || len(results.Types) == 0) && len(returned.Types) > 0 {
 					v.addError(node.Start(), errInvalidReturnFromVoid, typing.WriteType(returned), a.Signature.Identifier)
 					return
@@ -198,6 +206,7 @@ func (v *Validator) validateReturnStatement(node *ast.ReturnStatementNode) {
 			case *ast.FuncLiteralNode:
 				results := a.Resolved.(*typing.Func).Results
 				returned := v.ExpressionTuple(node.Results)
+				v.foldExpressions(node.Results, results)
 				if (results == nil || len(results.Types) == 0) && len(returned.Types) > 0 {
 					v.addError(node.Start(), errInvalidReturnFromVoid, typing.WriteType(returned), "literal")
 					return
@@ -218,6 +227,7 @@ func (v *Validator) validateForEachStatement(node *ast.ForEachStatementNode) {
 	v.openScope(nil, nil)
 
 	gen := v.resolveExpression(node.Producer)
+	node.Producer = v.foldConstants(node.Producer, nil)
 	var req int
 	switch a := gen.(type) {
 	case *typing.Map:
@@ -260,6 +270,7 @@ func (v *Validator) validateForStatement(node *ast.ForStatementNode) {
 
 	// cond statement must be a boolean
 	v.requireType(node.Cond.Start(), typing.Boolean(), v.resolveExpression(node.Cond))
+	node.Cond = v.foldConstants(node.Cond, nil)
 
 	// post statement must be valid
 	if node.Post != nil {
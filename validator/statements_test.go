@@ -467,3 +467,16 @@ func TestGenericCallExpression(t *testing.T) {
 	`)
 	goutil.AssertNow(t, len(errs) == 0, errs.Format())
 }
+
+func TestGenericCallExpressionsDifferentTypes(t *testing.T) {
+	_, errs := ValidateString(NewTestVM(), `
+		func main(){
+			var a []int
+			var b []bool
+			var x int
+			a = append(a, x)
+			b = append(b, true)
+		}
+	`)
+	goutil.AssertNow(t, len(errs) == 0, errs.Format())
+}
@@ -264,6 +264,14 @@ func (v *Validator) isTypeVisible(name string) (typing.Type, bool) {
 	return typing.Unknown(), false
 }
 
+// IsTypeVisible finds a type by name, as it can be referred to from the
+// current scope: primitive and builtin types are visible everywhere, then
+// the types declared in the enclosing scopes are searched outwards
+// it returns the unknown type and false if no type of that name is visible
+func (v *Validator) IsTypeVisible(name string) (typing.Type, bool) {
+	return v.isTypeVisible(name)
+}
+
 func (v *Validator) declareVar(loc util.Location, name string, typ typing.Type) {
 	if _, ok := v.isVarDeclared(name); ok {
 		v.addError(loc, errDuplicateVarDeclaration, name)
@@ -332,7 +332,7 @@ func operators() OperatorMap {
 	m.Add(BooleanOperator, token.LogicalAnd, token.LogicalOr)
 
 	// numericalOperator with floats/ints
-	m.Add(BinaryNumericOperator, token.Sub, token.Mul, token.Div)
+	m.Add(BinaryNumericOperator, token.Sub, token.Mul, token.Div, token.Mod)
 
 	// integers only
 	m.Add(BinaryIntegerOperator, token.Shl, token.Shr)
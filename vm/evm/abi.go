@@ -0,0 +1,552 @@
+package evm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/token"
+	"github.com/end-r/guardian/typing"
+	"github.com/end-r/guardian/util"
+)
+
+// type declarations are resolved to their underlying types by the validator,
+// so the builtin aliases can only be recovered from the declared type nodes
+var abiAliases = map[string]string{
+	"uint":    "uint256",
+	"int":     "int256",
+	"byte":    "bytes1",
+	"string":  "string",
+	"address": "address",
+	"bytes32": "bytes32",
+	"bool":    "bool",
+}
+
+const maxFixedBytes = 32
+
+// abiTypeOf returns the canonical ABI name of a declared type
+func (e *GuardianEVM) abiTypeOf(declared ast.Node, resolved typing.Type) (string, error) {
+	switch n := declared.(type) {
+	case *ast.PlainTypeNode:
+		if len(n.Names) == 1 {
+			if name, ok := abiAliases[n.Names[0]]; ok {
+				return name, nil
+			}
+		}
+	case *ast.ArrayTypeNode:
+		var value typing.Type = typing.Unknown()
+		if a, ok := typing.ResolveUnderlying(resolved).(*typing.Array); ok {
+			value = a.Value
+		}
+		elem, err := e.abiTypeOf(n.Value, value)
+		if err != nil {
+			return "", err
+		}
+		if elem == "bytes1" {
+			if n.Variable {
+				return "bytes", nil
+			}
+			if n.Length <= maxFixedBytes {
+				return fmt.Sprintf("bytes%d", n.Length), nil
+			}
+		}
+		if n.Variable {
+			return elem + "[]", nil
+		}
+		return fmt.Sprintf("%s[%d]", elem, n.Length), nil
+	}
+	return e.abiType(resolved)
+}
+
+// abiType returns the canonical ABI name of a resolved type
+// maps, classes, functions and the other types which can't be passed to or
+// from another contract have no ABI name
+func (e *GuardianEVM) abiType(t typing.Type) (string, error) {
+	if t == typing.Boolean() {
+		return "bool", nil
+	}
+	switch a := typing.ResolveUnderlying(t).(type) {
+	case *typing.NumericType:
+		if a.Signed {
+			return fmt.Sprintf("int%d", a.BitSize), nil
+		}
+		return fmt.Sprintf("uint%d", a.BitSize), nil
+	case *typing.BooleanType:
+		return "bool", nil
+	case *typing.Array:
+		elem, err := e.abiType(a.Value)
+		if err != nil {
+			return "", err
+		}
+		if a.Variable {
+			return elem + "[]", nil
+		}
+		return fmt.Sprintf("%s[%d]", elem, a.Length), nil
+	case *typing.Tuple:
+		var types []string
+		for _, typ := range a.Types {
+			elem, err := e.abiType(typ)
+			if err != nil {
+				return "", err
+			}
+			types = append(types, elem)
+		}
+		return "(" + strings.Join(types, ",") + ")", nil
+	case *typing.Contract, *typing.Interface:
+		return "address", nil
+	case *typing.Enum:
+		return "uint8", nil
+	}
+	return "", fmt.Errorf(errNoABIType, typing.WriteType(t))
+}
+
+// abiName returns the ABI name of a type which has already been checked,
+// or the name of the type itself if it has no ABI name
+func (e *GuardianEVM) abiName(declared ast.Node, resolved typing.Type) string {
+	name, err := e.abiTypeOf(declared, resolved)
+	if err != nil {
+		return typing.WriteType(resolved)
+	}
+	return name
+}
+
+// checkABITypes reports each parameter and result of a function which can
+// be called from outside the contract, but has no ABI name
+func (e *GuardianEVM) checkABITypes(loc util.Location, format, name string, params []*ast.ExplicitVarDeclarationNode, rs []result) {
+	for _, p := range params {
+		if _, err := e.abiTypeOf(p.DeclaredType, p.Resolved); err != nil {
+			e.addError(p.Start(), format, name, err)
+		}
+	}
+	for _, r := range rs {
+		if _, err := e.abiTypeOf(r.declared, r.resolved); err != nil {
+			e.addError(loc, format, name, err)
+		}
+	}
+}
+
+const (
+	errNoABIType           = "type %s has no ABI encoding"
+	errExternalFuncType    = "Function %s can be called from outside the contract, but its %v"
+	errConstructorArgument = "The constructor of %s is passed arguments from outside the contract, but its %v"
+)
+
+// canonical signatures use the declared name and the ABI names of all parameters
+func (e *GuardianEVM) signature(name string, params []*ast.ExplicitVarDeclarationNode) string {
+	var types []string
+	for _, p := range params {
+		abi := e.abiName(p.DeclaredType, p.Resolved)
+		for range p.Identifiers {
+			types = append(types, abi)
+		}
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(types, ","))
+}
+
+func (e *GuardianEVM) funcSignature(node *ast.FuncDeclarationNode) string {
+	return e.signature(node.Signature.Identifier, parameters(node))
+}
+
+func parameters(node *ast.FuncDeclarationNode) []*ast.ExplicitVarDeclarationNode {
+	return signatureParameters(node.Signature)
+}
+
+func signatureParameters(signature *ast.FuncTypeNode) []*ast.ExplicitVarDeclarationNode {
+	var params []*ast.ExplicitVarDeclarationNode
+	for _, p := range signature.Parameters {
+		params = append(params, p.(*ast.ExplicitVarDeclarationNode))
+	}
+	return params
+}
+
+// ethereum uses the original keccak padding rather than the sha3 standard
+func keccak(data []byte) []byte {
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(data)
+	return hasher.Sum(nil)
+}
+
+// Selector returns the leftmost 4 bytes of the hash of a canonical signature
+func Selector(signature string) []byte {
+	return keccak([]byte(signature))[:4]
+}
+
+// ABIParam describes a single input or output of an ABI entry
+type ABIParam struct {
+	Name       string
+	Type       string
+	Indexed    bool
+	Components []ABIParam
+}
+
+// ABIEntry describes a single function, constructor, fallback or event
+type ABIEntry struct {
+	Type    string
+	Name    string
+	Inputs  []ABIParam
+	Outputs []ABIParam
+	Payable bool
+}
+
+// ABI returns the standard JSON interface of a validated contract
+func (evm GuardianEVM) ABI(n *ast.ContractDeclarationNode) ([]byte, error) {
+	return json.Marshal(evm.ContractABI(n))
+}
+
+// ContractABI describes the parts of a validated contract which can be
+// interacted with from outside the chain, in declaration order
+func (evm GuardianEVM) ContractABI(n *ast.ContractDeclarationNode) []ABIEntry {
+	e := &evm
+	entries := make([]ABIEntry, 0)
+	if constructor(n) == nil {
+		// inherited constructors must still be passed their arguments
+		if params := constructorParameters(n); len(params) > 0 {
+			entries = append(entries, ABIEntry{
+				Type:   "constructor",
+				Inputs: e.abiParams(params, false),
+			})
+		}
+	}
+	if n.Body == nil || n.Body.Declarations == nil {
+		return entries
+	}
+	for _, d := range n.Body.Declarations.Array() {
+		switch a := d.(type) {
+		case *ast.FuncDeclarationNode:
+			if hasModifier(a.Modifiers.Modifiers, "external") ||
+				hasModifier(a.Modifiers.Modifiers, "global") {
+				entries = append(entries, e.funcEntry(a))
+			}
+			break
+		case *ast.LifecycleDeclarationNode:
+			switch a.Category {
+			case token.Constructor:
+				// the arguments of every constructor are passed together
+				entries = append(entries, ABIEntry{
+					Type:    "constructor",
+					Inputs:  e.abiParams(constructorParameters(n), false),
+					Payable: hasModifier(a.Modifiers.Modifiers, "payable"),
+				})
+				break
+			case token.Fallback:
+				entries = append(entries, ABIEntry{
+					Type:    "fallback",
+					Payable: hasModifier(a.Modifiers.Modifiers, "payable"),
+				})
+				break
+			}
+			break
+		case *ast.EventDeclarationNode:
+			entries = append(entries, ABIEntry{
+				Type:   "event",
+				Name:   a.Identifier,
+				Inputs: e.abiParams(a.Parameters, hasModifier(a.Modifiers.Modifiers, "indexed")),
+			})
+			break
+		}
+	}
+	return entries
+}
+
+func (e *GuardianEVM) funcEntry(n *ast.FuncDeclarationNode) ABIEntry {
+	params := parameters(n)
+	outputs := make([]ABIParam, 0)
+	for _, r := range results(n) {
+		outputs = append(outputs, e.abiParam(r.name, r.declared, r.resolved))
+	}
+	return ABIEntry{
+		Type:    "function",
+		Name:    n.Signature.Identifier,
+		Inputs:  e.abiParams(params, false),
+		Outputs: outputs,
+		Payable: hasModifier(n.Modifiers.Modifiers, "payable"),
+	}
+}
+
+type result struct {
+	name     string
+	declared ast.Node
+	resolved typing.Type
+}
+
+// results may be named or bare types, so are matched to their resolved types
+// by position
+func results(n *ast.FuncDeclarationNode) []result {
+	return signatureResults(n.Signature, n.Resolved)
+}
+
+func signatureResults(signature *ast.FuncTypeNode, f typing.Type) []result {
+	var resolved []typing.Type
+	if f, ok := f.(*typing.Func); ok && f.Results != nil {
+		resolved = f.Results.Types
+	}
+	var rs []result
+	for _, r := range signature.Results {
+		switch a := r.(type) {
+		case *ast.ExplicitVarDeclarationNode:
+			for _, id := range a.Identifiers {
+				rs = append(rs, result{id, a.DeclaredType, a.Resolved})
+			}
+			break
+		default:
+			var typ typing.Type = typing.Unknown()
+			if len(rs) < len(resolved) {
+				typ = resolved[len(rs)]
+			}
+			rs = append(rs, result{"", r, typ})
+			break
+		}
+	}
+	return rs
+}
+
+// resultTypes returns the ABI types of the results of a function
+func (e *GuardianEVM) resultTypes(n *ast.FuncDeclarationNode) []*abiValue {
+	var types []*abiValue
+	for _, r := range results(n) {
+		types = append(types, parseABIType(e.abiName(r.declared, r.resolved)))
+	}
+	return types
+}
+
+// an event marked indexed indexes all of its parameters
+func (e *GuardianEVM) abiParams(params []*ast.ExplicitVarDeclarationNode, indexed bool) []ABIParam {
+	inputs := make([]ABIParam, 0)
+	for _, p := range params {
+		for _, id := range p.Identifiers {
+			param := e.abiParam(id, p.DeclaredType, p.Resolved)
+			param.Indexed = indexed || hasModifier(p.Modifiers.Modifiers, "indexed")
+			inputs = append(inputs, param)
+		}
+	}
+	return inputs
+}
+
+// tuples are written as "tuple", with their members described as components
+func (e *GuardianEVM) abiParam(name string, declared ast.Node, resolved typing.Type) ABIParam {
+	p := ABIParam{
+		Name: name,
+		Type: e.abiName(declared, resolved),
+	}
+	if !strings.HasPrefix(p.Type, "(") {
+		return p
+	}
+	// strip any array dimensions to find the tuple itself
+	t := typing.ResolveUnderlying(resolved)
+	for {
+		a, ok := t.(*typing.Array)
+		if !ok {
+			break
+		}
+		t = typing.ResolveUnderlying(a.Value)
+	}
+	tuple, ok := t.(*typing.Tuple)
+	if !ok {
+		return p
+	}
+	p.Type = "tuple" + p.Type[len(e.abiName(nil, tuple)):]
+	p.Components = make([]ABIParam, 0)
+	for _, typ := range tuple.Types {
+		p.Components = append(p.Components, e.abiParam("", nil, typ))
+	}
+	return p
+}
+
+func (p ABIParam) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{
+		"name": p.Name,
+		"type": p.Type,
+	}
+	if p.Components != nil {
+		m["components"] = p.Components
+	}
+	return json.Marshal(m)
+}
+
+// each entry type has its own set of fields
+func (a ABIEntry) MarshalJSON() ([]byte, error) {
+	mutability := "nonpayable"
+	if a.Payable {
+		mutability = "payable"
+	}
+	m := map[string]interface{}{
+		"type": a.Type,
+	}
+	switch a.Type {
+	case "function":
+		m["name"] = a.Name
+		m["inputs"] = a.Inputs
+		m["outputs"] = a.Outputs
+		m["payable"] = a.Payable
+		m["constant"] = false
+		m["stateMutability"] = mutability
+		break
+	case "constructor":
+		m["inputs"] = a.Inputs
+		m["payable"] = a.Payable
+		m["stateMutability"] = mutability
+		break
+	case "fallback":
+		m["payable"] = a.Payable
+		m["stateMutability"] = mutability
+		break
+	case "event":
+		// event inputs must always state whether they are indexed
+		var inputs []map[string]interface{}
+		for _, p := range a.Inputs {
+			inputs = append(inputs, map[string]interface{}{
+				"name":    p.Name,
+				"type":    p.Type,
+				"indexed": p.Indexed,
+			})
+			if p.Components != nil {
+				inputs[len(inputs)-1]["components"] = p.Components
+			}
+		}
+		if inputs == nil {
+			inputs = make([]map[string]interface{}, 0)
+		}
+		m["name"] = a.Name
+		m["inputs"] = inputs
+		m["anonymous"] = false
+		break
+	}
+	return json.Marshal(m)
+}
+
+// abiKind classifies how a value is laid out in an ABI encoding
+type abiKind int
+
+const (
+	// values which fit in a single word
+	abiWord abiKind = iota
+	// dynamic byte arrays and strings
+	abiBytes
+	// arrays of fixed or variable length
+	abiArray
+	abiTuple
+)
+
+// abiValue describes a canonical ABI type, as produced by abiTypeOf
+type abiValue struct {
+	kind abiKind
+	// words: the number of significant bits, and how they are aligned
+	bits   uint
+	signed bool
+	left   bool
+	// arrays
+	elem     *abiValue
+	length   uint
+	variable bool
+	// tuples
+	components []*abiValue
+}
+
+func parseABIType(name string) *abiValue {
+	if strings.HasSuffix(name, "]") {
+		open := strings.LastIndex(name, "[")
+		elem := parseABIType(name[:open])
+		dims := name[open+1 : len(name)-1]
+		if dims == "" {
+			return &abiValue{kind: abiArray, elem: elem, variable: true}
+		}
+		length, _ := strconv.Atoi(dims)
+		return &abiValue{kind: abiArray, elem: elem, length: uint(length)}
+	}
+	if strings.HasPrefix(name, "(") {
+		t := &abiValue{kind: abiTuple}
+		for _, c := range splitTuple(name[1 : len(name)-1]) {
+			t.components = append(t.components, parseABIType(c))
+		}
+		return t
+	}
+	switch {
+	case name == "bytes", name == "string":
+		return &abiValue{kind: abiBytes}
+	case name == "bool":
+		return &abiValue{kind: abiWord, bits: 1}
+	case name == "address":
+		return &abiValue{kind: abiWord, bits: 160}
+	case strings.HasPrefix(name, "uint"):
+		return &abiValue{kind: abiWord, bits: abiBits(name[len("uint"):], 1)}
+	case strings.HasPrefix(name, "int"):
+		return &abiValue{kind: abiWord, bits: abiBits(name[len("int"):], 1), signed: true}
+	case strings.HasPrefix(name, "bytes"):
+		return &abiValue{kind: abiWord, bits: abiBits(name[len("bytes"):], 8), left: true}
+	}
+	return &abiValue{kind: abiWord, bits: wordSize}
+}
+
+func abiBits(size string, scale uint) uint {
+	n, err := strconv.Atoi(size)
+	if err != nil || n <= 0 {
+		return wordSize
+	}
+	return uint(n) * scale
+}
+
+// splitTuple splits the members of a tuple, ignoring nested commas
+func splitTuple(members string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range members {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, members[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if start < len(members) {
+		parts = append(parts, members[start:])
+	}
+	return parts
+}
+
+// dynamic values are encoded in the tail, and referenced by an offset
+func (t *abiValue) dynamic() bool {
+	switch t.kind {
+	case abiBytes:
+		return true
+	case abiArray:
+		return t.variable || t.elem.dynamic()
+	case abiTuple:
+		for _, c := range t.components {
+			if c.dynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headSize is the number of bytes a value occupies in the head of an encoding
+func (t *abiValue) headSize() uint {
+	if t.dynamic() {
+		return wordBytes
+	}
+	switch t.kind {
+	case abiArray:
+		return t.length * t.elem.headSize()
+	case abiTuple:
+		return tupleHeadSize(t.components)
+	}
+	return wordBytes
+}
+
+func tupleHeadSize(components []*abiValue) uint {
+	size := uint(0)
+	for _, c := range components {
+		size += c.headSize()
+	}
+	return size
+}
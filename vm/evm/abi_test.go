@@ -0,0 +1,193 @@
+package evm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/typing"
+	"github.com/end-r/guardian/validator"
+
+	"github.com/end-r/goutil"
+)
+
+func TestSelector(t *testing.T) {
+	goutil.Assert(t, hex.EncodeToString(Selector("transfer(address,uint256)")) == "a9059cbb", "wrong transfer selector")
+	goutil.Assert(t, hex.EncodeToString(Selector("balanceOf(address)")) == "70a08231", "wrong balanceOf selector")
+}
+
+func firstFunc(t *testing.T, e GuardianEVM, text string) *ast.FuncDeclarationNode {
+	scope, errs := validator.ValidateString(e, text)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c := scope.Declarations.Next().(*ast.ContractDeclarationNode)
+	return c.Body.Declarations.Next().(*ast.FuncDeclarationNode)
+}
+
+func TestFuncSignaturePrimitives(t *testing.T) {
+	e := NewVM()
+	f := firstFunc(t, e, `
+		contract Token {
+			external func give(to address, amount uint, small int8, ok bool) {}
+		}
+	`)
+	sig := e.funcSignature(f)
+	goutil.Assert(t, sig == "give(address,uint256,int8,bool)", sig)
+}
+
+func TestFuncSignatureSharedType(t *testing.T) {
+	e := NewVM()
+	f := firstFunc(t, e, `
+		contract Token {
+			external func add(a, b uint8) {}
+		}
+	`)
+	sig := e.funcSignature(f)
+	goutil.Assert(t, sig == "add(uint8,uint8)", sig)
+}
+
+func TestFuncSignatureArrays(t *testing.T) {
+	e := NewVM()
+	f := firstFunc(t, e, `
+		contract Token {
+			external func store(s string, data []byte, hash bytes32, sig [4]byte, nums [3]uint, all []address) {}
+		}
+	`)
+	sig := e.funcSignature(f)
+	goutil.Assert(t, sig == "store(string,bytes,bytes32,bytes4,uint256[3],address[])", sig)
+}
+
+func TestFuncSignatureEmpty(t *testing.T) {
+	e := NewVM()
+	f := firstFunc(t, e, `
+		contract Token {
+			external func total() uint {
+				return 0
+			}
+		}
+	`)
+	sig := e.funcSignature(f)
+	goutil.Assert(t, sig == "total()", sig)
+}
+
+func contractABI(t *testing.T, text string) []ABIEntry {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, text)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c := scope.Declarations.Next().(*ast.ContractDeclarationNode)
+	return e.ContractABI(c)
+}
+
+func TestContractABIFunctions(t *testing.T) {
+	entries := contractABI(t, `
+		contract Token {
+			external payable func buy(amount uint) bool {}
+			global func holdings(owner address) (total uint, frozen bool) {}
+			internal func helper() {}
+		}
+	`)
+	goutil.AssertNow(t, len(entries) == 2, "internal functions should not be described")
+	buy := entries[0]
+	goutil.Assert(t, buy.Type == "function" && buy.Name == "buy", "wrong buy entry")
+	goutil.Assert(t, buy.Payable, "buy should be payable")
+	goutil.AssertLength(t, len(buy.Inputs), 1)
+	goutil.Assert(t, buy.Inputs[0].Name == "amount" && buy.Inputs[0].Type == "uint256", "wrong buy input")
+	goutil.AssertLength(t, len(buy.Outputs), 1)
+	goutil.Assert(t, buy.Outputs[0].Name == "" && buy.Outputs[0].Type == "bool", "wrong buy output")
+	holdings := entries[1]
+	goutil.Assert(t, !holdings.Payable, "holdings should not be payable")
+	goutil.AssertLength(t, len(holdings.Outputs), 2)
+	goutil.Assert(t, holdings.Outputs[0].Name == "total" && holdings.Outputs[0].Type == "uint256", "wrong total output")
+	goutil.Assert(t, holdings.Outputs[1].Name == "frozen" && holdings.Outputs[1].Type == "bool", "wrong frozen output")
+}
+
+func TestContractABIEvents(t *testing.T) {
+	entries := contractABI(t, `
+		contract Token {
+			event Transfer(indexed from address, indexed to address, value uint)
+			indexed event Approval(owner address, spender address)
+		}
+	`)
+	goutil.AssertNow(t, len(entries) == 2, "wrong entry count")
+	transfer := entries[0]
+	goutil.Assert(t, transfer.Type == "event" && transfer.Name == "Transfer", "wrong transfer entry")
+	goutil.AssertLength(t, len(transfer.Inputs), 3)
+	goutil.Assert(t, transfer.Inputs[0].Indexed && transfer.Inputs[1].Indexed, "from and to should be indexed")
+	goutil.Assert(t, !transfer.Inputs[2].Indexed, "value should not be indexed")
+	approval := entries[1]
+	goutil.AssertLength(t, len(approval.Inputs), 2)
+	goutil.Assert(t, approval.Inputs[0].Indexed && approval.Inputs[1].Indexed, "all params should be indexed")
+}
+
+func TestContractABILifecycles(t *testing.T) {
+	entries := contractABI(t, `
+		contract Token {
+			payable constructor(supply uint, name string) {}
+			fallback() {}
+		}
+	`)
+	goutil.AssertNow(t, len(entries) == 2, "wrong entry count")
+	constructor := entries[0]
+	goutil.Assert(t, constructor.Type == "constructor", "wrong constructor type")
+	goutil.Assert(t, constructor.Payable, "constructor should be payable")
+	goutil.AssertLength(t, len(constructor.Inputs), 2)
+	goutil.Assert(t, constructor.Inputs[1].Type == "string", "wrong name type")
+	goutil.Assert(t, entries[1].Type == "fallback", "wrong fallback type")
+}
+
+func TestABIParamTuple(t *testing.T) {
+	e := NewVM()
+	u := &typing.NumericType{BitSize: 256, Integer: true}
+	tuple := typing.NewTuple(u, typing.Boolean())
+	p := e.abiParam("pair", nil, &typing.Array{Value: tuple, Variable: true})
+	goutil.Assert(t, p.Type == "tuple[]", p.Type)
+	goutil.AssertLength(t, len(p.Components), 2)
+	goutil.Assert(t, p.Components[0].Type == "uint256", p.Components[0].Type)
+	goutil.Assert(t, p.Components[1].Type == "bool", p.Components[1].Type)
+}
+
+func TestABITypeErrors(t *testing.T) {
+	e := NewVM()
+	_, err := e.abiType(&typing.Map{Key: typing.Boolean(), Value: typing.Boolean()})
+	goutil.Assert(t, err != nil, "maps have no abi type")
+	_, err = e.abiType(&typing.Array{Value: &typing.Class{Name: "Point"}, Variable: true})
+	goutil.Assert(t, err != nil, "arrays of classes have no abi type")
+	scope, errs := validator.ValidateString(e, `
+		contract Registry {
+			class Point {
+				var x uint
+			}
+			external func count(m map[string]uint) uint {
+				return 0
+			}
+			internal func origin() Point {
+				return Point{x: 0}
+			}
+		}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	_, errs = e.Compile(scope.GetDeclaration("Registry").(*ast.ContractDeclarationNode), false)
+	goutil.AssertNow(t, len(errs) == 1, errs.Format())
+	goutil.Assert(t, strings.Contains(errs.Format(), "Function count can be called from outside the contract"), errs.Format())
+}
+
+func TestABIJSON(t *testing.T) {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, `
+		contract Token {
+			event Transfer(indexed from address, value uint)
+			external func give(to address) {}
+		}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	data, err := e.ABI(scope.Declarations.Next().(*ast.ContractDeclarationNode))
+	goutil.AssertNow(t, err == nil, "failed to marshal abi")
+	var entries []map[string]interface{}
+	goutil.AssertNow(t, json.Unmarshal(data, &entries) == nil, "invalid json")
+	goutil.AssertNow(t, len(entries) == 2, string(data))
+	inputs := entries[0]["inputs"].([]interface{})
+	goutil.Assert(t, inputs[1].(map[string]interface{})["indexed"] == false, "event inputs should state indexed")
+	goutil.Assert(t, entries[1]["stateMutability"] == "nonpayable", "wrong mutability")
+	goutil.Assert(t, len(entries[1]["outputs"].([]interface{})) == 0, "outputs should be empty")
+}
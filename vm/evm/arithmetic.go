@@ -0,0 +1,317 @@
+package evm
+
+import (
+	"math/big"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/token"
+	"github.com/end-r/guardian/typing"
+	"github.com/end-r/vmgen"
+)
+
+// arithmetic is checked by default: +, - and * revert when their result
+// cannot be represented by the type of the expression
+// functions, constructors and contracts marked unchecked wrap instead, but
+// division by zero always reverts
+const (
+	checkedModifier   = "checked"
+	uncheckedModifier = "unchecked"
+)
+
+// uncheckedArithmetic reports whether arithmetic in a declaration wraps
+// declarations without either modifier use the mode of their contract
+func uncheckedArithmetic(contract *ast.ContractDeclarationNode, mods []string) bool {
+	if hasModifier(mods, uncheckedModifier) {
+		return true
+	}
+	if hasModifier(mods, checkedModifier) {
+		return false
+	}
+	return contract != nil && hasModifier(contract.Modifiers.Modifiers, uncheckedModifier)
+}
+
+// integerType returns the size and signedness of an integer type
+func integerType(t typing.Type) (bits uint, signed bool, ok bool) {
+	n, ok := typing.ResolveUnderlying(t).(*typing.NumericType)
+	if !ok || !n.Integer || n.BitSize <= 0 || uint(n.BitSize) > wordSize {
+		return 0, false, false
+	}
+	return uint(n.BitSize), n.Signed, true
+}
+
+func isInteger(t typing.Type) bool {
+	_, _, ok := integerType(t)
+	return ok
+}
+
+// arithmeticType returns the size of the integers produced by a binary
+// expression, which are signed if either operand is
+func arithmeticType(n *ast.BinaryExpressionNode) (bits uint, signed bool, ok bool) {
+	bits, signed, ok = integerType(n.Resolved)
+	if !ok {
+		return 0, false, false
+	}
+	for _, operand := range []ast.ExpressionNode{n.Left, n.Right} {
+		if _, s, ok := integerType(operand.ResolvedType()); ok && s {
+			signed = true
+		}
+	}
+	return bits, signed, true
+}
+
+// integers narrower than a word are kept in a canonical form on the stack:
+// unsigned integers have no bits set above their size, and signed integers
+// are sign-extended
+// truncate puts the word on top of the stack into that form, wrapping it if
+// it is out of range
+func truncate(bits uint, signed bool) (code vmgen.Bytecode) {
+	if bits >= wordSize {
+		return code
+	}
+	if signed {
+		code.Concat(push(encodeUint(bits/8 - 1)))
+		code.Add("SIGNEXTEND")
+		return code
+	}
+	code.Concat(push(encodeBig(sectionMask(bits))))
+	code.Add("AND")
+	return code
+}
+
+// nonZeroConstant reports whether an expression is an integer literal, or a
+// cast of one, which isn't zero
+func nonZeroConstant(n ast.ExpressionNode) bool {
+	switch a := n.(type) {
+	case *ast.LiteralNode:
+		if a.LiteralType != token.Integer {
+			return false
+		}
+		value, ok := new(big.Int).SetString(a.Data, 0)
+		return ok && value.Sign() != 0
+	case *ast.BinaryExpressionNode:
+		return a.Operator == token.As && nonZeroConstant(a.Left)
+	}
+	return false
+}
+
+// traverseArithmetic generates integer arithmetic with the left operand on
+// top of the stack and the right operand beneath it
+// it returns false for the operators and types it doesn't handle
+func (e *GuardianEVM) traverseArithmetic(n *ast.BinaryExpressionNode) (code vmgen.Bytecode, ok bool) {
+	bits, signed, ok := arithmeticType(n)
+	if !ok {
+		return code, false
+	}
+	switch n.Operator {
+	case token.Add, token.Sub, token.Mul:
+		if e.unchecked {
+			code.Concat(binaryOps[n.Operator](n))
+			code.Concat(truncate(bits, signed))
+			return code, true
+		}
+	case token.Shl:
+		// shifted bits beyond the size of the type are discarded
+		_, signed, _ := integerType(n.Resolved)
+		code.Concat(binaryOps[n.Operator](n))
+		code.Concat(truncate(bits, signed))
+		return code, true
+	case token.Div, token.Mod:
+		// the divisor is checked even in unchecked code, unless it is a
+		// constant which can't be zero
+		if !nonZeroConstant(n.Right) {
+			code.Add("DUP2")
+			code.Add("ISZERO")
+			code.Concat(e.revertIf())
+		}
+	default:
+		return code, false
+	}
+	switch n.Operator {
+	case token.Add:
+		code.Concat(e.checkedAdd(bits, signed))
+	case token.Sub:
+		code.Concat(e.checkedSub(bits, signed))
+	case token.Mul:
+		code.Concat(e.checkedMul(bits, signed))
+	case token.Div:
+		code.Concat(e.checkedDiv(bits, signed))
+	case token.Mod:
+		if signed {
+			code.Add("SMOD")
+		} else {
+			code.Add("MOD")
+		}
+	}
+	return code, true
+}
+
+func (e *GuardianEVM) checkedAdd(bits uint, signed bool) (code vmgen.Bytecode) {
+	if bits < wordSize {
+		// narrow operands can't overflow a word
+		code.Add("ADD")
+		code.Concat(e.revertIfOutside(bits, signed))
+		return code
+	}
+	if !signed {
+		// the sum wraps below either operand
+		code.Add("DUP2")
+		code.Add("ADD")
+		code.Add("DUP2")
+		code.Add("DUP2")
+		code.Add("LT")
+		code.Concat(e.revertIf())
+		code.Add("SWAP1")
+		code.Add("POP")
+		return code
+	}
+	// the sum is less than the left operand exactly when the right operand
+	// is negative
+	code.Add("DUP2")
+	code.Add("DUP2")
+	code.Add("ADD")
+	code.Add("DUP2")
+	code.Add("DUP2")
+	code.Add("SLT")
+	code.Concat(isNegative("DUP5"))
+	code.Add("XOR")
+	code.Concat(e.revertIf())
+	code.Concat(dropOperands())
+	return code
+}
+
+func (e *GuardianEVM) checkedSub(bits uint, signed bool) (code vmgen.Bytecode) {
+	if !signed {
+		// the right operand can't be larger than the left
+		code.Add("DUP2")
+		code.Add("DUP2")
+		code.Add("LT")
+		code.Concat(e.revertIf())
+		code.Add("SUB")
+		return code
+	}
+	if bits < wordSize {
+		code.Add("SUB")
+		code.Concat(e.revertIfOutside(bits, signed))
+		return code
+	}
+	// the difference is greater than the left operand exactly when the
+	// right operand is negative
+	code.Add("DUP2")
+	code.Add("DUP2")
+	code.Add("SUB")
+	code.Add("DUP2")
+	code.Add("DUP2")
+	code.Add("SGT")
+	code.Concat(isNegative("DUP5"))
+	code.Add("XOR")
+	code.Concat(e.revertIf())
+	code.Concat(dropOperands())
+	return code
+}
+
+func (e *GuardianEVM) checkedMul(bits uint, signed bool) (code vmgen.Bytecode) {
+	if bits <= wordSize/2 {
+		// the product of narrow operands fits in a word
+		code.Add("MUL")
+		code.Concat(e.revertIfOutside(bits, signed))
+		return code
+	}
+	// the product wrapped if dividing it by the non-zero left operand
+	// doesn't give the right operand
+	code.Add("DUP2")
+	code.Add("DUP2")
+	code.Add("MUL")
+	code.Add("DUP2")
+	code.Add("DUP2")
+	if signed {
+		code.Add("SDIV")
+	} else {
+		code.Add("DIV")
+	}
+	code.Add("DUP4")
+	code.Add("EQ")
+	code.Add("ISZERO")
+	code.Add("DUP3")
+	code.Add("ISZERO")
+	code.Add("ISZERO")
+	code.Add("AND")
+	if signed && bits == wordSize {
+		// -1 * min wraps to min, which passes the division check
+		code.Concat(push(encodeBig(minimumWord())))
+		code.Add("DUP5")
+		code.Add("EQ")
+		code.Concat(isMinusOne("DUP4"))
+		code.Add("AND")
+		code.Add("OR")
+	}
+	code.Concat(e.revertIf())
+	code.Concat(dropOperands())
+	if bits < wordSize {
+		code.Concat(e.revertIfOutside(bits, signed))
+	}
+	return code
+}
+
+func (e *GuardianEVM) checkedDiv(bits uint, signed bool) (code vmgen.Bytecode) {
+	if !signed {
+		code.Add("DIV")
+		return code
+	}
+	if e.unchecked {
+		code.Add("SDIV")
+		code.Concat(truncate(bits, signed))
+		return code
+	}
+	if bits < wordSize {
+		code.Add("SDIV")
+		code.Concat(e.revertIfOutside(bits, signed))
+		return code
+	}
+	// min / -1 wraps to min
+	code.Concat(isMinusOne("DUP2"))
+	code.Concat(push(encodeBig(minimumWord())))
+	code.Add("DUP3")
+	code.Add("EQ")
+	code.Add("AND")
+	code.Concat(e.revertIf())
+	code.Add("SDIV")
+	return code
+}
+
+// revertIfOutside reverts if the word on top of the stack is not a valid
+// integer of the given size, in the same way as decoded parameters
+func (e *GuardianEVM) revertIfOutside(bits uint, signed bool) vmgen.Bytecode {
+	return e.validateWord(&abiValue{kind: abiWord, bits: bits, signed: signed})
+}
+
+// isNegative pushes whether the item copied by a DUP instruction is
+// negative, where the instruction also counts the zero pushed before it
+func isNegative(dup string) (code vmgen.Bytecode) {
+	code.Concat(push([]byte{0}))
+	code.Add(dup)
+	code.Add("SLT")
+	return code
+}
+
+// isMinusOne pushes whether the item copied by a DUP instruction has every
+// bit set
+func isMinusOne(dup string) (code vmgen.Bytecode) {
+	code.Add(dup)
+	code.Add("NOT")
+	code.Add("ISZERO")
+	return code
+}
+
+// dropOperands leaves only the result of an operation which kept a copy of
+// both of its operands beneath it
+func dropOperands() (code vmgen.Bytecode) {
+	code.Add("SWAP2")
+	code.Add("POP")
+	code.Add("POP")
+	return code
+}
+
+// minimumWord returns the smallest signed word, as an unsigned integer
+func minimumWord() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), wordSize-1)
+}
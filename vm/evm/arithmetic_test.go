@@ -0,0 +1,266 @@
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/end-r/goutil"
+)
+
+// intWord encodes a signed integer as a word in two's complement
+func intWord(i int64) []byte {
+	return bigWord(new(big.Int).And(big.NewInt(i), maxUint256))
+}
+
+func bigWord(i *big.Int) []byte {
+	w := make([]byte, 32)
+	b := i.Bytes()
+	copy(w[32-len(b):], b)
+	return w
+}
+
+var (
+	maxUint256 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	maxInt256  = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(1))
+	minInt256  = new(big.Int).Lsh(big.NewInt(1), 255)
+)
+
+const arithmeticContract = `
+	contract Calculator {
+		external func add(a, b uint) uint {
+			return a + b
+		}
+		external func sub(a, b uint) uint {
+			return a - b
+		}
+		external func mul(a, b uint) uint {
+			return a * b
+		}
+		external func div(a, b uint) uint {
+			return a / b
+		}
+		external func mod(a, b uint) uint {
+			return a % b
+		}
+		external func add8(a, b uint8) uint8 {
+			return a + b
+		}
+		external func mul128(a, b uint128) uint128 {
+			return a * b
+		}
+		external func addSigned(a, b int) int {
+			return a + b
+		}
+		external func subSigned(a, b int) int {
+			return a - b
+		}
+		external func mulSigned(a, b int) int {
+			return a * b
+		}
+		external func divSigned(a, b int) int {
+			return a / b
+		}
+		external func sub8(a, b int8) int8 {
+			return a - b
+		}
+		unchecked external func wrap(a, b uint) uint {
+			return a + b
+		}
+		unchecked external func wrapDiv(a, b uint) uint {
+			return a / b
+		}
+	}
+`
+
+func TestCheckedArithmetic(t *testing.T) {
+	chain, a := execute(t, "Calculator", arithmeticContract)
+	tests := []struct {
+		signature string
+		args      [][]byte
+		result    []byte
+	}{
+		{"add(uint256,uint256)", [][]byte{uintWord(4), uintWord(5)}, uintWord(9)},
+		{"add(uint256,uint256)", [][]byte{bigWord(maxUint256), uintWord(1)}, nil},
+		{"sub(uint256,uint256)", [][]byte{uintWord(5), uintWord(3)}, uintWord(2)},
+		{"sub(uint256,uint256)", [][]byte{uintWord(3), uintWord(5)}, nil},
+		{"mul(uint256,uint256)", [][]byte{uintWord(6), uintWord(7)}, uintWord(42)},
+		{"mul(uint256,uint256)", [][]byte{uintWord(0), bigWord(maxUint256)}, uintWord(0)},
+		{"mul(uint256,uint256)", [][]byte{bigWord(minInt256), uintWord(2)}, nil},
+		{"div(uint256,uint256)", [][]byte{uintWord(7), uintWord(2)}, uintWord(3)},
+		{"div(uint256,uint256)", [][]byte{uintWord(7), uintWord(0)}, nil},
+		{"mod(uint256,uint256)", [][]byte{uintWord(7), uintWord(0)}, nil},
+		{"add8(uint8,uint8)", [][]byte{uintWord(100), uintWord(155)}, uintWord(255)},
+		{"add8(uint8,uint8)", [][]byte{uintWord(100), uintWord(156)}, nil},
+		{"mul128(uint128,uint128)", [][]byte{bigWord(new(big.Int).Lsh(big.NewInt(1), 127)), uintWord(2)}, nil},
+		{"addSigned(int256,int256)", [][]byte{intWord(-1), intWord(-1)}, intWord(-2)},
+		{"addSigned(int256,int256)", [][]byte{bigWord(maxInt256), intWord(1)}, nil},
+		{"addSigned(int256,int256)", [][]byte{bigWord(minInt256), intWord(-1)}, nil},
+		{"subSigned(int256,int256)", [][]byte{intWord(3), intWord(5)}, intWord(-2)},
+		{"subSigned(int256,int256)", [][]byte{bigWord(minInt256), intWord(1)}, nil},
+		{"subSigned(int256,int256)", [][]byte{bigWord(maxInt256), intWord(-1)}, nil},
+		{"mulSigned(int256,int256)", [][]byte{intWord(-3), intWord(4)}, intWord(-12)},
+		{"mulSigned(int256,int256)", [][]byte{intWord(-1), bigWord(minInt256)}, nil},
+		{"mulSigned(int256,int256)", [][]byte{bigWord(minInt256), intWord(-1)}, nil},
+		{"divSigned(int256,int256)", [][]byte{intWord(-7), intWord(2)}, intWord(-3)},
+		{"divSigned(int256,int256)", [][]byte{bigWord(minInt256), intWord(-1)}, nil},
+		{"sub8(int8,int8)", [][]byte{intWord(-100), intWord(28)}, intWord(-128)},
+		{"sub8(int8,int8)", [][]byte{intWord(-100), intWord(29)}, nil},
+		{"wrap(uint256,uint256)", [][]byte{bigWord(maxUint256), uintWord(2)}, uintWord(1)},
+		{"wrapDiv(uint256,uint256)", [][]byte{uintWord(7), uintWord(0)}, nil},
+	}
+	for _, test := range tests {
+		r := send(chain, a, test.signature, test.args...)
+		if test.result == nil {
+			goutil.Assert(t, r.Reverted(), fmt.Sprintf("%s %x should revert", test.signature, test.args))
+			continue
+		}
+		goutil.Assert(t, r.Err == nil, fmt.Sprintf("%s %x: %s", test.signature, test.args, r.Err))
+		goutil.Assert(t, bytes.Equal(r.Output, test.result), fmt.Sprintf("%s %x: wrong result %x", test.signature, test.args, r.Output))
+	}
+}
+
+func TestUncheckedContract(t *testing.T) {
+	chain, a := execute(t, "Counter", `
+		unchecked contract Counter {
+			var count uint
+			external func decrement() {
+				count -= 1
+			}
+			checked external func safeDecrement() {
+				count -= 1
+			}
+			external func get() uint {
+				return count
+			}
+		}
+	`)
+	r := send(chain, a, "safeDecrement()")
+	goutil.Assert(t, r.Reverted(), "checked functions should revert")
+	r = send(chain, a, "decrement()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, a, "get()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, bigWord(maxUint256)), fmt.Sprintf("wrong result %x", r.Output))
+}
+
+func TestCheckedCompoundAssignment(t *testing.T) {
+	chain, a := execute(t, "Bank", `
+		contract Bank {
+			var total uint
+			external func deposit(amount uint) uint {
+				total += amount
+				return total
+			}
+			external func withdraw(amount uint) uint {
+				total -= amount
+				return total
+			}
+		}
+	`)
+	r := send(chain, a, "deposit(uint256)", uintWord(10))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(10)), fmt.Sprintf("wrong result %x", r.Output))
+	r = send(chain, a, "withdraw(uint256)", uintWord(4))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(6)), fmt.Sprintf("wrong result %x", r.Output))
+	r = send(chain, a, "withdraw(uint256)", uintWord(7))
+	goutil.Assert(t, r.Reverted(), "withdrawing more than the total should revert")
+	r = send(chain, a, "deposit(uint256)", bigWord(maxUint256))
+	goutil.Assert(t, r.Reverted(), "overflowing the total should revert")
+}
+
+func TestNarrowIntegers(t *testing.T) {
+	chain, a := execute(t, "Narrow", `
+		contract Narrow {
+			var small uint8
+			var signed int8
+			var large uint16
+			external func less() bool {
+				return int8(-1) < int8(0)
+			}
+			external func lessEqual(a, b int8) bool {
+				return a <= b
+			}
+			external func notEqual(a, b int8) bool {
+				return a != b
+			}
+			external func narrow(a uint) uint8 {
+				return uint8(a)
+			}
+			external func narrowSigned(a int) int8 {
+				return int8(a)
+			}
+			external func widen(a int8) uint16 {
+				return uint16(a)
+			}
+			external func invert(a uint8) uint8 {
+				return !a
+			}
+			external func flip(a bool) bool {
+				return !a
+			}
+			external func shift(a uint8) uint8 {
+				return a << 4
+			}
+			external func shiftSigned(a int8) int8 {
+				return a >> 1
+			}
+			unchecked external func wrap(a, b uint8) uint8 {
+				return a + b
+			}
+			unchecked external func wrapSigned(a, b int8) int8 {
+				return a - b
+			}
+			external func pack(a uint8, b int8, c uint16) {
+				small = a
+				signed = b
+				large = c
+			}
+			external func unpack() (uint8, int8, uint16) {
+				return small, signed, large
+			}
+			external func isNegative() bool {
+				return signed < 0
+			}
+		}
+	`)
+	tests := []struct {
+		signature string
+		args      [][]byte
+		result    []byte
+	}{
+		{"less()", nil, uintWord(1)},
+		{"lessEqual(int8,int8)", [][]byte{intWord(-1), intWord(1)}, uintWord(1)},
+		{"lessEqual(int8,int8)", [][]byte{intWord(1), intWord(-1)}, uintWord(0)},
+		{"lessEqual(int8,int8)", [][]byte{intWord(-1), intWord(-1)}, uintWord(1)},
+		{"notEqual(int8,int8)", [][]byte{intWord(-1), intWord(-1)}, uintWord(0)},
+		{"notEqual(int8,int8)", [][]byte{intWord(-1), intWord(1)}, uintWord(1)},
+		{"narrow(uint256)", [][]byte{uintWord(0x1ff)}, uintWord(0xff)},
+		{"narrowSigned(int256)", [][]byte{uintWord(0xff)}, intWord(-1)},
+		{"narrowSigned(int256)", [][]byte{intWord(-129)}, intWord(127)},
+		{"widen(int8)", [][]byte{intWord(-1)}, uintWord(0xffff)},
+		{"invert(uint8)", [][]byte{uintWord(0x0f)}, uintWord(0xf0)},
+		{"flip(bool)", [][]byte{uintWord(1)}, uintWord(0)},
+		{"flip(bool)", [][]byte{uintWord(0)}, uintWord(1)},
+		{"shift(uint8)", [][]byte{uintWord(0x3c)}, uintWord(0xc0)},
+		{"shiftSigned(int8)", [][]byte{intWord(-8)}, intWord(-4)},
+		{"wrap(uint8,uint8)", [][]byte{uintWord(200), uintWord(100)}, uintWord(44)},
+		{"wrapSigned(int8,int8)", [][]byte{intWord(-128), intWord(1)}, intWord(127)},
+	}
+	for _, test := range tests {
+		r := send(chain, a, test.signature, test.args...)
+		goutil.AssertNow(t, r.Err == nil, fmt.Sprintf("%s %x: %s", test.signature, test.args, r.Err))
+		goutil.Assert(t, bytes.Equal(r.Output, test.result), fmt.Sprintf("%s %x: wrong result %x", test.signature, test.args, r.Output))
+	}
+	r := send(chain, a, "pack(uint8,int8,uint16)", uintWord(0xab), intWord(-2), uintWord(0x1234))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, a, "unpack()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	expected := append(append(uintWord(0xab), intWord(-2)...), uintWord(0x1234)...)
+	goutil.Assert(t, bytes.Equal(r.Output, expected), fmt.Sprintf("wrong fields %x", r.Output))
+	r = send(chain, a, "isNegative()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(1)), fmt.Sprintf("wrong result %x", r.Output))
+}
@@ -0,0 +1,224 @@
+package evm
+
+import (
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/typing"
+	"github.com/end-r/vmgen"
+)
+
+// arrays in memory are referred to by a pointer to their elements, one word
+// per element, which are preceded by the length if it is variable
+// strings and byte arrays are preceded by their length, and pack their bytes
+// memoryArray describes how an array of a type is held in memory
+type memoryArray struct {
+	variable bool
+	bytes    bool
+	length   uint
+}
+
+func memoryArrayOf(t typing.Type) (m memoryArray, ok bool) {
+	a, ok := typing.ResolveUnderlying(t).(*typing.Array)
+	if !ok {
+		return m, false
+	}
+	m.variable = a.Variable
+	m.bytes = isByteArray(t)
+	if !a.Variable && a.Length > 0 {
+		m.length = uint(a.Length)
+	}
+	return m, true
+}
+
+// isByteArray reports whether a type is held as packed bytes
+// aliases are resolved by the validator, so strings and byte arrays are
+// found by their elements: byte is an int8
+func isByteArray(t typing.Type) bool {
+	a, ok := typing.ResolveUnderlying(t).(*typing.Array)
+	if !ok || !a.Variable {
+		return false
+	}
+	bits, signed, ok := integerType(a.Value)
+	return ok && bits == 8 && signed
+}
+
+// data is the offset of the first element from the pointer
+func (m memoryArray) data() uint {
+	if m.variable {
+		return wordBytes
+	}
+	return 0
+}
+
+// pushLength pushes the length of the array whose pointer is copied by a
+// DUP instruction
+func (m memoryArray) pushLength(dup string) (code vmgen.Bytecode) {
+	if m.variable {
+		code.Add(dup)
+		code.Add("MLOAD")
+		return code
+	}
+	code.Concat(push(encodeUint(m.length)))
+	return code
+}
+
+// locateElement replaces the index on top of the stack and the pointer to an
+// array beneath it with the address of the element, reverting if the index
+// is out of bounds
+// the elements of byte arrays are addressed by the word which begins with them
+func (e *GuardianEVM) locateElement(m memoryArray) (code vmgen.Bytecode) {
+	// index, pointer
+	code.Concat(m.pushLength("DUP2"))
+	code.Concat(e.checkIndex())
+	if !m.bytes {
+		code.Concat(push([]byte{5}))
+		code.Add("SHL")
+	}
+	code.Add("ADD")
+	code.Concat(addConstant(m.data()))
+	return code
+}
+
+// locateMemory pushes the address of an element of an array in memory
+func (e *GuardianEVM) locateMemory(n *ast.IndexExpressionNode) (code vmgen.Bytecode, m memoryArray, ok bool) {
+	m, ok = memoryArrayOf(n.Expression.ResolvedType())
+	if !ok {
+		return code, m, false
+	}
+	code.Concat(e.traverseExpression(n.Expression))
+	code.Concat(e.traverseExpression(n.Index))
+	code.Concat(e.locateElement(m))
+	return code, m, true
+}
+
+// traverseMemoryIndex loads an element of an array in memory
+func (e *GuardianEVM) traverseMemoryIndex(n *ast.IndexExpressionNode) (code vmgen.Bytecode, ok bool) {
+	code, m, ok := e.locateMemory(n)
+	if !ok {
+		return code, false
+	}
+	code.Add("MLOAD")
+	if m.bytes {
+		code.Concat(push([]byte{0}))
+		code.Add("BYTE")
+		if bits, signed, ok := integerType(n.Resolved); ok {
+			code.Concat(truncate(bits, signed))
+		}
+	}
+	return code, true
+}
+
+// slices of arrays in memory are copied to a new variable array
+// indices which are out of order or out of bounds revert
+func (e *GuardianEVM) traverseMemorySlice(n *ast.SliceExpressionNode) (code vmgen.Bytecode, ok bool) {
+	m, ok := memoryArrayOf(n.Expression.ResolvedType())
+	if !ok {
+		return code, false
+	}
+	code.Concat(e.traverseExpression(n.Expression))
+	if n.Low != nil {
+		code.Concat(e.traverseExpression(n.Low))
+	} else {
+		code.Concat(push([]byte{0}))
+	}
+	if n.High != nil {
+		code.Concat(e.traverseExpression(n.High))
+	} else {
+		code.Concat(m.pushLength("DUP2"))
+	}
+	// high, low, pointer
+	code.Concat(m.pushLength("DUP3"))
+	code.Add("DUP2")
+	code.Add("GT")
+	code.Concat(e.revertIf())
+	code.Add("DUP2")
+	code.Add("DUP2")
+	code.Add("LT")
+	code.Concat(e.revertIf())
+	code.Add("DUP2")
+	code.Add("SWAP1")
+	code.Add("SUB")
+	// length, low, pointer
+	code.Add("DUP1")
+	if m.bytes {
+		code.Concat(roundToWord())
+		code.Concat(addConstant(wordBytes))
+	} else {
+		code.Concat(addConstant(1))
+		code.Concat(push([]byte{5}))
+		code.Add("SHL")
+	}
+	code.Concat(allocate())
+	code.Add("DUP2")
+	code.Add("DUP2")
+	code.Add("MSTORE")
+	// slice, length, low, pointer
+	code.Add("DUP1")
+	code.Concat(addConstant(wordBytes))
+	code.Add("DUP4")
+	if !m.bytes {
+		code.Concat(push([]byte{5}))
+		code.Add("SHL")
+	}
+	code.Add("DUP6")
+	code.Add("ADD")
+	code.Concat(addConstant(m.data()))
+	code.Add("DUP4")
+	if m.bytes {
+		code.Concat(addConstant(wordBytes - 1))
+		code.Concat(push([]byte{5}))
+		code.Add("SHR")
+	}
+	code.Concat(e.copyWords())
+	if m.bytes {
+		// clear the bytes copied after the end of the slice
+		code.Concat(push([]byte{0}))
+		code.Add("DUP3")
+		code.Add("DUP3")
+		code.Add("ADD")
+		code.Concat(addConstant(wordBytes))
+		code.Add("MSTORE")
+	}
+	code.Add("SWAP3")
+	code.Add("POP")
+	code.Add("POP")
+	code.Add("POP")
+	return code, true
+}
+
+// appendMemory replaces the value on top of the stack and the pointer to a
+// variable array beneath it with a pointer to a copy of the array, which
+// has the value as its last element
+func (e *GuardianEVM) appendMemory() (code vmgen.Bytecode) {
+	// value, pointer
+	code.Add("DUP2")
+	code.Add("MLOAD")
+	code.Add("DUP1")
+	code.Concat(addConstant(2))
+	code.Concat(push([]byte{5}))
+	code.Add("SHL")
+	code.Concat(allocate())
+	code.Add("DUP2")
+	code.Concat(addConstant(1))
+	code.Add("DUP2")
+	code.Add("MSTORE")
+	// copy, length, value, pointer
+	code.Add("DUP1")
+	code.Concat(addConstant(wordBytes))
+	code.Add("DUP5")
+	code.Concat(addConstant(wordBytes))
+	code.Add("DUP4")
+	code.Concat(e.copyWords())
+	code.Add("SWAP1")
+	code.Concat(push([]byte{5}))
+	code.Add("SHL")
+	code.Add("DUP2")
+	code.Add("ADD")
+	code.Concat(addConstant(wordBytes))
+	code.Add("DUP3")
+	code.Add("SWAP1")
+	code.Add("MSTORE")
+	code.Add("SWAP2")
+	code.Add("POP")
+	code.Add("POP")
+	return code
+}
@@ -0,0 +1,110 @@
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/end-r/goutil"
+)
+
+// wordArray encodes a variable array of words as the only parameter
+func wordArray(values ...int64) [][]byte {
+	return withArray(nil, values...)
+}
+
+// withArray encodes static parameters followed by a variable array of words
+func withArray(static [][]byte, values ...int64) [][]byte {
+	words := [][]byte{uintWord(int64(32 * (len(static) + 1)))}
+	words = append(words, static...)
+	words = append(words, uintWord(int64(len(values))))
+	for _, v := range values {
+		words = append(words, uintWord(v))
+	}
+	return words
+}
+
+func TestMemoryArrays(t *testing.T) {
+	chain, a := execute(t, "Arrays", `
+		contract Arrays {
+			external func get(xs []uint, i uint) uint {
+				return xs[i]
+			}
+			external func set(xs []uint, i, x uint) []uint {
+				xs[i] = x
+				return xs
+			}
+			external func count(xs []uint) int {
+				return len(xs)
+			}
+			external func add(xs []uint, x uint) []uint {
+				return append(xs, x)
+			}
+			external func slice(xs []uint, low, high uint) []uint {
+				return xs[low:high]
+			}
+			external func tail(xs []uint, low uint) []uint {
+				return xs[low:]
+			}
+		}
+	`)
+	tests := []struct {
+		signature string
+		args      [][]byte
+		result    []byte
+	}{
+		{"get(uint256[],uint256)", withArray([][]byte{uintWord(1)}, 4, 5, 6), uintWord(5)},
+		{"get(uint256[],uint256)", withArray([][]byte{uintWord(3)}, 4, 5, 6), nil},
+		{"set(uint256[],uint256,uint256)", withArray([][]byte{uintWord(0), uintWord(9)}, 4, 5), bytes.Join(wordArray(9, 5), nil)},
+		{"count(uint256[])", wordArray(4, 5, 6), uintWord(3)},
+		{"add(uint256[],uint256)", withArray([][]byte{uintWord(6)}, 4, 5), bytes.Join(wordArray(4, 5, 6), nil)},
+		{"slice(uint256[],uint256,uint256)", withArray([][]byte{uintWord(1), uintWord(3)}, 4, 5, 6, 7), bytes.Join(wordArray(5, 6), nil)},
+		{"slice(uint256[],uint256,uint256)", withArray([][]byte{uintWord(1), uintWord(3)}, 4, 5), nil},
+		{"slice(uint256[],uint256,uint256)", withArray([][]byte{uintWord(2), uintWord(1)}, 4, 5), nil},
+		{"tail(uint256[],uint256)", withArray([][]byte{uintWord(1)}, 4, 5, 6), bytes.Join(wordArray(5, 6), nil)},
+	}
+	for _, test := range tests {
+		r := send(chain, a, test.signature, test.args...)
+		if test.result == nil {
+			goutil.Assert(t, r.Reverted(), fmt.Sprintf("%s %x should revert", test.signature, test.args))
+			continue
+		}
+		goutil.Assert(t, r.Err == nil, fmt.Sprintf("%s %x: %s", test.signature, test.args, r.Err))
+		goutil.Assert(t, bytes.Equal(r.Output, test.result), fmt.Sprintf("%s %x: wrong result %x", test.signature, test.args, r.Output))
+	}
+}
+
+func TestMemoryStrings(t *testing.T) {
+	chain, a := execute(t, "Strings", `
+		contract Strings {
+			external func at(s string, i uint) byte {
+				return s[i]
+			}
+			external func slice(s string, low, high uint) string {
+				return s[low:high]
+			}
+			external func size(s string) int {
+				return len(s)
+			}
+		}
+	`)
+	text := func(static int, s string) [][]byte {
+		data := make([]byte, wordBytes)
+		copy(data, s)
+		return [][]byte{uintWord(int64(32 * (static + 1))), uintWord(int64(len(s))), data}
+	}
+	hello := text(1, "hello")
+	r := send(chain, a, "at(string,uint256)", hello[0], uintWord(1), hello[1], hello[2])
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord('e')), fmt.Sprintf("wrong byte %x", r.Output))
+	r = send(chain, a, "at(string,uint256)", hello[0], uintWord(5), hello[1], hello[2])
+	goutil.Assert(t, r.Reverted(), "indexing beyond the length should revert")
+	hello = text(2, "hello")
+	r = send(chain, a, "slice(string,uint256,uint256)", hello[0], uintWord(1), uintWord(4), hello[1], hello[2])
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	expected := text(0, "ell")
+	goutil.Assert(t, bytes.Equal(r.Output, bytes.Join(expected, nil)), fmt.Sprintf("wrong slice %x", r.Output))
+	r = send(chain, a, "size(string)", text(0, "hello")...)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(5)), fmt.Sprintf("wrong length %x", r.Output))
+}
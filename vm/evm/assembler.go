@@ -0,0 +1,161 @@
+package evm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/util"
+	"github.com/end-r/vmgen"
+)
+
+// generated bytecode is made up of instructions and markers:
+// - JUMPDEST markers are jump destinations, which define a label
+// - LABEL markers define a label without occupying any space
+// - PUSH markers push the offset of a label
+// - SOURCE markers record the source node which the following code was
+//   generated from, and occupy no space
+// - JUMP markers are jumps into or out of a function
+// every label resolves to its offset within the section which defines it, so
+// separately assembled sections, such as creation and runtime code, can refer
+// to each other's labels
+
+// Assemble lowers generated bytecode to raw bytes, resolving every label
+func (evm GuardianEVM) Assemble(code vmgen.Bytecode) ([]byte, util.Errors) {
+	sections, errs := assemble(code)
+	if errs != nil {
+		return nil, errs
+	}
+	return sections[0].code, nil
+}
+
+// AssembleContract lowers the artifacts of a contract to the code which is
+// sent to deploy it: the creation code followed by the runtime code
+func (evm GuardianEVM) AssembleContract(a Artifacts) ([]byte, util.Errors) {
+	sections, errs := assemble(a.Creation, a.Runtime)
+	if errs != nil {
+		return nil, errs
+	}
+	return append(sections[0].code, sections[1].code...), nil
+}
+
+// Compile generates and assembles a validated contract: its creation code,
+// or its runtime code if runtime is set
+func (evm GuardianEVM) Compile(n *ast.ContractDeclarationNode, runtime bool) ([]byte, util.Errors) {
+	a, errs := evm.Deploy(n)
+	if errs != nil {
+		return nil, errs
+	}
+	if runtime {
+		return evm.Assemble(a.Runtime)
+	}
+	return evm.AssembleContract(a)
+}
+
+type labelReference struct {
+	section, offset, size, label int
+}
+
+// sourceMark records that the code from an offset was generated from a
+// source node, or from no node if the index is negative
+type sourceMark struct {
+	offset, source int
+}
+
+// assembly is a single assembled section of code
+type assembly struct {
+	code    []byte
+	sources []sourceMark
+	// the kind of each marked jump, by offset
+	jumps map[int]int
+}
+
+func assemble(sections ...vmgen.Bytecode) ([]assembly, util.Errors) {
+	var errs util.Errors
+	addError := func(format string, args ...interface{}) {
+		errs = append(errs, util.Error{
+			Message: fmt.Sprintf(format, args...),
+			Stage:   util.Generation,
+		})
+	}
+	labels := make(map[int]int)
+	var references []labelReference
+	out := make([]assembly, len(sections))
+	for s, code := range sections {
+		var raw []byte
+		var sources []sourceMark
+		jumps := make(map[int]int)
+		for _, c := range code.Commands {
+			if c.IsMarker {
+				switch {
+				case c.Mnemonic == "SOURCE":
+					sources = append(sources, sourceMark{offset: len(raw), source: c.Offset})
+					break
+				case c.Mnemonic == "JUMP":
+					jumps[len(raw)] = c.Offset
+					raw = append(raw, instructions["JUMP"].Opcode)
+					break
+				case c.Mnemonic == "LABEL", c.Mnemonic == "JUMPDEST":
+					if _, ok := labels[c.Offset]; ok {
+						addError("Label %d is defined more than once", c.Offset)
+					}
+					labels[c.Offset] = len(raw)
+					if c.Mnemonic == "JUMPDEST" {
+						raw = append(raw, instructions["JUMPDEST"].Opcode)
+					}
+					break
+				case strings.HasPrefix(c.Mnemonic, "PUSH"):
+					i, ok := instructions[c.Mnemonic]
+					if !ok {
+						addError("Unknown instruction %s", c.Mnemonic)
+						break
+					}
+					raw = append(raw, i.Opcode)
+					references = append(references, labelReference{
+						section: s,
+						offset:  len(raw),
+						size:    i.Size() - 1,
+						label:   c.Offset,
+					})
+					raw = append(raw, make([]byte, i.Size()-1)...)
+					break
+				default:
+					addError("Unknown marker %s", c.Mnemonic)
+				}
+				continue
+			}
+			i, ok := instructions[c.Mnemonic]
+			if !ok {
+				addError("Unknown instruction %s", c.Mnemonic)
+				continue
+			}
+			raw = append(raw, i.Opcode)
+			size := i.Size() - 1
+			if len(c.Parameters) > size {
+				addError("Instruction %s cannot take %d bytes of data", c.Mnemonic, len(c.Parameters))
+				continue
+			}
+			// immediate data is padded on the left
+			raw = append(raw, make([]byte, size-len(c.Parameters))...)
+			raw = append(raw, c.Parameters...)
+		}
+		out[s] = assembly{code: raw, sources: sources, jumps: jumps}
+	}
+	for _, r := range references {
+		offset, ok := labels[r.label]
+		if !ok {
+			addError("Label %d is never defined", r.label)
+			continue
+		}
+		data := encodeUint(uint(offset))
+		if len(data) > r.size {
+			addError("Label %d at offset %d does not fit in %d bytes", r.label, offset, r.size)
+			continue
+		}
+		copy(out[r.section].code[r.offset+r.size-len(data):], data)
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return out, nil
+}
@@ -0,0 +1,161 @@
+package evm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/vmgen"
+)
+
+func assembleHex(t *testing.T, code vmgen.Bytecode) string {
+	raw, errs := NewVM().Assemble(code)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	return hex.EncodeToString(raw)
+}
+
+func TestAssembleInstructions(t *testing.T) {
+	var code vmgen.Bytecode
+	code.Add("PUSH1", 1)
+	code.Add("PUSH1", 2)
+	code.Add("ADD")
+	code.Add("STOP")
+	h := assembleHex(t, code)
+	goutil.Assert(t, h == "600160020100", h)
+}
+
+func TestAssemblePadsPushData(t *testing.T) {
+	var code vmgen.Bytecode
+	code.Add("PUSH2", 5)
+	h := assembleHex(t, code)
+	goutil.Assert(t, h == "610005", h)
+}
+
+func TestAssembleLabels(t *testing.T) {
+	e := NewVM()
+	l := e.newLabel()
+	var code vmgen.Bytecode
+	code.Concat(pushLabel(l))
+	code.Add("JUMP")
+	code.Add("INVALID")
+	code.Concat(jumpdest(l))
+	code.Add("STOP")
+	h := assembleHex(t, code)
+	goutil.Assert(t, h == "61000556fe5b00", h)
+}
+
+func TestAssembleCodeLabelsTakeNoSpace(t *testing.T) {
+	e := NewVM()
+	l := e.newLabel()
+	var code vmgen.Bytecode
+	code.Add("STOP")
+	code.Concat(codeLabel(l))
+	code.Concat(pushLabel(l))
+	h := assembleHex(t, code)
+	goutil.Assert(t, h == "00610001", h)
+}
+
+func TestAssembleUnknownInstruction(t *testing.T) {
+	var code vmgen.Bytecode
+	code.Add("CALLDATA")
+	_, errs := NewVM().Assemble(code)
+	goutil.AssertNow(t, len(errs) == 1, "unknown instructions should be reported")
+	goutil.Assert(t, errs[0].Message == "Unknown instruction CALLDATA", errs[0].Message)
+}
+
+func TestAssembleUndefinedLabel(t *testing.T) {
+	var code vmgen.Bytecode
+	code.Concat(pushLabel(7))
+	_, errs := NewVM().Assemble(code)
+	goutil.Assert(t, len(errs) == 1, "undefined labels should be reported")
+}
+
+func TestAssembleOversizedPushData(t *testing.T) {
+	var code vmgen.Bytecode
+	code.Add("PUSH1", 1, 2)
+	_, errs := NewVM().Assemble(code)
+	goutil.Assert(t, len(errs) == 1, "oversized data should be reported")
+}
+
+func TestAssembleContractSections(t *testing.T) {
+	e := NewVM()
+	end := e.newLabel()
+	var creation, runtime vmgen.Bytecode
+	// the creation code refers to the end of the runtime code
+	creation.Concat(pushLabel(end))
+	creation.Add("STOP")
+	runtime.Add("CALLER")
+	runtime.Add("STOP")
+	runtime.Concat(codeLabel(end))
+	raw, errs := e.AssembleContract(Artifacts{Creation: creation, Runtime: runtime})
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	h := hex.EncodeToString(raw)
+	goutil.Assert(t, h == "610002003300", h)
+}
+
+// every pushed label which is jumped to must be a jump destination
+func checkJumps(t *testing.T, raw []byte) {
+	for i := 0; i < len(raw); {
+		op := opcodes[raw[i]]
+		size := op.Size()
+		if raw[i] == 0x61 && i+3 < len(raw) && (raw[i+3] == 0x56 || raw[i+3] == 0x57) {
+			target := int(raw[i+1])<<8 | int(raw[i+2])
+			goutil.AssertNow(t, target < len(raw) && raw[target] == 0x5B, "jump to a non-destination")
+		}
+		i += size
+	}
+}
+
+func TestAssembleGeneratedContract(t *testing.T) {
+	a := deploy(t, "Token", `
+		contract Token {
+			var supply uint
+			var owner address
+			event Transfer(indexed from address, value uint)
+
+			constructor(initial uint) {
+				supply = initial
+			}
+
+			external func total() uint {
+				return supply
+			}
+
+			external func echo(s string, n []uint) (string, []uint) {
+				return s, n
+			}
+
+			external func check(a uint) bool {
+				if a > 5 {
+					return true
+				}
+				return false
+			}
+		}
+	`)
+	e := NewVM()
+	runtime, errs := e.Assemble(a.Runtime)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	checkJumps(t, runtime)
+	raw, errs := e.AssembleContract(a)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	goutil.Assert(t, bytes.HasSuffix(raw, runtime), "runtime code should follow the creation code")
+}
+
+func TestInstructionTable(t *testing.T) {
+	seen := make(map[byte]string)
+	for name, i := range instructions {
+		goutil.Assert(t, i.Mnemonic == name, "wrong mnemonic for "+name)
+		other, ok := seen[i.Opcode]
+		goutil.Assert(t, !ok, "opcode shared by "+name+" and "+other)
+		seen[i.Opcode] = name
+	}
+	goutil.Assert(t, instructions["PUSH32"].Size() == 33, "wrong PUSH32 size")
+	goutil.Assert(t, instructions["ADD"].Size() == 1, "wrong ADD size")
+	goutil.Assert(t, instructions["DUP3"].Pops == 3 && instructions["DUP3"].Pushes == 4, "wrong DUP3 stack")
+	goutil.Assert(t, instructions["SWAP2"].Pops == 3 && instructions["SWAP2"].Pushes == 3, "wrong SWAP2 stack")
+	goutil.Assert(t, instructions["LOG2"].Pops == 4, "wrong LOG2 stack")
+	goutil.Assert(t, instructions["CALL"].Pops == 7 && instructions["CALL"].Pushes == 1, "wrong CALL stack")
+	goutil.Assert(t, opcodes[0xFD].Mnemonic == "REVERT", "wrong REVERT opcode")
+}
@@ -7,48 +7,57 @@ import (
 	"github.com/end-r/vmgen"
 )
 
-var builtins = map[string]validator.BytecodeGenerator{
-	// arithmetic
-	"addmod":  validator.SimpleInstruction("ADDMOD"),
-	"mulmod":  validator.SimpleInstruction("MULMOD"),
-	"balance": singleArgumentCall("BALANCE"),
-	// transactional
-	"transfer":     transfer,
-	"delegateCall": delegateCall,
-	"call":         call,
-	//"callcode": callCode,
-	// error-checking
-	"revert":  validator.SimpleInstruction("REVERT"),
-	"throw":   validator.SimpleInstruction("REVERT"),
-	"require": require,
-	"assert":  assert,
-	// cryptographic
-	"keccak256": validator.SimpleInstruction("SHA3"),
-	"sha256":    nil,
-	"ecrecover": nil,
-	"ripemd160": nil,
-	// ending
-	"selfDestruct": singleArgumentCall("SELFDESTRUCT"),
-
-	// message
-	"calldata":  calldata,
-	"gas":       validator.SimpleInstruction("GAS"),
-	"sender":    validator.SimpleInstruction("CALLER"),
-	"signature": signature,
-
-	// block
-	"timestamp": validator.SimpleInstruction("TIMESTAMP"),
-	"number":    validator.SimpleInstruction("NUMBER"),
-	"blockhash": blockhash,
-	"coinbase":  validator.SimpleInstruction("COINBASE"),
-	"gasLimit":  validator.SimpleInstruction("GASLIMIT"),
-	// tx
-	"gasPrice": validator.SimpleInstruction("GASPRICE"),
-	"origin":   validator.SimpleInstruction("ORIGIN"),
+var builtins map[string]validator.BytecodeGenerator
+
+func init() {
+	builtins = map[string]validator.BytecodeGenerator{
+		// arithmetic
+		"addmod":  validator.SimpleInstruction("ADDMOD"),
+		"mulmod":  validator.SimpleInstruction("MULMOD"),
+		"balance": singleArgumentCall("BALANCE"),
+		// transactional
+		"transfer":     transfer,
+		"delegateCall": delegateCall,
+		"call":         call,
+		//"callcode": callCode,
+		// error-checking
+		"revert":  validator.SimpleInstruction("REVERT"),
+		"throw":   validator.SimpleInstruction("REVERT"),
+		"require": require,
+		"assert":  assert,
+		// arrays
+		"len":    length,
+		"append": appendBuiltin,
+		"delete": deleteValue,
+		// cryptographic
+		"keccak256": validator.SimpleInstruction("SHA3"),
+		"sha256":    nil,
+		"ecrecover": nil,
+		"ripemd160": nil,
+		// ending
+		"selfDestruct": singleArgumentCall("SELFDESTRUCT"),
+
+		// message
+		"calldata":  calldata,
+		"gas":       validator.SimpleInstruction("GAS"),
+		"caller":    validator.SimpleInstruction("CALLER"),
+		"signature": signature,
+		"value":     validator.SimpleInstruction("CALLVALUE"),
+
+		// block
+		"timestamp": validator.SimpleInstruction("TIMESTAMP"),
+		"number":    validator.SimpleInstruction("NUMBER"),
+		"blockhash": blockhash,
+		"coinbase":  validator.SimpleInstruction("COINBASE"),
+		"gasLimit":  validator.SimpleInstruction("GASLIMIT"),
+		// tx
+		"gasPrice": validator.SimpleInstruction("GASPRICE"),
+		"origin":   validator.SimpleInstruction("ORIGIN"),
+	}
 }
 
 func transfer(vm validator.VM) (code vmgen.Bytecode) {
-	e := vm.(GuardianEVM)
+	e := vm.(*GuardianEVM)
 	call := e.expression.(*ast.CallExpressionNode)
 	// gas
 	code.Concat(push(uintAsBytes(uint(2300))))
@@ -61,9 +70,7 @@ func transfer(vm validator.VM) (code vmgen.Bytecode) {
 	// in size
 	code.Concat(push(uintAsBytes(uint(0))))
 	// out offset
-	e.allocateMemory("transfer", 1)
-	mem := e.lookupMemory("transfer")
-	code.Concat(push(uintAsBytes(uint(mem.offset))))
+	code.Concat(push([]byte{scratchSpace}))
 	// out size
 	code.Concat(push(uintAsBytes(uint(1))))
 	code.Add("CALL")
@@ -71,7 +78,7 @@ func transfer(vm validator.VM) (code vmgen.Bytecode) {
 }
 
 func call(vm validator.VM) (code vmgen.Bytecode) {
-	e := vm.(GuardianEVM)
+	e := vm.(*GuardianEVM)
 	call := e.expression.(*ast.CallExpressionNode)
 	// gas
 	code.Concat(e.traverse(call.Arguments[1]))
@@ -84,56 +91,93 @@ func call(vm validator.VM) (code vmgen.Bytecode) {
 	// length of input data
 	code.Add("PUSH")
 	// out offset
-	e.allocateMemory("transfer", 1)
-	mem := e.lookupMemory("transfer")
-	code.Concat(push(uintAsBytes(uint(mem.offset))))
+	code.Concat(push([]byte{scratchSpace}))
 	// out size
 	code.Concat(push(uintAsBytes(uint(1))))
 	code.Add("CALL")
 	return code
 }
 
+// calldata copies the whole of calldata onto the heap as a byte array
 func calldata(vm validator.VM) (code vmgen.Bytecode) {
-	code.Add("CALLDATA")
+	code.Add("CALLDATASIZE")
+	code.Add("DUP1")
+	code.Concat(roundToWord())
+	code.Concat(addConstant(wordBytes))
+	code.Concat(allocate())
+	// size, pointer
+	code.Add("DUP2")
+	code.Add("DUP2")
+	code.Add("MSTORE")
+	code.Add("DUP2")
+	code.Concat(push([]byte{0}))
+	code.Add("DUP3")
+	code.Concat(addConstant(wordBytes))
+	code.Add("CALLDATACOPY")
+	code.Add("SWAP1")
+	code.Add("POP")
 	return code
 }
 
 func singleArgumentCall(opcode string) validator.BytecodeGenerator {
-	return func(vm validator.VM) vmgen.Bytecode {
-		e := vm.(GuardianEVM)
+	return func(vm validator.VM) (code vmgen.Bytecode) {
+		e := vm.(*GuardianEVM)
 		call := e.expression.(*ast.CallExpressionNode)
 
 		code.Concat(e.traverse(call.Arguments[0]))
 		code.Add(opcode)
+		return code
 	}
 }
 
+// the block number is on top of the stack
 func blockhash(vm validator.VM) (code vmgen.Bytecode) {
-	e := vm.(GuardianEVM)
-	call := e.expression.(*ast.CallExpressionNode)
-
-	code.Concat(e.traverse(call.Arguments[0]))
-	code.Add("BALANCE")
+	code.Add("BLOCKHASH")
 	return code
 }
 
+// require reverts if its condition, which is on top of the stack, does not
+// hold
 func require(vm validator.VM) (code vmgen.Bytecode) {
-	// code.Concat(pushMarker(2))
+	e := vm.(*GuardianEVM)
+	if e.test != nil {
+		return e.check()
+	}
+	code.Add("ISZERO")
+	code.Concat(e.revertIf())
+	return code
+}
 
-	e := vm.(GuardianEVM)
+// deleteValue resets its argument to its zero value
+// the argument has been evaluated, but it is the location which is needed
+func deleteValue(vm validator.VM) (code vmgen.Bytecode) {
+	e := vm.(*GuardianEVM)
 	call := e.expression.(*ast.CallExpressionNode)
-	code.Concat(e.traverse(call.Arguments[0]))
-
-	code.Add("JUMPI")
-	code.Add("REVERT")
+	code.Add("POP")
+	if location, l, ok := e.locateStorage(call.Arguments[0]); ok {
+		code.Concat(location)
+		code.Concat(e.clear(l))
+		return code
+	}
+	if i, ok := call.Arguments[0].(*ast.IdentifierNode); ok {
+		code.Concat(push([]byte{0}))
+		code.Concat(e.assignIdentifier(i.Name))
+	}
 	return code
 }
 
+// assert consumes all remaining gas if its condition, which is on top of the
+// stack, does not hold
 func assert(vm validator.VM) (code vmgen.Bytecode) {
-	// TODO: invalid opcodes
-	code.Concat(pushMarker(2))
+	e := vm.(*GuardianEVM)
+	if e.test != nil {
+		return e.check()
+	}
+	holds := e.newLabel()
+	code.Concat(pushLabel(holds))
 	code.Add("JUMPI")
 	code.Add("INVALID")
+	code.Concat(jumpdest(holds))
 	return code
 }
 
@@ -147,36 +191,87 @@ func callCode(vm validator.VM) (code vmgen.Bytecode) {
 	return code
 }
 
+// signature leaves the first four bytes of calldata, the selector of the
+// called function, on the stack
 func signature(vm validator.VM) (code vmgen.Bytecode) {
-	// get first four bytes of calldata
+	code.Concat(push([]byte{0}))
+	code.Add("CALLDATALOAD")
+	code.Concat(push([]byte{byte(wordSize - selectorSize*8)}))
+	code.Add("SHR")
 	return code
 }
 
+// builtinProperties names the generator of each property of the builtin
+// classes, as annotated in builtins.grd
+var builtinProperties = map[string]map[string]string{
+	"BuiltinMessage": {
+		"data":   "calldata",
+		"gas":    "gas",
+		"sender": "caller",
+		"sig":    "signature",
+		"value":  "value",
+	},
+	"BuiltinBlock": {
+		"timestamp": "timestamp",
+		"number":    "number",
+		"coinbase":  "coinbase",
+		"gasLimit":  "gasLimit",
+	},
+	"BuiltinTransaction": {
+		"gasPrice": "gasPrice",
+		"origin":   "origin",
+	},
+}
+
+// builtinProperty finds the generator of a property of a builtin class
+func builtinProperty(class, property string) (validator.BytecodeGenerator, bool) {
+	b, ok := builtins[builtinProperties[class][property]]
+	return b, ok && b != nil
+}
+
+// length replaces its evaluated argument with the length of the array
+// storage arrays, strings and byte arrays hold their length at their slot
 func length(vm validator.VM) (code vmgen.Bytecode) {
-	// must be an array
-	// array size is always at the first index
-	evm := vm.(*GuardianEVM)
-	if evm.inStorage {
+	e := vm.(*GuardianEVM)
+	call := e.expression.(*ast.CallExpressionNode)
+	if location, l, ok := e.locateStorage(call.Arguments[0]); ok && !l.packed {
+		code.Add("POP")
+		if isFixedArray(l.field) {
+			code.Concat(push(encodeUint(parseABIType(l.field.Type).length)))
+			return code
+		}
+		code.Concat(location)
 		code.Add("SLOAD")
-	} else {
-		code.Add("MLOAD")
+		return code
+	}
+	if m, ok := memoryArrayOf(call.Arguments[0].ResolvedType()); ok {
+		if !m.variable {
+			code.Add("POP")
+		}
+		code.Concat(m.pushLength("DUP1"))
+		if m.variable {
+			code.Add("SWAP1")
+			code.Add("POP")
+		}
 	}
 	return code
 }
 
+// appendBuiltin adds its evaluated value to the end of an array
+// arrays in storage grow in place, leaving their slot, while arrays in memory
+// are copied
 func appendBuiltin(vm validator.VM) (code vmgen.Bytecode) {
-	// must be an array
-	// array size is always at the first index
-	evm := vm.(*GuardianEVM)
-	if evm.inStorage {
-		//code.Add(push())
-		code.Add("SLOAD")
-		code.Concat(push(uintAsBytes(uint(1))))
-		code.Add("ADD")
-		code.Add("SSTORE")
-
-	} else {
-		code.Add("MLOAD")
+	e := vm.(*GuardianEVM)
+	call := e.expression.(*ast.CallExpressionNode)
+	if location, l, ok := e.locateStorage(call.Arguments[0]); ok && l.field.Encoding == dynamicArrayEncoding && l.field.elem != nil {
+		code.Add("SWAP1")
+		code.Add("POP")
+		code.Concat(location)
+		code.Concat(e.appendElement(*l.field.elem))
+		return code
+	}
+	if m, ok := memoryArrayOf(call.Arguments[0].ResolvedType()); ok && m.variable && !m.bytes {
+		code.Concat(e.appendMemory())
 	}
 	return code
 }
@@ -8,23 +8,6 @@ import (
 	"github.com/end-r/goutil"
 )
 
-func TestBuiltinRequire(t *testing.T) {
-	e := NewVM()
-	a, errs := validator.ValidateExpression(e, "require(5 > 3)")
-	goutil.AssertNow(t, len(errs) == 0, errs.Format())
-
-	code := e.traverseExpression(a)
-	expected := []string{
-		"PUSH1",
-		"PUSH1",
-		"LT",
-		"PUSH1",
-		"JUMPI",
-		"REVERT",
-	}
-	goutil.Assert(t, code.CompareMnemonics(expected), code.Format())
-}
-
 func TestBuiltinAssert(t *testing.T) {
 	e := NewVM()
 	a, errs := validator.ValidateExpression(e, "assert(5 > 3)")
@@ -33,110 +16,12 @@ func TestBuiltinAssert(t *testing.T) {
 	expected := []string{
 		"PUSH1",
 		"PUSH1",
-		"LT",
-		"PUSH",
+		"SWAP1",
+		"GT",
+		"PUSH2",
 		"JUMPI",
 		"INVALID",
-	}
-	goutil.Assert(t, code.CompareMnemonics(expected), code.Format())
-}
-
-func TestBuiltinArrayLength(t *testing.T) {
-	e := NewVM()
-	a, errs := validator.ValidateExpression(e, `len("hello")`)
-	goutil.AssertNow(t, errs == nil, errs.Format())
-	code := e.traverseExpression(a)
-	expected := []string{
-		"PUSH7",
-		"MLOAD",
-	}
-	goutil.Assert(t, code.CompareMnemonics(expected), code.Format())
-}
-
-func TestBuiltinAddmod(t *testing.T) {
-	e := NewVM()
-	a, errs := validator.ValidateExpression(e, "addmod(uint(1), uint(2), uint(3))")
-	goutil.AssertNow(t, errs == nil, errs.Format())
-	code := e.traverseExpression(a)
-	expected := []string{
-		"PUSH32",
-		"PUSH32",
-		"PUSH32",
-		"ADDMOD",
-	}
-	goutil.Assert(t, code.CompareMnemonics(expected), code.Format())
-}
-
-func TestBuiltinMulmod(t *testing.T) {
-	e := NewVM()
-	a, errs := validator.ValidateExpression(e, "mulmod(uint(1), uint(2), uint(3))")
-	goutil.AssertNow(t, errs == nil, errs.Format())
-	code := e.traverseExpression(a)
-	expected := []string{
-		"PUSH32",
-		"PUSH32",
-		"PUSH32",
-		"ADDMOD",
-	}
-	goutil.Assert(t, code.CompareMnemonics(expected), code.Format())
-}
-
-func TestBuiltinCall(t *testing.T) {
-	e := NewVM()
-	a, errs := validator.ValidateExpression(e, "call(0xFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF)")
-	goutil.AssertNow(t, errs == nil, errs.Format())
-	code := e.traverseExpression(a)
-	expected := []string{
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"CALL",
-	}
-	goutil.Assert(t, code.CompareMnemonics(expected), code.Format())
-}
-
-func TestBuiltinDelegateCall(t *testing.T) {
-	e := NewVM()
-	a, errs := validator.ValidateExpression(e, "delegate(0xFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF)")
-	goutil.AssertNow(t, errs == nil, errs.Format())
-	code := e.traverseExpression(a)
-	expected := []string{
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"CALL",
-	}
-	goutil.Assert(t, code.CompareMnemonics(expected), code.Format())
-}
-
-func TestBuiltinBalance(t *testing.T) {
-	e := NewVM()
-	a, errs := validator.ValidateExpression(e, "balance(0xFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF)")
-	goutil.AssertNow(t, errs == nil, errs.Format())
-	code := e.traverseExpression(a)
-	expected := []string{
-		"PUSH1",
-		"BALANCE",
-	}
-	goutil.Assert(t, code.CompareMnemonics(expected), code.Format())
-}
-
-func TestBuiltinSha3(t *testing.T) {
-	e := NewVM()
-	a, errs := validator.ValidateExpression(e, `sha3("hello")`)
-	goutil.AssertNow(t, errs == nil, errs.Format())
-	code := e.traverseExpression(a)
-	expected := []string{
-		"PUSH",
-		"SHA3",
+		"JUMPDEST",
 	}
 	goutil.Assert(t, code.CompareMnemonics(expected), code.Format())
 }
@@ -156,7 +41,17 @@ func TestCalldata(t *testing.T) {
 	e := new(GuardianEVM)
 	expr, _ := validator.ValidateExpression(e, "msg.data")
 	bytecode := e.traverseExpression(expr)
-	expected := []string{"CALLDATA"}
+	expected := []string{
+		// allocate the length and the data
+		"CALLDATASIZE", "DUP1",
+		"PUSH1", "ADD", "PUSH1", "SHR", "PUSH1", "SHL", "PUSH1", "ADD",
+		"PUSH1", "MLOAD", "SWAP1", "DUP2", "ADD", "PUSH1", "MSTORE",
+		// store the length
+		"DUP2", "DUP2", "MSTORE",
+		// copy the data after it
+		"DUP2", "PUSH1", "DUP3", "PUSH1", "ADD", "CALLDATACOPY",
+		"SWAP1", "POP",
+	}
 	goutil.Assert(t, bytecode.CompareMnemonics(expected), bytecode.Format())
 }
 
@@ -181,33 +76,31 @@ func TestSignature(t *testing.T) {
 	expr, _ := validator.ValidateExpression(e, "msg.sig")
 	bytecode := e.traverseExpression(expr)
 	// should get first 4 bytes of calldata
-	expected := []string{"CALLDATA"}
-	goutil.Assert(t, bytecode.CompareMnemonics(expected), bytecode.Format())
+	expected := []string{"PUSH1", "CALLDATALOAD", "PUSH1", "SHR"}
+	goutil.AssertNow(t, bytecode.CompareMnemonics(expected), bytecode.Format())
+	goutil.Assert(t, bytecode.Commands[2].Parameters[0] == 224, bytecode.Format())
 }
 
 func TestTimestamp(t *testing.T) {
 	e := new(GuardianEVM)
 	expr, _ := validator.ValidateExpression(e, "block.timestamp")
 	bytecode := e.traverseExpression(expr)
-	// should get first 4 bytes of calldata
 	expected := []string{"TIMESTAMP"}
 	goutil.Assert(t, bytecode.CompareMnemonics(expected), bytecode.Format())
 }
 
 func TestNumber(t *testing.T) {
 	e := new(GuardianEVM)
-	expr, _ := validator.ValidateExpression(e, "block.timestamp")
+	expr, _ := validator.ValidateExpression(e, "block.number")
 	bytecode := e.traverseExpression(expr)
-	// should get first 4 bytes of calldata
 	expected := []string{"NUMBER"}
 	goutil.Assert(t, bytecode.CompareMnemonics(expected), bytecode.Format())
 }
 
 func TestCoinbase(t *testing.T) {
 	e := new(GuardianEVM)
-	expr, _ := validator.ValidateExpression(e, "block.timestamp")
+	expr, _ := validator.ValidateExpression(e, "block.coinbase")
 	bytecode := e.traverseExpression(expr)
-	// should get first 4 bytes of calldata
 	expected := []string{"COINBASE"}
 	goutil.Assert(t, bytecode.CompareMnemonics(expected), bytecode.Format())
 }
@@ -216,16 +109,6 @@ func TestGasLimit(t *testing.T) {
 	e := new(GuardianEVM)
 	expr, _ := validator.ValidateExpression(e, "block.gasLimit")
 	bytecode := e.traverseExpression(expr)
-	// should get first 4 bytes of calldata
-	expected := []string{"GASLIMIT"}
-	goutil.Assert(t, bytecode.CompareMnemonics(expected), bytecode.Format())
-}
-
-func TestBlockhash(t *testing.T) {
-	e := new(GuardianEVM)
-	expr, _ := validator.ValidateExpression(e, "block.gasLimit")
-	bytecode := e.traverseExpression(expr)
-	// should get first 4 bytes of calldata
 	expected := []string{"GASLIMIT"}
 	goutil.Assert(t, bytecode.CompareMnemonics(expected), bytecode.Format())
 }
@@ -234,7 +117,6 @@ func TestGasPrice(t *testing.T) {
 	e := new(GuardianEVM)
 	expr, _ := validator.ValidateExpression(e, "tx.gasPrice")
 	bytecode := e.traverseExpression(expr)
-	// should get first 4 bytes of calldata
 	expected := []string{"GASPRICE"}
 	goutil.Assert(t, bytecode.CompareMnemonics(expected), bytecode.Format())
 }
@@ -243,47 +125,6 @@ func TestOrigin(t *testing.T) {
 	e := new(GuardianEVM)
 	expr, _ := validator.ValidateExpression(e, "tx.origin")
 	bytecode := e.traverseExpression(expr)
-	// should get first 4 bytes of calldata
 	expected := []string{"ORIGIN"}
 	goutil.Assert(t, bytecode.CompareMnemonics(expected), bytecode.Format())
 }
-
-func TestTransfer(t *testing.T) {
-	e := new(GuardianEVM)
-	expr, _ := validator.ValidateExpression(e, `
-		transfer(0x123f681646d4a755815f9cb19e1acc8565a0c2ac, 1000)
-	`)
-	bytecode := e.traverseExpression(expr)
-	// should get first 4 bytes of calldata
-	expected := []string{
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"CALL",
-	}
-	goutil.Assert(t, bytecode.CompareMnemonics(expected), bytecode.Format())
-}
-
-func TestCall(t *testing.T) {
-	e := new(GuardianEVM)
-	expr, _ := validator.ValidateExpression(e, `
-		Call(0x123f681646d4a755815f9cb19e1acc8565a0c2ac).gas(1000).value(1000).sig("aaaaa").call()
-	`)
-	bytecode := e.traverseExpression(expr)
-	// should get first 4 bytes of calldata
-	expected := []string{
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"PUSH",
-		"CALL",
-	}
-	goutil.Assert(t, bytecode.CompareMnemonics(expected), bytecode.Format())
-}
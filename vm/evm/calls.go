@@ -0,0 +1,315 @@
+package evm
+
+import (
+	"fmt"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/token"
+	"github.com/end-r/guardian/util"
+	"github.com/end-r/vmgen"
+)
+
+// internal functions are called by pushing the return address, then each
+// argument in turn, and jumping to the function
+// the return address stays on the stack while the function runs, and the
+// function replaces it with its results as it returns
+
+// the return address is swapped above the results, so no function can
+// return more results than SWAP can reach
+const maxResults = 16
+
+// each active call holds its return address on the stack, which holds at
+// most 1024 words
+const maxCallDepth = 1024
+
+// savedFrame names the memory block which holds the copy of the frame of the
+// call beneath the current one, and cannot clash with any identifier
+const savedFrame = "saved frame"
+
+// a callFrame is the memory used by the parameters and locals of an internal
+// function, which is copied to the heap as the function is called and
+// restored as it returns, so that recursive calls don't disturb the calls
+// beneath them
+type callFrame struct {
+	start, end uint
+	saved      *memoryBlock
+}
+
+func (f *callFrame) words() uint {
+	return (f.end - f.start) / wordBytes
+}
+
+// enterFrame begins the frame of the function being generated, and must be
+// called before its parameters are allocated
+func (e *GuardianEVM) enterFrame() *callFrame {
+	frame := &callFrame{start: e.heapStart()}
+	e.allocateMemory(savedFrame, wordSize)
+	frame.saved = e.lookupMemory(savedFrame)
+	return frame
+}
+
+// saveFrame copies the frame of the previous call onto the heap, and keeps a
+// pointer to the copy in the new frame
+func (e *GuardianEVM) saveFrame(frame *callFrame) (code vmgen.Bytecode) {
+	code.Concat(push(encodeUint(frame.words() * wordBytes)))
+	code.Concat(allocate())
+	code.Add("DUP1")
+	code.Concat(push(encodeUint(frame.start)))
+	code.Concat(push(encodeUint(frame.words())))
+	code.Concat(e.copyWords())
+	code.Concat(frame.saved.store())
+	return code
+}
+
+// restoreFrame copies the frame of the previous call back into place
+// the copy is freed if nothing has been allocated after it
+func (e *GuardianEVM) restoreFrame(frame *callFrame) (code vmgen.Bytecode) {
+	kept := e.newLabel()
+	code.Concat(frame.saved.retrieve())
+	code.Concat(push(encodeUint(frame.start)))
+	code.Add("DUP2")
+	code.Concat(push(encodeUint(frame.words())))
+	code.Concat(e.copyWords())
+	code.Add("DUP1")
+	code.Concat(addConstant(frame.words() * wordBytes))
+	code.Concat(push([]byte{freeMemoryPointer}))
+	code.Add("MLOAD")
+	code.Add("EQ")
+	code.Add("ISZERO")
+	code.Concat(pushLabel(kept))
+	code.Add("JUMPI")
+	code.Add("DUP1")
+	code.Concat(push([]byte{freeMemoryPointer}))
+	code.Add("MSTORE")
+	code.Concat(jumpdest(kept))
+	code.Add("POP")
+	return code
+}
+
+// createInternalFunction generates the code of a function which is called
+// from inside the contract, which begins at its label
+func (e *GuardianEVM) createInternalFunction(node *ast.FuncDeclarationNode, label int) (code vmgen.Bytecode) {
+	if n := len(results(node)); n > maxResults {
+		e.addError(node.Start(), errTooManyResults, node.Signature.Identifier, n, maxResults)
+	}
+	if e.alwaysRecurses(node) {
+		e.addError(node.Start(), errEndlessRecursion, node.Signature.Identifier, maxCallDepth)
+	}
+	// parameters must be allocated before the body refers to them
+	frame := e.enterFrame()
+	params := e.storeParameters(parameters(node))
+	body := e.createFunctionBody(node, vmgen.Bytecode{})
+	frame.end = e.heapStart()
+
+	code.Concat(jumpdest(label))
+	code.Concat(e.saveFrame(frame))
+	code.Concat(params)
+	code.Concat(body)
+	code.Concat(e.createInternalEpilogue(node, frame))
+	return code
+}
+
+// internal functions restore the frame of their caller, then jump back to
+// it, leaving their results on the stack in place of the return address
+func (e *GuardianEVM) createInternalEpilogue(node *ast.FuncDeclarationNode, frame *callFrame) (code vmgen.Bytecode) {
+	code.Concat(e.restoreFrame(frame))
+	for i := 1; i <= len(results(node)) && i <= maxResults; i++ {
+		code.Add(fmt.Sprintf("SWAP%d", i))
+	}
+	code.Concat(jump(jumpOutOfFunction))
+	return code
+}
+
+// functionLabel is the label at which the internal code of a function
+// begins, which calls may refer to before the function is generated
+func (e *GuardianEVM) functionLabel(name string) int {
+	if e.functionLabels == nil {
+		e.functionLabels = make(map[string]int)
+	}
+	if l, ok := e.functionLabels[name]; ok {
+		return l
+	}
+	l := e.newLabel()
+	e.functionLabels[name] = l
+	return l
+}
+
+// calledFunction finds the function of the current contract, or of the
+// contracts it inherits from, which can be called internally by a call
+// expression
+// functions declared outside of any contract in its package are generated
+// as internal functions of the contract
+func (e *GuardianEVM) calledFunction(n *ast.CallExpressionNode) *ast.FuncDeclarationNode {
+	i, ok := n.Call.(*ast.IdentifierNode)
+	if !ok || e.contract == nil || e.lookupMemory(i.Name) != nil {
+		return nil
+	}
+	if _, ok := builtins[i.Name]; ok {
+		return nil
+	}
+	if f := e.declaredFunction(i.Name); f != nil {
+		if hasModifier(f.Modifiers.Modifiers, "internal") || hasModifier(f.Modifiers.Modifiers, "global") {
+			return f
+		}
+		return nil
+	}
+	if f := e.packageFunction(i.Name); f != nil {
+		for _, p := range e.packageFunctions {
+			if p == f {
+				return f
+			}
+		}
+		e.packageFunctions = append(e.packageFunctions, f)
+		return f
+	}
+	return nil
+}
+
+// declaredFunction finds a function declared by the current contract, or by
+// the contracts it inherits from
+func (e *GuardianEVM) declaredFunction(name string) *ast.FuncDeclarationNode {
+	if e.contract == nil {
+		return nil
+	}
+	for _, c := range linearise(e.contract, nil) {
+		if c.Body == nil || c.Body.Declarations == nil {
+			continue
+		}
+		for _, d := range c.Body.Declarations.Array() {
+			if f, ok := d.(*ast.FuncDeclarationNode); ok && f.Signature.Identifier == name {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// packageFunction finds a function declared outside of any contract, in the
+// file of the current contract or another file of its package
+// tests can't be called
+func (e *GuardianEVM) packageFunction(name string) *ast.FuncDeclarationNode {
+	var scopes []*ast.ScopeNode
+	for s := e.contract.Body.Parent; s != nil; s = s.Parent {
+		scopes = append(scopes, s)
+	}
+	for _, s := range append(scopes, e.packageScopes...) {
+		f, ok := s.GetDeclaration(name).(*ast.FuncDeclarationNode)
+		if ok && !hasModifier(f.Modifiers.Modifiers, "test") {
+			return f
+		}
+	}
+	return nil
+}
+
+// traverseInternalCall calls a function of the contract, leaving its results
+// on the stack with the last result on top
+func (e *GuardianEVM) traverseInternalCall(n *ast.CallExpressionNode, f *ast.FuncDeclarationNode) (code vmgen.Bytecode) {
+	back := e.newLabel()
+	code.Concat(pushLabel(back))
+	for _, arg := range n.Arguments {
+		code.Concat(e.traverseExpression(arg))
+	}
+	code.Concat(pushLabel(e.functionLabel(f.Signature.Identifier)))
+	code.Concat(jump(jumpIntoFunction))
+	code.Concat(jumpdest(back))
+	return code
+}
+
+// alwaysRecurses reports whether a function calls itself before it can
+// return, in which case every call exceeds the call depth limit
+// only the statements which are certain to run are considered
+func (e *GuardianEVM) alwaysRecurses(node *ast.FuncDeclarationNode) bool {
+	name := node.Signature.Identifier
+	if node.Body == nil {
+		return false
+	}
+	for _, s := range inSourceOrder(node.Body) {
+		switch a := s.(type) {
+		case *ast.ExplicitVarDeclarationNode:
+			if callsFunction(a.Value, name) {
+				return true
+			}
+		case *ast.AssignmentStatementNode:
+			for _, r := range a.Right {
+				if callsFunction(r, name) {
+					return true
+				}
+			}
+		case *ast.CallExpressionNode:
+			if callsFunction(a, name) {
+				return true
+			}
+		case *ast.ReturnStatementNode:
+			for _, r := range a.Results {
+				if callsFunction(r, name) {
+					return true
+				}
+			}
+			return false
+		case *ast.IfStatementNode:
+			// only the first condition is certain to be tested
+			return len(a.Conditions) > 0 && callsFunction(a.Conditions[0].Condition, name)
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// callsFunction reports whether evaluating an expression certainly calls
+// the named function
+func callsFunction(n ast.ExpressionNode, name string) bool {
+	switch a := n.(type) {
+	case *ast.CallExpressionNode:
+		if i, ok := a.Call.(*ast.IdentifierNode); ok && i.Name == name {
+			return true
+		}
+		for _, arg := range a.Arguments {
+			if callsFunction(arg, name) {
+				return true
+			}
+		}
+		return callsFunction(a.Call, name)
+	case *ast.BinaryExpressionNode:
+		if callsFunction(a.Left, name) {
+			return true
+		}
+		// the right of a logical operator may not be evaluated
+		if a.Operator == token.LogicalAnd || a.Operator == token.LogicalOr {
+			return false
+		}
+		return callsFunction(a.Right, name)
+	case *ast.UnaryExpressionNode:
+		return callsFunction(a.Operand, name)
+	case *ast.IndexExpressionNode:
+		return callsFunction(a.Expression, name) || callsFunction(a.Index, name)
+	case *ast.SliceExpressionNode:
+		return callsFunction(a.Expression, name) || callsFunction(a.Low, name) || callsFunction(a.High, name)
+	case *ast.ReferenceNode:
+		return callsFunction(a.Parent, name)
+	case *ast.ArrayLiteralNode:
+		for _, d := range a.Data {
+			if callsFunction(d, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const (
+	errTooManyResults   = "Function %s returns %d results, but at most %d can be returned from an internal call"
+	errEndlessRecursion = "Function %s always calls itself, so every call exceeds the call depth limit of %d"
+	errExternalCall     = "Function %s is external, so can only be called from outside the contract"
+	errUnknownFunction  = "Function %s is not declared by this contract or its package"
+	errFunctionValue    = "Only functions declared by this contract or its package can be called"
+)
+
+// addError records an error in the contract being generated
+func (e *GuardianEVM) addError(location util.Location, format string, args ...interface{}) {
+	e.errs = append(e.errs, util.Error{
+		Location: location,
+		Message:  fmt.Sprintf(format, args...),
+		Stage:    util.Generation,
+	})
+}
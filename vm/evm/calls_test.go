@@ -0,0 +1,224 @@
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/parser"
+	"github.com/end-r/guardian/validator"
+	"github.com/end-r/guardian/vm/evm/interpreter"
+)
+
+func TestInternalCalls(t *testing.T) {
+	chain, a := execute(t, "Maths", `
+		contract Maths {
+			var calls uint
+			external func sumOfSquares(a, b uint) uint {
+				var total = square(a)
+				total += square(b)
+				return total
+			}
+			internal func square(x uint) uint {
+				var result = x * x
+				record()
+				return result
+			}
+			internal func record() {
+				calls += 1
+			}
+			external func count() uint {
+				return calls
+			}
+		}
+	`)
+	r := send(chain, a, "sumOfSquares(uint256,uint256)", uintWord(3), uintWord(4))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(25)), fmt.Sprintf("wrong result %x", r.Output))
+	r = send(chain, a, "count()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(2)), fmt.Sprintf("wrong count %x", r.Output))
+}
+
+func TestRecursiveCalls(t *testing.T) {
+	chain, a := execute(t, "Maths", `
+		contract Maths {
+			external func pow(base, exponent uint) uint {
+				return power(base, exponent)
+			}
+			internal func power(base, exponent uint) uint {
+				if exponent == 0 {
+					return 1
+				}
+				var half = power(base, exponent / 2)
+				if exponent % 2 == 0 {
+					return half * half
+				}
+				return base * half * half
+			}
+			global func fib(n uint) uint {
+				if n < 2 {
+					return n
+				}
+				return fib(n - 1) + fib(n - 2)
+			}
+		}
+	`)
+	r := send(chain, a, "pow(uint256,uint256)", uintWord(3), uintWord(5))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(243)), fmt.Sprintf("wrong power %x", r.Output))
+	r = send(chain, a, "fib(uint256)", uintWord(10))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(55)), fmt.Sprintf("wrong fibonacci number %x", r.Output))
+}
+
+func TestMultipleResults(t *testing.T) {
+	chain, a := execute(t, "Maths", `
+		contract Maths {
+			external func divide(a, b uint) (uint, uint) {
+				var q, r uint
+				q, r = divmod(a, b)
+				return q, r
+			}
+			external func swap(a, b uint) (uint, uint) {
+				return pair(b, a)
+			}
+			internal func divmod(a, b uint) (uint, uint) {
+				return a / b, a % b
+			}
+			internal func pair(a, b uint) (uint, uint) {
+				return a, b
+			}
+		}
+	`)
+	r := send(chain, a, "divide(uint256,uint256)", uintWord(17), uintWord(5))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, append(uintWord(3), uintWord(2)...)), fmt.Sprintf("wrong results %x", r.Output))
+	r = send(chain, a, "swap(uint256,uint256)", uintWord(1), uintWord(2))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, append(uintWord(2), uintWord(1)...)), fmt.Sprintf("wrong results %x", r.Output))
+}
+
+func TestPackageFunctions(t *testing.T) {
+	maths, errs := parser.ParseString(`
+		internal func square(x uint) uint {
+			return x * x
+		}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	scope, errs := parser.ParseString(`
+		contract Maths {
+			external func pow(base, exponent uint) uint {
+				return power(base, exponent)
+			}
+		}
+
+		internal func power(base, exponent uint) uint {
+			if exponent == 0 {
+				return 1
+			}
+			var half = square(power(base, exponent / 2))
+			if exponent % 2 == 0 {
+				return half
+			}
+			return base * half
+		}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	scopes := []*ast.ScopeNode{maths, scope}
+	e := NewVM()
+	errs = validator.ValidatePackageScopes(e, scopes)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c := scope.GetDeclaration("Maths").(*ast.ContractDeclarationNode)
+	code, errs := e.InPackage(scopes).(GuardianEVM).Compile(c, false)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	chain := interpreter.New()
+	a, r := chain.Deploy(account, code, nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, a, "pow(uint256,uint256)", uintWord(3), uintWord(5))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(243)), fmt.Sprintf("wrong power %x", r.Output))
+	// without the rest of the package, square can't be found
+	_, errs = e.Compile(c, false)
+	goutil.Assert(t, strings.Contains(errs.Format(), "square is not declared"), "unknown functions should be reported")
+}
+
+func TestConstructorCalls(t *testing.T) {
+	chain, a := execute(t, "Counter", `
+		contract Counter {
+			var count uint
+			constructor() {
+				count = triple(increment(2))
+			}
+			internal func increment(x uint) uint {
+				return x + 1
+			}
+			external func next() uint {
+				count = increment(count)
+				return count
+			}
+		}
+
+		internal func triple(x uint) uint {
+			return x * 3
+		}
+	`)
+	goutil.Assert(t, chain.Storage(a, big.NewInt(0)).Int64() == 9, "the constructor should call functions")
+	r := send(chain, a, "next()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(10)), fmt.Sprintf("wrong count %x", r.Output))
+}
+
+func TestCallErrors(t *testing.T) {
+	compile := func(text string) string {
+		e := NewVM()
+		scope, errs := validator.ValidateString(e, text)
+		goutil.AssertNow(t, errs == nil, errs.Format())
+		_, errs = e.Compile(scope.GetDeclaration("A").(*ast.ContractDeclarationNode), false)
+		return errs.Format()
+	}
+	errs := compile(`
+		contract A {
+			internal func loop(x uint) uint {
+				return loop(x + 1)
+			}
+		}
+	`)
+	goutil.Assert(t, strings.Contains(errs, "always calls itself"), "endless recursion should be reported")
+	errs = compile(`
+		contract A {
+			internal func count(x uint) uint {
+				if x == 0 {
+					return 0
+				}
+				return count(x - 1)
+			}
+		}
+	`)
+	goutil.Assert(t, !strings.Contains(errs, "always calls itself"), "conditional recursion should not be reported")
+	results := strings.TrimSuffix(strings.Repeat("uint, ", 17), ", ")
+	values := strings.TrimSuffix(strings.Repeat("x, ", 17), ", ")
+	errs = compile(`
+		contract A {
+			internal func many(x uint) (` + results + `) {
+				return ` + values + `
+			}
+		}
+	`)
+	goutil.Assert(t, strings.Contains(errs, "at most 16"), "too many results should be reported")
+	errs = compile(`
+		contract A {
+			external func a() uint {
+				return b()
+			}
+			external func b() uint {
+				return 1
+			}
+		}
+	`)
+	goutil.Assert(t, strings.Contains(errs, "b is external"), "internal calls of external functions should be reported")
+}
@@ -0,0 +1,166 @@
+package evm
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/end-r/guardian/ast"
+)
+
+// an upgraded contract must keep every field of the previous version at the
+// same position with the same type: new fields may only be appended
+
+// the kinds of incompatible layout changes
+const (
+	RemovedField   = "removed"
+	ReorderedField = "reordered"
+	RetypedField   = "retyped"
+	ResizedField   = "resized"
+	InsertedField  = "inserted"
+	MovedField     = "moved"
+)
+
+// LayoutIssue describes a change between two storage layouts which would
+// corrupt existing storage after an upgrade
+type LayoutIssue struct {
+	Kind    string `json:"kind"`
+	Label   string `json:"label"`
+	Message string `json:"message"`
+}
+
+func (i LayoutIssue) String() string {
+	return i.Message
+}
+
+// CheckStorageLayout compares the JSON storage layout of a previous version of
+// a contract against the layout of a validated contract
+func (evm GuardianEVM) CheckStorageLayout(previous []byte, n *ast.ContractDeclarationNode) ([]string, error) {
+	var layout StorageLayout
+	if err := json.Unmarshal(previous, &layout); err != nil {
+		return nil, err
+	}
+	var messages []string
+	for _, i := range CompareStorageLayouts(layout, evm.ContractStorageLayout(n)) {
+		messages = append(messages, i.String())
+	}
+	return messages, nil
+}
+
+// CompareStorageLayouts returns every field of a previous layout which is
+// not held in the same way by the next layout
+func CompareStorageLayouts(previous, next StorageLayout) []LayoutIssue {
+	return compareFields("", previous.Storage, next.Storage)
+}
+
+func compareFields(prefix string, previous, next []StorageField) []LayoutIssue {
+	var issues []LayoutIssue
+	matched := make(map[int]bool)
+	var moved []int
+	last := -1
+	for _, p := range previous {
+		label := prefix + p.Label
+		j := findField(next, p)
+		if j < 0 {
+			issues = append(issues, LayoutIssue{
+				Kind:    RemovedField,
+				Label:   label,
+				Message: fmt.Sprintf("field %s (%s at %s) was removed", label, p.Type, position(p)),
+			})
+			continue
+		}
+		matched[j] = true
+		n := next[j]
+		if j < last {
+			issues = append(issues, LayoutIssue{
+				Kind:    ReorderedField,
+				Label:   label,
+				Message: fmt.Sprintf("field %s was reordered from %s to %s", label, position(p), position(n)),
+			})
+			continue
+		}
+		last = j
+		if n.Type != p.Type {
+			kind := RetypedField
+			if n.Size != p.Size {
+				kind = ResizedField
+			}
+			issues = append(issues, LayoutIssue{
+				Kind:    kind,
+				Label:   label,
+				Message: fmt.Sprintf("field %s was %s from %s to %s", label, kind, p.Type, n.Type),
+			})
+			continue
+		}
+		if n.Slot != p.Slot || n.Offset != p.Offset {
+			moved = append(moved, j)
+			continue
+		}
+		issues = append(issues, compareFields(label+".", p.Members, n.Members)...)
+	}
+	// fields which shift later fields were inserted rather than appended
+	explained := make(map[int]bool)
+	for k := range next {
+		if matched[k] {
+			continue
+		}
+		first := -1
+		for _, j := range moved {
+			if j > k {
+				if first < 0 {
+					first = j
+				}
+				explained[j] = true
+			}
+		}
+		if first >= 0 {
+			label := prefix + next[k].Label
+			issues = append(issues, LayoutIssue{
+				Kind:  InsertedField,
+				Label: label,
+				Message: fmt.Sprintf("field %s was inserted before %s, which moved from %s to %s",
+					label, prefix+next[first].Label, position(previousField(previous, next[first])), position(next[first])),
+			})
+		}
+	}
+	for _, j := range moved {
+		if !explained[j] {
+			label := prefix + next[j].Label
+			issues = append(issues, LayoutIssue{
+				Kind:    MovedField,
+				Label:   label,
+				Message: fmt.Sprintf("field %s moved from %s to %s", label, position(previousField(previous, next[j])), position(next[j])),
+			})
+		}
+	}
+	return issues
+}
+
+// fields are matched by label, preferring the same declaring contract
+func findField(fields []StorageField, f StorageField) int {
+	found := -1
+	for i, g := range fields {
+		if g.Label == f.Label {
+			if g.Contract == f.Contract {
+				return i
+			}
+			if found < 0 {
+				found = i
+			}
+		}
+	}
+	return found
+}
+
+func previousField(previous []StorageField, f StorageField) StorageField {
+	if i := findField(previous, f); i >= 0 {
+		return previous[i]
+	}
+	return f
+}
+
+func position(f StorageField) string {
+	if f.Offset == 0 {
+		return fmt.Sprintf("slot %d", f.Slot)
+	}
+	return fmt.Sprintf("slot %d offset %d", f.Slot, f.Offset)
+}
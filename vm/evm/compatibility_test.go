@@ -0,0 +1,133 @@
+package evm
+
+import (
+	"testing"
+
+	"github.com/end-r/goutil"
+)
+
+func compareLayouts(t *testing.T, previous, next string) []LayoutIssue {
+	return CompareStorageLayouts(storageLayout(t, "Token", previous), storageLayout(t, "Token", next))
+}
+
+func TestCompareLayoutsAppended(t *testing.T) {
+	issues := compareLayouts(t, `
+		contract Token {
+			var owner address
+			var supply uint
+		}
+	`, `
+		contract Token {
+			var owner address
+			var supply uint
+			var paused bool
+		}
+	`)
+	goutil.Assert(t, len(issues) == 0, "appended fields should be compatible")
+}
+
+func TestCompareLayoutsRemoved(t *testing.T) {
+	issues := compareLayouts(t, `
+		contract Token {
+			var owner address
+			var supply uint
+		}
+	`, `
+		contract Token {
+			var supply uint
+		}
+	`)
+	goutil.AssertNow(t, len(issues) == 2, "wrong issue count")
+	goutil.Assert(t, issues[0].Kind == RemovedField && issues[0].Label == "owner", "owner should be removed")
+	goutil.Assert(t, issues[1].Kind == MovedField && issues[1].Label == "supply", "supply should be moved")
+}
+
+func TestCompareLayoutsReordered(t *testing.T) {
+	issues := compareLayouts(t, `
+		contract Token {
+			var owner address
+			var supply uint
+		}
+	`, `
+		contract Token {
+			var supply uint
+			var owner address
+		}
+	`)
+	goutil.AssertNow(t, len(issues) > 0, "reordered fields should be incompatible")
+	goutil.Assert(t, issues[0].Kind == ReorderedField && issues[0].Label == "supply", "supply should be reordered")
+}
+
+func TestCompareLayoutsRetyped(t *testing.T) {
+	issues := compareLayouts(t, `
+		contract Token {
+			var supply uint
+			var count int64
+		}
+	`, `
+		contract Token {
+			var supply int
+			var count uint32
+		}
+	`)
+	goutil.AssertNow(t, len(issues) == 2, "wrong issue count")
+	goutil.Assert(t, issues[0].Kind == RetypedField, "same sized types should be retyped")
+	goutil.Assert(t, issues[1].Kind == ResizedField, "different sized types should be resized")
+}
+
+func TestCompareLayoutsInserted(t *testing.T) {
+	issues := compareLayouts(t, `
+		contract Token {
+			var owner address
+			var supply uint
+			var cap uint
+		}
+	`, `
+		contract Token {
+			var owner address
+			var minter address
+			var supply uint
+			var cap uint
+		}
+	`)
+	// the insertion is reported once, rather than every field it moves
+	goutil.AssertNow(t, len(issues) == 1, "wrong issue count")
+	goutil.Assert(t, issues[0].Kind == InsertedField && issues[0].Label == "minter", issues[0].Message)
+}
+
+func TestCompareLayoutsPacked(t *testing.T) {
+	// fields which fit into the remainder of a slot move nothing
+	issues := compareLayouts(t, `
+		contract Token {
+			var owner address
+			var supply uint
+		}
+	`, `
+		contract Token {
+			var owner address
+			var paused bool
+			var supply uint
+		}
+	`)
+	goutil.Assert(t, len(issues) == 0, "packed fields should be compatible")
+}
+
+func TestCompareLayoutsClassMembers(t *testing.T) {
+	issues := compareLayouts(t, `
+		class Point {
+			var x, y uint
+		}
+		contract Token {
+			var origin Point
+		}
+	`, `
+		class Point {
+			var y, x uint
+		}
+		contract Token {
+			var origin Point
+		}
+	`)
+	goutil.AssertNow(t, len(issues) > 0, "reordered members should be incompatible")
+	goutil.Assert(t, issues[0].Label == "origin.x" || issues[0].Label == "origin.y", issues[0].Label)
+}
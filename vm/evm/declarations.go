@@ -1,11 +1,10 @@
 package evm
 
 import (
-	"fmt"
-
 	"github.com/end-r/vmgen"
 
 	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/token"
 )
 
 func (e *GuardianEVM) traverseType(n *ast.TypeDeclarationNode) (code vmgen.Bytecode) {
@@ -51,21 +50,37 @@ func (e *GuardianEVM) traverseContract(n *ast.ContractDeclarationNode) (code vmg
 
 	e.inStorage = false
 
+	// hooks and memory are laid out separately for each contract
+	e.externalHooks, e.globalHooks, e.internalHooks = nil, nil, nil
+	e.functionLabels, e.packageFunctions = nil, nil
+	e.memory, e.freedMemory, e.memoryCursor = nil, nil, 0
+	e.revertLabel, e.bubbleLabel = 0, 0
+	e.contract = n
+	e.layoutStorage(n)
+
+	var fallback vmgen.Bytecode
+
 	// create hooks for functions
 	// create hooks for constructors
 	// create hooks for events
 	// traverse everything else?
 	if n.Body.Declarations != nil {
-		for _, d := range n.Body.Declarations.Map() {
+		for _, d := range n.Body.Declarations.Array() {
 			switch a := d.(type) {
 			case *ast.LifecycleDeclarationNode:
+				if a.Category == token.Fallback {
+					fallback = e.traverseFallback(a)
+				}
 				//	e.addLifecycleHook(n.Identifier, a)
 				break
 			case *ast.FuncDeclarationNode:
-				e.addFunctionHook(n.Identifier, a)
+				e.traverseFunc(a)
 				break
 			case *ast.EventDeclarationNode:
-				e.addEventHook(n.Identifier, a)
+				// events are emitted where they are called
+				break
+			case *ast.ExplicitVarDeclarationNode:
+				// fields have already been laid out
 				break
 			default:
 				e.traverse(a.(ast.Node))
@@ -73,20 +88,21 @@ func (e *GuardianEVM) traverseContract(n *ast.ContractDeclarationNode) (code vmg
 		}
 	}
 
-	return code
-}
-
-func (e *GuardianEVM) addFunctionHook(parent string, node *ast.FuncDeclarationNode) {
-	/*e.hooks[parent][node.Identifier] = hook {
-		name: node.Identifier,
-	}*/
+	// the package functions which the contract calls may call more of them
+	for i := 0; i < len(e.packageFunctions); i++ {
+		e.traversePackageFunction(e.packageFunctions[i])
+	}
 
+	return e.finalise(fallback)
 }
 
-func (e *GuardianEVM) addEventHook(parent string, node *ast.EventDeclarationNode) {
-	/*e.hooks[parent][node.Identifier] = hook{
-		name: node.Identifier,
-	}*/
+// the fallback is run when calldata matches no function selector
+func (e *GuardianEVM) traverseFallback(n *ast.LifecycleDeclarationNode) (code vmgen.Bytecode) {
+	e.enterFunction()
+	defer e.exitFunction()
+	code.Concat(e.traverseScope(n.Body))
+	code.Add("STOP")
+	return code
 }
 
 func (e *GuardianEVM) addHook(name string) {
@@ -100,38 +116,7 @@ func (e *GuardianEVM) addHook(name string) {
 }
 
 func (e *GuardianEVM) traverseEvent(n *ast.EventDeclarationNode) (code vmgen.Bytecode) {
-	indexed := 0
-
-	if hasModifier(n.Modifiers.Modifiers, "indexed") {
-		// all parameters will be indexed
-		for _ = range n.Parameters {
-			for _ = range n.Identifier {
-				indexed++
-			}
-		}
-	} else {
-		for _, param := range n.Parameters {
-			for _ = range n.Identifier {
-				if hasModifier(param.Modifiers.Modifiers, "indexed") {
-					indexed++
-
-				}
-			}
-		}
-	}
-
-	topicLimit := 4
-	if indexed > topicLimit {
-		// TODO: add error
-	}
-
-	code.Add("JUMPDEST")
-	code.Add("CALLER")
-	// other parameters should be on the stack already
-	code.Add(fmt.Sprintf("LOG%d", indexed))
-
-	//e.addEventHook(n.Identifier, )
-
+	// events are emitted where they are called
 	return code
 }
 
@@ -161,8 +146,14 @@ func (e *GuardianEVM) traverseFunc(node *ast.FuncDeclarationNode) (code vmgen.By
 
 	e.inStorage = true
 
+	e.enterFunction()
+	defer e.exitFunction()
+
 	// don't worry about hooking
-	if hasModifier(node.Modifiers.Modifiers, "external") {
+	if node == e.test {
+		// the test is called like an external function
+		code.Concat(e.traverseExternalFunction(node))
+	} else if hasModifier(node.Modifiers.Modifiers, "external") {
 		code.Concat(e.traverseExternalFunction(node))
 	} else if hasModifier(node.Modifiers.Modifiers, "internal") {
 		code.Concat(e.traverseInternalFunction(node))
@@ -173,7 +164,21 @@ func (e *GuardianEVM) traverseFunc(node *ast.FuncDeclarationNode) (code vmgen.By
 	return code
 }
 
+// package functions are only called from inside the contract, whatever
+// their visibility
+func (e *GuardianEVM) traversePackageFunction(node *ast.FuncDeclarationNode) (code vmgen.Bytecode) {
+	e.inStorage = true
+
+	e.enterFunction()
+	defer e.exitFunction()
+
+	return e.traverseInternalFunction(node)
+}
+
 func (e *GuardianEVM) traverseExplicitVarDecl(n *ast.ExplicitVarDeclarationNode) (code vmgen.Bytecode) {
+	if e.inFunction() {
+		return e.declareLocals(n)
+	}
 	// variable declarations don't require storage (yet), just have to designate a slot
 	for _, id := range n.Identifiers {
 		if e.inStorage {
@@ -184,3 +189,24 @@ func (e *GuardianEVM) traverseExplicitVarDecl(n *ast.ExplicitVarDeclarationNode)
 	}
 	return code
 }
+
+// declareLocals allocates memory for variables declared in a function
+// blocks may be reused, and functions may be called more than once, so each
+// variable is set to its value or zero as it is declared
+// several variables may be declared with the results of a call
+func (e *GuardianEVM) declareLocals(n *ast.ExplicitVarDeclarationNode) (code vmgen.Bytecode) {
+	if n.Value != nil {
+		code.Concat(e.traverseExpression(n.Value))
+	} else {
+		for range n.Identifiers {
+			code.Concat(push([]byte{0}))
+		}
+	}
+	for _, id := range n.Identifiers {
+		e.allocateMemory(id, wordSize)
+	}
+	for i := len(n.Identifiers) - 1; i >= 0; i-- {
+		code.Concat(e.lookupMemory(n.Identifiers[i]).store())
+	}
+	return code
+}
@@ -0,0 +1,321 @@
+package evm
+
+import "github.com/end-r/vmgen"
+
+// calldata is decoded according to the ABI specification: static values are
+// encoded in place in the head, while dynamic values are encoded in the tail
+// and referenced from the head by an offset relative to the start of the
+// enclosing encoding
+
+// the largest offset or length accepted in calldata, far beyond any which
+// could be paid for
+const maxCalldataValue = 0xffffffff
+
+// constructor arguments are copied into memory from the end of the creation
+// code, and decoded from there rather than from calldata
+const (
+	inputBlock     = "input data"
+	inputSizeBlock = "input size"
+)
+
+// loadInput replaces the position on top of the stack with the word of input
+// held there
+func (e *GuardianEVM) loadInput() (code vmgen.Bytecode) {
+	if !e.inputInMemory {
+		code.Add("CALLDATALOAD")
+		return code
+	}
+	code.Concat(e.lookupMemory(inputBlock).retrieve())
+	code.Add("ADD")
+	code.Add("MLOAD")
+	return code
+}
+
+// inputSize pushes the number of bytes of input
+func (e *GuardianEVM) inputSize() (code vmgen.Bytecode) {
+	if !e.inputInMemory {
+		code.Add("CALLDATASIZE")
+		return code
+	}
+	code.Concat(e.lookupMemory(inputSizeBlock).retrieve())
+	return code
+}
+
+// copyInput consumes a destination, a position in the input and a number of
+// bytes, and copies the bytes between them
+func (e *GuardianEVM) copyInput() (code vmgen.Bytecode) {
+	if !e.inputInMemory {
+		code.Add("CALLDATACOPY")
+		return code
+	}
+	// whole words are copied, as destinations are allocated in words
+	code.Add("SWAP2")
+	code.Concat(roundToWord())
+	code.Concat(push([]byte{5}))
+	code.Add("SHR")
+	code.Add("SWAP1")
+	code.Concat(e.lookupMemory(inputBlock).retrieve())
+	code.Add("ADD")
+	code.Add("SWAP1")
+	code.Concat(e.copyWords())
+	return code
+}
+
+// decode replaces the base and head position on top of the stack with the
+// decoded value: words are left on the stack, while everything else is copied
+// onto the heap and replaced by a pointer
+func (e *GuardianEVM) decode(t *abiValue) (code vmgen.Bytecode) {
+	switch t.kind {
+	case abiBytes:
+		return e.decodeBytes()
+	case abiArray:
+		return e.decodeArray(t)
+	case abiTuple:
+		return e.decodeTuple(t)
+	}
+	code.Add("SWAP1")
+	code.Add("POP")
+	code.Concat(e.loadInput())
+	code.Concat(e.validateWord(t))
+	return code
+}
+
+// validateWord reverts if the word on top of the stack has bits set outside
+// of those used by its type
+func (e *GuardianEVM) validateWord(t *abiValue) (code vmgen.Bytecode) {
+	if t.bits >= wordSize {
+		return code
+	}
+	code.Add("DUP1")
+	switch {
+	case t.left:
+		// fixed bytes are padded on the right
+		code.Concat(push(encodeUint(t.bits)))
+		code.Add("SHL")
+	case t.signed:
+		code.Add("DUP1")
+		code.Concat(push(encodeUint(t.bits/8 - 1)))
+		code.Add("SIGNEXTEND")
+		code.Add("EQ")
+		code.Add("ISZERO")
+	default:
+		code.Concat(push(encodeUint(t.bits)))
+		code.Add("SHR")
+	}
+	code.Concat(e.revertIf())
+	return code
+}
+
+// tail replaces the base and head position on top of the stack with the
+// position of the dynamic value the head refers to
+func (e *GuardianEVM) tail() (code vmgen.Bytecode) {
+	code.Concat(e.loadInput())
+	code.Concat(e.checkCalldataValue())
+	code.Add("ADD")
+	return code
+}
+
+// checkCalldataValue reverts if the offset or length on top of the stack is
+// too large to be valid
+func (e *GuardianEVM) checkCalldataValue() (code vmgen.Bytecode) {
+	code.Add("DUP1")
+	code.Concat(push(encodeUint(maxCalldataValue)))
+	code.Add("LT")
+	code.Concat(e.revertIf())
+	return code
+}
+
+// checkCalldataEnd consumes the position on top of the stack, and reverts
+// if it is beyond the end of calldata
+func (e *GuardianEVM) checkCalldataEnd() (code vmgen.Bytecode) {
+	code.Concat(e.inputSize())
+	code.Add("LT")
+	code.Concat(e.revertIf())
+	return code
+}
+
+// allocate replaces the number of bytes on top of the stack with a pointer
+// to that many bytes on the heap
+func allocate() (code vmgen.Bytecode) {
+	code.Concat(push([]byte{freeMemoryPointer}))
+	code.Add("MLOAD")
+	code.Add("SWAP1")
+	code.Add("DUP2")
+	code.Add("ADD")
+	code.Concat(push([]byte{freeMemoryPointer}))
+	code.Add("MSTORE")
+	return code
+}
+
+// roundToWord rounds the number of bytes on top of the stack up to a whole
+// number of words
+func roundToWord() (code vmgen.Bytecode) {
+	code.Concat(push([]byte{byte(wordBytes - 1)}))
+	code.Add("ADD")
+	code.Concat(push([]byte{5}))
+	code.Add("SHR")
+	code.Concat(push([]byte{5}))
+	code.Add("SHL")
+	return code
+}
+
+func addConstant(n uint) (code vmgen.Bytecode) {
+	if n > 0 {
+		code.Concat(push(encodeUint(n)))
+		code.Add("ADD")
+	}
+	return code
+}
+
+// bytes and strings are copied to the heap as a length followed by the data
+func (e *GuardianEVM) decodeBytes() (code vmgen.Bytecode) {
+	// start
+	code.Concat(e.tail())
+	code.Add("DUP1")
+	code.Concat(addConstant(wordBytes))
+	code.Concat(e.checkCalldataEnd())
+	// start, length
+	code.Add("DUP1")
+	code.Concat(e.loadInput())
+	code.Concat(e.checkCalldataValue())
+	code.Add("DUP1")
+	code.Add("DUP3")
+	code.Add("ADD")
+	code.Concat(addConstant(wordBytes))
+	code.Concat(e.checkCalldataEnd())
+	// start, length, pointer
+	code.Add("DUP1")
+	code.Concat(roundToWord())
+	code.Concat(addConstant(wordBytes))
+	code.Concat(allocate())
+	code.Add("DUP2")
+	code.Add("DUP2")
+	code.Add("MSTORE")
+	// copy length bytes from after the length in calldata
+	code.Add("DUP2")
+	code.Add("DUP4")
+	code.Concat(addConstant(wordBytes))
+	code.Add("DUP3")
+	code.Concat(addConstant(wordBytes))
+	code.Concat(e.copyInput())
+	code.Add("SWAP2")
+	code.Add("POP")
+	code.Add("POP")
+	return code
+}
+
+// arrays are copied to the heap one word per element, preceded by the
+// length if it is variable
+func (e *GuardianEVM) decodeArray(t *abiValue) (code vmgen.Bytecode) {
+	// start
+	if t.dynamic() {
+		code.Concat(e.tail())
+	} else {
+		code.Add("SWAP1")
+		code.Add("POP")
+	}
+	// elements, length
+	if t.variable {
+		code.Add("DUP1")
+		code.Concat(addConstant(wordBytes))
+		code.Concat(e.checkCalldataEnd())
+		code.Add("DUP1")
+		code.Concat(e.loadInput())
+		code.Concat(e.checkCalldataValue())
+		code.Add("SWAP1")
+		code.Concat(addConstant(wordBytes))
+		code.Add("SWAP1")
+	} else {
+		code.Concat(push(encodeUint(t.length)))
+	}
+	// static fixed arrays are bounded by the enclosing encoding
+	if t.dynamic() {
+		code.Add("DUP1")
+		code.Concat(push(encodeUint(t.elem.headSize())))
+		code.Add("MUL")
+		code.Add("DUP3")
+		code.Add("ADD")
+		code.Concat(e.checkCalldataEnd())
+	}
+	// elements, length, pointer
+	data := uint(0)
+	if t.variable {
+		data = wordBytes
+	}
+	code.Add("DUP1")
+	code.Concat(push([]byte{5}))
+	code.Add("SHL")
+	code.Concat(addConstant(data))
+	code.Concat(allocate())
+	if t.variable {
+		code.Add("DUP2")
+		code.Add("DUP2")
+		code.Add("MSTORE")
+	}
+	// elements, length, pointer, index
+	loop, end := e.newLabel(), e.newLabel()
+	code.Concat(push([]byte{0}))
+	code.Concat(jumpdest(loop))
+	code.Add("DUP3")
+	code.Add("DUP2")
+	code.Add("LT")
+	code.Add("ISZERO")
+	code.Concat(pushLabel(end))
+	code.Add("JUMPI")
+	// offsets within the elements are relative to their start
+	code.Add("DUP4")
+	code.Add("DUP2")
+	code.Concat(push(encodeUint(t.elem.headSize())))
+	code.Add("MUL")
+	code.Add("DUP2")
+	code.Add("ADD")
+	code.Concat(e.decode(t.elem))
+	code.Add("DUP2")
+	code.Concat(push([]byte{5}))
+	code.Add("SHL")
+	code.Add("DUP4")
+	code.Add("ADD")
+	code.Concat(addConstant(data))
+	code.Add("MSTORE")
+	code.Concat(push([]byte{1}))
+	code.Add("ADD")
+	code.Concat(pushLabel(loop))
+	code.Add("JUMP")
+	code.Concat(jumpdest(end))
+	code.Add("POP")
+	code.Add("SWAP2")
+	code.Add("POP")
+	code.Add("POP")
+	return code
+}
+
+// tuples are copied to the heap one word per component
+func (e *GuardianEVM) decodeTuple(t *abiValue) (code vmgen.Bytecode) {
+	// start
+	if t.dynamic() {
+		code.Concat(e.tail())
+		code.Add("DUP1")
+		code.Concat(addConstant(t.headSize()))
+		code.Concat(e.checkCalldataEnd())
+	} else {
+		code.Add("SWAP1")
+		code.Add("POP")
+	}
+	// start, pointer
+	code.Concat(push(encodeUint(uint(len(t.components)) * wordBytes)))
+	code.Concat(allocate())
+	offset := uint(0)
+	for i, c := range t.components {
+		code.Add("DUP2")
+		code.Add("DUP1")
+		code.Concat(addConstant(offset))
+		code.Concat(e.decode(c))
+		code.Add("DUP2")
+		code.Concat(addConstant(uint(i) * wordBytes))
+		code.Add("MSTORE")
+		offset += c.headSize()
+	}
+	code.Add("SWAP1")
+	code.Add("POP")
+	return code
+}
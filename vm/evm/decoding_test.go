@@ -0,0 +1,96 @@
+package evm
+
+import (
+	"testing"
+
+	"github.com/end-r/goutil"
+)
+
+func TestParseABITypeStatic(t *testing.T) {
+	u := parseABIType("uint8")
+	goutil.Assert(t, u.kind == abiWord && u.bits == 8 && !u.signed, "wrong uint8")
+	i := parseABIType("int64")
+	goutil.Assert(t, i.kind == abiWord && i.bits == 64 && i.signed, "wrong int64")
+	b := parseABIType("bytes4")
+	goutil.Assert(t, b.kind == abiWord && b.bits == 32 && b.left, "wrong bytes4")
+	a := parseABIType("address")
+	goutil.Assert(t, a.bits == 160, "wrong address")
+	goutil.Assert(t, !a.dynamic(), "address should be static")
+	goutil.Assert(t, a.headSize() == 32, "wrong address head size")
+}
+
+func TestParseABITypeDynamic(t *testing.T) {
+	goutil.Assert(t, parseABIType("string").dynamic(), "string should be dynamic")
+	goutil.Assert(t, parseABIType("bytes").dynamic(), "bytes should be dynamic")
+	arr := parseABIType("uint256[]")
+	goutil.Assert(t, arr.kind == abiArray && arr.variable, "wrong variable array")
+	goutil.Assert(t, arr.dynamic(), "variable arrays should be dynamic")
+	goutil.Assert(t, arr.headSize() == 32, "dynamic values should occupy one head word")
+}
+
+func TestParseABITypeFixedArrays(t *testing.T) {
+	fixed := parseABIType("uint8[3]")
+	goutil.Assert(t, fixed.kind == abiArray && fixed.length == 3, "wrong fixed array")
+	goutil.Assert(t, !fixed.dynamic(), "fixed arrays of static types should be static")
+	goutil.Assert(t, fixed.headSize() == 96, "static arrays should be encoded in place")
+	nested := parseABIType("string[2]")
+	goutil.Assert(t, nested.dynamic(), "fixed arrays of dynamic types should be dynamic")
+	grid := parseABIType("uint8[2][3]")
+	goutil.Assert(t, grid.length == 3 && grid.elem.length == 2, "wrong array dimensions")
+	goutil.Assert(t, grid.headSize() == 192, "wrong grid head size")
+}
+
+func TestParseABITypeTuples(t *testing.T) {
+	tuple := parseABIType("(uint8,(bool,address),uint256[2])")
+	goutil.AssertNow(t, tuple.kind == abiTuple, "wrong tuple kind")
+	goutil.AssertLength(t, len(tuple.components), 3)
+	goutil.AssertLength(t, len(tuple.components[1].components), 2)
+	goutil.Assert(t, tuple.headSize() == 160, "static tuples should be encoded in place")
+	goutil.Assert(t, parseABIType("(uint8,string)").dynamic(), "tuples with dynamic members should be dynamic")
+	goutil.AssertLength(t, len(parseABIType("()").components), 0)
+}
+
+func TestDecodeWordValidation(t *testing.T) {
+	e := NewVM()
+	code := e.decode(parseABIType("address"))
+	expected := []string{
+		"SWAP1", "POP", "CALLDATALOAD",
+		// revert if any of the top 96 bits are set
+		"DUP1", "PUSH1", "SHR", "PUSH2", "JUMPI",
+	}
+	goutil.Assert(t, code.CompareMnemonics(expected), code.Format())
+	full := e.decode(parseABIType("uint256"))
+	goutil.Assert(t, full.CompareMnemonics([]string{"SWAP1", "POP", "CALLDATALOAD"}), full.Format())
+}
+
+func TestDecodeBytesCopiesCalldata(t *testing.T) {
+	e := NewVM()
+	code := e.decode(parseABIType("string"))
+	copies := 0
+	for _, c := range code.Commands {
+		if c.Mnemonic == "CALLDATACOPY" {
+			copies++
+		}
+	}
+	goutil.Assert(t, copies == 1, "bytes should be copied from calldata")
+}
+
+func TestExternalParametersStored(t *testing.T) {
+	code := traverseContract(t, `
+		contract Token {
+			external func give(to address, amount uint) {}
+		}
+	`)
+	// parameters are stored in reverse order into consecutive memory blocks
+	var stored []byte
+	for i, c := range code.Commands {
+		if c.Mnemonic == "MSTORE" && i > 0 && code.Commands[i-1].Mnemonic == "PUSH1" {
+			stored = append(stored, code.Commands[i-1].Parameters[0])
+		}
+	}
+	goutil.AssertNow(t, len(stored) == 3, "wrong number of stores")
+	// the first store initialises the free memory pointer
+	goutil.Assert(t, stored[0] == freeMemoryPointer, "wrong free memory pointer")
+	goutil.Assert(t, stored[1] == memoryStart+32, "amount should be stored second")
+	goutil.Assert(t, stored[2] == memoryStart, "to should be stored first")
+}
@@ -0,0 +1,223 @@
+package evm
+
+import (
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/token"
+	"github.com/end-r/guardian/util"
+	"github.com/end-r/vmgen"
+)
+
+// Artifacts are the two programs generated for a contract: the creation
+// code, which is run once to deploy the contract, and the runtime code which
+// the creation code returns to become the code of the contract
+// when they are assembled, the runtime code is appended to the creation code,
+// and is followed by the ABI-encoded constructor arguments
+type Artifacts struct {
+	Creation vmgen.Bytecode
+	Runtime  vmgen.Bytecode
+	// the source nodes indexed by SOURCE markers, if the code is annotated
+	sources []ast.Node
+	// the functions which can be called from outside the contract
+	functions []hook
+}
+
+// Deploy generates the creation and runtime code of a validated contract
+func (evm GuardianEVM) Deploy(n *ast.ContractDeclarationNode) (Artifacts, util.Errors) {
+	e := &evm
+	runtime := e.traverseContract(n)
+	// the runtime code is followed by the constructor arguments
+	runtimeEnd := e.newLabel()
+	runtime.Concat(codeLabel(runtimeEnd))
+	creation := e.createConstructor(n, runtimeEnd)
+	optimized := Optimize(e.optimization, creation, runtime)
+	return Artifacts{
+		Creation:  optimized[0],
+		Runtime:   optimized[1],
+		sources:   e.sources,
+		functions: e.callableHooks(),
+	}, e.errs
+}
+
+// constructors returns the constructor of a contract and each of its supers,
+// in the order in which they run
+func constructors(n *ast.ContractDeclarationNode) []*ast.LifecycleDeclarationNode {
+	var lifecycles []*ast.LifecycleDeclarationNode
+	for _, c := range linearise(n, nil) {
+		if l := constructor(c); l != nil {
+			lifecycles = append(lifecycles, l)
+		}
+	}
+	return lifecycles
+}
+
+func constructor(n *ast.ContractDeclarationNode) *ast.LifecycleDeclarationNode {
+	if n.Body == nil || n.Body.Declarations == nil {
+		return nil
+	}
+	for _, d := range n.Body.Declarations.Array() {
+		if l, ok := d.(*ast.LifecycleDeclarationNode); ok && l.Category == token.Constructor {
+			return l
+		}
+	}
+	return nil
+}
+
+// constructorParameters are the parameters of every constructor of a
+// contract, which are encoded together as its constructor arguments
+func constructorParameters(n *ast.ContractDeclarationNode) []*ast.ExplicitVarDeclarationNode {
+	var params []*ast.ExplicitVarDeclarationNode
+	for _, l := range constructors(n) {
+		params = append(params, l.Parameters...)
+	}
+	return params
+}
+
+// createConstructor generates the creation code of a contract: it decodes
+// the constructor arguments, then initialises the fields of each contract
+// and runs its constructor, supers first, and finally returns the runtime
+// code
+func (e *GuardianEVM) createConstructor(n *ast.ContractDeclarationNode, runtimeEnd int) (code vmgen.Bytecode) {
+	// the creation code runs with its own memory
+	e.memory, e.freedMemory, e.memoryCursor = nil, nil, 0
+	e.revertLabel, e.bubbleLabel = 0, 0
+	e.inStorage = true
+	// the functions called by the constructors are generated again, as the
+	// runtime code can't be jumped to before it is deployed
+	e.functionLabels, e.packageFunctions = nil, nil
+
+	creationEnd := e.newLabel()
+
+	var body vmgen.Bytecode
+	params := constructorParameters(n)
+	e.checkABITypes(n.Start(), errConstructorArgument, n.Identifier, params, nil)
+	if len(params) > 0 {
+		body.Concat(e.copyConstructorArguments(creationEnd, runtimeEnd))
+		e.inputInMemory = true
+		body.Concat(e.decodeParameters(params, 0))
+		e.inputInMemory = false
+		body.Concat(e.storeParameters(params))
+	}
+	for _, c := range linearise(n, nil) {
+		e.unchecked = uncheckedArithmetic(c, nil)
+		body.Concat(e.initialiseFields(c))
+		if l := constructor(c); l != nil {
+			body.Concat(e.annotate(l, func() (code vmgen.Bytecode) {
+				e.enterFunction()
+				defer e.exitFunction()
+				e.returnLabel = e.newLabel()
+				e.unchecked = uncheckedArithmetic(c, l.Modifiers.Modifiers)
+				code.Concat(e.traverseScope(l.Body))
+				code.Concat(jumpdest(e.returnLabel))
+				e.returnLabel = 0
+				return code
+			}))
+		}
+	}
+	e.unchecked = false
+	functions := e.createCalledFunctions()
+
+	// the heap begins after every memory block used by the constructors
+	code.Concat(push(encodeUint(e.heapStart())))
+	code.Concat(push([]byte{freeMemoryPointer}))
+	code.Add("MSTORE")
+	code.Concat(body)
+
+	// copy the runtime code to the start of memory and return it
+	code.Concat(pushLabel(runtimeEnd))
+	code.Add("DUP1")
+	code.Concat(pushLabel(creationEnd))
+	code.Concat(push([]byte{0}))
+	code.Add("CODECOPY")
+	code.Concat(push([]byte{0}))
+	code.Add("RETURN")
+
+	code.Concat(functions)
+	if e.revertLabel != 0 {
+		code.Concat(jumpdest(e.revertLabel))
+		code.Concat(revert())
+	}
+	code.Concat(e.bubbleRevert())
+	code.Concat(codeLabel(creationEnd))
+	return code
+}
+
+// createCalledFunctions generates every function which has been given a
+// label by a call, including the functions which they call in turn, in the
+// order in which they were first called
+func (e *GuardianEVM) createCalledFunctions() (code vmgen.Bytecode) {
+	generated := make(map[string]bool)
+	for {
+		name, label := "", 0
+		for n, l := range e.functionLabels {
+			if !generated[n] && (label == 0 || l < label) {
+				name, label = n, l
+			}
+		}
+		if label == 0 {
+			return code
+		}
+		generated[name] = true
+		f := e.declaredFunction(name)
+		if f == nil {
+			f = e.packageFunction(name)
+		}
+		code.Concat(e.annotate(f, func() vmgen.Bytecode {
+			e.enterFunction()
+			defer e.exitFunction()
+			return e.createInternalFunction(f, label)
+		}))
+	}
+}
+
+// copyConstructorArguments copies everything after the runtime code onto the
+// heap, and stores where it was copied to and its size
+func (e *GuardianEVM) copyConstructorArguments(creationEnd, runtimeEnd int) (code vmgen.Bytecode) {
+	e.allocateMemory(inputBlock, wordSize)
+	e.allocateMemory(inputSizeBlock, wordSize)
+	// start, size
+	code.Concat(pushLabel(creationEnd))
+	code.Concat(pushLabel(runtimeEnd))
+	code.Add("ADD")
+	code.Add("DUP1")
+	code.Add("CODESIZE")
+	code.Add("SUB")
+	// start, size, pointer
+	code.Add("DUP1")
+	code.Concat(roundToWord())
+	code.Concat(allocate())
+	code.Add("DUP2")
+	code.Add("DUP4")
+	code.Add("DUP3")
+	code.Add("CODECOPY")
+	code.Concat(e.lookupMemory(inputBlock).store())
+	code.Concat(e.lookupMemory(inputSizeBlock).store())
+	code.Add("POP")
+	return code
+}
+
+// initialiseFields stores the value of every field of a contract which is
+// declared with one
+func (e *GuardianEVM) initialiseFields(n *ast.ContractDeclarationNode) (code vmgen.Bytecode) {
+	if n.Body == nil || n.Body.Declarations == nil {
+		return code
+	}
+	// declarations of several variables are added once for each of them
+	seen := make(map[*ast.ExplicitVarDeclarationNode]bool)
+	for _, d := range n.Body.Declarations.Array() {
+		v, ok := d.(*ast.ExplicitVarDeclarationNode)
+		if !ok || v.Value == nil || seen[v] {
+			continue
+		}
+		seen[v] = true
+		code.Concat(e.annotate(v, func() (code vmgen.Bytecode) {
+			for _, id := range v.Identifiers {
+				if s := e.lookupStorage(id); s != nil {
+					code.Concat(e.traverseExpression(v.Value))
+					code.Concat(s.store())
+				}
+			}
+			return code
+		}))
+	}
+	return code
+}
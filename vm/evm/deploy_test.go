@@ -0,0 +1,82 @@
+package evm
+
+import (
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
+)
+
+func deploy(t *testing.T, name, text string) Artifacts {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, text)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c, ok := scope.GetDeclaration(name).(*ast.ContractDeclarationNode)
+	goutil.AssertNow(t, ok, "contract not found")
+	a, errs := e.Deploy(c)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	return a
+}
+
+func TestDeployWithoutConstructor(t *testing.T) {
+	a := deploy(t, "Token", `
+		contract Token {
+			external func pause() {}
+		}
+	`)
+	expected := []string{
+		// initialise the free memory pointer
+		"PUSH1", "PUSH1", "MSTORE",
+		// copy the runtime code to memory and return it
+		"PUSH2", "DUP1", "PUSH2", "PUSH1", "CODECOPY", "PUSH1", "RETURN",
+		// the end of the creation code
+		"LABEL",
+	}
+	goutil.Assert(t, a.Creation.CompareMnemonics(expected), a.Creation.Format())
+	last := a.Runtime.Commands[len(a.Runtime.Commands)-1]
+	goutil.Assert(t, last.IsMarker && last.Mnemonic == "LABEL", "runtime code should end with a label")
+}
+
+func TestDeployConstructorArguments(t *testing.T) {
+	a := deploy(t, "Token", `
+		contract Token {
+			constructor(supply uint, name string) {}
+		}
+	`)
+	// the arguments are copied from the end of the code, then decoded
+	goutil.Assert(t, countMnemonic(a.Creation.Commands, "CODESIZE") == 1, "arguments should be measured")
+	goutil.Assert(t, countMnemonic(a.Creation.Commands, "CODECOPY") == 2, "arguments should be copied")
+	goutil.Assert(t, countMnemonic(a.Creation.Commands, "CALLDATALOAD") == 0, "arguments are not in calldata")
+}
+
+func TestDeployFieldValues(t *testing.T) {
+	a := deploy(t, "Token", `
+		contract Token {
+			var owner address
+			var supply uint = 100
+		}
+	`)
+	goutil.Assert(t, countMnemonic(a.Creation.Commands, "SSTORE") == 1, "supply should be initialised")
+	goutil.Assert(t, countMnemonic(a.Runtime.Commands, "SSTORE") == 0, "fields are only initialised once")
+}
+
+func TestConstructorParametersInherited(t *testing.T) {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, `
+		contract Owned {
+			constructor(owner address) {}
+		}
+		contract Token inherits Owned {
+			constructor(supply uint) {}
+		}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c := scope.GetDeclaration("Token").(*ast.ContractDeclarationNode)
+	params := constructorParameters(c)
+	goutil.AssertNow(t, len(params) == 2, "wrong parameter count")
+	goutil.Assert(t, params[0].Identifiers[0] == "owner", "super constructors should run first")
+	entries := e.ContractABI(c)
+	goutil.AssertNow(t, len(entries) == 1, "wrong entry count")
+	goutil.Assert(t, len(entries[0].Inputs) == 2, "the constructor should take every argument")
+}
@@ -0,0 +1,74 @@
+package evm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Operation is a single instruction of disassembled code
+type Operation struct {
+	Offset   int
+	Opcode   byte
+	Mnemonic string
+	// the immediate data of a PUSH, which is shorter than the instruction
+	// expects if the code ends first
+	Data []byte
+}
+
+func (o Operation) String() string {
+	if _, ok := opcodes[o.Opcode]; !ok {
+		return fmt.Sprintf("%s 0x%02x", o.Mnemonic, o.Opcode)
+	}
+	if len(o.Data) == 0 {
+		return o.Mnemonic
+	}
+	return fmt.Sprintf("%s 0x%s", o.Mnemonic, hex.EncodeToString(o.Data))
+}
+
+// unknownMnemonic names opcodes which are not part of the instruction set
+const unknownMnemonic = "UNKNOWN"
+
+// Disassemble splits raw bytecode into its instructions
+// data which follows the code, such as the runtime code after the creation
+// code, is disassembled as though it were code
+func Disassemble(raw []byte) []Operation {
+	var ops []Operation
+	for offset := 0; offset < len(raw); {
+		op := Operation{
+			Offset:   offset,
+			Opcode:   raw[offset],
+			Mnemonic: unknownMnemonic,
+		}
+		size := 1
+		if i, ok := opcodes[op.Opcode]; ok {
+			op.Mnemonic = i.Mnemonic
+			size = i.Size()
+		}
+		end := offset + size
+		if end > len(raw) {
+			end = len(raw)
+		}
+		if end > offset+1 {
+			op.Data = raw[offset+1 : end]
+		}
+		ops = append(ops, op)
+		offset += size
+	}
+	return ops
+}
+
+// formatOperation lists an operation after its offset
+func formatOperation(o Operation) string {
+	return fmt.Sprintf("%04x | %s", o.Offset, o)
+}
+
+// Disassembly lists each instruction of raw bytecode on its own line,
+// after its offset
+func (evm GuardianEVM) Disassembly(raw []byte) string {
+	var lines []string
+	for _, o := range Disassemble(raw) {
+		lines = append(lines, formatOperation(o)+"\n")
+	}
+	return strings.Join(lines, "")
+}
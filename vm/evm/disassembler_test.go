@@ -0,0 +1,59 @@
+package evm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/vmgen"
+)
+
+func TestDisassembleInstructions(t *testing.T) {
+	ops := Disassemble([]byte{0x60, 0x80, 0x60, 0x40, 0x52, 0x00})
+	goutil.AssertNow(t, len(ops) == 4, "wrong operation count")
+	goutil.Assert(t, ops[0].Offset == 0 && ops[0].String() == "PUSH1 0x80", ops[0].String())
+	goutil.Assert(t, ops[1].Offset == 2 && ops[1].String() == "PUSH1 0x40", ops[1].String())
+	goutil.Assert(t, ops[2].Offset == 4 && ops[2].String() == "MSTORE", ops[2].String())
+	goutil.Assert(t, ops[3].Offset == 5 && ops[3].String() == "STOP", ops[3].String())
+}
+
+func TestDisassembleUnknownOpcode(t *testing.T) {
+	ops := Disassemble([]byte{0x0c, 0x00})
+	goutil.AssertNow(t, len(ops) == 2, "wrong operation count")
+	goutil.Assert(t, ops[0].Mnemonic == "UNKNOWN", ops[0].Mnemonic)
+	goutil.Assert(t, ops[0].String() == "UNKNOWN 0x0c", ops[0].String())
+}
+
+func TestDisassembleTruncatedPush(t *testing.T) {
+	ops := Disassemble([]byte{0x00, 0x62, 0x01, 0x02})
+	goutil.AssertNow(t, len(ops) == 2, "wrong operation count")
+	goutil.Assert(t, ops[1].Mnemonic == "PUSH3", ops[1].Mnemonic)
+	goutil.Assert(t, bytes.Equal(ops[1].Data, []byte{0x01, 0x02}), "wrong push data")
+}
+
+func TestDisassembly(t *testing.T) {
+	d := NewVM().Disassembly([]byte{0x61, 0x01, 0x02, 0x56, 0x5b})
+	expected := "0000 | PUSH2 0x0102\n0003 | JUMP\n0004 | JUMPDEST\n"
+	goutil.Assert(t, d == expected, d)
+}
+
+func TestDisassembleAssembledCode(t *testing.T) {
+	e := NewVM()
+	l := e.newLabel()
+	var code vmgen.Bytecode
+	code.Add("PUSH1", 1)
+	code.Add("PUSH32", bytes.Repeat([]byte{0xff}, 32)...)
+	code.Concat(pushLabel(l))
+	code.Add("JUMP")
+	code.Concat(jumpdest(l))
+	code.Add("LOG1")
+	raw, errs := e.Assemble(code)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	ops := Disassemble(raw)
+	expected := []string{"PUSH1", "PUSH32", "PUSH2", "JUMP", "JUMPDEST", "LOG1"}
+	goutil.AssertNow(t, len(ops) == len(expected), "wrong operation count")
+	for i, m := range expected {
+		goutil.Assert(t, ops[i].Mnemonic == m, ops[i].Mnemonic)
+	}
+	goutil.Assert(t, ops[2].Data[1] == byte(ops[4].Offset), "label should resolve to the jump destination")
+}
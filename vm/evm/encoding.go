@@ -0,0 +1,311 @@
+package evm
+
+import "github.com/end-r/vmgen"
+
+// values are encoded from the same memory layout which calldata is decoded
+// into: words are held directly, while everything else is held as a pointer
+// to its data on the heap
+
+// encodeReturn ABI-encodes the values on the stack, with the last value on
+// top, and returns them from the current call
+func (e *GuardianEVM) encodeReturn(types []*abiValue) (code vmgen.Bytecode) {
+	if len(types) == 0 {
+		code.Add("STOP")
+		return code
+	}
+	code.Concat(e.encodeValues(types))
+	// start, end
+	code.Add("DUP2")
+	code.Add("SWAP1")
+	code.Add("SUB")
+	code.Add("SWAP1")
+	code.Add("RETURN")
+	return code
+}
+
+// encodeValues replaces the values on the stack, with the last value on top,
+// with the start and end of their encoding on the heap
+func (e *GuardianEVM) encodeValues(types []*abiValue) (code vmgen.Bytecode) {
+	// move the values into a tuple on the heap
+	code.Concat(push(encodeUint(uint(len(types)) * wordBytes)))
+	code.Concat(allocate())
+	for i := len(types) - 1; i >= 0; i-- {
+		code.Add("SWAP1")
+		code.Add("DUP2")
+		code.Concat(addConstant(uint(i) * wordBytes))
+		code.Add("MSTORE")
+	}
+	// start, start, tuple
+	code.Concat(push([]byte{freeMemoryPointer}))
+	code.Add("MLOAD")
+	code.Add("DUP1")
+	code.Add("SWAP2")
+	code.Concat(e.encodeComponents(types))
+	return code
+}
+
+// encodeComponents replaces the destination and a pointer to the members of
+// a tuple with the end of their encoding
+func (e *GuardianEVM) encodeComponents(components []*abiValue) (code vmgen.Bytecode) {
+	// destination, pointer, end
+	code.Add("DUP2")
+	code.Concat(addConstant(tupleHeadSize(components)))
+	offset := uint(0)
+	for i, c := range components {
+		member := uint(i) * wordBytes
+		switch {
+		case c.dynamic():
+			// the head refers to the tail by its offset from the destination
+			code.Add("DUP3")
+			code.Add("DUP2")
+			code.Add("SUB")
+			code.Add("DUP4")
+			code.Concat(addConstant(offset))
+			code.Add("MSTORE")
+			code.Add("DUP2")
+			code.Concat(addConstant(member))
+			code.Add("MLOAD")
+			code.Concat(e.encodeDynamic(c))
+			break
+		default:
+			code.Add("DUP3")
+			code.Concat(addConstant(offset))
+			code.Add("DUP3")
+			code.Concat(addConstant(member))
+			code.Add("MLOAD")
+			code.Concat(e.encodeStatic(c))
+			break
+		}
+		offset += c.headSize()
+	}
+	code.Add("SWAP2")
+	code.Add("POP")
+	code.Add("POP")
+	return code
+}
+
+// encodeStatic consumes a destination and a value, and writes the encoding
+// of the value in place
+func (e *GuardianEVM) encodeStatic(t *abiValue) (code vmgen.Bytecode) {
+	switch t.kind {
+	case abiArray:
+		code.Concat(push(encodeUint(t.length)))
+		code.Concat(e.encodeElements(t.elem))
+		code.Add("POP")
+		break
+	case abiTuple:
+		code.Concat(e.encodeComponents(t.components))
+		code.Add("POP")
+		break
+	default:
+		code.Add("SWAP1")
+		code.Add("MSTORE")
+	}
+	return code
+}
+
+// encodeDynamic replaces the end of an encoding and a pointer to a dynamic
+// value with the end of the encoding once the value has been appended
+func (e *GuardianEVM) encodeDynamic(t *abiValue) (code vmgen.Bytecode) {
+	switch t.kind {
+	case abiBytes:
+		return e.encodeBytes()
+	case abiArray:
+		if t.variable {
+			// the length precedes the elements
+			code.Add("DUP1")
+			code.Add("MLOAD")
+			code.Add("DUP1")
+			code.Add("DUP4")
+			code.Add("MSTORE")
+			code.Add("SWAP1")
+			code.Concat(addConstant(wordBytes))
+			code.Add("SWAP1")
+			code.Add("SWAP2")
+			code.Concat(addConstant(wordBytes))
+			code.Add("SWAP2")
+		} else {
+			code.Concat(push(encodeUint(t.length)))
+		}
+		code.Concat(e.encodeElements(t.elem))
+		break
+	case abiTuple:
+		code.Concat(e.encodeComponents(t.components))
+		break
+	}
+	return code
+}
+
+// bytes and strings are copied along with their length, and padded with
+// zeros to a whole number of words
+func (e *GuardianEVM) encodeBytes() (code vmgen.Bytecode) {
+	// end, pointer, length, words
+	code.Add("DUP1")
+	code.Add("MLOAD")
+	code.Add("DUP1")
+	code.Concat(roundToWord())
+	code.Concat(push([]byte{5}))
+	code.Add("SHR")
+	code.Concat(addConstant(1))
+	code.Add("DUP4")
+	code.Add("DUP4")
+	code.Add("DUP3")
+	code.Concat(e.copyWords())
+	// clear anything after the data in its last word
+	code.Concat(push([]byte{0}))
+	code.Add("DUP3")
+	code.Add("DUP6")
+	code.Add("ADD")
+	code.Concat(addConstant(wordBytes))
+	code.Add("MSTORE")
+	code.Concat(push([]byte{5}))
+	code.Add("SHL")
+	code.Add("SWAP2")
+	code.Add("POP")
+	code.Add("POP")
+	code.Add("ADD")
+	return code
+}
+
+// encodeElements replaces a destination, a pointer to the elements of an
+// array and their number with the end of their encoding
+func (e *GuardianEVM) encodeElements(elem *abiValue) (code vmgen.Bytecode) {
+	if elem.dynamic() {
+		return e.encodeDynamicElements(elem)
+	}
+	if elem.kind == abiWord {
+		// words are already encoded
+		code.Add("DUP3")
+		code.Add("DUP3")
+		code.Add("DUP3")
+		code.Concat(e.copyWords())
+		code.Concat(push([]byte{5}))
+		code.Add("SHL")
+		code.Add("SWAP1")
+		code.Add("POP")
+		code.Add("ADD")
+		return code
+	}
+	// destination, pointer, length, index
+	loop, end := e.newLabel(), e.newLabel()
+	code.Concat(push([]byte{0}))
+	code.Concat(jumpdest(loop))
+	code.Add("DUP2")
+	code.Add("DUP2")
+	code.Add("LT")
+	code.Add("ISZERO")
+	code.Concat(pushLabel(end))
+	code.Add("JUMPI")
+	code.Add("DUP4")
+	code.Add("DUP2")
+	code.Concat(push(encodeUint(elem.headSize())))
+	code.Add("MUL")
+	code.Add("ADD")
+	code.Add("DUP4")
+	code.Add("DUP3")
+	code.Concat(push([]byte{5}))
+	code.Add("SHL")
+	code.Add("ADD")
+	code.Add("MLOAD")
+	code.Concat(e.encodeStatic(elem))
+	code.Concat(push([]byte{1}))
+	code.Add("ADD")
+	code.Concat(pushLabel(loop))
+	code.Add("JUMP")
+	code.Concat(jumpdest(end))
+	code.Add("POP")
+	code.Concat(push(encodeUint(elem.headSize())))
+	code.Add("MUL")
+	code.Add("SWAP1")
+	code.Add("POP")
+	code.Add("ADD")
+	return code
+}
+
+// dynamic elements are encoded as a head of offsets followed by their tails
+func (e *GuardianEVM) encodeDynamicElements(elem *abiValue) (code vmgen.Bytecode) {
+	// destination, pointer, length, end, index
+	code.Add("DUP1")
+	code.Concat(push([]byte{5}))
+	code.Add("SHL")
+	code.Add("DUP4")
+	code.Add("ADD")
+	loop, done := e.newLabel(), e.newLabel()
+	code.Concat(push([]byte{0}))
+	code.Concat(jumpdest(loop))
+	code.Add("DUP3")
+	code.Add("DUP2")
+	code.Add("LT")
+	code.Add("ISZERO")
+	code.Concat(pushLabel(done))
+	code.Add("JUMPI")
+	// the offset of the tail from the destination
+	code.Add("DUP5")
+	code.Add("DUP3")
+	code.Add("SUB")
+	code.Add("DUP2")
+	code.Concat(push([]byte{5}))
+	code.Add("SHL")
+	code.Add("DUP7")
+	code.Add("ADD")
+	code.Add("MSTORE")
+	// the tail itself
+	code.Add("DUP2")
+	code.Add("DUP2")
+	code.Concat(push([]byte{5}))
+	code.Add("SHL")
+	code.Add("DUP6")
+	code.Add("ADD")
+	code.Add("MLOAD")
+	code.Concat(e.encodeDynamic(elem))
+	code.Add("SWAP2")
+	code.Add("POP")
+	code.Concat(push([]byte{1}))
+	code.Add("ADD")
+	code.Concat(pushLabel(loop))
+	code.Add("JUMP")
+	code.Concat(jumpdest(done))
+	code.Add("POP")
+	code.Add("SWAP3")
+	code.Add("POP")
+	code.Add("POP")
+	code.Add("POP")
+	return code
+}
+
+// copyWords consumes a destination, a source and a number of words, and
+// copies the words between them
+func (e *GuardianEVM) copyWords() (code vmgen.Bytecode) {
+	// destination, source, words, index
+	loop, done := e.newLabel(), e.newLabel()
+	code.Concat(push([]byte{0}))
+	code.Concat(jumpdest(loop))
+	code.Add("DUP2")
+	code.Add("DUP2")
+	code.Add("LT")
+	code.Add("ISZERO")
+	code.Concat(pushLabel(done))
+	code.Add("JUMPI")
+	code.Add("DUP1")
+	code.Concat(push([]byte{5}))
+	code.Add("SHL")
+	code.Add("DUP4")
+	code.Add("ADD")
+	code.Add("MLOAD")
+	code.Add("DUP2")
+	code.Concat(push([]byte{5}))
+	code.Add("SHL")
+	code.Add("DUP6")
+	code.Add("ADD")
+	code.Add("MSTORE")
+	code.Concat(push([]byte{1}))
+	code.Add("ADD")
+	code.Concat(pushLabel(loop))
+	code.Add("JUMP")
+	code.Concat(jumpdest(done))
+	code.Add("POP")
+	code.Add("POP")
+	code.Add("POP")
+	code.Add("POP")
+	return code
+}
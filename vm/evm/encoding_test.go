@@ -0,0 +1,70 @@
+package evm
+
+import (
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/validator"
+	"github.com/end-r/vmgen"
+)
+
+func countMnemonic(commands []vmgen.Command, mnemonic string) int {
+	count := 0
+	for _, c := range commands {
+		if c.Mnemonic == mnemonic {
+			count++
+		}
+	}
+	return count
+}
+
+func TestEncodeReturnNothing(t *testing.T) {
+	e := NewVM()
+	code := e.encodeReturn(nil)
+	goutil.Assert(t, code.CompareMnemonics([]string{"STOP"}), code.Format())
+}
+
+func TestEncodeReturnWords(t *testing.T) {
+	e := NewVM()
+	code := e.encodeReturn([]*abiValue{parseABIType("uint256"), parseABIType("bool")})
+	goutil.AssertNow(t, len(code.Commands) > 0, "no code generated")
+	last := code.Commands[len(code.Commands)-1]
+	goutil.Assert(t, last.Mnemonic == "RETURN", "values should be returned")
+	// static values are written in place, without loops
+	goutil.Assert(t, countMnemonic(code.Commands, "JUMP") == 0, "static values should not loop")
+}
+
+func TestEncodeReturnString(t *testing.T) {
+	e := NewVM()
+	code := e.encodeReturn([]*abiValue{parseABIType("string")})
+	goutil.Assert(t, countMnemonic(code.Commands, "RETURN") == 1, "values should be returned")
+	// the data is copied word by word
+	goutil.Assert(t, countMnemonic(code.Commands, "JUMP") == 1, "string should be copied")
+}
+
+func TestExternalFunctionReturns(t *testing.T) {
+	code := traverseContract(t, `
+		contract Token {
+			external func add(a, b uint) uint {
+				return a + b
+			}
+		}
+	`)
+	goutil.Assert(t, countMnemonic(code.Commands, "RETURN") == 1, "result should be returned")
+}
+
+func TestTraverseBooleanLiterals(t *testing.T) {
+	e := new(GuardianEVM)
+	expr, _ := validator.ValidateExpression(e, "true")
+	bytecode := e.traverseExpression(expr)
+	goutil.AssertNow(t, bytecode.CompareMnemonics([]string{"PUSH1"}), bytecode.Format())
+	goutil.Assert(t, bytecode.Commands[0].Parameters[0] == 1, "true should be one")
+}
+
+func TestTraverseStringLiteral(t *testing.T) {
+	e := new(GuardianEVM)
+	expr, _ := validator.ValidateExpression(e, `"hello"`)
+	bytecode := e.traverseExpression(expr)
+	// strings are allocated on the heap, with their length first
+	goutil.Assert(t, countMnemonic(bytecode.Commands, "MSTORE") == 3, bytecode.Format())
+}
@@ -0,0 +1,796 @@
+package evm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/util"
+)
+
+// gas is estimated by running the runtime code of a contract on abstract
+// words, each of whose bits are either known or unknown, from the start of
+// the code with the calldata of a function: its selector, followed by the
+// head of its parameters, whose values are unknown
+// both branches of every condition which isn't known are followed, and the
+// cheapest and most expensive paths which end successfully give the range of
+// gas used by the function
+// paths whose gas can't be bounded are flagged: those which revisit a jump
+// destination with the same stack, such as loops, and those whose costs
+// depend on unknown values, such as the size of copied data or the gas used
+// by other contracts
+
+// GasEstimate is the range of gas used by successful calls to a function,
+// excluding the intrinsic gas of the transaction
+type GasEstimate struct {
+	Name      string `json:"name"`
+	Signature string `json:"signature"`
+	Min       uint64 `json:"min"`
+	Max       uint64 `json:"max"`
+	// why the function may use more gas than Max, if it can
+	Unbounded []string `json:"unbounded,omitempty"`
+	// whether every path through the function fails
+	Reverts bool `json:"reverts,omitempty"`
+}
+
+// Bounded reports whether the function never uses more gas than Max
+func (g GasEstimate) Bounded() bool {
+	return len(g.Unbounded) == 0
+}
+
+func (g GasEstimate) String() string {
+	if g.Reverts {
+		return fmt.Sprintf("%s: always reverts", g.Signature)
+	}
+	max := fmt.Sprint(g.Max)
+	if !g.Bounded() {
+		max = fmt.Sprintf("unbounded (%s)", strings.Join(g.Unbounded, ", "))
+	}
+	return fmt.Sprintf("%s: min %d, max %s", g.Signature, g.Min, max)
+}
+
+// GasEstimates returns the JSON gas estimates of a validated contract
+func (evm GuardianEVM) GasEstimates(n *ast.ContractDeclarationNode) ([]byte, error) {
+	estimates, errs := evm.ContractGasEstimates(n)
+	if errs != nil {
+		return nil, errors.New(errs.Format())
+	}
+	return json.Marshal(estimates)
+}
+
+// ContractGasEstimates estimates the gas used by each externally callable
+// function of a validated contract, in the order in which they are declared
+func (evm GuardianEVM) ContractGasEstimates(n *ast.ContractDeclarationNode) ([]GasEstimate, util.Errors) {
+	a, errs := evm.Deploy(n)
+	if errs != nil {
+		return nil, errs
+	}
+	runtime, errs := evm.Assemble(a.Runtime)
+	if errs != nil {
+		return nil, errs
+	}
+	functions := a.functions
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].position < functions[j].position
+	})
+	estimates := make([]GasEstimate, 0)
+	for _, f := range functions {
+		estimates = append(estimates, estimateGas(runtime, f.name, f.signature))
+	}
+	return estimates, nil
+}
+
+// estimateGas follows every path through code which is called with the
+// calldata of a function
+func estimateGas(code []byte, name, signature string) GasEstimate {
+	params := parseABIType(signature[strings.Index(signature, "("):])
+	a := &gasAnalysis{
+		code:         code,
+		jumpdests:    jumpdests(code),
+		selector:     Selector(signature),
+		calldataSize: uint64(selectorSize + tupleHeadSize(params.components)),
+		memo:         make(map[string]gasRange),
+		onPath:       make(map[string]bool),
+		reasons:      make(map[string]bool),
+	}
+	r := a.jump(&gasPath{memory: make(map[uint64]abstractWord)})
+	estimate := GasEstimate{
+		Name:      name,
+		Signature: signature,
+		Min:       r.min,
+		Max:       r.max,
+		Reverts:   !r.succeeds,
+	}
+	for reason := range a.reasons {
+		estimate.Unbounded = append(estimate.Unbounded, reason)
+	}
+	sort.Strings(estimate.Unbounded)
+	return estimate
+}
+
+// jumpdests returns the offset of every JUMPDEST which isn't PUSH data
+func jumpdests(code []byte) map[int]bool {
+	dests := make(map[int]bool)
+	for _, o := range Disassemble(code) {
+		if o.Mnemonic == "JUMPDEST" {
+			dests[o.Offset] = true
+		}
+	}
+	return dests
+}
+
+// abstract words hold the value of their known bits, and zero in every
+// unknown bit
+type abstractWord struct {
+	value, known *big.Int
+}
+
+var (
+	wordMask = sectionMask(wordSize)
+	noBits   = new(big.Int)
+)
+
+func knownWord(v *big.Int) abstractWord {
+	return abstractWord{value: new(big.Int).And(v, wordMask), known: wordMask}
+}
+
+func unknownWord() abstractWord {
+	return abstractWord{value: noBits, known: noBits}
+}
+
+func (w abstractWord) constant() (*big.Int, bool) {
+	return w.value, w.known.Cmp(wordMask) == 0
+}
+
+// small returns the value of a known word which fits in 64 bits
+func (w abstractWord) small() (uint64, bool) {
+	v, ok := w.constant()
+	if !ok || !v.IsUint64() {
+		return 0, false
+	}
+	return v.Uint64(), true
+}
+
+func (w abstractWord) key() string {
+	if _, ok := w.constant(); ok {
+		return w.value.Text(16)
+	}
+	return w.value.Text(16) + "?" + w.known.Text(16)
+}
+
+// gasPath is the abstract state of a path through the code
+type gasPath struct {
+	pc     int
+	stack  []abstractWord
+	memory map[uint64]abstractWord
+	// the number of words of memory in use
+	words uint64
+}
+
+func (p *gasPath) copy() *gasPath {
+	c := &gasPath{
+		pc:     p.pc,
+		stack:  append([]abstractWord(nil), p.stack...),
+		memory: make(map[uint64]abstractWord),
+		words:  p.words,
+	}
+	for k, v := range p.memory {
+		c.memory[k] = v
+	}
+	return c
+}
+
+func (p *gasPath) pop() abstractWord {
+	w := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+	return w
+}
+
+func (p *gasPath) push(w abstractWord) {
+	p.stack = append(p.stack, w)
+}
+
+// peek returns the word n places below the top of the stack
+func (p *gasPath) peek(n int) abstractWord {
+	return p.stack[len(p.stack)-1-n]
+}
+
+// loopKey identifies a jump destination reached with a stack, which is the
+// same for every iteration of a loop
+func (p *gasPath) loopKey() string {
+	keys := []string{fmt.Sprint(p.pc)}
+	for _, w := range p.stack {
+		keys = append(keys, w.key())
+	}
+	return strings.Join(keys, ",")
+}
+
+// key identifies the whole state of a path
+func (p *gasPath) key() string {
+	var offsets []uint64
+	for o := range p.memory {
+		offsets = append(offsets, o)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	keys := []string{p.loopKey(), fmt.Sprint(p.words)}
+	for _, o := range offsets {
+		keys = append(keys, fmt.Sprintf("%d=%s", o, p.memory[o].key()))
+	}
+	return strings.Join(keys, ";")
+}
+
+// write records a write to memory of size bytes from an offset, and the
+// value written if it is a single word
+// writes to unknown offsets are assumed to be to the heap, and never to
+// overlap the memory blocks of variables
+func (p *gasPath) write(offset, size abstractWord, value *abstractWord) {
+	o, ok := offset.small()
+	if !ok {
+		return
+	}
+	s, known := size.small()
+	for k := range p.memory {
+		if k+uint64(wordBytes) > o && (!known || k < o+s) {
+			delete(p.memory, k)
+		}
+	}
+	if value != nil {
+		p.memory[o] = *value
+	}
+}
+
+// gasRange is the range of gas used by the successful paths from a state
+type gasRange struct {
+	min, max uint64
+	succeeds bool
+}
+
+// after adds the gas used before the state to every path from it
+func (r gasRange) after(min, max uint64) gasRange {
+	if !r.succeeds {
+		return r
+	}
+	return gasRange{min: r.min + min, max: r.max + max, succeeds: true}
+}
+
+// or combines the paths from either of two states
+func (r gasRange) or(o gasRange) gasRange {
+	if !r.succeeds {
+		return o
+	}
+	if !o.succeeds {
+		return r
+	}
+	if o.min < r.min {
+		r.min = o.min
+	}
+	if o.max > r.max {
+		r.max = o.max
+	}
+	return r
+}
+
+var (
+	succeeded = gasRange{succeeds: true}
+	failed    = gasRange{}
+)
+
+// the number of jump destinations which are followed before the remaining
+// paths are treated as unbounded
+const maxGasStates = 20000
+
+type gasAnalysis struct {
+	code         []byte
+	jumpdests    map[int]bool
+	selector     []byte
+	calldataSize uint64
+	// the ranges of states which have already been followed
+	memo map[string]gasRange
+	// the jump destinations on the current path, by loop key
+	onPath  map[string]bool
+	states  int
+	reasons map[string]bool
+}
+
+func (a *gasAnalysis) unbounded(format string, args ...interface{}) {
+	a.reasons[fmt.Sprintf(format, args...)] = true
+}
+
+// jump follows the paths from a jump destination
+func (a *gasAnalysis) jump(p *gasPath) gasRange {
+	key := p.key()
+	if r, ok := a.memo[key]; ok {
+		return r
+	}
+	loop := p.loopKey()
+	if a.onPath[loop] {
+		a.unbounded("loop at %#04x", p.pc)
+		return failed
+	}
+	a.states++
+	if a.states > maxGasStates {
+		a.unbounded("too many paths")
+		return failed
+	}
+	a.onPath[loop] = true
+	r := a.run(p)
+	delete(a.onPath, loop)
+	a.memo[key] = r
+	return r
+}
+
+// run follows a path until it ends or branches, charging the gas of each
+// instruction on the way
+func (a *gasAnalysis) run(p *gasPath) gasRange {
+	var min, max uint64
+	charge := func(lo, hi uint64) {
+		min += lo
+		max += hi
+	}
+	for {
+		if p.pc >= len(a.code) {
+			// running off the end of the code stops
+			return succeeded.after(min, max)
+		}
+		pc := p.pc
+		op, ok := opcodes[a.code[pc]]
+		if !ok || op.Mnemonic == "INVALID" {
+			return failed
+		}
+		if len(p.stack) < op.Pops || len(p.stack)-op.Pops+op.Pushes > maxStackSize {
+			return failed
+		}
+		if op.Mnemonic != "SSTORE" {
+			charge(uint64(op.Gas), uint64(op.Gas))
+		}
+		p.pc += op.Size()
+		switch {
+		case strings.HasPrefix(op.Mnemonic, "PUSH"):
+			data := make([]byte, op.Size()-1)
+			copy(data, a.code[pc+1:])
+			p.push(knownWord(new(big.Int).SetBytes(data)))
+			continue
+		case strings.HasPrefix(op.Mnemonic, "DUP"):
+			p.push(p.peek(op.Pops - 1))
+			continue
+		case strings.HasPrefix(op.Mnemonic, "SWAP"):
+			top, n := len(p.stack)-1, len(p.stack)-op.Pops
+			p.stack[top], p.stack[n] = p.stack[n], p.stack[top]
+			continue
+		case strings.HasPrefix(op.Mnemonic, "LOG"):
+			offset, size := p.pop(), p.pop()
+			for i := 2; i < op.Pops; i++ {
+				p.pop()
+			}
+			lo, ok := a.expand(p, pc, offset, size)
+			if !ok {
+				return failed
+			}
+			charge(lo, lo)
+			a.charge(pc, size, gasLogByte, 1, charge)
+			continue
+		}
+		switch op.Mnemonic {
+		case "STOP":
+			return succeeded.after(min, max)
+		case "RETURN", "REVERT":
+			offset, size := p.pop(), p.pop()
+			lo, ok := a.expand(p, pc, offset, size)
+			if !ok || op.Mnemonic == "REVERT" {
+				return failed
+			}
+			charge(lo, lo)
+			return succeeded.after(min, max)
+		case "SELFDESTRUCT":
+			p.pop()
+			charge(0, gasCallNewAccount)
+			return succeeded.after(min, max)
+		case "JUMP":
+			dest, ok := a.destination(p, pc, p.pop())
+			if !ok {
+				return failed
+			}
+			p.pc = dest
+			return a.jump(p).after(min, max)
+		case "JUMPI":
+			target, condition := p.pop(), p.pop()
+			if c, ok := condition.constant(); ok {
+				if c.Sign() == 0 {
+					continue
+				}
+				dest, ok := a.destination(p, pc, target)
+				if !ok {
+					return failed
+				}
+				p.pc = dest
+				return a.jump(p).after(min, max)
+			}
+			r := failed
+			if dest, ok := a.destination(p, pc, target); ok {
+				taken := p.copy()
+				taken.pc = dest
+				r = a.jump(taken)
+			}
+			return r.or(a.jump(p)).after(min, max)
+		case "JUMPDEST":
+			continue
+		case "MLOAD":
+			offset := p.pop()
+			lo, ok := a.expand(p, pc, offset, knownWord(big.NewInt(int64(wordBytes))))
+			if !ok {
+				return failed
+			}
+			charge(lo, lo)
+			value := unknownWord()
+			if o, ok := offset.small(); ok {
+				if v, ok := p.memory[o]; ok {
+					value = v
+				}
+			}
+			p.push(value)
+			continue
+		case "MSTORE", "MSTORE8":
+			offset, value := p.pop(), p.pop()
+			size := uint64(wordBytes)
+			written := &value
+			if op.Mnemonic == "MSTORE8" {
+				size, written = 1, nil
+			}
+			lo, ok := a.expand(p, pc, offset, knownWord(new(big.Int).SetUint64(size)))
+			if !ok {
+				return failed
+			}
+			charge(lo, lo)
+			p.write(offset, knownWord(new(big.Int).SetUint64(size)), written)
+			continue
+		case "SSTORE":
+			p.pop()
+			p.pop()
+			charge(gasSStoreReset, gasSStoreSet)
+			continue
+		case "SHA3":
+			offset, size := p.pop(), p.pop()
+			lo, ok := a.expand(p, pc, offset, size)
+			if !ok {
+				return failed
+			}
+			charge(lo, lo)
+			a.charge(pc, size, gasSha3Word, uint64(wordBytes), charge)
+			p.push(unknownWord())
+			continue
+		case "CALLDATACOPY", "CODECOPY", "RETURNDATACOPY", "EXTCODECOPY":
+			if op.Mnemonic == "EXTCODECOPY" {
+				p.pop()
+			}
+			dest := p.pop()
+			p.pop()
+			size := p.pop()
+			lo, ok := a.expand(p, pc, dest, size)
+			if !ok {
+				return failed
+			}
+			charge(lo, lo)
+			a.charge(pc, size, gasCopyWord, uint64(wordBytes), charge)
+			p.write(dest, size, nil)
+			continue
+		case "EXP":
+			base, exponent := p.pop(), p.pop()
+			if e, ok := exponent.constant(); ok {
+				bytes := uint64((e.BitLen() + 7) / 8)
+				charge(bytes*gasExpByte, bytes*gasExpByte)
+				if b, ok := base.constant(); ok {
+					p.push(knownWord(new(big.Int).Exp(b, e, new(big.Int).Add(wordMask, big.NewInt(1)))))
+					continue
+				}
+			} else {
+				charge(0, uint64(wordBytes)*gasExpByte)
+			}
+			p.push(unknownWord())
+			continue
+		case "CALL", "CALLCODE", "DELEGATECALL", "STATICCALL":
+			gas := p.pop()
+			p.pop()
+			value := knownWord(noBits)
+			if op.Mnemonic == "CALL" || op.Mnemonic == "CALLCODE" {
+				value = p.pop()
+			}
+			inOffset, inSize, outOffset, outSize := p.pop(), p.pop(), p.pop(), p.pop()
+			for _, region := range [][2]abstractWord{{inOffset, inSize}, {outOffset, outSize}} {
+				lo, ok := a.expand(p, pc, region[0], region[1])
+				if !ok {
+					return failed
+				}
+				charge(lo, lo)
+			}
+			if v, ok := value.constant(); !ok {
+				charge(0, gasCallValue+gasCallNewAccount)
+			} else if v.Sign() != 0 {
+				charge(gasCallValue, gasCallValue)
+				if op.Mnemonic == "CALL" {
+					charge(0, gasCallNewAccount)
+				}
+			}
+			// the called contract may use all of the gas it is given
+			if g, ok := gas.small(); ok {
+				charge(0, g)
+			} else {
+				a.unbounded("call at %#04x", pc)
+			}
+			p.write(outOffset, outSize, nil)
+			p.push(unknownWord())
+			continue
+		case "CREATE", "CREATE2":
+			p.pop()
+			offset, size := p.pop(), p.pop()
+			if op.Mnemonic == "CREATE2" {
+				p.pop()
+				a.charge(pc, size, gasSha3Word, uint64(wordBytes), charge)
+			}
+			lo, ok := a.expand(p, pc, offset, size)
+			if !ok {
+				return failed
+			}
+			charge(lo, lo)
+			a.unbounded("contract creation at %#04x", pc)
+			p.push(unknownWord())
+			continue
+		case "CALLDATALOAD":
+			p.push(a.calldata(p.pop()))
+			continue
+		case "CALLDATASIZE":
+			p.push(knownWord(new(big.Int).SetUint64(a.calldataSize)))
+			continue
+		case "CODESIZE":
+			p.push(knownWord(big.NewInt(int64(len(a.code)))))
+			continue
+		case "PC":
+			p.push(knownWord(big.NewInt(int64(pc))))
+			continue
+		case "MSIZE":
+			p.push(knownWord(new(big.Int).SetUint64(p.words * uint64(wordBytes))))
+			continue
+		}
+		operands := make([]abstractWord, op.Pops)
+		for i := range operands {
+			operands[i] = p.pop()
+		}
+		if op.Pushes == 1 {
+			p.push(evaluate(op.Mnemonic, operands))
+		}
+	}
+}
+
+// destination checks that a jump target is known and is a jump destination
+func (a *gasAnalysis) destination(p *gasPath, pc int, target abstractWord) (int, bool) {
+	t, ok := target.small()
+	if !ok {
+		a.unbounded("jump to an unknown destination at %#04x", pc)
+		return 0, false
+	}
+	return int(t), t < uint64(len(a.code)) && a.jumpdests[int(t)]
+}
+
+// charge charges gas for each unit of the size of an operand, which is
+// unbounded if the size isn't known
+func (a *gasAnalysis) charge(pc int, size abstractWord, gas, unit uint64, charge func(lo, hi uint64)) {
+	s, ok := size.small()
+	if !ok {
+		a.unbounded("data of unknown size at %#04x", pc)
+		return
+	}
+	units := (s + unit - 1) / unit
+	charge(units*gas, units*gas)
+}
+
+// expand charges for the memory used by an access of size bytes from an
+// offset, and fails if the memory could never be paid for
+func (a *gasAnalysis) expand(p *gasPath, pc int, offset, size abstractWord) (uint64, bool) {
+	s, ok := size.small()
+	if ok && s == 0 {
+		return 0, true
+	}
+	o, known := offset.small()
+	if !ok || !known {
+		a.unbounded("memory of unknown size at %#04x", pc)
+		return 0, true
+	}
+	if o+s < o || o+s > maxMemorySize {
+		return 0, false
+	}
+	words := (o + s + uint64(wordBytes) - 1) / uint64(wordBytes)
+	if words <= p.words {
+		return 0, true
+	}
+	cost := memoryGas(words) - memoryGas(p.words)
+	p.words = words
+	return cost, true
+}
+
+func memoryGas(words uint64) uint64 {
+	return words*gasMemoryWord + words*words/gasQuadCoeffDiv
+}
+
+const (
+	maxStackSize  = 1024
+	maxMemorySize = 1 << 32
+)
+
+// calldata loads a word of calldata: the selector is known, and every byte
+// after the end of the calldata is zero
+func (a *gasAnalysis) calldata(offset abstractWord) abstractWord {
+	o, ok := offset.small()
+	if !ok {
+		return unknownWord()
+	}
+	value, known := new(big.Int), new(big.Int)
+	for i := uint64(0); i < uint64(wordBytes); i++ {
+		value.Lsh(value, 8)
+		known.Lsh(known, 8)
+		switch position := o + i; {
+		case position < uint64(len(a.selector)):
+			value.Or(value, big.NewInt(int64(a.selector[position])))
+			known.Or(known, big.NewInt(0xff))
+		case position >= a.calldataSize:
+			known.Or(known, big.NewInt(0xff))
+		}
+	}
+	return abstractWord{value: value, known: known}
+}
+
+// evaluate applies an instruction to its operands, the first of which was on
+// top of the stack
+// shifts and bitwise operations keep every bit which is known, and other
+// instructions have known results only if all their operands are known
+func evaluate(mnemonic string, operands []abstractWord) abstractWord {
+	switch mnemonic {
+	case "AND":
+		a, b := operands[0], operands[1]
+		// bits are known if they are known in both, or known to be zero in
+		// either
+		zeros := new(big.Int).Or(zeroBits(a), zeroBits(b))
+		known := new(big.Int).And(a.known, b.known)
+		known.Or(known, zeros)
+		return abstractWord{value: new(big.Int).And(a.value, b.value), known: known}
+	case "OR":
+		a, b := operands[0], operands[1]
+		ones := new(big.Int).Or(a.value, b.value)
+		known := new(big.Int).And(a.known, b.known)
+		known.Or(known, ones)
+		return abstractWord{value: ones, known: known}
+	case "SHL", "SHR":
+		shift, ok := operands[0].small()
+		if !ok {
+			return unknownWord()
+		}
+		if shift >= uint64(wordSize) {
+			return knownWord(noBits)
+		}
+		w := operands[1]
+		value, known := new(big.Int), new(big.Int)
+		if mnemonic == "SHL" {
+			value.Lsh(w.value, uint(shift))
+			// the bits shifted in are zero
+			known.Lsh(w.known, uint(shift))
+			known.Or(known, sectionMask(uint(shift)))
+		} else {
+			value.Rsh(w.value, uint(shift))
+			known.Rsh(w.known, uint(shift))
+			known.Or(known, new(big.Int).Xor(wordMask, new(big.Int).Rsh(wordMask, uint(shift))))
+		}
+		return abstractWord{value: value.And(value, wordMask), known: known.And(known, wordMask)}
+	}
+	var values []*big.Int
+	for _, o := range operands {
+		v, ok := o.constant()
+		if !ok {
+			return unknownWord()
+		}
+		values = append(values, v)
+	}
+	return fold(mnemonic, values)
+}
+
+func zeroBits(w abstractWord) *big.Int {
+	return new(big.Int).AndNot(w.known, w.value)
+}
+
+var one = big.NewInt(1)
+
+// fold evaluates an instruction with known operands
+func fold(mnemonic string, v []*big.Int) abstractWord {
+	boolean := func(b bool) abstractWord {
+		if b {
+			return knownWord(one)
+		}
+		return knownWord(noBits)
+	}
+	switch mnemonic {
+	case "ADD":
+		return knownWord(new(big.Int).Add(v[0], v[1]))
+	case "SUB":
+		return knownWord(new(big.Int).Sub(v[0], v[1]))
+	case "MUL":
+		return knownWord(new(big.Int).Mul(v[0], v[1]))
+	case "DIV":
+		if v[1].Sign() == 0 {
+			return knownWord(noBits)
+		}
+		return knownWord(new(big.Int).Div(v[0], v[1]))
+	case "MOD":
+		if v[1].Sign() == 0 {
+			return knownWord(noBits)
+		}
+		return knownWord(new(big.Int).Mod(v[0], v[1]))
+	case "LT":
+		return boolean(v[0].Cmp(v[1]) < 0)
+	case "GT":
+		return boolean(v[0].Cmp(v[1]) > 0)
+	case "EQ":
+		return boolean(v[0].Cmp(v[1]) == 0)
+	case "ISZERO":
+		return boolean(v[0].Sign() == 0)
+	case "XOR":
+		return knownWord(new(big.Int).Xor(v[0], v[1]))
+	case "NOT":
+		return knownWord(new(big.Int).Xor(v[0], wordMask))
+	case "BYTE":
+		if v[0].Cmp(big.NewInt(int64(wordBytes))) >= 0 {
+			return knownWord(noBits)
+		}
+		shift := uint(wordBytes-1-uint(v[0].Uint64())) * 8
+		b := new(big.Int).Rsh(v[1], shift)
+		return knownWord(b.And(b, big.NewInt(0xff)))
+	}
+	// the results of every other instruction, such as signed arithmetic or
+	// reads of the environment, are unknown
+	return unknownWord()
+}
+
+// CheckGasEstimates compares the JSON gas estimates of a previous version of
+// a contract against the estimates of a validated contract, and describes
+// every function which may now use more gas
+func (evm GuardianEVM) CheckGasEstimates(previous []byte, n *ast.ContractDeclarationNode) ([]string, error) {
+	var estimates []GasEstimate
+	if err := json.Unmarshal(previous, &estimates); err != nil {
+		return nil, err
+	}
+	next, errs := evm.ContractGasEstimates(n)
+	if errs != nil {
+		return nil, errors.New(errs.Format())
+	}
+	return CompareGasEstimates(estimates, next), nil
+}
+
+// CompareGasEstimates describes every function in both sets of estimates
+// which may use more gas in the next set, or which can no longer succeed
+func CompareGasEstimates(previous, next []GasEstimate) []string {
+	bySignature := make(map[string]GasEstimate)
+	for _, p := range previous {
+		bySignature[p.Signature] = p
+	}
+	var messages []string
+	for _, n := range next {
+		p, ok := bySignature[n.Signature]
+		if !ok || p.Reverts {
+			continue
+		}
+		if n.Reverts {
+			messages = append(messages, fmt.Sprintf("%s now always reverts", n.Signature))
+			continue
+		}
+		if p.Bounded() && !n.Bounded() {
+			messages = append(messages, fmt.Sprintf("%s is now unbounded (%s)", n.Signature, strings.Join(n.Unbounded, ", ")))
+		}
+		if n.Min > p.Min {
+			messages = append(messages, fmt.Sprintf("%s min gas rose from %d to %d", n.Signature, p.Min, n.Min))
+		}
+		if p.Bounded() && n.Bounded() && n.Max > p.Max {
+			messages = append(messages, fmt.Sprintf("%s max gas rose from %d to %d", n.Signature, p.Max, n.Max))
+		}
+	}
+	return messages
+}
@@ -0,0 +1,116 @@
+package evm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
+)
+
+func estimate(t *testing.T, name, text string) map[string]GasEstimate {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, text)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c, ok := scope.GetDeclaration(name).(*ast.ContractDeclarationNode)
+	goutil.AssertNow(t, ok, "contract not found")
+	estimates, errs := e.ContractGasEstimates(c)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	bySignature := make(map[string]GasEstimate)
+	for _, g := range estimates {
+		bySignature[g.Signature] = g
+	}
+	return bySignature
+}
+
+const gasCounter = `
+	contract Counter {
+		var count uint
+		event Set(n uint)
+
+		external func get() uint {
+			return count
+		}
+
+		external func add(a, b uint) uint {
+			return a + b
+		}
+
+		external func set(n uint) {
+			count = n
+			Set(n)
+		}
+
+		external func loop(n uint) {
+			for i = 0; i < n; i++ {
+				count = i
+			}
+		}
+
+		external func fail() {
+			require(1 == 2)
+		}
+	}
+`
+
+func TestEstimateGasBounded(t *testing.T) {
+	estimates := estimate(t, "Counter", gasCounter)
+	chain, a := execute(t, "Counter", gasCounter)
+	for signature, args := range map[string][][]byte{
+		"get()":                nil,
+		"add(uint256,uint256)": {uintWord(1), uintWord(2)},
+		"set(uint256)":         {uintWord(9)},
+	} {
+		g, ok := estimates[signature]
+		goutil.AssertNow(t, ok, "no estimate for "+signature)
+		goutil.Assert(t, g.Bounded(), g.String())
+		goutil.Assert(t, !g.Reverts, g.String())
+		r := send(chain, a, signature, args...)
+		goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+		goutil.Assert(t, g.Min <= r.GasUsed && r.GasUsed <= g.Max, fmt.Sprintf("%s used %d", g, r.GasUsed))
+	}
+	// reading and adding take the same path every time
+	for _, signature := range []string{"get()", "add(uint256,uint256)"} {
+		g := estimates[signature]
+		goutil.Assert(t, g.Min == g.Max, g.String())
+	}
+}
+
+func TestEstimateGasStorageWrite(t *testing.T) {
+	g := estimate(t, "Counter", gasCounter)["set(uint256)"]
+	// resetting a slot is cheaper than setting it
+	goutil.Assert(t, g.Max-g.Min == gasSStoreSet-gasSStoreReset, g.String())
+}
+
+func TestEstimateGasLoop(t *testing.T) {
+	g := estimate(t, "Counter", gasCounter)["loop(uint256)"]
+	goutil.AssertNow(t, !g.Bounded(), g.String())
+	goutil.AssertLength(t, len(g.Unbounded), 1)
+	goutil.Assert(t, strings.HasPrefix(g.Unbounded[0], "loop at"), g.Unbounded[0])
+}
+
+func TestEstimateGasReverts(t *testing.T) {
+	g := estimate(t, "Counter", gasCounter)["fail()"]
+	goutil.Assert(t, g.Reverts, g.String())
+	goutil.Assert(t, g.String() == "fail(): always reverts", g.String())
+}
+
+func TestCompareGasEstimates(t *testing.T) {
+	previous := []GasEstimate{
+		{Signature: "get()", Min: 100, Max: 100},
+		{Signature: "set(uint256)", Min: 200, Max: 300},
+		{Signature: "loop(uint256)", Min: 50, Max: 60},
+	}
+	next := []GasEstimate{
+		{Signature: "get()", Min: 90, Max: 90},
+		{Signature: "set(uint256)", Min: 200, Max: 400},
+		{Signature: "loop(uint256)", Min: 50, Max: 60, Unbounded: []string{"loop at 0x10"}},
+		{Signature: "add(uint256)", Min: 10, Max: 10},
+	}
+	messages := CompareGasEstimates(previous, next)
+	goutil.AssertNow(t, len(messages) == 2, fmt.Sprint(messages))
+	goutil.Assert(t, messages[0] == "set(uint256) max gas rose from 300 to 400", messages[0])
+	goutil.Assert(t, messages[1] == "loop(uint256) is now unbounded (loop at 0x10)", messages[1])
+}
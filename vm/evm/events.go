@@ -0,0 +1,137 @@
+package evm
+
+import (
+	"fmt"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/vmgen"
+)
+
+// calling an event emits a log: the first topic is the hash of the event
+// signature, followed by each indexed parameter, while the other parameters
+// are ABI-encoded as the data of the log
+
+type eventParam struct {
+	abi     *abiValue
+	indexed bool
+}
+
+// lookupEvent finds the declaration of an event which can be called from the
+// current contract
+func (e *GuardianEVM) lookupEvent(name string) *ast.EventDeclarationNode {
+	if e.contract == nil {
+		return nil
+	}
+	contracts := linearise(e.contract, nil)
+	for i := len(contracts) - 1; i >= 0; i-- {
+		if d, ok := lookupDeclaration(contracts[i].Body, []string{name}).(*ast.EventDeclarationNode); ok {
+			return d
+		}
+	}
+	return nil
+}
+
+// eventParams describes each parameter of an event, in declaration order
+func (e *GuardianEVM) eventParams(n *ast.EventDeclarationNode) []eventParam {
+	all := hasModifier(n.Modifiers.Modifiers, "indexed")
+	var params []eventParam
+	for _, p := range n.Parameters {
+		indexed := all || hasModifier(p.Modifiers.Modifiers, "indexed")
+		for range p.Identifiers {
+			params = append(params, eventParam{
+				abi:     parseABIType(e.abiName(p.DeclaredType, p.Resolved)),
+				indexed: indexed,
+			})
+		}
+	}
+	return params
+}
+
+// EventTopic returns the first topic of the logs emitted by an event
+func EventTopic(signature string) []byte {
+	return keccak([]byte(signature))
+}
+
+// traverseEventCall emits a log with the arguments of an event call
+// indexed arguments are evaluated first, from last to first, as the topics
+// must be beneath the data on the stack
+func (e *GuardianEVM) traverseEventCall(n *ast.CallExpressionNode, name string) (code vmgen.Bytecode) {
+	decl := e.lookupEvent(name)
+	if decl == nil {
+		e.addError(n.Start(), errUnknownEvent, name)
+		return code
+	}
+	params := e.eventParams(decl)
+	if len(params) != len(n.Arguments) {
+		e.addError(n.Start(), errEventArguments, name, len(params), len(n.Arguments))
+		return code
+	}
+
+	topics := 1
+	for i := len(params) - 1; i >= 0; i-- {
+		if params[i].indexed {
+			code.Concat(e.traverseExpression(n.Arguments[i]))
+			code.Concat(topic(params[i].abi))
+			topics++
+		}
+	}
+	code.Concat(push(EventTopic(e.signature(decl.Identifier, decl.Parameters))))
+
+	var data []*abiValue
+	for i, p := range params {
+		if !p.indexed {
+			code.Concat(e.traverseExpression(n.Arguments[i]))
+			data = append(data, p.abi)
+		}
+	}
+	if len(data) == 0 {
+		code.Concat(push([]byte{0}))
+		code.Add("DUP1")
+	} else {
+		code.Concat(e.encodeValues(data))
+		// size, start
+		code.Add("DUP2")
+		code.Add("SWAP1")
+		code.Add("SUB")
+		code.Add("SWAP1")
+	}
+	code.Add(fmt.Sprintf("LOG%d", topics))
+	return code
+}
+
+const (
+	errUnknownEvent   = "Event %s is not declared by this contract or the contracts it inherits from"
+	errEventArguments = "Event %s takes %d arguments, but is called with %d"
+)
+
+// topic replaces an indexed value with its topic: words are used directly,
+// while other values are replaced by the hash of their data
+func topic(t *abiValue) (code vmgen.Bytecode) {
+	switch {
+	case t.kind == abiWord:
+		return code
+	case t.kind == abiBytes:
+		// hash the data after the length
+		code.Add("DUP1")
+		code.Add("MLOAD")
+		code.Add("SWAP1")
+		code.Concat(addConstant(wordBytes))
+		code.Add("SHA3")
+		return code
+	case t.kind == abiArray && t.elem.kind == abiWord && t.variable:
+		// hash the elements after the length
+		code.Add("DUP1")
+		code.Add("MLOAD")
+		code.Concat(push(encodeUint(wordBytes)))
+		code.Add("MUL")
+		code.Add("SWAP1")
+		code.Concat(addConstant(wordBytes))
+		code.Add("SHA3")
+		return code
+	}
+	// everything else is hashed as it is held on the heap
+	code.Concat(push(encodeUint(t.headSize())))
+	code.Add("SWAP1")
+	code.Add("SHA3")
+	return code
+}
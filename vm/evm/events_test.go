@@ -0,0 +1,114 @@
+package evm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
+)
+
+func TestEventTopic(t *testing.T) {
+	topic := hex.EncodeToString(EventTopic("Transfer(address,address,uint256)"))
+	goutil.Assert(t, topic == "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef", topic)
+}
+
+func TestEventDeclarationGeneratesNothing(t *testing.T) {
+	e := NewVM()
+	code := e.traverseEvent(nil)
+	goutil.Assert(t, len(code.Commands) == 0, code.Format())
+}
+
+func TestEmitEventWithoutParameters(t *testing.T) {
+	code := traverseContract(t, `
+		contract C {
+			event Ping()
+			external func f() {
+				Ping()
+			}
+		}
+	`)
+	goutil.Assert(t, countMnemonic(code.Commands, "LOG1") == 1, code.Format())
+	found := false
+	for _, c := range code.Commands {
+		if c.Mnemonic == "PUSH32" && bytes.Equal(c.Parameters, EventTopic("Ping()")) {
+			found = true
+		}
+	}
+	goutil.Assert(t, found, "signature topic should be pushed")
+}
+
+func TestEmitIndexedParameters(t *testing.T) {
+	code := traverseContract(t, `
+		contract C {
+			event Transfer(indexed from address, indexed to address, value uint)
+			indexed event Approval(owner address, spender address)
+			external func f(a, b address, v uint) {
+				Transfer(a, b, v)
+				Approval(a, b)
+			}
+		}
+	`)
+	goutil.Assert(t, countMnemonic(code.Commands, "LOG3") == 2, code.Format())
+}
+
+func TestEmitIndexedString(t *testing.T) {
+	code := traverseContract(t, `
+		contract C {
+			event Note(indexed s string)
+			external func f() {
+				Note("hi")
+			}
+		}
+	`)
+	goutil.Assert(t, countMnemonic(code.Commands, "LOG2") == 1, code.Format())
+	// indexed strings are replaced by the hash of their data
+	goutil.Assert(t, countMnemonic(code.Commands, "SHA3") == 1, code.Format())
+}
+
+func TestEmitInheritedEvent(t *testing.T) {
+	code := traverseContract(t, `
+		contract A {
+			event Ping(n uint)
+		}
+		contract C inherits A {
+			external func f() {
+				Ping(1)
+			}
+		}
+	`)
+	goutil.Assert(t, countMnemonic(code.Commands, "LOG1") == 1, code.Format())
+}
+
+func TestEventCallErrors(t *testing.T) {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, `
+		contract C {
+			event Ping(n uint)
+		}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	e.contract = scope.GetDeclaration("C").(*ast.ContractDeclarationNode)
+	call := &ast.CallExpressionNode{Call: &ast.IdentifierNode{Name: "Ping"}}
+	code := e.traverseEventCall(call, "Pong")
+	goutil.Assert(t, len(code.Commands) == 0, code.Format())
+	goutil.Assert(t, strings.Contains(e.errs.Format(), "Event Pong is not declared"), "unknown events should be reported")
+	code = e.traverseEventCall(call, "Ping")
+	goutil.Assert(t, len(code.Commands) == 0, code.Format())
+	goutil.Assert(t, strings.Contains(e.errs.Format(), "Event Ping takes 1 arguments, but is called with 0"), "wrong argument counts should be reported")
+}
+
+func TestGeneratedLogOpcodes(t *testing.T) {
+	logs := generateLogs()
+	goutil.AssertNow(t, len(logs) == 5, "wrong number of logs")
+	goutil.Assert(t, logs["LOG0"].Opcode == 0xA0, "wrong LOG0 opcode")
+	goutil.Assert(t, logs["LOG4"].Opcode == 0xA4, "wrong LOG4 opcode")
+	pushes := generatePushes()
+	goutil.Assert(t, pushes["PUSH1"].Opcode == 0x60, "wrong PUSH1 opcode")
+	goutil.Assert(t, pushes["PUSH32"].Opcode == 0x7F, "wrong PUSH32 opcode")
+	goutil.Assert(t, generateDups()["DUP1"].Opcode == 0x80, "wrong DUP1 opcode")
+	goutil.Assert(t, generateSwaps()["SWAP1"].Opcode == 0x90, "wrong SWAP1 opcode")
+}
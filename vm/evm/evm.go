@@ -1,6 +1,8 @@
 package evm
 
 import (
+	"fmt"
+	"sort"
 	"strconv"
 
 	"github.com/end-r/guardian/ast"
@@ -20,21 +22,50 @@ type GuardianEVM struct {
 	storage            map[string]*storageBlock
 	freedMemory        []*memoryBlock
 	memoryCursor       uint
-	memory             map[string]*memoryBlock
+	memory             *memoryScope
 	currentlyAssigning string
 	internalHooks      hookMap
 	externalHooks      hookMap
 	globalHooks        hookMap
-	eventHooks         hookMap
 	lifecycleHooks     hookMap
 	inStorage          bool
 	mapLiteralCount    int
 	arrayLiteralCount  int
+	labelCount         int
+	hookCount          int
+	revertLabel        int
+	bubbleLabel        int
+	returnLabel        int
+	inputInMemory      bool
+	contract           *ast.ContractDeclarationNode
+	// annotated code marks the source node of each statement and function
+	annotating bool
+	source     ast.Node
+	sources    []ast.Node
+	// test builds run a single test function, whose failed checks revert
+	// with a message describing them
+	test  *ast.FuncDeclarationNode
+	files *sourceFiles
+	// the passes run over the code generated for a contract
+	optimization OptimizationLevel
+	// arithmetic in the code being generated wraps instead of reverting
+	unchecked bool
+	// the labels of internal functions, by name
+	functionLabels map[string]int
+	// the files of the package being compiled, whose functions are generated
+	// as internal functions of the contracts which call them
+	packageScopes    []*ast.ScopeNode
+	packageFunctions []*ast.FuncDeclarationNode
+	// errors found while generating code
+	errs util.Errors
 }
 
+// push pushes data of up to a word onto the stack
+// there are no instructions which push more, so longer data is a bug in the
+// generator
 func push(data []byte) (code vmgen.Bytecode) {
-	if len(data) > 32 {
-		// TODO: error
+	if uint(len(data)) > wordBytes {
+		panic(fmt.Sprintf("evm: can't push %d bytes", len(data)))
 	}
 	m := "PUSH" + strconv.Itoa(len(data))
 	code.Add(m, data...)
@@ -53,10 +84,59 @@ func bytesRequired(offset int) int {
 	return (count / 8) + 1
 }
 
-// support all offsets which can be stored in a 64 bit integer
-func pushMarker(offset int) (code vmgen.Bytecode) {
-	//TODO: fix
-	code.AddMarker("PUSH"+strconv.Itoa(bytesRequired(offset)), offset)
+// labels are unique markers which are resolved into code offsets once the
+// bytecode is assembled
+func (e *GuardianEVM) newLabel() int {
+	e.labelCount++
+	return e.labelCount
+}
+
+// jump destinations are at most 2 bytes, which covers the contract size limit
+func pushLabel(label int) (code vmgen.Bytecode) {
+	code.AddMarker("PUSH2", label)
+	return code
+}
+
+func jumpdest(label int) (code vmgen.Bytecode) {
+	code.AddMarker("JUMPDEST", label)
+	return code
+}
+
+// jumps into and out of functions are marked for source maps
+const (
+	regularJump = iota
+	jumpIntoFunction
+	jumpOutOfFunction
+)
+
+func jump(kind int) (code vmgen.Bytecode) {
+	code.AddMarker("JUMP", kind)
+	return code
+}
+
+// codeLabel marks a position in the code which is not a jump destination
+// every label resolves to its offset within the code which marks it, even
+// when it is pushed by other code
+func codeLabel(label int) (code vmgen.Bytecode) {
+	code.AddMarker("LABEL", label)
+	return code
+}
+
+// revertIf reverts with no data if the top of the stack is non-zero
+// every check in a contract shares a single revert
+func (e *GuardianEVM) revertIf() (code vmgen.Bytecode) {
+	if e.revertLabel == 0 {
+		e.revertLabel = e.newLabel()
+	}
+	code.Concat(pushLabel(e.revertLabel))
+	code.Add("JUMPI")
+	return code
+}
+
+func revert() (code vmgen.Bytecode) {
+	code.Concat(push([]byte{0}))
+	code.Add("DUP1")
+	code.Add("REVERT")
 	return code
 }
 
@@ -67,12 +147,18 @@ var (
 )
 
 func (evm GuardianEVM) Traverse(node ast.Node) (vmgen.Bytecode, util.Errors) {
+	// generation state is local to each traversal
+	e := &evm
 	// do pre-processing/hooks etc
-	code := evm.traverse(node)
-	// generate the bytecode
-	// finalise the bytecode
-	//evm.finalise()
-	return code, nil
+	code := e.traverse(node)
+	return code, e.errs
+}
+
+// InPackage returns a VM which can call the functions declared in the files
+// of a package, as well as those in the file of each contract
+func (evm GuardianEVM) InPackage(scopes []*ast.ScopeNode) validator.VM {
+	evm.packageScopes = scopes
+	return evm
 }
 
 // NewGuardianEVM ...
@@ -80,43 +166,47 @@ func NewVM() GuardianEVM {
 	return GuardianEVM{}
 }
 
-// A hook conditionally jumps the code to a particular point
-//
-
-func (e *GuardianEVM) finalise() {
-
-	// add external functions
-	// add internal functions
-	// add events
-
-	// number of instructions =
-	/*
-		for _, hook := range e.hooks {
-			e.VM.AddBytecode("POP")
-
-			e.VM.AddBytecode("EQL")
-			e.VM.AddBytecode("JMPI")
-		}
-		// if the data matches none of the function hooks
-		e.VM.AddBytecode("STOP")
-		for _, callable := range e.callables {
-			// add function bytecode
-		}*/
-}
-
-//
-
-// can be called from outside or inside the contract
-func (e *GuardianEVM) hookPublicFunc(h *hook) {
-
+// finalise lays out the runtime code of a contract: the free memory pointer,
+// the dispatcher, then the fallback, then every function in the order in
+// which it was declared
+func (e *GuardianEVM) finalise(fallback vmgen.Bytecode) (code vmgen.Bytecode) {
+	label := e.newLabel()
+	// the heap begins after every memory block used by the contract
+	code.Concat(push(encodeUint(e.heapStart())))
+	code.Concat(push([]byte{freeMemoryPointer}))
+	code.Add("MSTORE")
+	code.Concat(e.createDispatcher(label))
+	code.Concat(jumpdest(label))
+	if fallback.Length() == 0 {
+		// contracts without a fallback reject unknown calls
+		code.Concat(revert())
+	} else {
+		code.Concat(fallback)
+	}
+	for _, h := range e.orderedHooks() {
+		code.Concat(h.bytecode)
+	}
+	if e.revertLabel != 0 {
+		code.Concat(jumpdest(e.revertLabel))
+		code.Concat(revert())
+	}
+	code.Concat(e.bubbleRevert())
+	return code
 }
 
-// can be
-func (e *GuardianEVM) hookPrivateFunc(h *hook) {
-
+func (e *GuardianEVM) traverse(n ast.Node) vmgen.Bytecode {
+	switch n.(type) {
+	case *ast.ForStatementNode, *ast.ForEachStatementNode, *ast.AssignmentStatementNode,
+		*ast.CaseStatementNode, *ast.ReturnStatementNode, *ast.IfStatementNode,
+		*ast.SwitchStatementNode, *ast.CallExpressionNode:
+		return e.annotate(n, func() vmgen.Bytecode {
+			return e.generate(n)
+		})
+	}
+	return e.generate(n)
 }
 
-func (e GuardianEVM) traverse(n ast.Node) (code vmgen.Bytecode) {
+func (e *GuardianEVM) generate(n ast.Node) (code vmgen.Bytecode) {
 	/* initialise the vm
 	if e.VM == nil {
 		e.VM = firevm.NewVM()
@@ -142,6 +232,8 @@ func (e GuardianEVM) traverse(n ast.Node) (code vmgen.Bytecode) {
 		return e.traverseFunc(node)
 	case *ast.ForStatementNode:
 		return e.traverseForStatement(node)
+	case *ast.ForEachStatementNode:
+		return e.traverseForEachStatement(node)
 	case *ast.AssignmentStatementNode:
 		return e.traverseAssignmentStatement(node)
 	case *ast.CaseStatementNode:
@@ -152,6 +244,23 @@ func (e GuardianEVM) traverse(n ast.Node) (code vmgen.Bytecode) {
 		return e.traverseIfStatement(node)
 	case *ast.SwitchStatementNode:
 		return e.traverseSwitchStatement(node)
+	case *ast.CallExpressionNode:
+		code.Concat(e.traverseCallExpr(node))
+		if f := e.calledFunction(node); f != nil {
+			// the results of a call made as a statement are unused
+			for range results(f) {
+				code.Add("POP")
+			}
+		}
+		return code
+	case *ast.ReferenceNode:
+		code.Concat(e.traverseReference(node))
+		if f, ok := e.externalFunction(node); ok {
+			for range f.Results.Types {
+				code.Add("POP")
+			}
+		}
+		return code
 	}
 	return code
 }
@@ -161,16 +270,39 @@ func (evm *GuardianEVM) traverseScope(s *ast.ScopeNode) (code vmgen.Bytecode) {
 		return code
 	}
 
+	evm.enterScope()
+	defer evm.exitScope()
+
+	for _, n := range inSourceOrder(s) {
+		code.Concat(evm.traverse(n))
+	}
+
+	return code
+}
+
+// inSourceOrder returns the declarations and statements of a scope in the
+// order in which they appear
+// local variables are held with the declarations of their scope, but must
+// be declared between the statements around them
+func inSourceOrder(s *ast.ScopeNode) []ast.Node {
+	var nodes []ast.Node
 	if s.Declarations != nil {
+		// declarations of several names are held once for each name
+		seen := make(map[ast.Node]bool)
 		for _, d := range s.Declarations.Array() {
-			code.Concat(evm.traverse(d.(ast.Node)))
+			if n := d.(ast.Node); !seen[n] {
+				seen[n] = true
+				nodes = append(nodes, n)
+			}
 		}
 	}
-	if s.Sequence != nil {
-		for _, s := range s.Sequence {
-			code.Concat(evm.traverse(s))
+	nodes = append(nodes, s.Sequence...)
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i].Start(), nodes[j].Start()
+		if a.Line != b.Line {
+			return a.Line < b.Line
 		}
-	}
-
-	return code
+		return a.Column < b.Column
+	})
+	return nodes
 }
@@ -12,3 +12,12 @@ func TestBytesRequired(t *testing.T) {
 	goutil.Assert(t, bytesRequired(8) == 1, fmt.Sprintf("wrong 8: %d", bytesRequired(8)))
 	goutil.Assert(t, bytesRequired(257) == 2, fmt.Sprintf("wrong 257: %d", bytesRequired(257)))
 }
+
+func TestPushTooLong(t *testing.T) {
+	code := push(make([]byte, 32))
+	goutil.Assert(t, code.CompareMnemonics([]string{"PUSH32"}), code.Format())
+	defer func() {
+		goutil.Assert(t, recover() != nil, "pushing more than a word should panic")
+	}()
+	push(make([]byte, 33))
+}
@@ -0,0 +1,161 @@
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
+	"github.com/end-r/guardian/vm/evm/interpreter"
+)
+
+var account = interpreter.BytesToAddress([]byte{0x01})
+
+// execute compiles a contract and deploys it to an in-memory chain, with
+// ABI-encoded constructor arguments
+func execute(t *testing.T, name, text string, args ...[]byte) (*interpreter.EVM, interpreter.Address) {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, text)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c, ok := scope.GetDeclaration(name).(*ast.ContractDeclarationNode)
+	goutil.AssertNow(t, ok, "contract not found")
+	code, errs := e.Compile(c, false)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	chain := interpreter.New()
+	a, r := chain.Deploy(account, append(code, bytes.Join(args, nil)...), nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	return chain, a
+}
+
+// send sends a transaction to a function of a contract with ABI-encoded
+// arguments
+func send(chain *interpreter.EVM, a interpreter.Address, signature string, args ...[]byte) interpreter.Result {
+	input := append(Selector(signature), bytes.Join(args, nil)...)
+	return chain.Call(account, a, input, nil)
+}
+
+func uintWord(i int64) []byte {
+	w := make([]byte, 32)
+	b := big.NewInt(i).Bytes()
+	copy(w[32-len(b):], b)
+	return w
+}
+
+func TestExecuteReturn(t *testing.T) {
+	chain, a := execute(t, "Counter", `
+		contract Counter {
+			var count = 3
+			external func get() uint {
+				return count
+			}
+			external func add(a, b uint) uint {
+				return a + b
+			}
+		}
+	`)
+	r := send(chain, a, "get()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(3)), fmt.Sprintf("wrong result %x", r.Output))
+	goutil.Assert(t, chain.Storage(a, big.NewInt(0)).Int64() == 3, "wrong storage")
+	r = send(chain, a, "add(uint256,uint256)", uintWord(4), uintWord(5))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(9)), fmt.Sprintf("wrong result %x", r.Output))
+}
+
+func TestExecuteEvent(t *testing.T) {
+	chain, a := execute(t, "Token", `
+		contract Token {
+			event Transfer(indexed from address, indexed to address, value uint)
+			external func send(from, to address, value uint) {
+				Transfer(from, to, value)
+			}
+		}
+	`)
+	r := send(chain, a, "send(address,address,uint256)", uintWord(6), uintWord(7), uintWord(50))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.AssertNow(t, len(r.Logs) == 1, "wrong log count")
+	l := r.Logs[0]
+	goutil.AssertNow(t, len(l.Topics) == 3, "wrong topic count")
+	goutil.Assert(t, bytes.Equal(l.Topics[0], EventTopic("Transfer(address,address,uint256)")), "wrong topic")
+	goutil.Assert(t, bytes.Equal(l.Topics[1], uintWord(6)), "wrong sender")
+	goutil.Assert(t, bytes.Equal(l.Topics[2], uintWord(7)), "wrong recipient")
+	goutil.Assert(t, bytes.Equal(l.Data, uintWord(50)), "wrong data")
+}
+
+func TestExecuteRequire(t *testing.T) {
+	chain, a := execute(t, "Vault", `
+		contract Vault {
+			external func check(amount uint) uint {
+				require(amount == 5)
+				return amount
+			}
+		}
+	`)
+	r := send(chain, a, "check(uint256)", uintWord(0))
+	goutil.Assert(t, r.Reverted(), "other amounts should revert")
+	r = send(chain, a, "check(uint256)", uintWord(5))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(5)), fmt.Sprintf("wrong result %x", r.Output))
+}
+
+func TestExecuteUnknownFunction(t *testing.T) {
+	chain, a := execute(t, "Counter", `
+		contract Counter {
+			external func get() uint {
+				return 1
+			}
+		}
+	`)
+	r := send(chain, a, "set(uint256)", uintWord(1))
+	goutil.Assert(t, r.Reverted(), "unknown functions should revert")
+}
+
+func TestExecuteConstants(t *testing.T) {
+	chain, a := execute(t, "Shop", `
+		contract Shop {
+			const fee = 3 * finney
+			const offset int = 0 - 5
+			external func price(n uint) uint {
+				return n * fee
+			}
+			external func shift() int {
+				return offset
+			}
+		}
+	`)
+	r := send(chain, a, "price(uint256)", uintWord(2))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(6000000000000000)), fmt.Sprintf("wrong result %x", r.Output))
+	r = send(chain, a, "shift()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	minusFive := bytes.Repeat([]byte{0xff}, 32)
+	minusFive[31] = 0xfb
+	goutil.Assert(t, bytes.Equal(r.Output, minusFive), fmt.Sprintf("wrong result %x", r.Output))
+}
+
+func TestExecuteOperandOrder(t *testing.T) {
+	chain, a := execute(t, "Counter", `
+		contract Counter {
+			var count uint
+			internal func next() uint {
+				count += 1
+				return count
+			}
+			external func sum() uint {
+				return next() * 10 + next()
+			}
+			external func difference() uint {
+				return next() * 10 - next()
+			}
+		}
+	`)
+	r := send(chain, a, "sum()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(12)), fmt.Sprintf("wrong sum %x", r.Output))
+	r = send(chain, a, "difference()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(26)), fmt.Sprintf("wrong difference %x", r.Output))
+}
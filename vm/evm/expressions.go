@@ -2,6 +2,7 @@ package evm
 
 import (
 	"fmt"
+	"math/big"
 
 	"github.com/end-r/guardian/typing"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/end-r/vmgen"
 
 	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
 )
 
 func (e *GuardianEVM) traverseValue(n ast.ExpressionNode) (code vmgen.Bytecode) {
@@ -63,6 +65,10 @@ func (e *GuardianEVM) traverseArrayLiteral(n *ast.ArrayLiteralNode) (code vmgen.
 }
 
 func (e *GuardianEVM) traverseSliceExpression(n *ast.SliceExpressionNode) (code vmgen.Bytecode) {
+	if slice, ok := e.traverseMemorySlice(n); ok {
+		return slice
+	}
+
 	// evaluate the original expression first
 
 	// get the data
@@ -100,44 +106,64 @@ var binaryOps = map[token.Type]BinaryOperator{
 	token.Mul:        simpleOperator("MUL"),
 	token.Div:        signedOperator("DIV", "SDIV"),
 	token.Mod:        signedOperator("MOD", "SMOD"),
-	token.Shl:        simpleOperator("SHL"),
-	token.Shr:        simpleOperator("SHR"),
+	token.Shl:        shiftOperator("SHL", "SHL"),
+	token.Shr:        shiftOperator("SHR", "SAR"),
 	token.And:        simpleOperator("AND"),
 	token.Or:         simpleOperator("OR"),
 	token.Xor:        simpleOperator("XOR"),
 	token.As:         ignoredOperator(),
 	token.Gtr:        signedOperator("GT", "SGT"),
 	token.Lss:        signedOperator("LT", "SLT"),
-	token.Eql:        simpleOperator("EQL"),
-	token.Neq:        reversedOperator("EQL"),
-	token.Geq:        reversedSignedOperator("LT", "SLT"),
-	token.Leq:        reversedSignedOperator("GT", "SGT"),
+	token.Eql:        simpleOperator("EQ"),
+	token.Neq:        negatedOperator("EQ"),
+	token.Geq:        negatedSignedOperator("LT", "SLT"),
+	token.Leq:        negatedSignedOperator("GT", "SGT"),
 	token.LogicalAnd: ignoredOperator(),
 	token.LogicalOr:  ignoredOperator(),
 }
 
+// operators whose instructions take their first argument from the top of the
+// stack, which must swap the operands after they are evaluated
+var swappedOperators = map[token.Type]bool{
+	token.Sub: true,
+	token.Div: true,
+	token.Mod: true,
+	token.Gtr: true,
+	token.Lss: true,
+	token.Geq: true,
+	token.Leq: true,
+}
+
 type BinaryOperator func(n *ast.BinaryExpressionNode) vmgen.Bytecode
 
-func reversedSignedOperator(unsigned, signed string) BinaryOperator {
+// negated operators test the opposite condition: x >= y is not x < y
+func negatedSignedOperator(unsigned, signed string) BinaryOperator {
 	return func(n *ast.BinaryExpressionNode) (code vmgen.Bytecode) {
-		left, lok := typing.ResolveUnderlying(n.Left.ResolvedType()).(*typing.NumericType)
-		right, rok := typing.ResolveUnderlying(n.Left.ResolvedType()).(*typing.NumericType)
-		if lok && rok {
-			if left.Signed || right.Signed {
-				code.Add(signed)
-			} else {
-				code.Add(unsigned)
-			}
-			code.Add("NOT")
-		}
+		code.Concat(signedOperator(unsigned, signed)(n))
+		code.Add("ISZERO")
 		return code
 	}
 }
 
-func reversedOperator(mnemonic string) BinaryOperator {
+func negatedOperator(mnemonic string) BinaryOperator {
 	return func(n *ast.BinaryExpressionNode) (code vmgen.Bytecode) {
 		code.Add(mnemonic)
-		code.Add("NOT")
+		code.Add("ISZERO")
+		return code
+	}
+}
+
+// shift instructions take the shift from the top of the stack, where the
+// right operand already is
+// the signedness of the shifted value decides whether bits shifted right are
+// filled with its sign
+func shiftOperator(unsigned, signed string) BinaryOperator {
+	return func(n *ast.BinaryExpressionNode) (code vmgen.Bytecode) {
+		if _, s, _ := integerType(n.Left.ResolvedType()); s {
+			code.Add(signed)
+		} else {
+			code.Add(unsigned)
+		}
 		return code
 	}
 }
@@ -186,15 +212,24 @@ func additionOrConcatenation(n *ast.BinaryExpressionNode) (code vmgen.Bytecode)
 func (e *GuardianEVM) traverseBinaryExpr(n *ast.BinaryExpressionNode) (code vmgen.Bytecode) {
 	/* alter stack:
 
-	| Operand 1 |
 	| Operand 2 |
+	| Operand 1 |
 	| Operator  |
 
 	Note that these operands may contain further expressions of arbitrary depth.
+	The operands are evaluated from left to right, then swapped if the
+	instruction takes its first argument from the top of the stack.
 	*/
 	code.Concat(e.traverseExpression(n.Left))
 	code.Concat(e.traverseExpression(n.Right))
+	if swappedOperators[n.Operator] {
+		code.Add("SWAP1")
+	}
 
+	if arithmetic, ok := e.traverseArithmetic(n); ok {
+		code.Concat(arithmetic)
+		return code
+	}
 	code.Concat(binaryOps[n.Operator](n))
 
 	return code
@@ -213,6 +248,17 @@ func (e *GuardianEVM) traverseUnaryExpr(n *ast.UnaryExpressionNode) (code vmgen.
 	Note that these expressions may contain further expressions of arbitrary depth.
 	*/
 	code.Concat(e.traverseExpression(n.Operand))
+	if n.Operator == token.Not {
+		if typing.ResolveUnderlying(n.Operand.ResolvedType()) == typing.Boolean() {
+			code.Add("ISZERO")
+			return code
+		}
+		if bits, signed, ok := integerType(n.Operand.ResolvedType()); ok {
+			code.Add("NOT")
+			code.Concat(truncate(bits, signed))
+			return code
+		}
+	}
 	code.Add(unaryOps[n.Operator])
 	return code
 }
@@ -234,36 +280,47 @@ func (e *GuardianEVM) traverseClassCall(n *ast.CallExpressionNode) (code vmgen.B
 }
 
 func (e *GuardianEVM) traverseFunctionCall(n *ast.CallExpressionNode) (code vmgen.Bytecode) {
+	if f := e.calledFunction(n); f != nil {
+		return e.traverseInternalCall(n, f)
+	}
+
 	for _, arg := range n.Arguments {
 		code.Concat(e.traverseExpression(arg))
 	}
 
-	// traverse the call expression
-	// should leave the function address on top of the stack
-
-	// need to get annotations through this process
-
 	if n.Call.Type() == ast.Identifier {
 		i := n.Call.(*ast.IdentifierNode)
 		if b, ok := builtins[i.Name]; ok {
+			// the arguments may have replaced the current expression
+			e.expression = n
 			code.Concat(b(e))
 			return code
 		}
 	}
 
-	call := e.traverse(n.Call)
-
-	code.Concat(call)
-
-	// parameters are at the top of the stack
-	// jump to the top of the function
+	// every other call is to a function which can't be called from here
+	if i, ok := n.Call.(*ast.IdentifierNode); ok && e.lookupMemory(i.Name) == nil {
+		if e.declaredFunction(i.Name) != nil {
+			e.addError(n.Start(), errExternalCall, i.Name)
+		} else {
+			e.addError(n.Start(), errUnknownFunction, i.Name)
+		}
+		return code
+	}
+	e.addError(n.Start(), errFunctionValue)
 	return code
 }
 
 func (e *GuardianEVM) traverseCallExpr(n *ast.CallExpressionNode) (code vmgen.Bytecode) {
 	e.expression = n
 
-	switch typing.ResolveUnderlying(n.Call.ResolvedType()).(type) {
+	if isCast(n) {
+		return e.traverseCast(n)
+	}
+
+	switch t := typing.ResolveUnderlying(n.Call.ResolvedType()).(type) {
+	case *typing.Event:
+		return e.traverseEventCall(n, t.Name)
 	case *typing.Func:
 		return e.traverseFunctionCall(n)
 	case *typing.Contract:
@@ -274,40 +331,97 @@ func (e *GuardianEVM) traverseCallExpr(n *ast.CallExpressionNode) (code vmgen.By
 	return code
 }
 
+// casts are calls whose callee is the type they produce
+func isCast(n *ast.CallExpressionNode) bool {
+	return len(n.Arguments) == 1 && n.Resolved != nil && n.Call.ResolvedType() == n.Resolved
+}
+
+// traverseCast converts an integer to the size and signedness of its new type
+// other casts only change the type of their value
+func (e *GuardianEVM) traverseCast(n *ast.CallExpressionNode) (code vmgen.Bytecode) {
+	code.Concat(e.traverseExpression(n.Arguments[0]))
+	bits, signed, ok := integerType(n.Resolved)
+	if !ok {
+		return code
+	}
+	if from, s, ok := integerType(n.Arguments[0].ResolvedType()); ok && s == signed && from <= bits {
+		// the value is already valid
+		return code
+	}
+	code.Concat(truncate(bits, signed))
+	return code
+}
+
 func (e *GuardianEVM) traverseLiteral(n *ast.LiteralNode) (code vmgen.Bytecode) {
 
 	// Literal Nodes are directly converted to push instructions
-	// these nodes must be divided into blocks of 16 bytes
-	// in order to maintain
 
 	// maximum number size is 256 bits (32 bytes)
 	switch n.LiteralType {
-	case token.Integer, token.Float:
-		if len(n.Data) > 32 {
+	case token.Integer:
+		value, ok := new(big.Int).SetString(n.Data, 0)
+		if ok && value.Sign() < 0 {
+			// negative values are pushed in two's complement
+			value.And(value, wordMask)
+		}
+		if !ok || len(value.Bytes()) > 32 {
 			// error
 		} else {
-			fmt.Println("HERE")
-			// TODO: type size or data size
-			code.Add(fmt.Sprintf("PUSH%d", bytesRequired(int(n.Resolved.Size()))), []byte(n.Data)...)
+			code.Concat(push(encodeBig(value)))
 		}
 		break
 	case token.String:
-		bytes := []byte(n.Data)
-		max := 32
-		size := 0
-		for size = len(bytes); size > max; size -= max {
-			code.Add("PUSH32", bytes[len(bytes)-size:len(bytes)-size+max]...)
-		}
-		op := fmt.Sprintf("PUSH%d", size)
-		code.Add(op, bytes[size:len(bytes)]...)
+		code.Concat(stringLiteral([]byte(n.Data)))
 		break
+	case token.True:
+		code.Concat(push([]byte{1}))
+		break
+	case token.False:
+		code.Concat(push([]byte{0}))
+		break
+	}
+	return code
+}
+
+// string literals are copied onto the heap in the same layout as decoded
+// strings, and are referred to by a pointer
+func stringLiteral(data []byte) (code vmgen.Bytecode) {
+	words := (uint(len(data)) + wordBytes - 1) / wordBytes
+	code.Concat(push(encodeUint((words + 1) * wordBytes)))
+	code.Concat(allocate())
+	code.Concat(push(encodeUint(uint(len(data)))))
+	code.Add("DUP2")
+	code.Add("MSTORE")
+	for i := uint(0); i < words; i++ {
+		start := i * wordBytes
+		end := start + wordBytes
+		if end > uint(len(data)) {
+			end = uint(len(data))
+		}
+		code.Concat(push(data[start:end]))
+		// the data is padded on the right
+		if end-start < wordBytes {
+			code.Concat(push(encodeUint((wordBytes - (end - start)) * 8)))
+			code.Add("SHL")
+		}
+		code.Add("DUP2")
+		code.Concat(addConstant(start + wordBytes))
+		code.Add("MSTORE")
 	}
 	return code
 }
 
 func (e *GuardianEVM) traverseIndex(n *ast.IndexExpressionNode) (code vmgen.Bytecode) {
 
-	// TODO: bounds checking?
+	if location, l, ok := e.locateStorage(n); ok {
+		code.Concat(location)
+		code.Concat(l.load())
+		return code
+	}
+
+	if element, ok := e.traverseMemoryIndex(n); ok {
+		return element
+	}
 
 	// load the data
 	code.Concat(e.traverseExpression(n.Expression))
@@ -340,8 +454,9 @@ func (evm *GuardianEVM) traverseMapLiteral(n *ast.MapLiteralNode) (code vmgen.By
 
 	// must be deterministic iteration here
 	for _, v := range n.Data {
+		code.Concat(evm.traverseExpression(v))
 		// each storage slot must be 32 bytes regardless of contents
-		slot := EncodeName(fakeKey + evm.traverse(n))
+		slot := EncodeName(fakeKey)
 		code.Concat(push(slot))
 		code.Add("SSTORE")
 	}
@@ -354,6 +469,9 @@ func (evm *GuardianEVM) traverseMapLiteral(n *ast.MapLiteralNode) (code vmgen.By
 func (e *GuardianEVM) traverseFuncLiteral(n *ast.FuncLiteralNode) (code vmgen.Bytecode) {
 	// create an internal hook
 
+	e.enterFunction()
+	defer e.exitFunction()
+
 	// parameters should have been pushed onto the stack by the caller
 	// take them off and put them in memory
 	for _, p := range n.Parameters {
@@ -365,41 +483,36 @@ func (e *GuardianEVM) traverseFuncLiteral(n *ast.FuncLiteralNode) (code vmgen.By
 
 	code.Concat(e.traverseScope(n.Scope))
 
-	for _, p := range n.Parameters {
-		for _, i := range p.Identifiers {
-			e.freeMemory(i)
-		}
-	}
-
 	return code
 }
 
 func (e *GuardianEVM) traverseIdentifier(n *ast.IdentifierNode) (code vmgen.Bytecode) {
 
+	// parameters and local variables shadow storage
+	if m := e.lookupMemory(n.Name); m != nil {
+		return m.retrieve()
+	}
+
+	if s := e.lookupStorage(n.Name); s != nil {
+		return s.retrieve()
+	}
+
+	// identifiers which weren't resolved by the validator have no size
+	if n.Resolved == nil {
+		e.addError(n.Start(), errUnresolvedIdentifier, n.Name)
+		return code
+	}
+
 	if e.inStorage {
-		s := e.lookupStorage(n.Name)
-		if s != nil {
-			return s.retrieve()
-		}
 		e.allocateStorage(n.Name, n.Resolved.Size())
-		s = e.lookupStorage(n.Name)
-		if s != nil {
-			return s.retrieve()
-		}
-	} else {
-		m := e.lookupMemory(n.Name)
-		if m != nil {
-			return m.retrieve()
-		}
-		e.allocateMemory(n.Name, n.Resolved.Size())
-		m = e.lookupMemory(n.Name)
-		if m != nil {
-			return m.retrieve()
-		}
+		return e.lookupStorage(n.Name).retrieve()
 	}
-	return code
+	e.allocateMemory(n.Name, n.Resolved.Size())
+	return e.lookupMemory(n.Name).retrieve()
 }
 
+const errUnresolvedIdentifier = "Identifier %s has no resolved type"
+
 func (e *GuardianEVM) traverseContextual(t typing.Type, expr ast.ExpressionNode) (code vmgen.Bytecode) {
 	switch expr.(type) {
 	case *ast.IdentifierNode:
@@ -417,7 +530,33 @@ func (e *GuardianEVM) traverseContextual(t typing.Type, expr ast.ExpressionNode)
 	return code
 }
 
+// builtinReference finds the generator of a property of a builtin, such as
+// msg.sender
+func (e *GuardianEVM) builtinReference(n *ast.ReferenceNode) (validator.BytecodeGenerator, bool) {
+	property, ok := n.Reference.(*ast.IdentifierNode)
+	if !ok || n.Parent.ResolvedType() == nil {
+		return nil, false
+	}
+	c, ok := typing.ResolveUnderlying(n.Parent.ResolvedType()).(*typing.Class)
+	if !ok {
+		return nil, false
+	}
+	return builtinProperty(c.Name, property.Name)
+}
+
 func (e *GuardianEVM) traverseReference(n *ast.ReferenceNode) (code vmgen.Bytecode) {
+	if _, ok := e.externalFunction(n); ok {
+		return e.traverseExternalCall(n)
+	}
+	if b, ok := e.builtinReference(n); ok {
+		return b(e)
+	}
+	if location, l, ok := e.locateStorage(n); ok {
+		code.Concat(location)
+		code.Concat(l.load())
+		return code
+	}
+
 	code.Concat(e.traverse(n.Parent))
 
 	resolved := n.Parent.ResolvedType()
@@ -0,0 +1,260 @@
+package evm
+
+import (
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/typing"
+	"github.com/end-r/vmgen"
+)
+
+// functions of other contracts are called through a contract or interface
+// reference: Token(addr).transfer(to, amount)
+// the arguments are ABI-encoded after the selector of the function, and the
+// call forwards all remaining gas
+// functions marked view are called with STATICCALL, and cannot change state
+const viewModifier = "view"
+
+// externalFunction finds the function called by a reference to a function of
+// a contract or interface
+func (e *GuardianEVM) externalFunction(n *ast.ReferenceNode) (*typing.Func, bool) {
+	call, ok := n.Reference.(*ast.CallExpressionNode)
+	if !ok || n.Parent.ResolvedType() == nil {
+		return nil, false
+	}
+	i, ok := call.Call.(*ast.IdentifierNode)
+	if !ok {
+		return nil, false
+	}
+	switch a := typing.ResolveUnderlying(n.Parent.ResolvedType()).(type) {
+	case *typing.Interface:
+		return interfaceFunction(a, i.Name)
+	case *typing.Contract:
+		return contractFunction(a, i.Name)
+	}
+	return nil, false
+}
+
+func interfaceFunction(i *typing.Interface, name string) (*typing.Func, bool) {
+	if f, ok := i.Funcs[name]; ok {
+		return f, true
+	}
+	for _, s := range i.Supers {
+		if f, ok := interfaceFunction(s, name); ok {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+func contractFunction(c *typing.Contract, name string) (*typing.Func, bool) {
+	if f, ok := c.Properties[name].(*typing.Func); ok {
+		return f, true
+	}
+	for _, s := range c.Supers {
+		if f, ok := contractFunction(s, name); ok {
+			return f, true
+		}
+	}
+	for _, i := range c.Interfaces {
+		if f, ok := interfaceFunction(i, name); ok {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// externalSignature finds the declared signature of a function of another
+// contract, as aliases such as address are only named by the declared types
+func (e *GuardianEVM) externalSignature(n *ast.ReferenceNode, name string) *ast.FuncTypeNode {
+	if e.contract == nil {
+		return nil
+	}
+	var declared string
+	switch a := typing.ResolveUnderlying(n.Parent.ResolvedType()).(type) {
+	case *typing.Interface:
+		declared = a.Name
+	case *typing.Contract:
+		declared = a.Name
+	}
+	scope := e.contract.Body
+	return findSignature(scope, lookupDeclaration(scope, []string{declared}), name)
+}
+
+// findSignature searches a contract or interface and those it inherits from,
+// the most derived first
+func findSignature(scope *ast.ScopeNode, declaration ast.Node, name string) *ast.FuncTypeNode {
+	switch a := declaration.(type) {
+	case *ast.InterfaceDeclarationNode:
+		for _, s := range a.Signatures {
+			if s.Identifier == name {
+				return s
+			}
+		}
+		for _, super := range a.Supers {
+			if s := findSignature(scope, lookupDeclaration(scope, super.Names), name); s != nil {
+				return s
+			}
+		}
+	case *ast.ContractDeclarationNode:
+		order := linearise(a, nil)
+		for i := len(order) - 1; i >= 0; i-- {
+			c := order[i]
+			if c.Body != nil && c.Body.Declarations != nil {
+				for _, d := range c.Body.Declarations.Array() {
+					if f, ok := d.(*ast.FuncDeclarationNode); ok && f.Signature.Identifier == name {
+						return f.Signature
+					}
+				}
+			}
+			for _, super := range c.Interfaces {
+				if s := findSignature(c.Body, lookupDeclaration(c.Body, super.Names), name); s != nil {
+					return s
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// traverseExternalCall calls a function of another contract, leaving its
+// decoded results on the stack with the last on top
+// calls to addresses without code revert, as do calls which fail, with the
+// data the failed call reverted with
+func (e *GuardianEVM) traverseExternalCall(n *ast.ReferenceNode) (code vmgen.Bytecode) {
+	f, _ := e.externalFunction(n)
+	call := n.Reference.(*ast.CallExpressionNode)
+	name := call.Call.(*ast.IdentifierNode).Name
+	signature := e.externalSignature(n, name)
+	if signature == nil {
+		e.addError(n.Start(), errUnknownExternalFunction, name)
+		return code
+	}
+	params := signatureParameters(signature)
+	var types, results []*abiValue
+	for _, p := range params {
+		for range p.Identifiers {
+			types = append(types, parseABIType(e.abiName(p.DeclaredType, p.Resolved)))
+		}
+	}
+	for _, r := range signatureResults(signature, f) {
+		results = append(results, parseABIType(e.abiName(r.declared, r.resolved)))
+	}
+
+	// the return data is copied out after the call, rather than into a
+	// region set aside for it
+	code.Concat(push([]byte{0}))
+	code.Add("DUP1")
+	code.Concat(e.traverseExpression(n.Parent))
+	code.Add("DUP1")
+	code.Add("EXTCODESIZE")
+	code.Add("ISZERO")
+	code.Concat(e.revertIf())
+	for _, arg := range call.Arguments {
+		code.Concat(e.traverseExpression(arg))
+	}
+	code.Concat(e.encodeCall(Selector(e.signature(name, params)), types))
+	// 0, 0, address, size, start
+	code.Add("SWAP1")
+	code.Add("SWAP2")
+	if hasModifier(signature.Mods.Modifiers, viewModifier) {
+		code.Add("GAS")
+		code.Add("STATICCALL")
+	} else {
+		code.Concat(push([]byte{0}))
+		code.Add("SWAP1")
+		code.Add("GAS")
+		code.Add("CALL")
+	}
+	code.Add("ISZERO")
+	code.Concat(pushLabel(e.bubble()))
+	code.Add("JUMPI")
+	code.Concat(e.decodeReturnData(results))
+	return code
+}
+
+// encodeCall replaces the arguments on the stack, with the last on top, with
+// the size and start of the input of a call: the selector followed by their
+// encoding
+func (e *GuardianEVM) encodeCall(selector []byte, params []*abiValue) (code vmgen.Bytecode) {
+	if len(params) == 0 {
+		// the selector alone is held in the scratch space
+		code.Concat(push(selector))
+		code.Concat(push([]byte{byte(wordSize - selectorSize*8)}))
+		code.Add("SHL")
+		code.Concat(push([]byte{scratchSpace}))
+		code.Add("MSTORE")
+		code.Concat(push([]byte{selectorSize}))
+		code.Concat(push([]byte{scratchSpace}))
+		return code
+	}
+	code.Concat(e.encodeValues(params))
+	// start, end
+	// the word before the encoding is part of the tuple it was encoded
+	// from, so the selector is written over its last bytes
+	code.Concat(push(selector))
+	code.Add("DUP3")
+	code.Concat(push([]byte{byte(wordBytes)}))
+	code.Add("SWAP1")
+	code.Add("SUB")
+	code.Add("MSTORE")
+	code.Add("DUP2")
+	code.Add("SWAP1")
+	code.Add("SUB")
+	code.Concat(addConstant(selectorSize))
+	code.Add("SWAP1")
+	code.Concat(push([]byte{selectorSize}))
+	code.Add("SWAP1")
+	code.Add("SUB")
+	return code
+}
+
+// decodeReturnData copies the data returned by a call onto the heap and
+// decodes each of its values onto the stack
+// return data which is too short to hold them reverts
+func (e *GuardianEVM) decodeReturnData(types []*abiValue) (code vmgen.Bytecode) {
+	if len(types) == 0 {
+		return code
+	}
+	e.allocateMemory(inputBlock, wordSize)
+	e.allocateMemory(inputSizeBlock, wordSize)
+	code.Add("RETURNDATASIZE")
+	code.Add("DUP1")
+	code.Concat(roundToWord())
+	code.Concat(allocate())
+	code.Add("RETURNDATASIZE")
+	code.Concat(push([]byte{0}))
+	code.Add("DUP3")
+	code.Add("RETURNDATACOPY")
+	code.Concat(e.lookupMemory(inputBlock).store())
+	code.Concat(e.lookupMemory(inputSizeBlock).store())
+	e.inputInMemory = true
+	code.Concat(e.decodeValues(types, 0))
+	e.inputInMemory = false
+	return code
+}
+
+// bubble returns the label which failed calls jump to
+func (e *GuardianEVM) bubble() int {
+	if e.bubbleLabel == 0 {
+		e.bubbleLabel = e.newLabel()
+	}
+	return e.bubbleLabel
+}
+
+// bubbleRevert reverts with the data returned by the last call, which is
+// copied to the start of memory
+func (e *GuardianEVM) bubbleRevert() (code vmgen.Bytecode) {
+	if e.bubbleLabel == 0 {
+		return code
+	}
+	code.Concat(jumpdest(e.bubbleLabel))
+	code.Add("RETURNDATASIZE")
+	code.Concat(push([]byte{0}))
+	code.Add("DUP1")
+	code.Add("RETURNDATACOPY")
+	code.Add("RETURNDATASIZE")
+	code.Concat(push([]byte{0}))
+	code.Add("REVERT")
+	return code
+}
+
+const errUnknownExternalFunction = "Function %s of another contract must be declared by an interface or contract in scope"
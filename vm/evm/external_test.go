@@ -0,0 +1,139 @@
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
+	"github.com/end-r/guardian/vm/evm/interpreter"
+)
+
+// deployTo compiles a contract and deploys it to an existing chain
+func deployTo(t *testing.T, chain *interpreter.EVM, name, text string) interpreter.Address {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, text)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c, ok := scope.GetDeclaration(name).(*ast.ContractDeclarationNode)
+	goutil.AssertNow(t, ok, "contract not found")
+	code, errs := e.Compile(c, false)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	a, r := chain.Deploy(account, code, nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	return a
+}
+
+func addressWord(a interpreter.Address) []byte {
+	return append(make([]byte, 12), a[:]...)
+}
+
+const externalToken = `
+	contract Token {
+		var balances map[address]uint
+		var unissued uint
+		var touched uint
+		external func mint(amount uint) {
+			unissued += amount
+		}
+		external func send(to address, amount uint) bool {
+			require(unissued >= amount)
+			unissued -= amount
+			balances[to] += amount
+			return true
+		}
+		external func holdings(owner address) uint {
+			return balances[owner]
+		}
+		external func split(amount uint) (uint, uint) {
+			return amount / 2, amount - amount / 2
+		}
+		external func decimals() uint {
+			return 18
+		}
+		external func touch() uint {
+			touched += 1
+			return touched
+		}
+	}
+`
+
+const externalVault = `
+	interface ERC20 {
+		send(to address, amount uint) bool
+		view holdings(owner address) uint
+		view split(amount uint) (uint, uint)
+		view decimals() uint
+		view touch() uint
+	}
+	contract Vault {
+		external func pay(token, to address, amount uint) bool {
+			return ERC20(token).send(to, amount)
+		}
+		external func payTwice(token, to address, amount uint) {
+			ERC20(token).send(to, amount)
+			ERC20(token).send(to, amount)
+		}
+		external func held(token, owner address) uint {
+			return ERC20(token).holdings(owner)
+		}
+		external func halves(token address, amount uint) (uint, uint) {
+			var a, b uint
+			a, b = ERC20(token).split(amount)
+			return b, a
+		}
+		external func decimals(token address) uint {
+			return ERC20(token).decimals()
+		}
+		external func touch(token address) uint {
+			return ERC20(token).touch()
+		}
+	}
+`
+
+func TestExternalCalls(t *testing.T) {
+	chain := interpreter.New()
+	token := deployTo(t, chain, "Token", externalToken)
+	vault := deployTo(t, chain, "Vault", externalVault)
+	to := interpreter.BytesToAddress([]byte{0x02})
+	r := send(chain, token, "mint(uint256)", uintWord(80))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, vault, "pay(address,address,uint256)", addressWord(token), addressWord(to), uintWord(30))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(1)), fmt.Sprintf("wrong result %x", r.Output))
+	r = send(chain, vault, "payTwice(address,address,uint256)", addressWord(token), addressWord(to), uintWord(10))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, vault, "held(address,address)", addressWord(token), addressWord(to))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(50)), fmt.Sprintf("wrong balance %x", r.Output))
+	r = send(chain, vault, "halves(address,uint256)", addressWord(token), uintWord(7))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, append(uintWord(4), uintWord(3)...)), fmt.Sprintf("wrong results %x", r.Output))
+	r = send(chain, vault, "decimals(address)", addressWord(token))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(18)), fmt.Sprintf("wrong decimals %x", r.Output))
+}
+
+func TestExternalCallFailures(t *testing.T) {
+	chain := interpreter.New()
+	token := deployTo(t, chain, "Token", externalToken)
+	vault := deployTo(t, chain, "Vault", externalVault)
+	to := interpreter.BytesToAddress([]byte{0x02})
+	// nothing has been minted
+	r := send(chain, vault, "pay(address,address,uint256)", addressWord(token), addressWord(to), uintWord(1))
+	goutil.Assert(t, r.Reverted(), "failed calls should revert")
+	// view functions are called without permission to change state
+	r = send(chain, vault, "touch(address)", addressWord(token))
+	goutil.Assert(t, r.Reverted(), "view calls should not change state")
+	r = send(chain, vault, "decimals(address)", addressWord(to))
+	goutil.Assert(t, r.Reverted(), "calls to addresses without code should revert")
+	// a contract which always reverts with 0xdeadbeef
+	runtime := []byte{0x63, 0xde, 0xad, 0xbe, 0xef, 0x60, 0x00, 0x52, 0x60, 0x04, 0x60, 0x1c, 0xfd}
+	init := []byte{0x60, byte(len(runtime)), 0x60, 0x0c, 0x60, 0x00, 0x39, 0x60, byte(len(runtime)), 0x60, 0x00, 0xf3}
+	reverter, d := chain.Deploy(account, append(init, runtime...), nil)
+	goutil.AssertNow(t, d.Err == nil, fmt.Sprint(d.Err))
+	r = send(chain, vault, "decimals(address)", addressWord(reverter))
+	goutil.Assert(t, r.Reverted(), "failed calls should revert")
+	goutil.Assert(t, bytes.Equal(r.Output, []byte{0xde, 0xad, 0xbe, 0xef}), fmt.Sprintf("wrong revert data %x", r.Output))
+}
@@ -12,14 +12,14 @@ import (
 
 func TestBuiltins(t *testing.T) {
 	expr, _ := parser.ParseFile("test/builtins.grd")
-	errs := validator.Validate(expr, NewVM())
+	errs := validator.Validate(NewVM(), expr, nil)
 	goutil.Assert(t, expr != nil, "expr is nil")
-	goutil.Assert(t, len(errs) == 0, "expr is nil")
+	goutil.Assert(t, len(errs) == 0, errs.Format())
 }
 
 func TestGreeter(t *testing.T) {
 	expr, _ := parser.ParseFile("test/greeter.grd")
-	errs := validator.Validate(expr, NewVM())
+	errs := validator.Validate(NewVM(), expr, nil)
 	goutil.Assert(t, expr != nil, "expr is nil")
-	goutil.Assert(t, len(errs) == 0, "expr is nil")
+	goutil.Assert(t, len(errs) == 0, errs.Format())
 }
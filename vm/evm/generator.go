@@ -10,6 +10,8 @@ import (
 // to enable the compiler to estimate the gas usage of different execution paths
 // can display to user at compile time
 
+// gas costs follow the Istanbul schedule
+
 type EVMGenerator struct {
 }
 
@@ -23,21 +25,60 @@ const (
 
 	gasJumpDest = 1
 
-	gasQuickStep   = 2
-	gasFastestStep = 3
-	gasFastStep    = 5
-	gasMidStep     = 8
-	gasSlowStep    = 10
-	gasExtStep     = 20
+	gasExt          = 700
+	gasSLoad        = 800
+	gasSha3         = 30
+	gasLog          = 375
+	gasCreate       = 32000
+	gasCall         = 700
+	gasSelfDestruct = 5000
+	gasSelfBalance  = 5
 
 	gasContractByte = 200
 
 	gasBlockhash = 20
 )
 
+// the gas charged for each unit of an operand, on top of the static cost
+const (
+	gasSha3Word   = 6
+	gasCopyWord   = 3
+	gasExpByte    = 50
+	gasLogTopic   = 375
+	gasLogByte    = 8
+	gasMemoryWord = 3
+	// memory expansion is also charged quadratically, by this divisor
+	gasQuadCoeffDiv = 512
+)
+
+// storage writes and calls charge more depending on the state they change
+const (
+	gasSStoreSet      = 20000
+	gasSStoreReset    = 5000
+	gasCallValue      = 9000
+	gasCallNewAccount = 25000
+)
+
+// Instruction describes a single EVM opcode
 type Instruction struct {
-	opcode []byte
-	cost   func(interface{}) int
+	Mnemonic string
+	Opcode   byte
+	// the number of stack items consumed and produced
+	Pops, Pushes int
+	// the gas charged every time the instruction is run
+	Gas int
+	// dynamic instructions charge further gas depending on their operands or
+	// on the state, such as for memory expansion or storage writes
+	Dynamic bool
+}
+
+// Size is the number of bytes an instruction occupies, including any
+// immediate data
+func (i Instruction) Size() int {
+	if i.Opcode >= 0x60 && i.Opcode <= 0x7F {
+		return 1 + int(i.Opcode-0x5F)
+	}
+	return 1
 }
 
 func constantGas(gas int) func(interface{}) int {
@@ -46,154 +87,157 @@ func constantGas(gas int) func(interface{}) int {
 	}
 }
 
-func generateDups() (im vmgen.InstructionMap) {
+func generateDups() (im map[string]Instruction) {
+	im = make(map[string]Instruction)
 	number := 16
 	for i := 1; i <= number; i++ {
-		im[fmt.Sprintf("DUP%d", i)] = vmgen.Instruction{Opcode: uint(0x80 + i), Cost: constantGas(gasZero)}
+		im[fmt.Sprintf("DUP%d", i)] = Instruction{Opcode: byte(0x7F + i), Pops: i, Pushes: i + 1, Gas: gasVeryLow}
 	}
 	return im
 }
 
-func generateSwaps() (im vmgen.InstructionMap) {
+func generateSwaps() (im map[string]Instruction) {
+	im = make(map[string]Instruction)
 	number := 16
 	for i := 1; i <= number; i++ {
-		im[fmt.Sprintf("SWAP%d", i)] = vmgen.Instruction{Opcode: uint(0x90 + i), Cost: constantGas(gasZero)}
+		im[fmt.Sprintf("SWAP%d", i)] = Instruction{Opcode: byte(0x8F + i), Pops: i + 1, Pushes: i + 1, Gas: gasVeryLow}
 	}
 	return im
 }
 
-func generatePushes() (im vmgen.InstructionMap) {
+func generatePushes() (im map[string]Instruction) {
+	im = make(map[string]Instruction)
 	number := 32
 	for i := 1; i <= number; i++ {
-		im[fmt.Sprintf("SWAP%d", i)] = vmgen.Instruction{Opcode: uint(0x60 + i), Cost: constantGas(gasZero)}
+		im[fmt.Sprintf("PUSH%d", i)] = Instruction{Opcode: byte(0x5F + i), Pushes: 1, Gas: gasVeryLow}
 	}
 	return im
 }
 
-func generateLogs() (im vmgen.InstructionMap) {
+func generateLogs() (im map[string]Instruction) {
+	im = make(map[string]Instruction)
 	number := 5
 	for i := 0; i < number; i++ {
-		im[fmt.Sprintf("SWAP%d", i)] = vmgen.Instruction{Opcode: uint(0xA0 + i), Cost: constantGas(gasZero)}
+		im[fmt.Sprintf("LOG%d", i)] = Instruction{Opcode: byte(0xA0 + i), Pops: 2 + i, Gas: gasLog + i*gasLogTopic, Dynamic: true}
 	}
 	return im
 }
 
-func gasExp(interface{}) int {
-	return 0
-}
-
-func gasSLoad(interface{}) int {
-	return 0
-}
-
-func gasSStore(interface{}) int {
-	return 0
-}
-
-func gasCall(interface{}) int {
-	return 0
-}
-
-func gasBalance(interface{}) int {
-	return 0
-}
-
-func gasSha3(interface{}) int {
-	return 0
-}
-
-func gasCodeCopy(interface{}) int {
-	return 0
-}
-
-func gasCallDataCopy(interface{}) int {
-	return 0
-}
-
-func gasExtCodeSize(interface{}) int {
-	return 0
+var instructions = generateInstructions()
+
+// opcodes indexes the instruction set by opcode
+var opcodes = indexInstructions(instructions)
+
+func generateInstructions() map[string]Instruction {
+	m := map[string]Instruction{
+		"STOP":       {Opcode: 0x00},
+		"ADD":        {Opcode: 0x01, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"MUL":        {Opcode: 0x02, Pops: 2, Pushes: 1, Gas: gasLow},
+		"SUB":        {Opcode: 0x03, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"DIV":        {Opcode: 0x04, Pops: 2, Pushes: 1, Gas: gasLow},
+		"SDIV":       {Opcode: 0x05, Pops: 2, Pushes: 1, Gas: gasLow},
+		"MOD":        {Opcode: 0x06, Pops: 2, Pushes: 1, Gas: gasLow},
+		"SMOD":       {Opcode: 0x07, Pops: 2, Pushes: 1, Gas: gasLow},
+		"ADDMOD":     {Opcode: 0x08, Pops: 3, Pushes: 1, Gas: gasMid},
+		"MULMOD":     {Opcode: 0x09, Pops: 3, Pushes: 1, Gas: gasMid},
+		"EXP":        {Opcode: 0x0A, Pops: 2, Pushes: 1, Gas: gasHigh, Dynamic: true},
+		"SIGNEXTEND": {Opcode: 0x0B, Pops: 2, Pushes: 1, Gas: gasLow},
+
+		"LT":     {Opcode: 0x10, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"GT":     {Opcode: 0x11, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"SLT":    {Opcode: 0x12, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"SGT":    {Opcode: 0x13, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"EQ":     {Opcode: 0x14, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"ISZERO": {Opcode: 0x15, Pops: 1, Pushes: 1, Gas: gasVeryLow},
+		"AND":    {Opcode: 0x16, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"OR":     {Opcode: 0x17, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"XOR":    {Opcode: 0x18, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"NOT":    {Opcode: 0x19, Pops: 1, Pushes: 1, Gas: gasVeryLow},
+		"BYTE":   {Opcode: 0x1A, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"SHL":    {Opcode: 0x1B, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"SHR":    {Opcode: 0x1C, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+		"SAR":    {Opcode: 0x1D, Pops: 2, Pushes: 1, Gas: gasVeryLow},
+
+		"SHA3": {Opcode: 0x20, Pops: 2, Pushes: 1, Gas: gasSha3, Dynamic: true},
+
+		"ADDRESS":        {Opcode: 0x30, Pushes: 1, Gas: gasBase},
+		"BALANCE":        {Opcode: 0x31, Pops: 1, Pushes: 1, Gas: gasExt},
+		"ORIGIN":         {Opcode: 0x32, Pushes: 1, Gas: gasBase},
+		"CALLER":         {Opcode: 0x33, Pushes: 1, Gas: gasBase},
+		"CALLVALUE":      {Opcode: 0x34, Pushes: 1, Gas: gasBase},
+		"CALLDATALOAD":   {Opcode: 0x35, Pops: 1, Pushes: 1, Gas: gasVeryLow},
+		"CALLDATASIZE":   {Opcode: 0x36, Pushes: 1, Gas: gasBase},
+		"CALLDATACOPY":   {Opcode: 0x37, Pops: 3, Gas: gasVeryLow, Dynamic: true},
+		"CODESIZE":       {Opcode: 0x38, Pushes: 1, Gas: gasBase},
+		"CODECOPY":       {Opcode: 0x39, Pops: 3, Gas: gasVeryLow, Dynamic: true},
+		"GASPRICE":       {Opcode: 0x3A, Pushes: 1, Gas: gasBase},
+		"EXTCODESIZE":    {Opcode: 0x3B, Pops: 1, Pushes: 1, Gas: gasExt},
+		"EXTCODECOPY":    {Opcode: 0x3C, Pops: 4, Gas: gasExt, Dynamic: true},
+		"RETURNDATASIZE": {Opcode: 0x3D, Pushes: 1, Gas: gasBase},
+		"RETURNDATACOPY": {Opcode: 0x3E, Pops: 3, Gas: gasVeryLow, Dynamic: true},
+		"EXTCODEHASH":    {Opcode: 0x3F, Pops: 1, Pushes: 1, Gas: gasExt},
+
+		"BLOCKHASH":   {Opcode: 0x40, Pops: 1, Pushes: 1, Gas: gasBlockhash},
+		"COINBASE":    {Opcode: 0x41, Pushes: 1, Gas: gasBase},
+		"TIMESTAMP":   {Opcode: 0x42, Pushes: 1, Gas: gasBase},
+		"NUMBER":      {Opcode: 0x43, Pushes: 1, Gas: gasBase},
+		"DIFFICULTY":  {Opcode: 0x44, Pushes: 1, Gas: gasBase},
+		"GASLIMIT":    {Opcode: 0x45, Pushes: 1, Gas: gasBase},
+		"CHAINID":     {Opcode: 0x46, Pushes: 1, Gas: gasBase},
+		"SELFBALANCE": {Opcode: 0x47, Pushes: 1, Gas: gasSelfBalance},
+
+		"POP":      {Opcode: 0x50, Pops: 1, Gas: gasBase},
+		"MLOAD":    {Opcode: 0x51, Pops: 1, Pushes: 1, Gas: gasVeryLow, Dynamic: true},
+		"MSTORE":   {Opcode: 0x52, Pops: 2, Gas: gasVeryLow, Dynamic: true},
+		"MSTORE8":  {Opcode: 0x53, Pops: 2, Gas: gasVeryLow, Dynamic: true},
+		"SLOAD":    {Opcode: 0x54, Pops: 1, Pushes: 1, Gas: gasSLoad},
+		"SSTORE":   {Opcode: 0x55, Pops: 2, Gas: gasSLoad, Dynamic: true},
+		"JUMP":     {Opcode: 0x56, Pops: 1, Gas: gasMid},
+		"JUMPI":    {Opcode: 0x57, Pops: 2, Gas: gasHigh},
+		"PC":       {Opcode: 0x58, Pushes: 1, Gas: gasBase},
+		"MSIZE":    {Opcode: 0x59, Pushes: 1, Gas: gasBase},
+		"GAS":      {Opcode: 0x5A, Pushes: 1, Gas: gasBase},
+		"JUMPDEST": {Opcode: 0x5B, Gas: gasJumpDest},
+
+		"CREATE":       {Opcode: 0xF0, Pops: 3, Pushes: 1, Gas: gasCreate, Dynamic: true},
+		"CALL":         {Opcode: 0xF1, Pops: 7, Pushes: 1, Gas: gasCall, Dynamic: true},
+		"CALLCODE":     {Opcode: 0xF2, Pops: 7, Pushes: 1, Gas: gasCall, Dynamic: true},
+		"RETURN":       {Opcode: 0xF3, Pops: 2, Dynamic: true},
+		"DELEGATECALL": {Opcode: 0xF4, Pops: 6, Pushes: 1, Gas: gasCall, Dynamic: true},
+		"CREATE2":      {Opcode: 0xF5, Pops: 4, Pushes: 1, Gas: gasCreate, Dynamic: true},
+		"STATICCALL":   {Opcode: 0xFA, Pops: 6, Pushes: 1, Gas: gasCall, Dynamic: true},
+		"REVERT":       {Opcode: 0xFD, Pops: 2, Dynamic: true},
+		// invalid instructions consume all remaining gas
+		"INVALID":      {Opcode: 0xFE, Dynamic: true},
+		"SELFDESTRUCT": {Opcode: 0xFF, Pops: 1, Gas: gasSelfDestruct, Dynamic: true},
+	}
+	for _, generated := range []map[string]Instruction{
+		generatePushes(), generateDups(), generateLogs(), generateSwaps(),
+	} {
+		for k, v := range generated {
+			m[k] = v
+		}
+	}
+	for k, v := range m {
+		v.Mnemonic = k
+		m[k] = v
+	}
+	return m
 }
 
-func gasExtCodeCopy(interface{}) int {
-	return 0
+func indexInstructions(m map[string]Instruction) map[byte]Instruction {
+	index := make(map[byte]Instruction)
+	for _, i := range m {
+		index[i.Opcode] = i
+	}
+	return index
 }
 
+// Opcodes describes the instruction set for vmgen
 func (e EVMGenerator) Opcodes() vmgen.InstructionMap {
-	m := vmgen.InstructionMap{
-		"STOP":       vmgen.Instruction{Opcode: 0x00, Cost: constantGas(gasZero)},
-		"ADD":        vmgen.Instruction{Opcode: 0x01, Cost: constantGas(gasVeryLow)},
-		"MUL":        vmgen.Instruction{Opcode: 0x02, Cost: constantGas(gasLow)},
-		"SUB":        vmgen.Instruction{Opcode: 0x03, Cost: constantGas(gasVeryLow)},
-		"DIV":        vmgen.Instruction{Opcode: 0x04, Cost: constantGas(gasLow)},
-		"SDIV":       vmgen.Instruction{Opcode: 0x05, Cost: constantGas(gasLow)},
-		"MOD":        vmgen.Instruction{Opcode: 0x06, Cost: constantGas(gasLow)},
-		"SMOD":       vmgen.Instruction{Opcode: 0x07, Cost: constantGas(gasLow)},
-		"ADDMOD":     vmgen.Instruction{Opcode: 0x08, Cost: constantGas(gasMid)},
-		"MULMOD":     vmgen.Instruction{Opcode: 0x09, Cost: constantGas(gasMid)},
-		"EXP":        vmgen.Instruction{Opcode: 0x0A, Cost: gasExp},
-		"SIGNEXTEND": vmgen.Instruction{Opcode: 0x0B, Cost: constantGas(gasLow)},
-
-		"LT":     vmgen.Instruction{Opcode: 0x10, Cost: constantGas(gasVeryLow)},
-		"GT":     vmgen.Instruction{Opcode: 0x11, Cost: constantGas(gasVeryLow)},
-		"SLT":    vmgen.Instruction{Opcode: 0x12, Cost: constantGas(gasVeryLow)},
-		"SGT":    vmgen.Instruction{Opcode: 0x13, Cost: constantGas(gasVeryLow)},
-		"EQ":     vmgen.Instruction{Opcode: 0x14, Cost: constantGas(gasVeryLow)},
-		"ISZERO": vmgen.Instruction{Opcode: 0x15, Cost: constantGas(gasVeryLow)},
-		"AND":    vmgen.Instruction{Opcode: 0x16, Cost: constantGas(gasVeryLow)},
-		"OR":     vmgen.Instruction{Opcode: 0x17, Cost: constantGas(gasVeryLow)},
-		"XOR":    vmgen.Instruction{Opcode: 0x18, Cost: constantGas(gasVeryLow)},
-		"NOT":    vmgen.Instruction{Opcode: 0x19, Cost: constantGas(gasVeryLow)},
-		"BYTE":   vmgen.Instruction{Opcode: 0x1A, Cost: constantGas(gasVeryLow)},
-
-		"SHA3": vmgen.Instruction{Opcode: 0x20, Cost: gasSha3},
-
-		"ADDRESS":      vmgen.Instruction{Opcode: 0x30, Cost: constantGas(gasBase)},
-		"BALANCE":      vmgen.Instruction{Opcode: 0x31, Cost: gasBalance},
-		"ORIGIN":       vmgen.Instruction{Opcode: 0x32, Cost: constantGas(gasBase)},
-		"CALLER":       vmgen.Instruction{Opcode: 0x33, Cost: constantGas(gasBase)},
-		"CALLVALUE":    vmgen.Instruction{Opcode: 0x34, Cost: constantGas(gasBase)},
-		"CALLDATALOAD": vmgen.Instruction{Opcode: 0x35, Cost: constantGas(gasVeryLow)},
-		"CALLDATASIZE": vmgen.Instruction{Opcode: 0x36, Cost: constantGas(gasBase)},
-		"CALLDATACOPY": vmgen.Instruction{Opcode: 0x37, Cost: gasCallDataCopy},
-		"CODESIZE":     vmgen.Instruction{Opcode: 0x38, Cost: constantGas(gasBase)},
-		"CODECOPY":     vmgen.Instruction{Opcode: 0x39, Cost: gasCodeCopy},
-		"GASPRICE":     vmgen.Instruction{Opcode: 0x3A, Cost: constantGas(gasBase)},
-		"EXTCODESIZE":  vmgen.Instruction{Opcode: 0x3B, Cost: gasExtCodeSize},
-		"EXTCODECOPY":  vmgen.Instruction{Opcode: 0x3C, Cost: gasExtCodeCopy},
-
-		"BLOCKHASH":  vmgen.Instruction{Opcode: 0x40, Cost: constantGas(gasExtStep)},
-		"COINBASE":   vmgen.Instruction{Opcode: 0x41, Cost: constantGas(gasBase)},
-		"TIMESTAMP":  vmgen.Instruction{Opcode: 0x42, Cost: constantGas(gasBase)},
-		"NUMBER":     vmgen.Instruction{Opcode: 0x43, Cost: constantGas(gasBase)},
-		"DIFFICULTY": vmgen.Instruction{Opcode: 0x44, Cost: constantGas(gasBase)},
-		"GASLIMIT":   vmgen.Instruction{Opcode: 0x45, Cost: constantGas(gasBase)},
-
-		"POP":      vmgen.Instruction{Opcode: 0x50, Cost: constantGas(gasBase)},
-		"MLOAD":    vmgen.Instruction{Opcode: 0x51, Cost: constantGas(gasVeryLow)},
-		"MSTORE":   vmgen.Instruction{Opcode: 0x52, Cost: constantGas(gasVeryLow)},
-		"MSTORE8":  vmgen.Instruction{Opcode: 0x53, Cost: constantGas(gasVeryLow)},
-		"SLOAD":    vmgen.Instruction{Opcode: 0x54, Cost: gasSLoad},
-		"SSTORE":   vmgen.Instruction{Opcode: 0x55, Cost: gasSStore},
-		"JUMP":     vmgen.Instruction{Opcode: 0x56, Cost: constantGas(gasMid)},
-		"JUMPI":    vmgen.Instruction{Opcode: 0x57, Cost: constantGas(gasHigh)},
-		"PC":       vmgen.Instruction{Opcode: 0x58, Cost: constantGas(gasBase)},
-		"MSIZE":    vmgen.Instruction{Opcode: 0x59, Cost: constantGas(gasBase)},
-		"GAS":      vmgen.Instruction{Opcode: 0x5A, Cost: constantGas(gasBase)},
-		"JUMPDEST": vmgen.Instruction{Opcode: 0x5B, Cost: constantGas(gasJumpDest)},
-
-		"CREATE":       vmgen.Instruction{Opcode: 0xF0, Cost: constantGas(gasJumpDest)},
-		"CALL":         vmgen.Instruction{Opcode: 0xF1, Cost: constantGas(gasJumpDest)},
-		"CALLCODE":     vmgen.Instruction{Opcode: 0xF2, Cost: constantGas(gasJumpDest)},
-		"RETURN":       vmgen.Instruction{Opcode: 0xF3, Cost: constantGas(gasJumpDest)},
-		"DELEGATECALL": vmgen.Instruction{Opcode: 0xF4, Cost: constantGas(gasJumpDest)},
-
-		"SELFDESTRUCT": vmgen.Instruction{Opcode: 0xFF, Cost: constantGas(gasJumpDest)},
+	m := make(vmgen.InstructionMap)
+	for k, v := range instructions {
+		m[k] = vmgen.Instruction{Opcode: uint(v.Opcode), Cost: constantGas(v.Gas)}
 	}
-
-	m.AddAll(generatePushes())
-	m.AddAll(generateDups())
-	m.AddAll(generateLogs())
-	m.AddAll(generateSwaps())
-
 	return m
 }
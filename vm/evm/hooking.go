@@ -1,54 +1,125 @@
 package evm
 
 import (
+	"bytes"
+	"sort"
+
 	"github.com/end-r/guardian/ast"
 	"github.com/end-r/vmgen"
 )
 
 type hook struct {
-	name     string
-	position int
-	bytecode vmgen.Bytecode
+	name      string
+	position  int
+	label     int
+	signature string
+	selector  []byte
+	bytecode  vmgen.Bytecode
 }
 
-func (e *GuardianEVM) createFunctionBody(node *ast.FuncDeclarationNode) (body vmgen.Bytecode) {
-	// all function bodies look the same
-	// traverse the scope
+// createFunctionBody traverses the body of a function, followed by its
+// epilogue, which every return statement jumps to with the results of the
+// function on the stack
+func (e *GuardianEVM) createFunctionBody(node *ast.FuncDeclarationNode, epilogue vmgen.Bytecode) (body vmgen.Bytecode) {
+	e.returnLabel = e.newLabel()
+	e.unchecked = uncheckedArithmetic(e.contract, node.Modifiers.Modifiers)
+
 	body.Concat(e.traverseScope(node.Body))
 
-	// return address should be on top of the stack
-	body.Add("JUMP")
+	if len(node.Signature.Results) > 0 {
+		// functions with results must not run off the end of their body
+		body.Concat(revert())
+	}
+	body.Concat(jumpdest(e.returnLabel))
+	body.Concat(epilogue)
+
+	e.returnLabel = 0
+	e.unchecked = false
 	return body
 }
 
-func (e *GuardianEVM) createExternalFunctionComponents(node *ast.FuncDeclarationNode) (params, body vmgen.Bytecode) {
-	// move params from calldata to memory
+// createExternalParameters decodes every parameter from calldata onto the
+// stack, with the last parameter on top
+func (e *GuardianEVM) createExternalParameters(node *ast.FuncDeclarationNode) (code vmgen.Bytecode) {
+	// offsets are relative to the end of the selector
+	return e.decodeParameters(parameters(node), selectorSize)
+}
 
-	return params, e.createFunctionBody(node)
+// decodeParameters decodes parameters which are encoded from base onwards
+func (e *GuardianEVM) decodeParameters(params []*ast.ExplicitVarDeclarationNode, base uint) (code vmgen.Bytecode) {
+	var types []*abiValue
+	for _, exp := range params {
+		t := parseABIType(e.abiName(exp.DeclaredType, exp.Resolved))
+		for range exp.Identifiers {
+			types = append(types, t)
+		}
+	}
+	return e.decodeValues(types, base)
 }
 
-func (e *GuardianEVM) createExternalParameters(node *ast.FuncDeclarationNode) (code vmgen.Bytecode) {
-	offset := uint(0)
-	for _, param := range node.Signature.Parameters {
-		exp := param.(*ast.ExplicitVarDeclarationNode)
-		for _ = range exp.Identifiers {
-			offset += exp.Resolved.Size()
+// decodeValues decodes values of each type, which are encoded from base
+// onwards, onto the stack with the last value on top
+func (e *GuardianEVM) decodeValues(types []*abiValue, base uint) (code vmgen.Bytecode) {
+	if len(types) == 0 {
+		return code
+	}
+	// the input must at least hold the head of every parameter
+	code.Concat(push(encodeUint(base + tupleHeadSize(types))))
+	code.Concat(e.checkCalldataEnd())
+	offset := base
+	for _, t := range types {
+		code.Concat(push(encodeUint(base)))
+		code.Concat(push(encodeUint(offset)))
+		code.Concat(e.decode(t))
+		offset += t.headSize()
+	}
+	return code
+}
 
+// storeParameters moves every parameter from the stack into its own memory
+// block, and expects the last parameter to be on top
+func (e *GuardianEVM) storeParameters(params []*ast.ExplicitVarDeclarationNode) (code vmgen.Bytecode) {
+	var blocks []*memoryBlock
+	for _, exp := range params {
+		for _, i := range exp.Identifiers {
+			e.allocateMemory(i, wordSize)
+			blocks = append(blocks, e.lookupMemory(i))
 		}
 	}
+	for i := len(blocks) - 1; i >= 0; i-- {
+		code.Concat(blocks[i].store())
+	}
+	return code
+}
+
+// external entry points are reached from the dispatcher with the selector
+// still on the stack
+func (e *GuardianEVM) createExternalEntry(label int) (code vmgen.Bytecode) {
+	code.Concat(jumpdest(label))
+	code.Add("POP")
 	return code
 }
 
 func (e *GuardianEVM) traverseExternalFunction(node *ast.FuncDeclarationNode) (code vmgen.Bytecode) {
 
-	params := e.createExternalParameters(node)
+	e.checkABITypes(node.Start(), errExternalFuncType, node.Signature.Identifier, parameters(node), results(node))
+
+	label := e.newLabel()
 
-	body := e.createFunctionBody(node)
+	code = e.annotate(node, func() (code vmgen.Bytecode) {
+		params := e.createExternalParameters(node)
 
-	code.Concat(params)
-	code.Concat(body)
+		params.Concat(e.storeParameters(parameters(node)))
 
-	e.addExternalHook(node.Signature.Identifier, code)
+		body := e.createFunctionBody(node, e.encodeReturn(e.resultTypes(node)))
+
+		code.Concat(e.createExternalEntry(label))
+		code.Concat(params)
+		code.Concat(body)
+		return code
+	})
+
+	e.addExternalHook(node.Signature.Identifier, e.funcSignature(node), label, code)
 
 	return code
 }
@@ -60,98 +131,163 @@ func (e *GuardianEVM) traverseExternalFunction(node *ast.FuncDeclarationNode) (c
 */
 func (e *GuardianEVM) traverseInternalFunction(node *ast.FuncDeclarationNode) (code vmgen.Bytecode) {
 
-	params := e.createInternalParameters(node)
-
-	body := e.createFunctionBody(node)
+	label := e.functionLabel(node.Signature.Identifier)
 
-	code.Concat(params)
-	code.Concat(body)
+	code = e.annotate(node, func() vmgen.Bytecode {
+		// as internal functions can only be called from inside the contract
+		// no need to have a hook
+		// can just jump to the location
+		return e.createInternalFunction(node, label)
+	})
 
-	e.addInternalHook(node.Signature.Identifier, code)
+	e.addInternalHook(node.Signature.Identifier, label, code)
 
 	return code
 }
 
-func (e *GuardianEVM) createInternalParameters(node *ast.FuncDeclarationNode) (params vmgen.Bytecode) {
-	// as internal functions can only be called from inside the contract
-	// no need to have a hook
-	// can just jump to the location
-	params.Add("JUMPDEST")
-	// load all parameters into memory blocks
-	for _, param := range node.Signature.Parameters {
-		exp := param.(*ast.ExplicitVarDeclarationNode)
-		for _, i := range exp.Identifiers {
-			e.allocateMemory(i, exp.Resolved.Size())
-			// all parameters must be on the stack
-			loc := e.lookupMemory(i)
-			params.Concat(loc.retrieve())
-		}
-	}
-
-	return params
-}
-
 func (e *GuardianEVM) traverseGlobalFunction(node *ast.FuncDeclarationNode) (code vmgen.Bytecode) {
 	// hook here
-	// get all parameters out of calldata and into memory
-	// then jump over the parameter init in the internal declaration
-	// leave the hook for later
-	external := e.createExternalParameters(node)
+	// get all parameters out of calldata
+	// then call the internal declaration, which returns to the exit
+	e.checkABITypes(node.Start(), errExternalFuncType, node.Signature.Identifier, parameters(node), results(node))
+
+	external, internal, exit := e.newLabel(), e.functionLabel(node.Signature.Identifier), e.newLabel()
 
-	internal := e.createInternalParameters(node)
+	code = e.annotate(node, func() (code vmgen.Bytecode) {
+		params := e.createExternalParameters(node)
 
-	body := e.createFunctionBody(node)
+		function := e.createInternalFunction(node, internal)
 
-	code.Concat(pushMarker(external.Length()))
+		code.Concat(e.createExternalEntry(external))
 
-	code.Concat(external)
+		code.Concat(pushLabel(exit))
 
-	code.Add("JUMPDEST")
+		code.Concat(params)
 
-	code.Concat(internal)
+		code.Concat(pushLabel(internal))
+		code.Concat(jump(jumpIntoFunction))
 
-	code.Concat(body)
+		// the internal function returns its results to the exit
+		code.Concat(jumpdest(exit))
+		code.Concat(e.encodeReturn(e.resultTypes(node)))
 
-	e.addGlobalHook(node.Signature.Identifier, code)
+		code.Concat(function)
+		return code
+	})
+
+	e.addGlobalHook(node.Signature.Identifier, e.funcSignature(node), external, code)
 	return code
 }
 
-func (e *GuardianEVM) addGlobalHook(id string, code vmgen.Bytecode) {
+func (e *GuardianEVM) addGlobalHook(id, signature string, label int, code vmgen.Bytecode) {
 	if e.globalHooks == nil {
 		e.globalHooks = make(map[string]hook)
 	}
-	e.globalHooks[id] = hook{
-		name:     id,
-		bytecode: code,
-	}
+	h := e.newHook(id, label, code)
+	h.signature = signature
+	h.selector = Selector(signature)
+	e.globalHooks[id] = h
 }
 
-func (e *GuardianEVM) addInternalHook(id string, code vmgen.Bytecode) {
+func (e *GuardianEVM) addInternalHook(id string, label int, code vmgen.Bytecode) {
 	if e.internalHooks == nil {
 		e.internalHooks = make(map[string]hook)
 	}
-	e.internalHooks[id] = hook{
-		name:     id,
-		bytecode: code,
-	}
+	e.internalHooks[id] = e.newHook(id, label, code)
 }
 
-func (e *GuardianEVM) addExternalHook(id string, code vmgen.Bytecode) {
+func (e *GuardianEVM) addExternalHook(id, signature string, label int, code vmgen.Bytecode) {
 	if e.externalHooks == nil {
 		e.externalHooks = make(map[string]hook)
 	}
-	e.externalHooks[id] = hook{
-		name:     id,
-		bytecode: code,
-	}
+	h := e.newHook(id, label, code)
+	h.signature = signature
+	h.selector = Selector(signature)
+	e.externalHooks[id] = h
 }
 
 func (e *GuardianEVM) addLifecycleHook(id string, code vmgen.Bytecode) {
 	if e.lifecycleHooks == nil {
 		e.lifecycleHooks = make(map[string]hook)
 	}
-	e.lifecycleHooks[id] = hook{
+	e.lifecycleHooks[id] = e.newHook(id, 0, code)
+}
+
+// hooks are positioned in the order in which they were declared
+func (e *GuardianEVM) newHook(id string, label int, code vmgen.Bytecode) hook {
+	e.hookCount++
+	return hook{
 		name:     id,
+		position: e.hookCount,
+		label:    label,
 		bytecode: code,
 	}
 }
+
+const selectorSize = 4
+
+// the dispatcher compares the first four bytes of calldata against the
+// selector of every externally callable function, and falls through to the
+// fallback label with an empty stack if none match
+func (e *GuardianEVM) createDispatcher(fallback int) (code vmgen.Bytecode) {
+	// calldata too short to hold a selector
+	code.Concat(push([]byte{selectorSize}))
+	code.Add("CALLDATASIZE")
+	code.Add("LT")
+	code.Concat(pushLabel(fallback))
+	code.Add("JUMPI")
+
+	// the selector is the leftmost 4 bytes of the first word
+	code.Concat(push([]byte{0}))
+	code.Add("CALLDATALOAD")
+	code.Concat(push([]byte{(32 - selectorSize) * 8}))
+	code.Add("SHR")
+
+	hooks := e.callableHooks()
+	for i := 1; i < len(hooks); i++ {
+		if bytes.Equal(hooks[i-1].selector, hooks[i].selector) {
+			e.addError(e.contract.Start(), errDuplicateSelector, hooks[i-1].signature, hooks[i].signature, hooks[i].selector)
+		}
+	}
+
+	for _, h := range hooks {
+		code.Add("DUP1")
+		code.Concat(push(h.selector))
+		code.Add("EQ")
+		code.Concat(pushLabel(h.label))
+		code.Add("JUMPI")
+	}
+	code.Add("POP")
+	return code
+}
+
+const errDuplicateSelector = "Functions %s and %s have the same selector %x, so only one of them could be called"
+
+// callableHooks returns every external and global hook, ordered by selector
+func (e *GuardianEVM) callableHooks() []hook {
+	var hooks []hook
+	for _, h := range e.externalHooks {
+		hooks = append(hooks, h)
+	}
+	for _, h := range e.globalHooks {
+		hooks = append(hooks, h)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return bytes.Compare(hooks[i].selector, hooks[j].selector) < 0
+	})
+	return hooks
+}
+
+// orderedHooks returns every function hook in declaration order
+func (e *GuardianEVM) orderedHooks() []hook {
+	var hooks []hook
+	for _, m := range []hookMap{e.externalHooks, e.globalHooks, e.internalHooks} {
+		for _, h := range m {
+			hooks = append(hooks, h)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].position < hooks[j].position
+	})
+	return hooks
+}
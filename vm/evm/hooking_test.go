@@ -0,0 +1,110 @@
+package evm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/end-r/guardian/validator"
+	"github.com/end-r/vmgen"
+
+	"github.com/end-r/goutil"
+)
+
+func traverseContract(t *testing.T, text string) vmgen.Bytecode {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, text)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	code, errs := e.Traverse(scope)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	return code
+}
+
+func pushedSelectors(code vmgen.Bytecode) [][]byte {
+	var selectors [][]byte
+	for _, c := range code.Commands {
+		if c.Mnemonic == "PUSH4" {
+			selectors = append(selectors, c.Parameters)
+		}
+	}
+	return selectors
+}
+
+func TestDispatcherSingleFunction(t *testing.T) {
+	code := traverseContract(t, `
+		contract Token {
+			external func pause() {}
+		}
+	`)
+	expected := []string{
+		// initialise the free memory pointer
+		"PUSH1", "PUSH1", "MSTORE",
+		// check for a selector
+		"PUSH1", "CALLDATASIZE", "LT", "PUSH2", "JUMPI",
+		// load the selector
+		"PUSH1", "CALLDATALOAD", "PUSH1", "SHR",
+		// compare against each hook
+		"DUP1", "PUSH4", "EQ", "PUSH2", "JUMPI",
+		"POP",
+		// fallback
+		"JUMPDEST", "PUSH1", "DUP1", "REVERT",
+		// pause, which returns nothing
+		"JUMPDEST", "POP", "JUMPDEST", "STOP",
+	}
+	goutil.Assert(t, code.CompareMnemonics(expected), code.Format())
+	selectors := pushedSelectors(code)
+	goutil.AssertNow(t, len(selectors) == 1, "wrong selector count")
+	goutil.Assert(t, bytes.Equal(selectors[0], Selector("pause()")), "wrong selector")
+}
+
+func TestDispatcherSelectorOrder(t *testing.T) {
+	code := traverseContract(t, `
+		contract Token {
+			external func give(to address, amount uint) {}
+			external func take(from address) {}
+			global func count() {}
+			internal func helper() {}
+		}
+	`)
+	selectors := pushedSelectors(code)
+	goutil.AssertNow(t, len(selectors) == 3, "internal functions should not be dispatched")
+	for i := 1; i < len(selectors); i++ {
+		goutil.Assert(t, bytes.Compare(selectors[i-1], selectors[i]) < 0, "selectors should be ordered")
+	}
+}
+
+func TestDispatcherFallback(t *testing.T) {
+	code := traverseContract(t, `
+		contract Token {
+			external func pause() {}
+			fallback() {}
+		}
+	`)
+	expected := []string{
+		"PUSH1", "PUSH1", "MSTORE",
+		"PUSH1", "CALLDATASIZE", "LT", "PUSH2", "JUMPI",
+		"PUSH1", "CALLDATALOAD", "PUSH1", "SHR",
+		"DUP1", "PUSH4", "EQ", "PUSH2", "JUMPI",
+		"POP",
+		// fallback body
+		"JUMPDEST", "STOP",
+		// pause, which returns nothing
+		"JUMPDEST", "POP", "JUMPDEST", "STOP",
+	}
+	goutil.Assert(t, code.CompareMnemonics(expected), code.Format())
+}
+
+func TestDispatcherDuplicateSelectors(t *testing.T) {
+	goutil.AssertNow(t, bytes.Equal(Selector("f8491()"), Selector("f130736()")), "selectors should collide")
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, `
+		contract Token {
+			external func f8491() {}
+			global func f130736() {}
+		}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	_, errs = e.Traverse(scope)
+	goutil.AssertNow(t, len(errs) == 1, errs.Format())
+	goutil.Assert(t, strings.Contains(errs.Format(), "have the same selector 62018627"), errs.Format())
+}
@@ -0,0 +1,152 @@
+package interpreter
+
+// gas costs follow the Istanbul schedule, except that storage writes are
+// charged without refunds or the net metering of EIP-2200
+
+const (
+	gasZero    = 0
+	gasBase    = 2
+	gasVeryLow = 3
+	gasLow     = 5
+	gasMid     = 8
+	gasHigh    = 10
+
+	gasJumpDest     = 1
+	gasExt          = 700
+	gasSLoad        = 800
+	gasSha3         = 30
+	gasLog          = 375
+	gasCreate       = 32000
+	gasCall         = 700
+	gasSelfDestruct = 5000
+	gasSelfBalance  = 5
+	gasBlockhash    = 20
+
+	gasSStoreSet   = 20000
+	gasSStoreReset = 5000
+
+	gasCallValue      = 9000
+	gasCallNewAccount = 25000
+	gasCallStipend    = 2300
+	gasCodeDeposit    = 200
+	gasSha3Word       = 6
+	gasCopyWord       = 3
+	gasExpByte        = 50
+	gasLogTopic       = 375
+	gasLogByte        = 8
+	gasMemoryWord     = 3
+	gasQuadCoeffDiv   = 512
+	maxStack          = 1024
+	maxMemory         = 1 << 32
+)
+
+// operation describes how an opcode uses the stack, and the gas it is
+// charged before any dynamic costs
+type operation struct {
+	name         string
+	pops, pushes int
+	gas          uint64
+}
+
+var operations = map[byte]operation{
+	0x00: {"STOP", 0, 0, gasZero},
+	0x01: {"ADD", 2, 1, gasVeryLow},
+	0x02: {"MUL", 2, 1, gasLow},
+	0x03: {"SUB", 2, 1, gasVeryLow},
+	0x04: {"DIV", 2, 1, gasLow},
+	0x05: {"SDIV", 2, 1, gasLow},
+	0x06: {"MOD", 2, 1, gasLow},
+	0x07: {"SMOD", 2, 1, gasLow},
+	0x08: {"ADDMOD", 3, 1, gasMid},
+	0x09: {"MULMOD", 3, 1, gasMid},
+	0x0A: {"EXP", 2, 1, gasHigh},
+	0x0B: {"SIGNEXTEND", 2, 1, gasLow},
+
+	0x10: {"LT", 2, 1, gasVeryLow},
+	0x11: {"GT", 2, 1, gasVeryLow},
+	0x12: {"SLT", 2, 1, gasVeryLow},
+	0x13: {"SGT", 2, 1, gasVeryLow},
+	0x14: {"EQ", 2, 1, gasVeryLow},
+	0x15: {"ISZERO", 1, 1, gasVeryLow},
+	0x16: {"AND", 2, 1, gasVeryLow},
+	0x17: {"OR", 2, 1, gasVeryLow},
+	0x18: {"XOR", 2, 1, gasVeryLow},
+	0x19: {"NOT", 1, 1, gasVeryLow},
+	0x1A: {"BYTE", 2, 1, gasVeryLow},
+	0x1B: {"SHL", 2, 1, gasVeryLow},
+	0x1C: {"SHR", 2, 1, gasVeryLow},
+	0x1D: {"SAR", 2, 1, gasVeryLow},
+
+	0x20: {"SHA3", 2, 1, gasSha3},
+
+	0x30: {"ADDRESS", 0, 1, gasBase},
+	0x31: {"BALANCE", 1, 1, gasExt},
+	0x32: {"ORIGIN", 0, 1, gasBase},
+	0x33: {"CALLER", 0, 1, gasBase},
+	0x34: {"CALLVALUE", 0, 1, gasBase},
+	0x35: {"CALLDATALOAD", 1, 1, gasVeryLow},
+	0x36: {"CALLDATASIZE", 0, 1, gasBase},
+	0x37: {"CALLDATACOPY", 3, 0, gasVeryLow},
+	0x38: {"CODESIZE", 0, 1, gasBase},
+	0x39: {"CODECOPY", 3, 0, gasVeryLow},
+	0x3A: {"GASPRICE", 0, 1, gasBase},
+	0x3B: {"EXTCODESIZE", 1, 1, gasExt},
+	0x3C: {"EXTCODECOPY", 4, 0, gasExt},
+	0x3D: {"RETURNDATASIZE", 0, 1, gasBase},
+	0x3E: {"RETURNDATACOPY", 3, 0, gasVeryLow},
+	0x3F: {"EXTCODEHASH", 1, 1, gasExt},
+
+	0x40: {"BLOCKHASH", 1, 1, gasBlockhash},
+	0x41: {"COINBASE", 0, 1, gasBase},
+	0x42: {"TIMESTAMP", 0, 1, gasBase},
+	0x43: {"NUMBER", 0, 1, gasBase},
+	0x44: {"DIFFICULTY", 0, 1, gasBase},
+	0x45: {"GASLIMIT", 0, 1, gasBase},
+	0x46: {"CHAINID", 0, 1, gasBase},
+	0x47: {"SELFBALANCE", 0, 1, gasSelfBalance},
+
+	0x50: {"POP", 1, 0, gasBase},
+	0x51: {"MLOAD", 1, 1, gasVeryLow},
+	0x52: {"MSTORE", 2, 0, gasVeryLow},
+	0x53: {"MSTORE8", 2, 0, gasVeryLow},
+	0x54: {"SLOAD", 1, 1, gasSLoad},
+	0x55: {"SSTORE", 2, 0, gasZero},
+	0x56: {"JUMP", 1, 0, gasMid},
+	0x57: {"JUMPI", 2, 0, gasHigh},
+	0x58: {"PC", 0, 1, gasBase},
+	0x59: {"MSIZE", 0, 1, gasBase},
+	0x5A: {"GAS", 0, 1, gasBase},
+	0x5B: {"JUMPDEST", 0, 0, gasJumpDest},
+
+	0xF0: {"CREATE", 3, 1, gasCreate},
+	0xF1: {"CALL", 7, 1, gasCall},
+	0xF2: {"CALLCODE", 7, 1, gasCall},
+	0xF3: {"RETURN", 2, 0, gasZero},
+	0xF4: {"DELEGATECALL", 6, 1, gasCall},
+	0xF5: {"CREATE2", 4, 1, gasCreate},
+	0xFA: {"STATICCALL", 6, 1, gasCall},
+	0xFD: {"REVERT", 2, 0, gasZero},
+	0xFF: {"SELFDESTRUCT", 1, 0, gasSelfDestruct},
+}
+
+func init() {
+	for i := 1; i <= 32; i++ {
+		operations[byte(0x5F+i)] = operation{"PUSH", 0, 1, gasVeryLow}
+	}
+	for i := 1; i <= 16; i++ {
+		operations[byte(0x7F+i)] = operation{"DUP", i, i + 1, gasVeryLow}
+		operations[byte(0x8F+i)] = operation{"SWAP", i + 1, i + 1, gasVeryLow}
+	}
+	for i := 0; i <= 4; i++ {
+		operations[byte(0xA0+i)] = operation{"LOG", 2 + i, 0, gasLog + uint64(i)*gasLogTopic}
+	}
+}
+
+func toWords(size uint64) uint64 {
+	return (size + 31) / 32
+}
+
+// memoryGas is the total cost of holding a number of words in memory
+func memoryGas(words uint64) uint64 {
+	return words*gasMemoryWord + words*words/gasQuadCoeffDiv
+}
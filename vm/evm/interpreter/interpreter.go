@@ -0,0 +1,631 @@
+package interpreter
+
+import (
+	"math/big"
+)
+
+var (
+	tt255   = new(big.Int).Lsh(big.NewInt(1), 255)
+	tt256   = new(big.Int).Lsh(big.NewInt(1), 256)
+	tt256m1 = new(big.Int).Sub(tt256, big.NewInt(1))
+)
+
+// wrap reduces a value to an unsigned 256-bit word, in two's complement
+func wrap(x *big.Int) *big.Int {
+	return x.And(x, tt256m1)
+}
+
+// signed interprets a word as a two's complement integer
+func signed(x *big.Int) *big.Int {
+	if x.Cmp(tt255) >= 0 {
+		return new(big.Int).Sub(x, tt256)
+	}
+	return new(big.Int).Set(x)
+}
+
+// word returns the 32 byte big-endian encoding of a value
+func word(x *big.Int) []byte {
+	w := make([]byte, 32)
+	b := wrap(new(big.Int).Set(x)).Bytes()
+	copy(w[32-len(b):], b)
+	return w
+}
+
+func boolean(b bool) *big.Int {
+	if b {
+		return big.NewInt(1)
+	}
+	return new(big.Int)
+}
+
+// frame is a single call: its code is run against the storage of its
+// address
+type frame struct {
+	evm        *EVM
+	tx         *transaction
+	address    Address
+	caller     Address
+	value      *big.Int
+	input      []byte
+	code       []byte
+	gas        uint64
+	depth      int
+	static     bool
+	pc         uint64
+	stack      []*big.Int
+	memory     []byte
+	returnData []byte
+	dests      map[uint64]bool
+}
+
+func (f *frame) useGas(gas uint64) error {
+	if f.gas < gas {
+		f.gas = 0
+		return ErrOutOfGas
+	}
+	f.gas -= gas
+	return nil
+}
+
+func (f *frame) pop() *big.Int {
+	x := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+	return x
+}
+
+func (f *frame) push(x *big.Int) {
+	f.stack = append(f.stack, wrap(x))
+}
+
+// memoryRange charges for expanding memory to hold size bytes from an
+// offset, and returns the range
+// empty ranges never expand memory
+func (f *frame) memoryRange(offset, size *big.Int) (uint64, uint64, error) {
+	if size.Sign() == 0 {
+		return 0, 0, nil
+	}
+	if !offset.IsUint64() || !size.IsUint64() || offset.Uint64()+size.Uint64() > maxMemory {
+		return 0, 0, ErrOutOfGas
+	}
+	o, s := offset.Uint64(), size.Uint64()
+	words := toWords(o + s)
+	current := uint64(len(f.memory)) / 32
+	if words > current {
+		if err := f.useGas(memoryGas(words) - memoryGas(current)); err != nil {
+			return 0, 0, err
+		}
+		f.memory = append(f.memory, make([]byte, (words-current)*32)...)
+	}
+	return o, s, nil
+}
+
+// copyGas charges for copying size bytes
+func (f *frame) copyGas(size uint64) error {
+	return f.useGas(gasCopyWord * toWords(size))
+}
+
+// padded returns size bytes of data from an offset, padded with zeros
+func padded(data []byte, offset *big.Int, size uint64) []byte {
+	out := make([]byte, size)
+	if !offset.IsUint64() || offset.Uint64() >= uint64(len(data)) {
+		return out
+	}
+	copy(out, data[offset.Uint64():])
+	return out
+}
+
+// jumpdests finds every JUMPDEST which is not the data of a PUSH
+func jumpdests(code []byte) map[uint64]bool {
+	dests := make(map[uint64]bool)
+	for pc := 0; pc < len(code); pc++ {
+		op := code[pc]
+		if op == 0x5B {
+			dests[uint64(pc)] = true
+		} else if op >= 0x60 && op <= 0x7F {
+			pc += int(op - 0x5F)
+		}
+	}
+	return dests
+}
+
+func (f *frame) run() ([]byte, error) {
+	f.dests = jumpdests(f.code)
+	for f.pc < uint64(len(f.code)) {
+		op := f.code[f.pc]
+		o, ok := operations[op]
+		if !ok {
+			return nil, ErrInvalidOpcode
+		}
+		if len(f.stack) < o.pops {
+			return nil, ErrStackUnderflow
+		}
+		if len(f.stack)-o.pops+o.pushes > maxStack {
+			return nil, ErrStackOverflow
+		}
+		if err := f.useGas(o.gas); err != nil {
+			return nil, err
+		}
+		output, halt, err := f.execute(op)
+		if err != nil && err != ErrReverted {
+			return nil, err
+		}
+		if halt {
+			return output, err
+		}
+	}
+	// running off the end of the code stops
+	return nil, nil
+}
+
+// execute runs a single instruction, returning its output if it halts
+func (f *frame) execute(op byte) ([]byte, bool, error) {
+	e := f.evm
+	pc := f.pc
+	f.pc++
+	switch {
+	case op >= 0x60 && op <= 0x7F:
+		n := uint64(op - 0x5F)
+		f.push(new(big.Int).SetBytes(padded(f.code, new(big.Int).SetUint64(f.pc), n)))
+		f.pc += n
+		return nil, false, nil
+	case op >= 0x80 && op <= 0x8F:
+		n := int(op - 0x7F)
+		f.push(new(big.Int).Set(f.stack[len(f.stack)-n]))
+		return nil, false, nil
+	case op >= 0x90 && op <= 0x9F:
+		n := int(op - 0x8F)
+		top := len(f.stack) - 1
+		f.stack[top], f.stack[top-n] = f.stack[top-n], f.stack[top]
+		return nil, false, nil
+	case op >= 0xA0 && op <= 0xA4:
+		if f.static {
+			return nil, false, ErrWriteProtection
+		}
+		offset, size := f.pop(), f.pop()
+		o, s, err := f.memoryRange(offset, size)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := f.useGas(gasLogByte * s); err != nil {
+			return nil, false, err
+		}
+		l := Log{Address: f.address, Data: append([]byte{}, f.memory[o:o+s]...)}
+		for i := 0; i < int(op-0xA0); i++ {
+			l.Topics = append(l.Topics, word(f.pop()))
+		}
+		e.logs = append(e.logs, l)
+		return nil, false, nil
+	}
+
+	switch op {
+	case 0x00:
+		return nil, true, nil
+	case 0x01:
+		a, b := f.pop(), f.pop()
+		f.push(new(big.Int).Add(a, b))
+	case 0x02:
+		a, b := f.pop(), f.pop()
+		f.push(new(big.Int).Mul(a, b))
+	case 0x03:
+		a, b := f.pop(), f.pop()
+		f.push(new(big.Int).Sub(a, b))
+	case 0x04:
+		a, b := f.pop(), f.pop()
+		if b.Sign() == 0 {
+			f.push(new(big.Int))
+		} else {
+			f.push(new(big.Int).Div(a, b))
+		}
+	case 0x05:
+		a, b := signed(f.pop()), signed(f.pop())
+		if b.Sign() == 0 {
+			f.push(new(big.Int))
+		} else {
+			f.push(new(big.Int).Quo(a, b))
+		}
+	case 0x06:
+		a, b := f.pop(), f.pop()
+		if b.Sign() == 0 {
+			f.push(new(big.Int))
+		} else {
+			f.push(new(big.Int).Mod(a, b))
+		}
+	case 0x07:
+		a, b := signed(f.pop()), signed(f.pop())
+		if b.Sign() == 0 {
+			f.push(new(big.Int))
+		} else {
+			f.push(new(big.Int).Rem(a, b))
+		}
+	case 0x08, 0x09:
+		a, b, n := f.pop(), f.pop(), f.pop()
+		if n.Sign() == 0 {
+			f.push(new(big.Int))
+		} else if op == 0x08 {
+			f.push(new(big.Int).Mod(new(big.Int).Add(a, b), n))
+		} else {
+			f.push(new(big.Int).Mod(new(big.Int).Mul(a, b), n))
+		}
+	case 0x0A:
+		base, exponent := f.pop(), f.pop()
+		if err := f.useGas(gasExpByte * uint64((exponent.BitLen()+7)/8)); err != nil {
+			return nil, false, err
+		}
+		f.push(new(big.Int).Exp(base, exponent, tt256))
+	case 0x0B:
+		b, x := f.pop(), f.pop()
+		if b.Cmp(big.NewInt(31)) < 0 {
+			bit := uint(b.Uint64()*8 + 7)
+			mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bit+1), big.NewInt(1))
+			if x.Bit(int(bit)) == 1 {
+				x = new(big.Int).Or(x, new(big.Int).Not(mask))
+			} else {
+				x = new(big.Int).And(x, mask)
+			}
+		}
+		f.push(x)
+
+	case 0x10:
+		a, b := f.pop(), f.pop()
+		f.push(boolean(a.Cmp(b) < 0))
+	case 0x11:
+		a, b := f.pop(), f.pop()
+		f.push(boolean(a.Cmp(b) > 0))
+	case 0x12:
+		a, b := signed(f.pop()), signed(f.pop())
+		f.push(boolean(a.Cmp(b) < 0))
+	case 0x13:
+		a, b := signed(f.pop()), signed(f.pop())
+		f.push(boolean(a.Cmp(b) > 0))
+	case 0x14:
+		a, b := f.pop(), f.pop()
+		f.push(boolean(a.Cmp(b) == 0))
+	case 0x15:
+		f.push(boolean(f.pop().Sign() == 0))
+	case 0x16:
+		a, b := f.pop(), f.pop()
+		f.push(new(big.Int).And(a, b))
+	case 0x17:
+		a, b := f.pop(), f.pop()
+		f.push(new(big.Int).Or(a, b))
+	case 0x18:
+		a, b := f.pop(), f.pop()
+		f.push(new(big.Int).Xor(a, b))
+	case 0x19:
+		f.push(new(big.Int).Not(f.pop()))
+	case 0x1A:
+		i, x := f.pop(), f.pop()
+		if i.Cmp(big.NewInt(32)) < 0 {
+			f.push(big.NewInt(int64(word(x)[i.Uint64()])))
+		} else {
+			f.push(new(big.Int))
+		}
+	case 0x1B, 0x1C:
+		shift, value := f.pop(), f.pop()
+		if shift.Cmp(big.NewInt(256)) >= 0 {
+			f.push(new(big.Int))
+		} else if op == 0x1B {
+			f.push(new(big.Int).Lsh(value, uint(shift.Uint64())))
+		} else {
+			f.push(new(big.Int).Rsh(value, uint(shift.Uint64())))
+		}
+	case 0x1D:
+		shift, value := f.pop(), signed(f.pop())
+		if shift.Cmp(big.NewInt(256)) >= 0 {
+			shift = big.NewInt(256)
+		}
+		// shifts of negative values round towards negative infinity
+		f.push(new(big.Int).Rsh(value, uint(shift.Uint64())))
+
+	case 0x20:
+		offset, size := f.pop(), f.pop()
+		o, s, err := f.memoryRange(offset, size)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := f.useGas(gasSha3Word * toWords(s)); err != nil {
+			return nil, false, err
+		}
+		f.push(new(big.Int).SetBytes(keccak(f.memory[o : o+s])))
+
+	case 0x30:
+		f.push(new(big.Int).SetBytes(f.address[:]))
+	case 0x31:
+		f.push(e.Balance(BytesToAddress(word(f.pop()))))
+	case 0x32:
+		f.push(new(big.Int).SetBytes(f.tx.origin[:]))
+	case 0x33:
+		f.push(new(big.Int).SetBytes(f.caller[:]))
+	case 0x34:
+		f.push(new(big.Int).Set(f.value))
+	case 0x35:
+		f.push(new(big.Int).SetBytes(padded(f.input, f.pop(), 32)))
+	case 0x36:
+		f.push(big.NewInt(int64(len(f.input))))
+	case 0x37, 0x39:
+		memOffset, dataOffset, size := f.pop(), f.pop(), f.pop()
+		o, s, err := f.memoryRange(memOffset, size)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := f.copyGas(s); err != nil {
+			return nil, false, err
+		}
+		data := f.input
+		if op == 0x39 {
+			data = f.code
+		}
+		copy(f.memory[o:o+s], padded(data, dataOffset, s))
+	case 0x38:
+		f.push(big.NewInt(int64(len(f.code))))
+	case 0x3A:
+		f.push(new(big.Int).Set(e.GasPrice))
+	case 0x3B:
+		f.push(big.NewInt(int64(len(e.Code(BytesToAddress(word(f.pop())))))))
+	case 0x3C:
+		a, memOffset, codeOffset, size := f.pop(), f.pop(), f.pop(), f.pop()
+		o, s, err := f.memoryRange(memOffset, size)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := f.copyGas(s); err != nil {
+			return nil, false, err
+		}
+		copy(f.memory[o:o+s], padded(e.Code(BytesToAddress(word(a))), codeOffset, s))
+	case 0x3D:
+		f.push(big.NewInt(int64(len(f.returnData))))
+	case 0x3E:
+		memOffset, dataOffset, size := f.pop(), f.pop(), f.pop()
+		end := new(big.Int).Add(dataOffset, size)
+		if !end.IsUint64() || end.Uint64() > uint64(len(f.returnData)) {
+			return nil, false, ErrReturnDataOutOfBounds
+		}
+		o, s, err := f.memoryRange(memOffset, size)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := f.copyGas(s); err != nil {
+			return nil, false, err
+		}
+		copy(f.memory[o:o+s], f.returnData[dataOffset.Uint64():])
+	case 0x3F:
+		a := BytesToAddress(word(f.pop()))
+		if !e.exists(a) {
+			f.push(new(big.Int))
+		} else {
+			f.push(new(big.Int).SetBytes(keccak(e.Code(a))))
+		}
+
+	case 0x40:
+		// no previous blocks are held
+		f.pop()
+		f.push(new(big.Int))
+	case 0x41:
+		f.push(new(big.Int).SetBytes(e.Block.Coinbase[:]))
+	case 0x42:
+		f.push(new(big.Int).Set(e.Block.Timestamp))
+	case 0x43:
+		f.push(new(big.Int).Set(e.Block.Number))
+	case 0x44:
+		f.push(new(big.Int).Set(e.Block.Difficulty))
+	case 0x45:
+		f.push(new(big.Int).SetUint64(e.Block.GasLimit))
+	case 0x46:
+		f.push(new(big.Int).Set(e.Block.ChainID))
+	case 0x47:
+		f.push(e.Balance(f.address))
+
+	case 0x50:
+		f.pop()
+	case 0x51:
+		offset := f.pop()
+		o, _, err := f.memoryRange(offset, big.NewInt(32))
+		if err != nil {
+			return nil, false, err
+		}
+		f.push(new(big.Int).SetBytes(f.memory[o : o+32]))
+	case 0x52:
+		offset, value := f.pop(), f.pop()
+		o, _, err := f.memoryRange(offset, big.NewInt(32))
+		if err != nil {
+			return nil, false, err
+		}
+		copy(f.memory[o:o+32], word(value))
+	case 0x53:
+		offset, value := f.pop(), f.pop()
+		o, _, err := f.memoryRange(offset, big.NewInt(1))
+		if err != nil {
+			return nil, false, err
+		}
+		f.memory[o] = word(value)[31]
+	case 0x54:
+		f.push(e.Storage(f.address, f.pop()))
+	case 0x55:
+		if f.static {
+			return nil, false, ErrWriteProtection
+		}
+		slot, value := f.pop(), f.pop()
+		gas := uint64(gasSStoreReset)
+		if e.Storage(f.address, slot).Sign() == 0 && value.Sign() != 0 {
+			gas = gasSStoreSet
+		}
+		if err := f.useGas(gas); err != nil {
+			return nil, false, err
+		}
+		e.setStorage(f.address, slot, value)
+	case 0x56:
+		return nil, false, f.jump(f.pop())
+	case 0x57:
+		dest, cond := f.pop(), f.pop()
+		if cond.Sign() != 0 {
+			return nil, false, f.jump(dest)
+		}
+	case 0x58:
+		f.push(new(big.Int).SetUint64(pc))
+	case 0x59:
+		f.push(big.NewInt(int64(len(f.memory))))
+	case 0x5A:
+		f.push(new(big.Int).SetUint64(f.gas))
+	case 0x5B:
+
+	case 0xF0, 0xF5:
+		return nil, false, f.create(op == 0xF5)
+	case 0xF1, 0xF2, 0xF4, 0xFA:
+		return nil, false, f.call(op)
+	case 0xF3, 0xFD:
+		offset, size := f.pop(), f.pop()
+		o, s, err := f.memoryRange(offset, size)
+		if err != nil {
+			return nil, false, err
+		}
+		output := append([]byte{}, f.memory[o:o+s]...)
+		if op == 0xFD {
+			return output, true, ErrReverted
+		}
+		return output, true, nil
+	case 0xFF:
+		if f.static {
+			return nil, false, ErrWriteProtection
+		}
+		beneficiary := BytesToAddress(word(f.pop()))
+		if err := e.transfer(f.address, beneficiary, e.Balance(f.address)); err != nil {
+			return nil, false, err
+		}
+		delete(e.accounts, f.address)
+		return nil, true, nil
+	}
+	return nil, false, nil
+}
+
+func (f *frame) jump(dest *big.Int) error {
+	if !dest.IsUint64() || !f.dests[dest.Uint64()] {
+		return ErrInvalidJump
+	}
+	f.pc = dest.Uint64()
+	return nil
+}
+
+// callGas is the gas passed to a call: all but one 64th of the remaining gas,
+// or less if requested
+func (f *frame) callGas(requested *big.Int) uint64 {
+	available := f.gas - f.gas/64
+	if requested.IsUint64() && requested.Uint64() < available {
+		return requested.Uint64()
+	}
+	return available
+}
+
+func (f *frame) create(salted bool) error {
+	e := f.evm
+	if f.static {
+		return ErrWriteProtection
+	}
+	value, offset, size := f.pop(), f.pop(), f.pop()
+	var salt *big.Int
+	if salted {
+		salt = f.pop()
+	}
+	o, s, err := f.memoryRange(offset, size)
+	if err != nil {
+		return err
+	}
+	code := append([]byte{}, f.memory[o:o+s]...)
+	if salted {
+		if err := f.useGas(gasSha3Word * toWords(s)); err != nil {
+			return err
+		}
+	}
+	gas := f.gas - f.gas/64
+	f.gas -= gas
+	f.returnData = nil
+	if f.depth+1 > maxCallDepth || e.Balance(f.address).Cmp(value) < 0 {
+		f.gas += gas
+		f.push(new(big.Int))
+		return nil
+	}
+	creator := e.account(f.address)
+	address := createAddress(f.address, creator.Nonce)
+	if salted {
+		address = create2Address(f.address, salt, code)
+	}
+	creator.Nonce++
+	output, remaining, err := e.create(f.tx, f.address, address, code, value, gas, f.depth+1)
+	f.gas += remaining
+	if err != nil {
+		if err == ErrReverted {
+			f.returnData = output
+		}
+		f.push(new(big.Int))
+		return nil
+	}
+	f.push(new(big.Int).SetBytes(address[:]))
+	return nil
+}
+
+func (f *frame) call(op byte) error {
+	e := f.evm
+	requested, target := f.pop(), BytesToAddress(word(f.pop()))
+	value := new(big.Int)
+	if op == 0xF1 || op == 0xF2 {
+		value = f.pop()
+	} else if op == 0xF4 {
+		value = f.value
+	}
+	inOffset, inSize, outOffset, outSize := f.pop(), f.pop(), f.pop(), f.pop()
+	transfers := (op == 0xF1 || op == 0xF2) && value.Sign() != 0
+	if op == 0xF1 && transfers && f.static {
+		return ErrWriteProtection
+	}
+	in, inLength, err := f.memoryRange(inOffset, inSize)
+	if err != nil {
+		return err
+	}
+	out, outLength, err := f.memoryRange(outOffset, outSize)
+	if err != nil {
+		return err
+	}
+	if transfers {
+		extra := uint64(gasCallValue)
+		if op == 0xF1 && !e.exists(target) {
+			extra += gasCallNewAccount
+		}
+		if err := f.useGas(extra); err != nil {
+			return err
+		}
+	}
+	gas := f.callGas(requested)
+	f.gas -= gas
+	if transfers {
+		gas += gasCallStipend
+	}
+	f.returnData = nil
+	if f.depth+1 > maxCallDepth || (transfers && e.Balance(f.address).Cmp(value) < 0) {
+		f.gas += gas
+		f.push(new(big.Int))
+		return nil
+	}
+	input := append([]byte{}, f.memory[in:in+inLength]...)
+	var output []byte
+	var remaining uint64
+	switch op {
+	case 0xF1:
+		output, remaining, err = e.call(f.tx, f.address, target, target, input, value, gas, f.depth+1, f.static, true)
+	case 0xF2:
+		output, remaining, err = e.call(f.tx, f.address, f.address, target, input, value, gas, f.depth+1, f.static, true)
+	case 0xF4:
+		output, remaining, err = e.call(f.tx, f.caller, f.address, target, input, value, gas, f.depth+1, f.static, false)
+	case 0xFA:
+		output, remaining, err = e.call(f.tx, f.address, target, target, input, value, gas, f.depth+1, true, false)
+	}
+	f.gas += remaining
+	if err == nil || err == ErrReverted {
+		f.returnData = output
+		n := outLength
+		if uint64(len(output)) < n {
+			n = uint64(len(output))
+		}
+		copy(f.memory[out:out+n], output)
+	}
+	f.push(boolean(err == nil))
+	return nil
+}
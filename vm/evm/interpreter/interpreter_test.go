@@ -0,0 +1,177 @@
+package interpreter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/end-r/goutil"
+)
+
+var sender = BytesToAddress([]byte{0xaa})
+
+func decode(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	goutil.AssertNow(t, err == nil, "invalid hex")
+	return b
+}
+
+// creation returns creation code which deploys runtime code
+func creation(runtime []byte) []byte {
+	// PUSH1 size DUP1 PUSH1 12 PUSH1 0 CODECOPY PUSH1 0 RETURN
+	code := []byte{0x60, byte(len(runtime)), 0x80, 0x60, 0x0c, 0x60, 0x00, 0x39, 0x60, 0x00, 0xf3, 0x00}
+	return append(code, runtime...)
+}
+
+// run deploys runtime code and calls it
+func run(t *testing.T, runtime string, input []byte) (*EVM, Address, Result) {
+	e := New()
+	a, r := e.Deploy(sender, creation(decode(t, runtime)), nil)
+	goutil.AssertNow(t, r.Err == nil, "deployment failed")
+	goutil.AssertNow(t, bytes.Equal(e.Code(a), decode(t, runtime)), "wrong code deployed")
+	return e, a, e.Call(sender, a, input, nil)
+}
+
+func TestCreateAddress(t *testing.T) {
+	creator := BytesToAddress(decode(t, "6ac7ea33f8831ea9dcc53393aaa88b25a785dbf0"))
+	goutil.Assert(t, createAddress(creator, 0).String() == "0xcd234a471b72ba2f1ccf0a70fcaba648a5eecd8d", createAddress(creator, 0).String())
+	goutil.Assert(t, createAddress(creator, 1).String() == "0x343c43a37d37dff08ae8c4a11544c718abb4fcf8", createAddress(creator, 1).String())
+}
+
+func TestCreate2Address(t *testing.T) {
+	a := create2Address(Address{}, new(big.Int), []byte{0x00})
+	goutil.Assert(t, a.String() == "0x4d1a2e2bb4f88f0250f26ffff098b0b30b26bf38", a.String())
+}
+
+func TestReturnValue(t *testing.T) {
+	// 2 - 7 = -5, returned as a word
+	_, _, r := run(t, "6007600203600052602060"+"00f3", nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	expected := new(big.Int).Sub(tt256, big.NewInt(5))
+	goutil.Assert(t, new(big.Int).SetBytes(r.Output).Cmp(expected) == 0, "wrong result")
+}
+
+func TestSignedArithmetic(t *testing.T) {
+	// -7 SDIV 3 = -2, -7 SAR 1 = -4, SIGNEXTEND(0, 0xff) = -1
+	_, _, r := run(t, "6003600760000305600052"+"600760000360011d602052"+"60ff60000b604052"+"60606000f3", nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.AssertNow(t, len(r.Output) == 96, "wrong output size")
+	goutil.Assert(t, signed(new(big.Int).SetBytes(r.Output[:32])).Int64() == -2, "wrong SDIV")
+	goutil.Assert(t, signed(new(big.Int).SetBytes(r.Output[32:64])).Int64() == -4, "wrong SAR")
+	goutil.Assert(t, signed(new(big.Int).SetBytes(r.Output[64:])).Int64() == -1, "wrong SIGNEXTEND")
+}
+
+func TestStorage(t *testing.T) {
+	// store calldata word 0 at slot 1
+	e, a, r := run(t, "600035600155", append(make([]byte, 31), 9))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, e.Storage(a, big.NewInt(1)).Int64() == 9, "wrong stored value")
+	goutil.Assert(t, r.GasUsed == 3+3+3+20000, "wrong gas")
+}
+
+func TestRevertRestoresState(t *testing.T) {
+	// store 1 at slot 0, then revert with one byte of data
+	e, a, r := run(t, "600160005560ff60005360016000fd", nil)
+	goutil.AssertNow(t, r.Reverted(), "should revert")
+	goutil.Assert(t, bytes.Equal(r.Output, []byte{0xff}), "wrong revert data")
+	goutil.Assert(t, e.Storage(a, big.NewInt(0)).Sign() == 0, "storage should be restored")
+}
+
+func TestLogs(t *testing.T) {
+	// LOG2 with topics 1 and 2 and one byte of data
+	_, a, r := run(t, "602a6000536002600160016000a2", nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.AssertNow(t, len(r.Logs) == 1, "wrong log count")
+	l := r.Logs[0]
+	goutil.Assert(t, l.Address == a, "wrong address")
+	goutil.AssertNow(t, len(l.Topics) == 2, "wrong topic count")
+	goutil.Assert(t, l.Topics[0][31] == 1 && l.Topics[1][31] == 2, "wrong topics")
+	goutil.Assert(t, bytes.Equal(l.Data, []byte{0x2a}), "wrong data")
+}
+
+func TestInvalidJump(t *testing.T) {
+	_, _, r := run(t, "600356", nil)
+	goutil.Assert(t, r.Err == ErrInvalidJump, "should fail to jump")
+	goutil.Assert(t, r.GasUsed == DefaultGasLimit, "failures consume all gas")
+}
+
+func TestJumpIntoPushData(t *testing.T) {
+	// the JUMPDEST at 3 is the data of a PUSH
+	_, _, r := run(t, "60035660"+"5b", nil)
+	goutil.Assert(t, r.Err == ErrInvalidJump, "should not jump into push data")
+}
+
+func TestInvalidOpcode(t *testing.T) {
+	_, _, r := run(t, "fe", nil)
+	goutil.Assert(t, r.Err == ErrInvalidOpcode, "should be invalid")
+}
+
+func TestStackUnderflow(t *testing.T) {
+	_, _, r := run(t, "01", nil)
+	goutil.Assert(t, r.Err == ErrStackUnderflow, "should underflow")
+}
+
+func TestOutOfGas(t *testing.T) {
+	e := New()
+	a, r := e.Deploy(sender, creation(decode(t, "600160005500")), nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	e.GasLimit = 1000
+	r = e.Call(sender, a, nil, nil)
+	goutil.Assert(t, r.Err == ErrOutOfGas, "should run out of gas")
+}
+
+func TestStaticCallWriteProtection(t *testing.T) {
+	e := New()
+	a, r := e.Deploy(sender, creation(decode(t, "600160005500")), nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = e.StaticCall(sender, a, nil)
+	goutil.Assert(t, r.Err == ErrWriteProtection, "static calls should not write")
+}
+
+func TestContractCalls(t *testing.T) {
+	e := New()
+	// the callee returns 42 and the caller's address
+	callee, r := e.Deploy(sender, creation(decode(t, "602a6000523360205260406000f3")), nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	// CALL(gas, callee, 0, 0, 0, 0, 64) then return the output
+	caller := "60406000600060006000" + "73" + hex.EncodeToString(callee[:]) + "5a" + "f1" + "50" + "60406000f3"
+	a, r := e.Deploy(sender, creation(decode(t, caller)), nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = e.Call(sender, a, nil, nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.AssertNow(t, len(r.Output) == 64, "wrong output size")
+	goutil.Assert(t, r.Output[31] == 42, "wrong result")
+	goutil.Assert(t, BytesToAddress(r.Output[32:]) == a, "the caller should be the calling contract")
+}
+
+func TestCreateFromContract(t *testing.T) {
+	e := New()
+	// copy creation code for the runtime STOP into memory, then CREATE it
+	inner := creation([]byte{0x00})
+	code := "60" + hex.EncodeToString([]byte{byte(len(inner))}) + "80" + "6015" + "6000" + "39" +
+		"60006000f0" + "600052" + "60206000f3"
+	code += hex.EncodeToString(inner)
+	a, r := e.Deploy(sender, creation(decode(t, code)), nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = e.Call(sender, a, nil, nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	created := BytesToAddress(r.Output)
+	goutil.Assert(t, created == createAddress(a, 1), "wrong created address")
+	goutil.Assert(t, bytes.Equal(e.Code(created), []byte{0x00}), "wrong created code")
+}
+
+func TestValueTransfer(t *testing.T) {
+	e := New()
+	e.SetBalance(sender, big.NewInt(100))
+	// return CALLVALUE and SELFBALANCE
+	a, r := e.Deploy(sender, creation(decode(t, "34600052476020526040"+"6000f3")), nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = e.Call(sender, a, nil, big.NewInt(30))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, r.Output[31] == 30 && r.Output[63] == 30, "wrong value")
+	goutil.Assert(t, e.Balance(sender).Int64() == 70, "wrong sender balance")
+	r = e.Call(sender, a, nil, big.NewInt(1000))
+	goutil.Assert(t, r.Err == ErrInsufficientBalance, "should not transfer more than the balance")
+}
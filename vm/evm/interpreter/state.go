@@ -0,0 +1,401 @@
+// Package interpreter runs EVM bytecode in memory, so that compiled contracts
+// can be deployed and called without a network or an external node
+package interpreter
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Address identifies an account
+type Address [20]byte
+
+func (a Address) String() string {
+	return "0x" + hex.EncodeToString(a[:])
+}
+
+// BytesToAddress returns the address held by the rightmost 20 bytes of data
+func BytesToAddress(data []byte) Address {
+	var a Address
+	if len(data) > len(a) {
+		data = data[len(data)-len(a):]
+	}
+	copy(a[len(a)-len(data):], data)
+	return a
+}
+
+// Log is an event emitted by a contract
+type Log struct {
+	Address Address
+	// each topic is a 32 byte word
+	Topics [][]byte
+	Data   []byte
+}
+
+// Account is the state held at an address
+type Account struct {
+	Nonce   uint64
+	Balance *big.Int
+	Code    []byte
+	Storage map[string]*big.Int
+}
+
+func (a *Account) copy() *Account {
+	c := &Account{
+		Nonce:   a.Nonce,
+		Balance: new(big.Int).Set(a.Balance),
+		Code:    a.Code,
+		Storage: make(map[string]*big.Int),
+	}
+	for k, v := range a.Storage {
+		c.Storage[k] = v
+	}
+	return c
+}
+
+// Block describes the block in which every transaction is run
+type Block struct {
+	Number     *big.Int
+	Timestamp  *big.Int
+	Coinbase   Address
+	Difficulty *big.Int
+	GasLimit   uint64
+	ChainID    *big.Int
+}
+
+// Result describes the outcome of a transaction or call
+type Result struct {
+	// the returned data, or the revert data if the execution reverted
+	Output []byte
+	// the gas used by execution, excluding the intrinsic gas of the
+	// transaction
+	GasUsed uint64
+	// the logs emitted by every contract, if the execution succeeded
+	Logs []Log
+	Err  error
+}
+
+// Reverted reports whether the execution ended with REVERT
+func (r Result) Reverted() bool {
+	return r.Err == ErrReverted
+}
+
+// errors which end execution
+var (
+	ErrReverted              = errors.New("execution reverted")
+	ErrOutOfGas              = errors.New("out of gas")
+	ErrStackUnderflow        = errors.New("stack underflow")
+	ErrStackOverflow         = errors.New("stack overflow")
+	ErrInvalidJump           = errors.New("invalid jump destination")
+	ErrInvalidOpcode         = errors.New("invalid opcode")
+	ErrWriteProtection       = errors.New("state modification during a static call")
+	ErrDepth                 = errors.New("max call depth exceeded")
+	ErrInsufficientBalance   = errors.New("insufficient balance for transfer")
+	ErrReturnDataOutOfBounds = errors.New("return data out of bounds")
+	ErrAddressCollision      = errors.New("contract address collision")
+)
+
+const (
+	// DefaultGasLimit is the gas given to each transaction by default
+	DefaultGasLimit = 10000000
+	maxCallDepth    = 1024
+)
+
+// EVM holds the state of an in-memory chain, against which transactions are
+// run one at a time
+type EVM struct {
+	Block    Block
+	GasPrice *big.Int
+	// the gas given to each transaction
+	GasLimit uint64
+	accounts map[Address]*Account
+	logs     []Log
+}
+
+// New creates an empty chain
+func New() *EVM {
+	return &EVM{
+		Block: Block{
+			Number:     big.NewInt(1),
+			Timestamp:  big.NewInt(0),
+			Difficulty: big.NewInt(0),
+			GasLimit:   DefaultGasLimit,
+			ChainID:    big.NewInt(1),
+		},
+		GasPrice: big.NewInt(0),
+		GasLimit: DefaultGasLimit,
+		accounts: make(map[Address]*Account),
+	}
+}
+
+func (e *EVM) account(a Address) *Account {
+	acc, ok := e.accounts[a]
+	if !ok {
+		acc = &Account{
+			Balance: new(big.Int),
+			Storage: make(map[string]*big.Int),
+		}
+		e.accounts[a] = acc
+	}
+	return acc
+}
+
+func (e *EVM) exists(a Address) bool {
+	_, ok := e.accounts[a]
+	return ok
+}
+
+// Balance returns the balance of an account
+func (e *EVM) Balance(a Address) *big.Int {
+	if acc, ok := e.accounts[a]; ok {
+		return new(big.Int).Set(acc.Balance)
+	}
+	return new(big.Int)
+}
+
+// SetBalance sets the balance of an account
+func (e *EVM) SetBalance(a Address, balance *big.Int) {
+	e.account(a).Balance = new(big.Int).Set(balance)
+}
+
+// Code returns the code of an account
+func (e *EVM) Code(a Address) []byte {
+	if acc, ok := e.accounts[a]; ok {
+		return acc.Code
+	}
+	return nil
+}
+
+// Storage returns the word held at a storage slot of an account
+func (e *EVM) Storage(a Address, slot *big.Int) *big.Int {
+	if acc, ok := e.accounts[a]; ok {
+		if v, ok := acc.Storage[string(word(slot))]; ok {
+			return new(big.Int).Set(v)
+		}
+	}
+	return new(big.Int)
+}
+
+func (e *EVM) setStorage(a Address, slot, value *big.Int) {
+	acc := e.account(a)
+	if value.Sign() == 0 {
+		delete(acc.Storage, string(word(slot)))
+		return
+	}
+	acc.Storage[string(word(slot))] = new(big.Int).Set(value)
+}
+
+// snapshots are copies of the state, which are restored if a call fails
+type snapshot struct {
+	accounts map[Address]*Account
+	logs     int
+}
+
+func (e *EVM) snapshot() snapshot {
+	s := snapshot{
+		accounts: make(map[Address]*Account),
+		logs:     len(e.logs),
+	}
+	for a, acc := range e.accounts {
+		s.accounts[a] = acc.copy()
+	}
+	return s
+}
+
+func (e *EVM) restore(s snapshot) {
+	e.accounts = s.accounts
+	e.logs = e.logs[:s.logs]
+}
+
+func (e *EVM) transfer(from, to Address, value *big.Int) error {
+	if value.Sign() == 0 {
+		e.account(to)
+		return nil
+	}
+	f := e.account(from)
+	if f.Balance.Cmp(value) < 0 {
+		return ErrInsufficientBalance
+	}
+	f.Balance.Sub(f.Balance, value)
+	t := e.account(to)
+	t.Balance.Add(t.Balance, value)
+	return nil
+}
+
+// Deploy runs creation code sent by an account, which should be followed by
+// any ABI-encoded constructor arguments, and creates a contract holding the
+// code which it returns
+func (e *EVM) Deploy(from Address, code []byte, value *big.Int) (Address, Result) {
+	e.logs = nil
+	sender := e.account(from)
+	address := createAddress(from, sender.Nonce)
+	sender.Nonce++
+	output, gas, err := e.create(&transaction{origin: from}, from, address, code, orZero(value), e.GasLimit, 0)
+	return address, e.result(output, e.GasLimit-gas, err)
+}
+
+// Call sends a transaction from an account to an address
+func (e *EVM) Call(from, to Address, input []byte, value *big.Int) Result {
+	e.logs = nil
+	e.account(from).Nonce++
+	output, gas, err := e.call(&transaction{origin: from}, from, to, to, input, orZero(value), e.GasLimit, 0, false, true)
+	return e.result(output, e.GasLimit-gas, err)
+}
+
+// StaticCall runs a call which may not modify the state, such as a view of
+// a contract's fields
+func (e *EVM) StaticCall(from, to Address, input []byte) Result {
+	e.logs = nil
+	output, gas, err := e.call(&transaction{origin: from}, from, to, to, input, new(big.Int), e.GasLimit, 0, true, false)
+	return e.result(output, e.GasLimit-gas, err)
+}
+
+func (e *EVM) result(output []byte, used uint64, err error) Result {
+	r := Result{Output: output, GasUsed: used, Err: err}
+	if err == nil {
+		r.Logs = e.logs
+	}
+	e.logs = nil
+	return r
+}
+
+func orZero(value *big.Int) *big.Int {
+	if value == nil {
+		return new(big.Int)
+	}
+	return value
+}
+
+// transaction holds the context shared by every call in a transaction
+type transaction struct {
+	origin Address
+}
+
+// call runs the code of an account with the storage of another: they are
+// the same account except for DELEGATECALL and CALLCODE
+// it returns the output of the call and its remaining gas
+func (e *EVM) call(tx *transaction, caller, storage, code Address, input []byte, value *big.Int, gas uint64, depth int, static, transfer bool) ([]byte, uint64, error) {
+	if depth > maxCallDepth {
+		return nil, gas, ErrDepth
+	}
+	s := e.snapshot()
+	if transfer {
+		if err := e.transfer(caller, storage, value); err != nil {
+			return nil, gas, err
+		}
+	}
+	f := &frame{
+		evm:     e,
+		tx:      tx,
+		address: storage,
+		caller:  caller,
+		value:   value,
+		input:   input,
+		code:    e.Code(code),
+		gas:     gas,
+		depth:   depth,
+		static:  static,
+	}
+	output, err := f.run()
+	if err != nil {
+		e.restore(s)
+		if err != ErrReverted {
+			// every error but a revert consumes all gas
+			f.gas = 0
+		}
+	}
+	return output, f.gas, err
+}
+
+// create runs creation code and stores the code it returns at an address
+func (e *EVM) create(tx *transaction, caller, address Address, code []byte, value *big.Int, gas uint64, depth int) ([]byte, uint64, error) {
+	if depth > maxCallDepth {
+		return nil, gas, ErrDepth
+	}
+	if acc, ok := e.accounts[address]; ok && (acc.Nonce != 0 || len(acc.Code) != 0) {
+		return nil, 0, ErrAddressCollision
+	}
+	s := e.snapshot()
+	if err := e.transfer(caller, address, value); err != nil {
+		return nil, gas, err
+	}
+	e.account(address).Nonce = 1
+	f := &frame{
+		evm:     e,
+		tx:      tx,
+		address: address,
+		caller:  caller,
+		value:   value,
+		code:    code,
+		gas:     gas,
+		depth:   depth,
+	}
+	output, err := f.run()
+	if err == nil {
+		// the returned code is paid for by the creation
+		err = f.useGas(gasCodeDeposit * uint64(len(output)))
+	}
+	if err != nil {
+		e.restore(s)
+		if err != ErrReverted {
+			f.gas = 0
+		}
+		return output, f.gas, err
+	}
+	e.account(address).Code = output
+	return nil, f.gas, nil
+}
+
+func keccak(data ...[]byte) []byte {
+	hasher := sha3.NewLegacyKeccak256()
+	for _, d := range data {
+		hasher.Write(d)
+	}
+	return hasher.Sum(nil)
+}
+
+// createAddress is the address of a contract created by an account with a
+// nonce: the hash of the RLP encoding of the account and nonce
+func createAddress(creator Address, nonce uint64) Address {
+	return BytesToAddress(keccak(rlpList(rlpBytes(creator[:]), rlpBytes(trimmed(nonce)))))
+}
+
+// create2Address is the address of a contract created with CREATE2
+func create2Address(creator Address, salt *big.Int, code []byte) Address {
+	return BytesToAddress(keccak([]byte{0xff}, creator[:], word(salt), keccak(code)))
+}
+
+// trimmed returns the big-endian encoding of n without leading zeros
+func trimmed(n uint64) []byte {
+	var bs []byte
+	for ; n > 0; n >>= 8 {
+		bs = append([]byte{byte(n)}, bs...)
+	}
+	return bs
+}
+
+func rlpBytes(data []byte) []byte {
+	if len(data) == 1 && data[0] < 0x80 {
+		return data
+	}
+	return append(rlpLength(0x80, len(data)), data...)
+}
+
+func rlpList(items ...[]byte) []byte {
+	var payload []byte
+	for _, i := range items {
+		payload = append(payload, i...)
+	}
+	return append(rlpLength(0xc0, len(payload)), payload...)
+}
+
+func rlpLength(offset byte, length int) []byte {
+	if length < 56 {
+		return []byte{offset + byte(length)}
+	}
+	l := trimmed(uint64(length))
+	return append([]byte{offset + 55 + byte(len(l))}, l...)
+}
@@ -0,0 +1,270 @@
+package evm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/typing"
+)
+
+// contract fields are laid out in storage in declaration order, beginning
+// with the fields of each super contract
+// fields are packed into the low-order bytes of a slot until the next field
+// no longer fits, while mappings, dynamic values, arrays and classes always
+// begin a new slot, and the field after them does too
+
+// the storage encodings of fields
+const (
+	inplaceEncoding      = "inplace"
+	bytesEncoding        = "bytes"
+	mappingEncoding      = "mapping"
+	dynamicArrayEncoding = "dynamic_array"
+)
+
+// StorageField describes where a contract field (or a class member) is held
+type StorageField struct {
+	Contract string         `json:"contract,omitempty"`
+	Label    string         `json:"label"`
+	Type     string         `json:"type"`
+	Slot     uint           `json:"slot"`
+	Offset   uint           `json:"offset"`
+	Size     uint           `json:"size"`
+	Encoding string         `json:"encoding"`
+	Members  []StorageField `json:"members,omitempty"`
+	// whole fields occupy whole slots
+	whole bool
+	// the keys and values of mappings, and the elements of arrays
+	key, value, elem *StorageField
+}
+
+// StorageLayout describes the storage of a contract: offsets and sizes are
+// in bytes, with offsets counted from the low-order end of the slot
+type StorageLayout struct {
+	Contract string         `json:"contract"`
+	Slots    uint           `json:"slots"`
+	Storage  []StorageField `json:"storage"`
+}
+
+// StorageLayout returns the JSON storage layout of a validated contract
+func (evm GuardianEVM) StorageLayout(n *ast.ContractDeclarationNode) ([]byte, error) {
+	return json.Marshal(evm.ContractStorageLayout(n))
+}
+
+// ContractStorageLayout lays out the fields of a validated contract
+func (evm GuardianEVM) ContractStorageLayout(n *ast.ContractDeclarationNode) StorageLayout {
+	e := &evm
+	var c storageCursor
+	fields := make([]StorageField, 0)
+	for _, contract := range linearise(n, nil) {
+		for _, f := range e.storageFields(contract.Body) {
+			f.Contract = contract.Identifier
+			c.place(&f)
+			fields = append(fields, f)
+		}
+	}
+	return StorageLayout{
+		Contract: n.Identifier,
+		Slots:    c.slots(),
+		Storage:  fields,
+	}
+}
+
+// linearise orders a contract after all of its supers, each of which is
+// included only once
+func linearise(n *ast.ContractDeclarationNode, seen map[string]bool) []*ast.ContractDeclarationNode {
+	if seen == nil {
+		seen = make(map[string]bool)
+	}
+	if seen[n.Identifier] {
+		return nil
+	}
+	seen[n.Identifier] = true
+	var order []*ast.ContractDeclarationNode
+	for _, s := range n.Supers {
+		if super, ok := lookupDeclaration(n.Body, s.Names).(*ast.ContractDeclarationNode); ok {
+			order = append(order, linearise(super, seen)...)
+		}
+	}
+	return append(order, n)
+}
+
+// lookupDeclaration finds a declaration in the scopes enclosing a scope
+func lookupDeclaration(scope *ast.ScopeNode, names []string) ast.Node {
+	if len(names) == 0 {
+		return nil
+	}
+	for s := scope; s != nil; s = s.Parent {
+		if d := s.GetDeclaration(names[len(names)-1]); d != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+// storageFields describes each variable declared directly in a scope, without
+// placing them
+func (e *GuardianEVM) storageFields(scope *ast.ScopeNode) []StorageField {
+	var fields []StorageField
+	if scope == nil || scope.Declarations == nil {
+		return fields
+	}
+	// declarations of several variables are added once for each of them
+	seen := make(map[*ast.ExplicitVarDeclarationNode]bool)
+	for _, d := range scope.Declarations.Array() {
+		v, ok := d.(*ast.ExplicitVarDeclarationNode)
+		if !ok || v.IsConstant || seen[v] {
+			continue
+		}
+		seen[v] = true
+		for _, id := range v.Identifiers {
+			f := e.storageType(scope, v.DeclaredType, v.Resolved)
+			f.Label = id
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// storageType describes the type and size of a field
+func (e *GuardianEVM) storageType(scope *ast.ScopeNode, declared ast.Node, resolved typing.Type) StorageField {
+	if m, ok := declared.(*ast.MapTypeNode); ok {
+		var key, value typing.Type = typing.Unknown(), typing.Unknown()
+		if r, ok := typing.ResolveUnderlying(resolved).(*typing.Map); ok {
+			key, value = r.Key, r.Value
+		}
+		k := e.storageType(scope, m.Key, key)
+		v := e.storageType(scope, m.Value, value)
+		return StorageField{
+			Type:     "mapping(" + k.Type + " => " + v.Type + ")",
+			Size:     wordBytes,
+			Encoding: mappingEncoding,
+			whole:    true,
+			key:      &k,
+			value:    &v,
+		}
+	}
+	if class, ok := typing.ResolveUnderlying(resolved).(*typing.Class); ok {
+		f := StorageField{
+			Type:     class.Name,
+			Encoding: inplaceEncoding,
+			whole:    true,
+		}
+		if p, ok := declared.(*ast.PlainTypeNode); ok {
+			if decl, ok := lookupDeclaration(scope, p.Names).(*ast.ClassDeclarationNode); ok {
+				var c storageCursor
+				for _, m := range e.storageFields(decl.Body) {
+					c.place(&m)
+					f.Members = append(f.Members, m)
+				}
+				f.Size = c.slots() * wordBytes
+			}
+		}
+		return f
+	}
+	if a, ok := declared.(*ast.ArrayTypeNode); ok {
+		abi := e.abiName(declared, resolved)
+		if abi == "bytes" || !a.Variable && parseABIType(abi).kind == abiWord {
+			// byte arrays are laid out as their ABI types
+			return abiStorageType(abi)
+		}
+		var value typing.Type = typing.Unknown()
+		if r, ok := typing.ResolveUnderlying(resolved).(*typing.Array); ok {
+			value = r.Value
+		}
+		elem := e.storageType(scope, a.Value, value)
+		if a.Variable {
+			return StorageField{
+				Type:     elem.Type + "[]",
+				Size:     wordBytes,
+				Encoding: dynamicArrayEncoding,
+				whole:    true,
+				elem:     &elem,
+			}
+		}
+		return StorageField{
+			Type:     fmt.Sprintf("%s[%d]", elem.Type, a.Length),
+			Size:     arraySlots(elem, uint(a.Length)) * wordBytes,
+			Encoding: inplaceEncoding,
+			whole:    true,
+			elem:     &elem,
+		}
+	}
+	return abiStorageType(e.abiName(declared, resolved))
+}
+
+func abiStorageType(abi string) StorageField {
+	t := parseABIType(abi)
+	switch t.kind {
+	case abiBytes:
+		return StorageField{Type: abi, Size: wordBytes, Encoding: bytesEncoding, whole: true}
+	case abiWord:
+		size := (t.bits + 7) / 8
+		return StorageField{Type: abi, Size: size, Encoding: inplaceEncoding}
+	}
+	return StorageField{Type: abi, Size: wordBytes, Encoding: inplaceEncoding, whole: true}
+}
+
+// elements of fixed arrays are packed as consecutive fields
+func arraySlots(elem StorageField, length uint) uint {
+	if elem.whole || elem.Size == 0 {
+		return length * ((elem.Size + wordBytes - 1) / wordBytes)
+	}
+	perSlot := wordBytes / elem.Size
+	return (length + perSlot - 1) / perSlot
+}
+
+type storageCursor struct {
+	slot   uint
+	offset uint
+}
+
+// place assigns the next position to a field
+func (c *storageCursor) place(f *StorageField) {
+	if f.whole {
+		if c.offset > 0 {
+			c.slot++
+			c.offset = 0
+		}
+		f.Slot, f.Offset = c.slot, 0
+		words := (f.Size + wordBytes - 1) / wordBytes
+		if words == 0 {
+			words = 1
+		}
+		c.slot += words
+		return
+	}
+	if c.offset+f.Size > wordBytes {
+		c.slot++
+		c.offset = 0
+	}
+	f.Slot, f.Offset = c.slot, c.offset
+	c.offset += f.Size
+}
+
+// slots is the number of slots used so far
+func (c *storageCursor) slots() uint {
+	if c.offset > 0 {
+		return c.slot + 1
+	}
+	return c.slot
+}
+
+// MappingSlot returns the slot which holds the value of a key in a mapping
+// held at a slot: the keccak256 hash of the key and the slot, each padded to
+// a word
+func MappingSlot(key []byte, slot *big.Int) []byte {
+	data := make([]byte, 2*wordBytes)
+	copy(data[wordBytes-uint(len(key)):wordBytes], key)
+	slot.FillBytes(data[wordBytes:])
+	return keccak(data)
+}
+
+// DataSlot returns the first slot of the elements of a dynamic array, or of
+// the data of a byte array or string, whose length is held at a slot
+func DataSlot(slot *big.Int) []byte {
+	data := make([]byte, wordBytes)
+	slot.FillBytes(data)
+	return keccak(data)
+}
@@ -0,0 +1,173 @@
+package evm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
+)
+
+func storageLayout(t *testing.T, name, text string) StorageLayout {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, text)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c, ok := scope.GetDeclaration(name).(*ast.ContractDeclarationNode)
+	goutil.AssertNow(t, ok, "contract not found")
+	return e.ContractStorageLayout(c)
+}
+
+func TestStorageLayoutPacking(t *testing.T) {
+	layout := storageLayout(t, "Token", `
+		contract Token {
+			var owner address
+			var paused bool
+			var supply uint
+			var decimals uint8
+		}
+	`)
+	goutil.AssertNow(t, len(layout.Storage) == 4, "wrong field count")
+	owner, paused, supply, decimals := layout.Storage[0], layout.Storage[1], layout.Storage[2], layout.Storage[3]
+	goutil.Assert(t, owner.Label == "owner" && owner.Slot == 0 && owner.Offset == 0 && owner.Size == 20, "wrong owner")
+	goutil.Assert(t, paused.Slot == 0 && paused.Offset == 20 && paused.Size == 1, "paused should be packed with owner")
+	goutil.Assert(t, supply.Slot == 1 && supply.Offset == 0 && supply.Type == "uint256", "supply should begin a new slot")
+	goutil.Assert(t, decimals.Slot == 2 && decimals.Offset == 0, "wrong decimals")
+	goutil.Assert(t, layout.Slots == 3, "wrong slot count")
+}
+
+func TestStorageLayoutDeclarationOrder(t *testing.T) {
+	// the same contract must always produce the same layout
+	text := `
+		contract Token {
+			var z uint8
+			var y uint8
+			var x uint8
+			var w uint8
+		}
+	`
+	for i := 0; i < 10; i++ {
+		layout := storageLayout(t, "Token", text)
+		for j, name := range []string{"z", "y", "x", "w"} {
+			goutil.AssertNow(t, layout.Storage[j].Label == name, "fields should be in declaration order")
+			goutil.AssertNow(t, layout.Storage[j].Offset == uint(j), "wrong offset")
+		}
+	}
+}
+
+func TestStorageLayoutDynamic(t *testing.T) {
+	layout := storageLayout(t, "Token", `
+		contract Token {
+			var flag bool
+			var balances map[address]uint
+			var holders []address
+			var name string
+			var last uint8
+		}
+	`)
+	goutil.AssertNow(t, len(layout.Storage) == 5, "wrong field count")
+	balances := layout.Storage[1]
+	goutil.Assert(t, balances.Slot == 1 && balances.Encoding == "mapping", "wrong balances")
+	goutil.Assert(t, balances.Type == "mapping(address => uint256)", balances.Type)
+	holders := layout.Storage[2]
+	goutil.Assert(t, holders.Slot == 2 && holders.Encoding == "dynamic_array" && holders.Type == "address[]", "wrong holders")
+	name := layout.Storage[3]
+	goutil.Assert(t, name.Slot == 3 && name.Encoding == "bytes", "wrong name")
+	goutil.Assert(t, layout.Storage[4].Slot == 4, "fields after dynamic values should begin a new slot")
+}
+
+func TestStorageLayoutFixedArrays(t *testing.T) {
+	layout := storageLayout(t, "Token", `
+		contract Token {
+			var small [40]uint8
+			var large [3]uint
+			var after bool
+		}
+	`)
+	goutil.Assert(t, layout.Storage[0].Slot == 0 && layout.Storage[0].Size == 64, "small elements should be packed")
+	goutil.Assert(t, layout.Storage[1].Slot == 2 && layout.Storage[1].Size == 96, "wrong large")
+	goutil.Assert(t, layout.Storage[2].Slot == 5, "wrong after")
+}
+
+func TestStorageLayoutInheritance(t *testing.T) {
+	layout := storageLayout(t, "Token", `
+		contract Owned {
+			var owner address
+		}
+		contract Token inherits Owned {
+			var paused bool
+			var supply uint
+		}
+	`)
+	goutil.AssertNow(t, len(layout.Storage) == 3, "inherited fields should be included")
+	owner := layout.Storage[0]
+	goutil.Assert(t, owner.Label == "owner" && owner.Contract == "Owned", "super fields should come first")
+	goutil.Assert(t, layout.Storage[1].Contract == "Token" && layout.Storage[1].Slot == 0, "fields should pack after super fields")
+}
+
+func TestStorageLayoutJSON(t *testing.T) {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, `
+		contract Token {
+			var owner address
+		}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	data, err := e.StorageLayout(scope.Declarations.Next().(*ast.ContractDeclarationNode))
+	goutil.AssertNow(t, err == nil, "marshal error")
+	var report map[string]interface{}
+	goutil.AssertNow(t, json.Unmarshal(data, &report) == nil, "invalid json")
+	goutil.Assert(t, report["contract"] == "Token", "wrong contract")
+	fields := report["storage"].([]interface{})
+	goutil.AssertNow(t, len(fields) == 1, "wrong field count")
+	owner := fields[0].(map[string]interface{})
+	goutil.Assert(t, owner["label"] == "owner" && owner["type"] == "address" && owner["slot"] == 0.0, string(data))
+}
+
+func TestMappingSlot(t *testing.T) {
+	// the slot of key 1 in a mapping at slot 0
+	slot := MappingSlot([]byte{1}, big.NewInt(0))
+	goutil.Assert(t, hex.EncodeToString(slot) == "ada5013122d395ba3c54772283fb069b10426056ef8ca54750cb9bb552a59e7d", hex.EncodeToString(slot))
+	data := DataSlot(big.NewInt(0))
+	goutil.Assert(t, hex.EncodeToString(data) == "290decd9548b62a8d60345a988386fc84ba6bc95484008f6362f93160ef3e563", hex.EncodeToString(data))
+}
+
+func TestStorageFieldRetrieve(t *testing.T) {
+	code := traverseContract(t, `
+		contract Token {
+			var owner address
+			var paused bool
+			global func isPaused() bool {
+				return paused
+			}
+		}
+	`)
+	// paused is shifted down from its offset in slot 0
+	var shifted bool
+	for i, c := range code.Commands {
+		if c.Mnemonic == "SHR" && i > 0 && code.Commands[i-1].Mnemonic == "PUSH1" && code.Commands[i-1].Parameters[0] == 160 {
+			shifted = true
+		}
+	}
+	goutil.Assert(t, shifted, code.Format())
+}
+
+func TestStorageLayoutClasses(t *testing.T) {
+	layout := storageLayout(t, "Token", `
+		class Point {
+			var x, y uint8
+		}
+		contract Token {
+			var flag bool
+			var origin Point
+			var after bool
+		}
+	`)
+	origin := layout.Storage[1]
+	goutil.AssertNow(t, origin.Slot == 1 && origin.Type == "Point", "classes should begin a new slot")
+	goutil.AssertNow(t, len(origin.Members) == 2, "wrong member count")
+	goutil.Assert(t, origin.Members[1].Label == "y" && origin.Members[1].Offset == 1, "members should be packed")
+	goutil.Assert(t, layout.Storage[2].Slot == 2, "wrong after")
+}
@@ -0,0 +1,144 @@
+package evm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/util"
+	"github.com/end-r/vmgen"
+)
+
+// annotated code is generated with SOURCE markers, each of which indexes the
+// node which the code after it was generated from
+// a marker with a negative index ends the code generated from any node
+
+func (e *GuardianEVM) markSource(n ast.Node) (code vmgen.Bytecode) {
+	if n == nil {
+		code.AddMarker("SOURCE", -1)
+		return code
+	}
+	e.sources = append(e.sources, n)
+	code.AddMarker("SOURCE", len(e.sources)-1)
+	return code
+}
+
+// annotate marks the code generated for a node with its source, then marks
+// the code after it with the source of the enclosing node
+func (e *GuardianEVM) annotate(n ast.Node, generate func() vmgen.Bytecode) (code vmgen.Bytecode) {
+	if !e.annotating {
+		return generate()
+	}
+	parent := e.source
+	e.source = n
+	code.Concat(e.markSource(n))
+	code.Concat(generate())
+	e.source = parent
+	code.Concat(e.markSource(parent))
+	return code
+}
+
+// Listing disassembles the creation code of a validated contract, or its
+// runtime code if runtime is set, with a comment holding the source line
+// of each statement and function before the instructions generated from it
+// source files are read from disk unless they are given in files, keyed by
+// filename
+func (evm GuardianEVM) Listing(n *ast.ContractDeclarationNode, runtime bool, files map[string][]byte) (string, util.Errors) {
+	evm.annotating = true
+	a, errs := evm.Deploy(n)
+	if errs != nil {
+		return "", errs
+	}
+	sections, errs := assemble(a.Creation, a.Runtime)
+	if errs != nil {
+		return "", errs
+	}
+	section := sections[0]
+	if runtime {
+		section = sections[1]
+	}
+	return list(section, a.sources, newSourceFiles(files)), nil
+}
+
+func list(a assembly, sources []ast.Node, files *sourceFiles) string {
+	var lines []string
+	marks := a.sources
+	current := -1
+	var last util.Location
+	for _, o := range Disassemble(a.code) {
+		for len(marks) > 0 && marks[0].offset <= o.Offset {
+			current = marks[0].source
+			marks = marks[1:]
+		}
+		if current < 0 {
+			last = util.Location{}
+		} else if loc := sources[current].Start(); loc.Filename != last.Filename || loc.Line != last.Line {
+			lines = append(lines, files.comment(loc))
+			last = loc
+		}
+		lines = append(lines, formatOperation(o)+"\n")
+	}
+	return strings.Join(lines, "")
+}
+
+// sourceFiles reads each source file once
+type sourceFiles struct {
+	data  map[string][]byte
+	lines map[string][]string
+}
+
+func newSourceFiles(files map[string][]byte) *sourceFiles {
+	s := &sourceFiles{
+		data:  make(map[string][]byte),
+		lines: make(map[string][]string),
+	}
+	for name, data := range files {
+		s.data[name] = data
+	}
+	return s
+}
+
+func (s *sourceFiles) read(name string) []byte {
+	data, ok := s.data[name]
+	if !ok {
+		// files which can't be read are treated as empty
+		data, _ = ioutil.ReadFile(name)
+		s.data[name] = data
+	}
+	return data
+}
+
+func (s *sourceFiles) line(loc util.Location) string {
+	lines, ok := s.lines[loc.Filename]
+	if !ok {
+		lines = strings.Split(string(s.read(loc.Filename)), "\n")
+		s.lines[loc.Filename] = lines
+	}
+	if loc.Line < 1 || int(loc.Line) > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[loc.Line-1])
+}
+
+// text returns the source of a node, or nothing if it can't be read
+func (s *sourceFiles) text(n ast.Node) string {
+	begin, final := n.Start(), n.End()
+	data := s.read(begin.Filename)
+	if final.Offset <= begin.Offset || int(final.Offset) > len(data) {
+		return ""
+	}
+	return string(data[begin.Offset:final.Offset])
+}
+
+// comment describes the source line at a location
+func (s *sourceFiles) comment(loc util.Location) string {
+	position := fmt.Sprintf("%d", loc.Line)
+	if loc.Filename != "" {
+		position = fmt.Sprintf("%s:%d", loc.Filename, loc.Line)
+	}
+	if text := s.line(loc); text != "" {
+		return fmt.Sprintf("// %s: %s\n", position, text)
+	}
+	return fmt.Sprintf("// %s\n", position)
+}
@@ -0,0 +1,68 @@
+package evm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
+)
+
+// strings are lexed as the file "input"
+const listedContract = `contract Counter {
+	var count = uint(1)
+	external func increment() {
+		count = count + 1
+	}
+	external func get() uint {
+		return count
+	}
+}`
+
+func listing(t *testing.T, runtime bool) string {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, listedContract)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c, ok := scope.GetDeclaration("Counter").(*ast.ContractDeclarationNode)
+	goutil.AssertNow(t, ok, "contract not found")
+	l, errs := e.Listing(c, runtime, map[string][]byte{"input": []byte(listedContract)})
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	return l
+}
+
+func TestListingRuntimeSourceLines(t *testing.T) {
+	l := listing(t, true)
+	for _, line := range []string{
+		"// input:3: external func increment() {",
+		"// input:4: count = count + 1",
+		"// input:6: external func get() uint {",
+		"// input:7: return count",
+	} {
+		goutil.Assert(t, strings.Contains(l, line+"\n"), "missing "+line)
+	}
+	goutil.Assert(t, !strings.Contains(l, "// input:2:"), "field values are initialised by the creation code")
+	goutil.Assert(t, strings.HasPrefix(l, "0000 | PUSH"), "the listing should begin with the free memory pointer")
+}
+
+func TestListingCreationSourceLines(t *testing.T) {
+	l := listing(t, false)
+	goutil.Assert(t, strings.Contains(l, "// input:2: var count = uint(1)\n"), "missing field initialiser")
+	goutil.Assert(t, !strings.Contains(l, "// input:4:"), "functions are part of the runtime code")
+}
+
+func TestListingMatchesCompiledCode(t *testing.T) {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, listedContract)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c := scope.GetDeclaration("Counter").(*ast.ContractDeclarationNode)
+	raw, errs := e.Compile(c, true)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	var listed []string
+	for _, line := range strings.Split(listing(t, true), "\n") {
+		if line != "" && !strings.HasPrefix(line, "//") {
+			listed = append(listed, line)
+		}
+	}
+	goutil.Assert(t, strings.Join(listed, "\n")+"\n" == e.Disassembly(raw), "annotations should not change the code")
+}
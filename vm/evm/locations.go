@@ -0,0 +1,399 @@
+package evm
+
+import (
+	"strings"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/vmgen"
+)
+
+// values within mappings, arrays and classes are held at slots which are
+// calculated as the code runs
+// a storageLocation is the layout of such a value, whose slot is on the
+// stack: packed array elements also have their offset in bits above it,
+// while other values are at the offset given by their layout
+type storageLocation struct {
+	field  StorageField
+	packed bool
+}
+
+// locateStorage pushes the position of the value of an expression held in
+// storage, and returns false if the value isn't held in storage
+func (e *GuardianEVM) locateStorage(n ast.ExpressionNode) (code vmgen.Bytecode, l storageLocation, ok bool) {
+	switch a := n.(type) {
+	case *ast.IdentifierNode:
+		// parameters and local variables shadow storage
+		if e.lookupMemory(a.Name) != nil {
+			return code, l, false
+		}
+		s := e.lookupStorage(a.Name)
+		if s == nil || s.field == nil {
+			return code, l, false
+		}
+		code.Concat(push(encodeUint(s.slot)))
+		return code, storageLocation{field: *s.field}, true
+	case *ast.IndexExpressionNode:
+		parent, p, ok := e.locateStorage(a.Expression)
+		if !ok || p.packed {
+			return code, l, false
+		}
+		f := p.field
+		switch {
+		case f.Encoding == mappingEncoding && f.key != nil && f.value != nil:
+			code.Concat(parent)
+			code.Concat(e.traverseExpression(a.Index))
+			code.Concat(e.mappingSlot(*f.key))
+			return code, storageLocation{field: *f.value}, true
+		case f.Encoding == dynamicArrayEncoding && f.elem != nil:
+			code.Concat(parent)
+			code.Concat(e.traverseExpression(a.Index))
+			// index, slot
+			code.Add("DUP2")
+			code.Add("SLOAD")
+			code.Concat(e.checkIndex())
+			code.Add("SWAP1")
+			code.Concat(dataSlot())
+			code.Add("SWAP1")
+			code, l = elementLocation(code, *f.elem)
+			return code, l, true
+		case f.Encoding == inplaceEncoding && f.elem != nil:
+			code.Concat(parent)
+			code.Concat(e.traverseExpression(a.Index))
+			code.Concat(push(encodeUint(parseABIType(f.Type).length)))
+			code.Concat(e.checkIndex())
+			code, l = elementLocation(code, *f.elem)
+			return code, l, true
+		}
+	case *ast.ReferenceNode:
+		member, ok := a.Reference.(*ast.IdentifierNode)
+		if !ok {
+			return code, l, false
+		}
+		parent, c, ok := e.locateStorage(a.Parent)
+		if !ok || c.packed {
+			return code, l, false
+		}
+		for _, m := range c.field.Members {
+			if m.Label == member.Name {
+				code.Concat(parent)
+				code.Concat(addConstant(m.Slot))
+				return code, storageLocation{field: m}, true
+			}
+		}
+	}
+	return code, l, false
+}
+
+// checkIndex consumes the length on top of the stack, and reverts unless the
+// index beneath it is less than the length
+func (e *GuardianEVM) checkIndex() (code vmgen.Bytecode) {
+	code.Add("DUP2")
+	code.Add("LT")
+	code.Add("ISZERO")
+	code.Concat(e.revertIf())
+	return code
+}
+
+// dataSlot replaces the slot on top of the stack with the first slot of the
+// data it refers to: keccak256(slot)
+func dataSlot() (code vmgen.Bytecode) {
+	code.Concat(push([]byte{scratchSpace}))
+	code.Add("MSTORE")
+	code.Concat(push(encodeUint(wordBytes)))
+	code.Concat(push([]byte{scratchSpace}))
+	code.Add("SHA3")
+	return code
+}
+
+// elements are laid out in the same way as the elements of fixed arrays:
+// elements of at most half a slot are packed into each slot, while larger
+// elements begin a new slot
+// elementLocation adds the code which replaces an index and the first slot
+// of an array with the position of the element
+func elementLocation(code vmgen.Bytecode, elem StorageField) (vmgen.Bytecode, storageLocation) {
+	if elem.whole || elem.Size == 0 || elem.Size > wordBytes/2 {
+		words := (elem.Size + wordBytes - 1) / wordBytes
+		if words > 1 {
+			code.Concat(push(encodeUint(words)))
+			code.Add("MUL")
+		}
+		code.Add("ADD")
+		return code, storageLocation{field: elem}
+	}
+	perSlot := wordBytes / elem.Size
+	// index, data
+	code.Concat(push(encodeUint(perSlot)))
+	code.Add("DUP2")
+	code.Add("DIV")
+	code.Add("DUP3")
+	code.Add("ADD")
+	code.Add("SWAP2")
+	code.Add("POP")
+	// index, slot
+	code.Concat(push(encodeUint(perSlot)))
+	code.Add("SWAP1")
+	code.Add("MOD")
+	code.Concat(push(encodeUint(elem.Size * 8)))
+	code.Add("MUL")
+	return code, storageLocation{field: elem, packed: true}
+}
+
+// mappingSlot replaces the key on top of the stack and the slot of a mapping
+// beneath it with the slot of the key's value: keccak256(key . slot)
+// word keys are padded to a word, while strings and byte arrays are hashed
+// as their data
+func (e *GuardianEVM) mappingSlot(key StorageField) (code vmgen.Bytecode) {
+	if key.Encoding != bytesEncoding {
+		code.Concat(push([]byte{scratchSpace}))
+		code.Add("MSTORE")
+		code.Concat(push(encodeUint(scratchSpace + wordBytes)))
+		code.Add("MSTORE")
+		code.Concat(push(encodeUint(2 * wordBytes)))
+		code.Concat(push([]byte{scratchSpace}))
+		code.Add("SHA3")
+		return code
+	}
+	// copy the data after the heap, without allocating it
+	// pointer, slot
+	code.Concat(push([]byte{freeMemoryPointer}))
+	code.Add("MLOAD")
+	code.Add("DUP1")
+	code.Add("DUP3")
+	code.Concat(addConstant(wordBytes))
+	code.Add("DUP4")
+	code.Add("MLOAD")
+	code.Concat(push([]byte{byte(wordBytes - 1)}))
+	code.Add("ADD")
+	code.Concat(push([]byte{5}))
+	code.Add("SHR")
+	code.Concat(e.copyWords())
+	// destination, pointer, slot
+	code.Add("SWAP1")
+	code.Add("MLOAD")
+	code.Add("SWAP2")
+	// the slot follows the data directly
+	code.Add("DUP3")
+	code.Add("DUP3")
+	code.Add("ADD")
+	code.Add("MSTORE")
+	code.Add("SWAP1")
+	code.Concat(addConstant(wordBytes))
+	code.Add("SWAP1")
+	code.Add("SHA3")
+	return code
+}
+
+// isWordField reports whether a field holds a single value, rather than
+// referring to the slots of a mapping, array, string or class
+func isWordField(f StorageField) bool {
+	return f.Encoding == inplaceEncoding && len(f.Members) == 0 && !strings.HasSuffix(f.Type, "]")
+}
+
+// isFixedArray reports whether a field holds the elements of a fixed array
+func isFixedArray(f StorageField) bool {
+	return f.Encoding == inplaceEncoding && strings.HasSuffix(f.Type, "]")
+}
+
+// load replaces the position on top of the stack with the value held there
+// values which refer to other slots are left as their slot
+func (l storageLocation) load() (code vmgen.Bytecode) {
+	f := l.field
+	if !isWordField(f) {
+		return code
+	}
+	if l.packed {
+		// offset, slot
+		code.Add("SWAP1")
+		code.Add("SLOAD")
+		code.Add("SWAP1")
+		code.Add("SHR")
+	} else {
+		code.Add("SLOAD")
+		if f.Offset > 0 {
+			code.Concat(push(encodeUint(f.Offset * 8)))
+			code.Add("SHR")
+		}
+	}
+	if f.Size < wordBytes {
+		t := parseABIType(f.Type)
+		code.Concat(truncate(f.Size*8, t.kind == abiWord && t.signed))
+	}
+	return code
+}
+
+// store consumes the position on top of the stack and stores the value
+// beneath it there, leaving the rest of the slot unchanged
+func (l storageLocation) store() (code vmgen.Bytecode) {
+	f := l.field
+	if !l.packed && f.Offset == 0 && f.Size >= wordBytes {
+		code.Add("SSTORE")
+		return code
+	}
+	mask := sectionMask(f.Size * 8)
+	if l.packed {
+		// offset, slot, value
+		code.Add("SWAP2")
+		code.Concat(push(encodeBig(mask)))
+		code.Add("AND")
+		code.Add("DUP3")
+		code.Add("SHL")
+		// clear the section before merging
+		code.Concat(push(encodeBig(mask)))
+		code.Add("DUP4")
+		code.Add("SHL")
+		code.Add("NOT")
+		code.Add("DUP3")
+		code.Add("SLOAD")
+		code.Add("AND")
+		code.Add("OR")
+		code.Add("SWAP1")
+		code.Add("SSTORE")
+		code.Add("POP")
+		return code
+	}
+	code.Add("SWAP1")
+	code.Concat(push(encodeBig(mask)))
+	code.Add("AND")
+	if f.Offset > 0 {
+		code.Concat(push(encodeUint(f.Offset * 8)))
+		code.Add("SHL")
+	}
+	// clear the section before merging
+	cleared := mask.Lsh(mask, f.Offset*8)
+	cleared.Xor(cleared, sectionMask(wordSize))
+	code.Add("DUP2")
+	code.Add("SLOAD")
+	code.Concat(push(encodeBig(cleared)))
+	code.Add("AND")
+	code.Add("OR")
+	code.Add("SWAP1")
+	code.Add("SSTORE")
+	return code
+}
+
+// clear consumes the position on top of the stack and resets the value held
+// there to its zero value
+// the values of mappings can't be found, and are left unchanged
+func (e *GuardianEVM) clear(l storageLocation) (code vmgen.Bytecode) {
+	f := l.field
+	switch {
+	case l.packed:
+		code.Concat(push([]byte{0}))
+		code.Add("SWAP2")
+		code.Add("SWAP1")
+		code.Concat(l.store())
+	case f.Encoding == mappingEncoding:
+		code.Add("POP")
+	case len(f.Members) > 0:
+		for _, m := range f.Members {
+			code.Add("DUP1")
+			code.Concat(addConstant(m.Slot))
+			code.Concat(e.clear(storageLocation{field: m}))
+		}
+		code.Add("POP")
+	case isFixedArray(f):
+		// fixed arrays are cleared a slot at a time
+		for i := uint(0); i < f.Size/wordBytes; i++ {
+			code.Concat(push([]byte{0}))
+			code.Add("DUP2")
+			code.Concat(addConstant(i))
+			code.Add("SSTORE")
+		}
+		code.Add("POP")
+	case f.Encoding == dynamicArrayEncoding && f.elem != nil:
+		// the slots holding the elements are cleared, then the length
+		code.Add("DUP1")
+		code.Add("SLOAD")
+		code.Concat(arraySlotsUsed(*f.elem))
+		code.Add("DUP2")
+		code.Concat(dataSlot())
+		code.Concat(e.clearSlots())
+		code.Concat(push([]byte{0}))
+		code.Add("SWAP1")
+		code.Add("SSTORE")
+	default:
+		// strings and byte arrays are cleared by their length
+		code.Concat(push([]byte{0}))
+		code.Add("SWAP1")
+		code.Concat(l.store())
+	}
+	return code
+}
+
+// arraySlotsUsed replaces the length on top of the stack with the number of
+// slots used by that many elements
+func arraySlotsUsed(elem StorageField) (code vmgen.Bytecode) {
+	if elem.whole || elem.Size == 0 || elem.Size > wordBytes/2 {
+		words := (elem.Size + wordBytes - 1) / wordBytes
+		if words > 1 {
+			code.Concat(push(encodeUint(words)))
+			code.Add("MUL")
+		}
+		return code
+	}
+	perSlot := wordBytes / elem.Size
+	code.Concat(addConstant(perSlot - 1))
+	code.Concat(push(encodeUint(perSlot)))
+	code.Add("SWAP1")
+	code.Add("DIV")
+	return code
+}
+
+// clearSlots consumes a first slot on top of the stack and a number of
+// slots beneath it, and clears each of them
+func (e *GuardianEVM) clearSlots() (code vmgen.Bytecode) {
+	// first, count, index
+	loop, done := e.newLabel(), e.newLabel()
+	code.Concat(push([]byte{0}))
+	code.Concat(jumpdest(loop))
+	code.Add("DUP3")
+	code.Add("DUP2")
+	code.Add("LT")
+	code.Add("ISZERO")
+	code.Concat(pushLabel(done))
+	code.Add("JUMPI")
+	code.Concat(push([]byte{0}))
+	code.Add("DUP2")
+	code.Add("DUP4")
+	code.Add("ADD")
+	code.Add("SSTORE")
+	code.Concat(push([]byte{1}))
+	code.Add("ADD")
+	code.Concat(pushLabel(loop))
+	code.Add("JUMP")
+	code.Concat(jumpdest(done))
+	code.Add("POP")
+	code.Add("POP")
+	code.Add("POP")
+	return code
+}
+
+// appendElement pushes the value beneath the slot of a dynamic array on top
+// of the stack onto the array, leaving the slot
+func (e *GuardianEVM) appendElement(elem StorageField) (code vmgen.Bytecode) {
+	// slot, value
+	code.Add("DUP1")
+	code.Add("SLOAD")
+	// length, slot, value
+	code.Add("DUP1")
+	code.Concat(addConstant(1))
+	code.Add("DUP3")
+	code.Add("SSTORE")
+	code.Add("DUP2")
+	code.Concat(dataSlot())
+	code.Add("SWAP1")
+	code, l := elementLocation(code, elem)
+	// position, slot, value
+	// the value is moved above the slot of the array
+	if l.packed {
+		code.Add("SWAP2")
+		code.Add("SWAP3")
+		code.Add("SWAP2")
+	} else {
+		code.Add("SWAP1")
+		code.Add("SWAP2")
+		code.Add("SWAP1")
+	}
+	code.Concat(l.store())
+	return code
+}
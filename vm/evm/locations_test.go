@@ -0,0 +1,225 @@
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/end-r/goutil"
+)
+
+func TestMappingReadWrite(t *testing.T) {
+	chain, a := execute(t, "Token", `
+		contract Token {
+			var supply uint
+			var balances map[address]uint
+			external func mint(to address, amount uint) {
+				balances[to] += amount
+				supply += amount
+			}
+			external func holding(owner address) uint {
+				return balances[owner]
+			}
+		}
+	`)
+	r := send(chain, a, "mint(address,uint256)", uintWord(7), uintWord(50))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, a, "mint(address,uint256)", uintWord(7), uintWord(25))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, a, "holding(address)", uintWord(7))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(75)), fmt.Sprintf("wrong balance %x", r.Output))
+	r = send(chain, a, "holding(address)", uintWord(8))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(0)), fmt.Sprintf("wrong balance %x", r.Output))
+	// the balance is held where solidity would hold it
+	slot := new(big.Int).SetBytes(MappingSlot([]byte{7}, big.NewInt(1)))
+	goutil.Assert(t, chain.Storage(a, slot).Int64() == 75, "wrong slot")
+	goutil.Assert(t, chain.Storage(a, big.NewInt(0)).Int64() == 75, "wrong supply")
+}
+
+func TestNestedMapping(t *testing.T) {
+	chain, a := execute(t, "Token", `
+		contract Token {
+			var allowed map[address]map[address]uint
+			external func approve(owner, spender address, amount uint) {
+				allowed[owner][spender] = amount
+			}
+			external func allowance(owner, spender address) uint {
+				return allowed[owner][spender]
+			}
+		}
+	`)
+	r := send(chain, a, "approve(address,address,uint256)", uintWord(1), uintWord(2), uintWord(30))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, a, "allowance(address,address)", uintWord(1), uintWord(2))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(30)), fmt.Sprintf("wrong allowance %x", r.Output))
+	r = send(chain, a, "allowance(address,address)", uintWord(2), uintWord(1))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(0)), fmt.Sprintf("wrong allowance %x", r.Output))
+	inner := MappingSlot([]byte{1}, big.NewInt(0))
+	slot := new(big.Int).SetBytes(MappingSlot([]byte{2}, new(big.Int).SetBytes(inner)))
+	goutil.Assert(t, chain.Storage(a, slot).Int64() == 30, "wrong slot")
+}
+
+func TestMappingClassValues(t *testing.T) {
+	chain, a := execute(t, "Registry", `
+		class Entry {
+			var active bool
+			var level int8
+			var owner address
+			var value uint
+		}
+		contract Registry {
+			var entries map[uint]Entry
+			external func set(id uint, level int8, owner address, value uint) {
+				entries[id].active = true
+				entries[id].level = level
+				entries[id].owner = owner
+				entries[id].value = value
+			}
+			external func get(id uint) (bool, int8, address, uint) {
+				return entries[id].active, entries[id].level, entries[id].owner, entries[id].value
+			}
+			external func remove(id uint) {
+				delete(entries[id])
+			}
+		}
+	`)
+	r := send(chain, a, "set(uint256,int8,address,uint256)", uintWord(3), intWord(-5), uintWord(9), uintWord(100))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, a, "get(uint256)", uintWord(3))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	expected := bytes.Join([][]byte{uintWord(1), intWord(-5), uintWord(9), uintWord(100)}, nil)
+	goutil.Assert(t, bytes.Equal(r.Output, expected), fmt.Sprintf("wrong entry %x", r.Output))
+	r = send(chain, a, "remove(uint256)", uintWord(3))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, a, "get(uint256)", uintWord(3))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, make([]byte, 4*wordBytes)), fmt.Sprintf("entry not deleted %x", r.Output))
+}
+
+func TestMappingStringKeys(t *testing.T) {
+	chain, a := execute(t, "Names", `
+		contract Names {
+			var owners map[string]address
+			external func register(name string, owner address) {
+				owners[name] = owner
+			}
+			external func lookup(name string) address {
+				return owners[name]
+			}
+			external func unregister(name string) {
+				delete(owners[name])
+			}
+		}
+	`)
+	name := func(s string) [][]byte {
+		data := make([]byte, wordBytes)
+		copy(data, s)
+		return [][]byte{uintWord(int64(len(s))), data}
+	}
+	args := append([][]byte{uintWord(64), uintWord(4)}, name("alice")...)
+	r := send(chain, a, "register(string,address)", args...)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	lookup := append([][]byte{uintWord(32)}, name("alice")...)
+	r = send(chain, a, "lookup(string)", lookup...)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(4)), fmt.Sprintf("wrong owner %x", r.Output))
+	r = send(chain, a, "lookup(string)", append([][]byte{uintWord(32)}, name("alic")...)...)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(0)), fmt.Sprintf("wrong owner %x", r.Output))
+	// string keys are hashed as their data followed by the slot
+	slot := new(big.Int).SetBytes(keccak(append([]byte("alice"), make([]byte, wordBytes)...)))
+	goutil.Assert(t, chain.Storage(a, slot).Int64() == 4, "wrong slot")
+	r = send(chain, a, "unregister(string)", lookup...)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, a, "lookup(string)", lookup...)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(0)), fmt.Sprintf("owner not deleted %x", r.Output))
+}
+
+func TestStorageArrays(t *testing.T) {
+	chain, a := execute(t, "List", `
+		contract List {
+			var items []uint
+			var flags []uint8
+			external func add(item uint, flag uint8) {
+				items = append(items, item)
+				flags = append(flags, flag)
+			}
+			external func get(i uint) (uint, uint8) {
+				return items[i], flags[i]
+			}
+			external func set(i uint, item uint) {
+				items[i] = item
+			}
+			external func count() int {
+				return len(items)
+			}
+			external func clear() {
+				delete(items)
+				delete(flags)
+			}
+		}
+	`)
+	for i := int64(1); i <= 3; i++ {
+		r := send(chain, a, "add(uint256,uint8)", uintWord(i*10), uintWord(i))
+		goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	}
+	r := send(chain, a, "count()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(3)), fmt.Sprintf("wrong length %x", r.Output))
+	r = send(chain, a, "set(uint256,uint256)", uintWord(1), uintWord(99))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, a, "get(uint256)", uintWord(1))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, append(uintWord(99), uintWord(2)...)), fmt.Sprintf("wrong element %x", r.Output))
+	r = send(chain, a, "get(uint256)", uintWord(3))
+	goutil.Assert(t, r.Reverted(), "indexing beyond the length should revert")
+	r = send(chain, a, "set(uint256,uint256)", uintWord(3), uintWord(1))
+	goutil.Assert(t, r.Reverted(), "assigning beyond the length should revert")
+	// the elements are held where solidity would hold them, with small
+	// elements packed into one slot
+	data := new(big.Int).SetBytes(DataSlot(big.NewInt(0)))
+	goutil.Assert(t, chain.Storage(a, new(big.Int).Add(data, big.NewInt(2))).Int64() == 30, "wrong element slot")
+	packed := new(big.Int).SetBytes(DataSlot(big.NewInt(1)))
+	goutil.Assert(t, chain.Storage(a, packed).Int64() == 0x030201, "elements should be packed")
+	r = send(chain, a, "clear()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, a, "count()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(0)), fmt.Sprintf("wrong length %x", r.Output))
+	goutil.Assert(t, chain.Storage(a, data).Sign() == 0, "elements should be cleared")
+	goutil.Assert(t, chain.Storage(a, packed).Sign() == 0, "packed elements should be cleared")
+}
+
+func TestStorageFixedArrays(t *testing.T) {
+	chain, a := execute(t, "Grid", `
+		contract Grid {
+			var cells [4]uint16
+			external func set(i uint, value uint16) {
+				cells[i] = value
+			}
+			external func get(i uint) uint16 {
+				return cells[i]
+			}
+			external func size() int {
+				return len(cells)
+			}
+		}
+	`)
+	r := send(chain, a, "set(uint256,uint16)", uintWord(2), uintWord(0x1234))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	r = send(chain, a, "get(uint256)", uintWord(2))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(0x1234)), fmt.Sprintf("wrong element %x", r.Output))
+	goutil.Assert(t, chain.Storage(a, big.NewInt(0)).Int64() == 0x1234<<32, "wrong element offset")
+	r = send(chain, a, "get(uint256)", uintWord(4))
+	goutil.Assert(t, r.Reverted(), "indexing beyond the length should revert")
+	r = send(chain, a, "size()")
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(4)), fmt.Sprintf("wrong length %x", r.Output))
+}
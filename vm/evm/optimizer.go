@@ -0,0 +1,588 @@
+package evm
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/end-r/guardian/validator"
+	"github.com/end-r/vmgen"
+)
+
+// generated code is optimized by passes which rewrite the code of every
+// section, and which are repeated until none of them changes anything
+// sections are optimized together, as they can refer to each other's labels
+// SOURCE markers occupy no space, so they are ignored when code is matched
+// and kept ahead of any code rewritten around them: annotated code is
+// optimized in exactly the same way as the code it annotates
+
+// OptimizationLevel selects the passes which are run over generated code,
+// each level running every pass of the levels below it
+type OptimizationLevel int
+
+const (
+	// NoOptimization leaves generated code as it is
+	NoOptimization OptimizationLevel = iota
+	// OptimizePeephole rewrites short sequences of instructions into cheaper
+	// ones, such as values which are pushed and immediately popped
+	OptimizePeephole
+	// OptimizeConstants folds operations on pushed constants
+	OptimizeConstants
+	// OptimizeJumps threads jumps to other jumps through to their targets,
+	// and removes jumps to the next instruction
+	OptimizeJumps
+	// OptimizeDeadCode removes code which can never be run
+	OptimizeDeadCode
+	// MaxOptimization runs every pass
+	MaxOptimization = OptimizeDeadCode
+)
+
+// Optimized returns a copy of the VM which optimizes the code it generates
+// at a level from 0, which leaves it as it is, to MaxOptimization
+func (evm GuardianEVM) Optimized(level int) validator.VM {
+	evm.optimization = OptimizationLevel(level)
+	if evm.optimization > MaxOptimization {
+		evm.optimization = MaxOptimization
+	}
+	return evm
+}
+
+// Optimize rewrites sections of generated code, which may refer to each
+// other's labels, without changing what they do
+func Optimize(level OptimizationLevel, sections ...vmgen.Bytecode) []vmgen.Bytecode {
+	code := make([][]vmgen.Command, len(sections))
+	for i, s := range sections {
+		code[i] = append([]vmgen.Command(nil), s.Commands...)
+	}
+	for changed := level > NoOptimization; changed; {
+		changed = false
+		for i := range code {
+			var rewritten bool
+			if level >= OptimizePeephole {
+				code[i], rewritten = rewrite(code[i], peepholeRules)
+				changed = changed || rewritten
+			}
+			if level >= OptimizeConstants {
+				code[i], rewritten = rewrite(code[i], constantRules)
+				changed = changed || rewritten
+			}
+			if level >= OptimizeJumps {
+				code[i], rewritten = rewrite(code[i], jumpRules)
+				changed = changed || rewritten
+				code[i], rewritten = removeJumpsToNext(code[i])
+				changed = changed || rewritten
+			}
+		}
+		if level >= OptimizeJumps && threadJumps(code) {
+			changed = true
+		}
+		if level >= OptimizeDeadCode && removeDeadCode(code) {
+			changed = true
+		}
+	}
+	optimized := make([]vmgen.Bytecode, len(code))
+	for i, c := range code {
+		optimized[i] = vmgen.Bytecode{Commands: c}
+	}
+	return optimized
+}
+
+// a rule rewrites a window of instructions, or returns false if it does not
+// apply to them
+type rule struct {
+	size    int
+	rewrite func(w []vmgen.Command) ([]vmgen.Command, bool)
+}
+
+// rewrite applies the first matching rule at each instruction in turn
+func rewrite(code []vmgen.Command, rules []rule) ([]vmgen.Command, bool) {
+	var out []vmgen.Command
+	changed := false
+	for i := 0; i < len(code); {
+		if isSource(code[i]) {
+			out = append(out, code[i])
+			i++
+			continue
+		}
+		matched := false
+		for _, r := range rules {
+			window, sources, end := windowAt(code, i, r.size)
+			if window == nil {
+				continue
+			}
+			if replacement, ok := r.rewrite(window); ok {
+				out = append(out, sources...)
+				out = append(out, replacement...)
+				i = end
+				changed, matched = true, true
+				break
+			}
+		}
+		if !matched {
+			out = append(out, code[i])
+			i++
+		}
+	}
+	return out, changed
+}
+
+// windowAt returns the next size commands from an index, which are not
+// SOURCE markers, the SOURCE markers between them, and the index after them
+func windowAt(code []vmgen.Command, i, size int) (window, sources []vmgen.Command, end int) {
+	for end = i; end < len(code) && len(window) < size; end++ {
+		if isSource(code[end]) {
+			sources = append(sources, code[end])
+		} else {
+			window = append(window, code[end])
+		}
+	}
+	if len(window) < size {
+		return nil, nil, i
+	}
+	return window, sources, end
+}
+
+func instruction(mnemonic string, data ...byte) vmgen.Command {
+	return vmgen.Command{Mnemonic: mnemonic, Parameters: data}
+}
+
+func isSource(c vmgen.Command) bool {
+	return c.IsMarker && c.Mnemonic == "SOURCE"
+}
+
+// is reports whether a command is an instruction, rather than a marker,
+// with one of the given mnemonics if there are any
+func is(c vmgen.Command, mnemonics ...string) bool {
+	if c.IsMarker {
+		return false
+	}
+	if len(mnemonics) == 0 {
+		return true
+	}
+	for _, m := range mnemonics {
+		if c.Mnemonic == m {
+			return true
+		}
+	}
+	return false
+}
+
+// isJump reports whether a command jumps, including marked jumps into and
+// out of functions
+func isJump(c vmgen.Command) bool {
+	return c.Mnemonic == "JUMP"
+}
+
+// pushed returns the value pushed by a PUSH of constant data
+func pushed(c vmgen.Command) (*big.Int, bool) {
+	if c.IsMarker || !strings.HasPrefix(c.Mnemonic, "PUSH") {
+		return nil, false
+	}
+	return new(big.Int).SetBytes(c.Parameters), true
+}
+
+// pushedLabel returns the label pushed by a PUSH marker
+func pushedLabel(c vmgen.Command) (int, bool) {
+	if !c.IsMarker || !strings.HasPrefix(c.Mnemonic, "PUSH") {
+		return 0, false
+	}
+	return c.Offset, true
+}
+
+func pushValue(v *big.Int) vmgen.Command {
+	data := encodeBig(v)
+	return instruction("PUSH"+strconv.Itoa(len(data)), data...)
+}
+
+// sameConstant reports whether two commands push the same constant
+func sameConstant(a, b vmgen.Command) bool {
+	x, ok := pushed(a)
+	if !ok {
+		return false
+	}
+	y, ok := pushed(b)
+	return ok && x.Cmp(y) == 0
+}
+
+// codeSize is the number of bytes taken up by assembled commands
+func codeSize(code []vmgen.Command) int {
+	size := 0
+	for _, c := range code {
+		if c.IsMarker && (c.Mnemonic == "SOURCE" || c.Mnemonic == "LABEL") {
+			continue
+		}
+		if i, ok := instructions[c.Mnemonic]; ok {
+			size += i.Size()
+		}
+	}
+	return size
+}
+
+// instructions which only push a value, and so can be removed if the value
+// is popped straight away
+var producers = map[string]bool{
+	"ADDRESS": true, "ORIGIN": true, "CALLER": true, "CALLVALUE": true,
+	"CALLDATASIZE": true, "CODESIZE": true, "GASPRICE": true, "RETURNDATASIZE": true,
+	"COINBASE": true, "TIMESTAMP": true, "NUMBER": true, "DIFFICULTY": true,
+	"GASLIMIT": true, "PC": true, "MSIZE": true, "GAS": true,
+}
+
+// instructions without side effects which replace one value, or two, with
+// their result
+var (
+	pureUnary = map[string]bool{
+		"ISZERO": true, "NOT": true, "CALLDATALOAD": true, "SLOAD": true,
+	}
+	pureBinary = map[string]bool{
+		"ADD": true, "MUL": true, "SUB": true, "DIV": true, "SDIV": true,
+		"MOD": true, "SMOD": true, "EXP": true, "SIGNEXTEND": true,
+		"LT": true, "GT": true, "SLT": true, "SGT": true, "EQ": true,
+		"AND": true, "OR": true, "XOR": true, "BYTE": true,
+		"SHL": true, "SHR": true, "SAR": true,
+	}
+	commutative = map[string]bool{
+		"ADD": true, "MUL": true, "AND": true, "OR": true, "XOR": true, "EQ": true,
+	}
+)
+
+func isProducer(c vmgen.Command) bool {
+	if _, ok := pushedLabel(c); ok {
+		return true
+	}
+	return !c.IsMarker && (strings.HasPrefix(c.Mnemonic, "PUSH") ||
+		strings.HasPrefix(c.Mnemonic, "DUP") || producers[c.Mnemonic])
+}
+
+var peepholeRules = []rule{
+	// values which are popped as soon as they are produced are never used
+	{2, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		if !is(w[1], "POP") {
+			return nil, false
+		}
+		switch {
+		case isProducer(w[0]):
+			return nil, true
+		case is(w[0]) && pureUnary[w[0].Mnemonic]:
+			return w[1:], true
+		case is(w[0]) && pureBinary[w[0].Mnemonic]:
+			return []vmgen.Command{w[1], w[1]}, true
+		}
+		return nil, false
+	}},
+	// swaps undo each other
+	{2, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		if !is(w[0]) || !strings.HasPrefix(w[0].Mnemonic, "SWAP") || !is(w[1], w[0].Mnemonic) {
+			return nil, false
+		}
+		return nil, true
+	}},
+	// the order of the operands of commutative instructions doesn't matter
+	{2, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		if !is(w[0], "SWAP1") || !is(w[1]) || !commutative[w[1].Mnemonic] {
+			return nil, false
+		}
+		return w[1:], true
+	}},
+	// swapping a duplicate with its original changes nothing
+	{2, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		if !is(w[0], "DUP1") || !is(w[1], "SWAP1") {
+			return nil, false
+		}
+		return w[:1], true
+	}},
+	{2, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		if !is(w[0], "NOT") || !is(w[1], "NOT") {
+			return nil, false
+		}
+		return nil, true
+	}},
+	{3, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		if !is(w[0], "ISZERO") || !is(w[1], "ISZERO") || !is(w[2], "ISZERO") {
+			return nil, false
+		}
+		return w[2:], true
+	}},
+	// conditional jumps only test whether their condition is zero
+	{4, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		if _, ok := pushedLabel(w[2]); !ok || !is(w[0], "ISZERO") || !is(w[1], "ISZERO") || !is(w[3], "JUMPI") {
+			return nil, false
+		}
+		return w[2:], true
+	}},
+	// a word which has just been stored doesn't need to be loaded
+	{4, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		if !sameConstant(w[0], w[2]) {
+			return nil, false
+		}
+		if (is(w[1], "MSTORE") && is(w[3], "MLOAD")) || (is(w[1], "SSTORE") && is(w[3], "SLOAD")) {
+			return []vmgen.Command{instruction("DUP1"), w[0], w[1]}, true
+		}
+		return nil, false
+	}},
+	// nor does a word which has just been loaded
+	{4, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		if !sameConstant(w[0], w[2]) || !is(w[1], "MLOAD", "SLOAD") || !is(w[3], w[1].Mnemonic) {
+			return nil, false
+		}
+		return []vmgen.Command{w[0], w[1], instruction("DUP1")}, true
+	}},
+}
+
+// foldConstants evaluates an instruction on pushed constants, as long as the
+// result takes up no more space than the code it replaces
+func foldConstants(w []vmgen.Command) ([]vmgen.Command, bool) {
+	var operands []abstractWord
+	// the top of the stack is the first operand
+	for i := len(w) - 2; i >= 0; i-- {
+		v, ok := pushed(w[i])
+		if !ok {
+			return nil, false
+		}
+		operands = append(operands, knownWord(v))
+	}
+	op := w[len(w)-1]
+	if !is(op) {
+		return nil, false
+	}
+	result, ok := evaluate(op.Mnemonic, operands).constant()
+	if !ok {
+		return nil, false
+	}
+	folded := []vmgen.Command{pushValue(result)}
+	if codeSize(folded) > codeSize(w) {
+		return nil, false
+	}
+	return folded, true
+}
+
+// identities are the values which leave the other operand of an
+// instruction unchanged
+var identities = map[string]int64{
+	"ADD": 0, "OR": 0, "XOR": 0, "SHL": 0, "SHR": 0, "SAR": 0, "MUL": 1,
+}
+
+var constantRules = []rule{
+	{3, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		if !is(w[2]) || !pureBinary[w[2].Mnemonic] {
+			return nil, false
+		}
+		return foldConstants(w)
+	}},
+	{2, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		if !is(w[1], "ISZERO", "NOT") {
+			return nil, false
+		}
+		return foldConstants(w)
+	}},
+	// constants are pushed in the order in which they are used
+	{3, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		if _, ok := pushed(w[0]); !ok || !is(w[2], "SWAP1") {
+			return nil, false
+		}
+		if _, ok := pushed(w[1]); !ok {
+			return nil, false
+		}
+		return []vmgen.Command{w[1], w[0]}, true
+	}},
+	// for shifts, the identity is the top operand: the size of the shift
+	{2, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		v, ok := pushed(w[0])
+		if !ok || !is(w[1]) {
+			return nil, false
+		}
+		identity, ok := identities[w[1].Mnemonic]
+		if !ok || v.Cmp(big.NewInt(identity)) != 0 {
+			return nil, false
+		}
+		return nil, true
+	}},
+}
+
+var jumpRules = []rule{
+	// conditional jumps on constants are either taken or not
+	{3, func(w []vmgen.Command) ([]vmgen.Command, bool) {
+		v, ok := pushed(w[0])
+		if _, isLabel := pushedLabel(w[1]); !ok || !isLabel || !is(w[2], "JUMPI") {
+			return nil, false
+		}
+		if v.Sign() == 0 {
+			return nil, true
+		}
+		return []vmgen.Command{w[1], instruction("JUMP")}, true
+	}},
+}
+
+// removeJumpsToNext removes jumps to the jump destination which follows
+// them, and conditional jumps over an unconditional jump
+func removeJumpsToNext(code []vmgen.Command) ([]vmgen.Command, bool) {
+	var out []vmgen.Command
+	changed := false
+	for i := 0; i < len(code); {
+		if isSource(code[i]) {
+			out = append(out, code[i])
+			i++
+			continue
+		}
+		window, sources, end := windowAt(code, i, 2)
+		if window != nil {
+			label, ok := pushedLabel(window[0])
+			if ok && (isJump(window[1]) || is(window[1], "JUMPI")) && labelFollows(code, end, label) {
+				out = append(out, sources...)
+				if is(window[1], "JUMPI") {
+					// the condition is still popped
+					out = append(out, instruction("POP"))
+				}
+				i, changed = end, true
+				continue
+			}
+		}
+		// a conditional jump over a jump is a jump if the condition fails
+		window, sources, end = windowAt(code, i, 4)
+		if window != nil {
+			over, ok := pushedLabel(window[0])
+			_, isLabel := pushedLabel(window[2])
+			if ok && isLabel && is(window[1], "JUMPI") && is(window[3], "JUMP") && labelFollows(code, end, over) {
+				out = append(out, sources...)
+				out = append(out, instruction("ISZERO"), window[2], instruction("JUMPI"))
+				i, changed = end, true
+				continue
+			}
+		}
+		out = append(out, code[i])
+		i++
+	}
+	return out, changed
+}
+
+// labelFollows reports whether a jump destination is defined at an index
+// before any instruction, which may come after other labels
+func labelFollows(code []vmgen.Command, i, label int) bool {
+	for ; i < len(code); i++ {
+		c := code[i]
+		if !c.IsMarker || (c.Mnemonic != "SOURCE" && c.Mnemonic != "LABEL" && c.Mnemonic != "JUMPDEST") {
+			return false
+		}
+		if c.Mnemonic == "JUMPDEST" && c.Offset == label {
+			return true
+		}
+	}
+	return false
+}
+
+// threadJumps sends jumps to a jump destination which immediately jumps
+// elsewhere, or which is followed by another jump destination, straight to
+// the final destination
+func threadJumps(code [][]vmgen.Command) bool {
+	next := make(map[int]int)
+	for _, section := range code {
+		for i, c := range section {
+			if !c.IsMarker || c.Mnemonic != "JUMPDEST" {
+				continue
+			}
+			if target, ok := jumpsTo(section, i+1); ok && target != c.Offset {
+				next[c.Offset] = target
+			}
+		}
+	}
+	resolve := func(label int) int {
+		seen := map[int]bool{label: true}
+		for {
+			target, ok := next[label]
+			// jumps around a loop of jumps are left as they are
+			if !ok || seen[target] {
+				return label
+			}
+			seen[target] = true
+			label = target
+		}
+	}
+	changed := false
+	for _, section := range code {
+		for i, c := range section {
+			label, ok := pushedLabel(c)
+			if !ok {
+				continue
+			}
+			window, _, _ := windowAt(section, i+1, 1)
+			if window == nil || !(isJump(window[0]) || is(window[0], "JUMPI")) {
+				continue
+			}
+			if target := resolve(label); target != label {
+				section[i].Offset = target
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// jumpsTo returns where the code from an index always jumps before running
+// any other instruction
+func jumpsTo(code []vmgen.Command, i int) (int, bool) {
+	for ; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case isSource(c), c.IsMarker && c.Mnemonic == "LABEL":
+			continue
+		case c.IsMarker && c.Mnemonic == "JUMPDEST":
+			return c.Offset, true
+		}
+		window, _, _ := windowAt(code, i, 2)
+		if window == nil {
+			return 0, false
+		}
+		label, ok := pushedLabel(window[0])
+		return label, ok && isJump(window[1])
+	}
+	return 0, false
+}
+
+// instructions after which the next instruction is never run
+var terminators = map[string]bool{
+	"STOP": true, "JUMP": true, "RETURN": true, "REVERT": true,
+	"INVALID": true, "SELFDESTRUCT": true,
+}
+
+// removeDeadCode removes code which follows a terminating instruction and
+// which no jump can reach, as well as jump destinations which are never
+// pushed
+func removeDeadCode(code [][]vmgen.Command) bool {
+	pushedLabels := make(map[int]bool)
+	for _, section := range code {
+		for _, c := range section {
+			if label, ok := pushedLabel(c); ok {
+				pushedLabels[label] = true
+			}
+		}
+	}
+	changed := false
+	for s, section := range code {
+		var out []vmgen.Command
+		reachable := true
+		for _, c := range section {
+			switch {
+			case isSource(c), c.IsMarker && c.Mnemonic == "LABEL":
+				out = append(out, c)
+				continue
+			case c.IsMarker && c.Mnemonic == "JUMPDEST":
+				if pushedLabels[c.Offset] {
+					reachable = true
+					out = append(out, c)
+				} else {
+					changed = true
+				}
+				continue
+			}
+			if !reachable {
+				changed = true
+				continue
+			}
+			out = append(out, c)
+			if terminators[c.Mnemonic] {
+				reachable = false
+			}
+		}
+		code[s] = out
+	}
+	return changed
+}
@@ -0,0 +1,364 @@
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
+	"github.com/end-r/guardian/vm/evm/interpreter"
+	"github.com/end-r/vmgen"
+)
+
+func optimizeHex(t *testing.T, level OptimizationLevel, code vmgen.Bytecode) string {
+	return assembleHex(t, Optimize(level, code)[0])
+}
+
+func TestOptimizeNothing(t *testing.T) {
+	var code vmgen.Bytecode
+	code.Add("PUSH1", 1)
+	code.Add("POP")
+	h := optimizeHex(t, NoOptimization, code)
+	goutil.Assert(t, h == "600150", h)
+}
+
+func TestOptimizePushPop(t *testing.T) {
+	var code vmgen.Bytecode
+	code.Add("CALLER")
+	code.Add("PUSH1", 1)
+	code.Add("POP")
+	code.Add("DUP1")
+	code.Add("POP")
+	code.Add("STOP")
+	h := optimizeHex(t, OptimizePeephole, code)
+	goutil.Assert(t, h == "3300", h)
+}
+
+func TestOptimizePopResult(t *testing.T) {
+	var code vmgen.Bytecode
+	code.Add("CALLER")
+	code.Add("CALLVALUE")
+	code.Add("ADD")
+	code.Add("POP")
+	h := optimizeHex(t, OptimizePeephole, code)
+	goutil.Assert(t, h == "", h)
+}
+
+func TestOptimizeSwaps(t *testing.T) {
+	var code vmgen.Bytecode
+	code.Add("CALLER")
+	code.Add("CALLVALUE")
+	code.Add("SWAP1")
+	code.Add("SWAP1")
+	code.Add("SWAP1")
+	code.Add("ADD")
+	h := optimizeHex(t, OptimizePeephole, code)
+	goutil.Assert(t, h == "333401", h)
+}
+
+func TestOptimizeStoreLoad(t *testing.T) {
+	var code vmgen.Bytecode
+	code.Add("CALLER")
+	code.Add("PUSH1", 0x80)
+	code.Add("MSTORE")
+	code.Add("PUSH1", 0x80)
+	code.Add("MLOAD")
+	code.Add("PUSH1", 0)
+	code.Add("SLOAD")
+	code.Add("PUSH1", 0)
+	code.Add("SLOAD")
+	h := optimizeHex(t, OptimizePeephole, code)
+	goutil.Assert(t, h == "338060805260005480", h)
+}
+
+func TestOptimizeConstants(t *testing.T) {
+	var code vmgen.Bytecode
+	code.Add("PUSH1", 3)
+	code.Add("PUSH1", 4)
+	code.Add("PUSH1", 2)
+	code.Add("MUL")
+	code.Add("ADD")
+	h := optimizeHex(t, OptimizeConstants, code)
+	goutil.Assert(t, h == "600b", h)
+	// constants aren't folded at lower levels
+	h = optimizeHex(t, OptimizePeephole, code)
+	goutil.Assert(t, h == "6003600460020201", h)
+}
+
+func TestOptimizeConstantsSize(t *testing.T) {
+	// zero minus one takes up a whole word
+	var code vmgen.Bytecode
+	code.Add("PUSH1", 1)
+	code.Add("PUSH1", 0)
+	code.Add("SUB")
+	h := optimizeHex(t, MaxOptimization, code)
+	goutil.Assert(t, h == "6001600003", h)
+}
+
+func TestOptimizeIdentities(t *testing.T) {
+	var code vmgen.Bytecode
+	code.Add("CALLER")
+	code.Add("PUSH1", 0)
+	code.Add("ADD")
+	code.Add("PUSH1", 1)
+	code.Add("MUL")
+	code.Add("PUSH1", 0)
+	code.Add("SHR")
+	h := optimizeHex(t, OptimizeConstants, code)
+	goutil.Assert(t, h == "33", h)
+}
+
+// evaluateSequence runs code as creation code and returns the word it leaves
+// on top of the stack
+func evaluateSequence(t *testing.T, code vmgen.Bytecode) []byte {
+	code.Add("PUSH1", 0)
+	code.Add("MSTORE")
+	code.Add("PUSH1", 0x20)
+	code.Add("PUSH1", 0)
+	code.Add("RETURN")
+	raw, errs := NewVM().Assemble(code)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	chain := interpreter.New()
+	a, r := chain.Deploy(account, raw, nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	return chain.Code(a)
+}
+
+func TestOptimizeConstantsExecution(t *testing.T) {
+	operands := []*big.Int{
+		big.NewInt(0), big.NewInt(1), big.NewInt(3), big.NewInt(31), big.NewInt(0xff00),
+		new(big.Int).Sub(new(big.Int).Lsh(one, 256), one),
+	}
+	for _, op := range []string{"ADD", "SUB", "MUL", "DIV", "MOD", "LT", "GT", "EQ", "AND", "OR", "XOR", "BYTE", "SHL", "SHR"} {
+		for _, a := range operands {
+			for _, b := range operands {
+				var code vmgen.Bytecode
+				code.Commands = append(code.Commands, pushValue(a), pushValue(b))
+				code.Add(op)
+				expected := evaluateSequence(t, code)
+				optimized := Optimize(MaxOptimization, code)[0]
+				actual := evaluateSequence(t, optimized)
+				goutil.Assert(t, bytes.Equal(expected, actual), fmt.Sprintf("%s %s %s: %x != %x", a, b, op, expected, actual))
+			}
+		}
+	}
+}
+
+func TestOptimizeJumpToNext(t *testing.T) {
+	e := NewVM()
+	l := e.newLabel()
+	var code vmgen.Bytecode
+	code.Concat(pushLabel(l))
+	code.Add("JUMP")
+	code.Concat(jumpdest(l))
+	code.Add("STOP")
+	h := optimizeHex(t, OptimizeJumps, code)
+	goutil.Assert(t, h == "5b00", h)
+	// the destination is no longer needed
+	h = optimizeHex(t, OptimizeDeadCode, code)
+	goutil.Assert(t, h == "00", h)
+}
+
+func TestOptimizeThreadJumps(t *testing.T) {
+	e := NewVM()
+	first, second := e.newLabel(), e.newLabel()
+	var code vmgen.Bytecode
+	code.Add("CALLVALUE")
+	code.Concat(pushLabel(first))
+	code.Add("JUMPI")
+	code.Add("STOP")
+	code.Concat(jumpdest(first))
+	code.Concat(pushLabel(second))
+	code.Add("JUMP")
+	code.Concat(jumpdest(second))
+	code.Add("INVALID")
+	h := optimizeHex(t, MaxOptimization, code)
+	goutil.Assert(t, h == "3461000657005bfe", h)
+}
+
+func TestOptimizeConditionalJumpOverJump(t *testing.T) {
+	e := NewVM()
+	over, target := e.newLabel(), e.newLabel()
+	var code vmgen.Bytecode
+	code.Add("CALLVALUE")
+	code.Concat(pushLabel(over))
+	code.Add("JUMPI")
+	code.Concat(pushLabel(target))
+	code.Add("JUMP")
+	code.Concat(jumpdest(over))
+	code.Add("STOP")
+	code.Concat(jumpdest(target))
+	code.Add("INVALID")
+	h := optimizeHex(t, MaxOptimization, code)
+	goutil.Assert(t, h == "341561000757005bfe", h)
+}
+
+func TestOptimizeConstantConditions(t *testing.T) {
+	e := NewVM()
+	l := e.newLabel()
+	var code vmgen.Bytecode
+	code.Add("PUSH1", 0)
+	code.Concat(pushLabel(l))
+	code.Add("JUMPI")
+	code.Add("STOP")
+	code.Concat(jumpdest(l))
+	code.Add("INVALID")
+	// the jump is never taken, so its destination can't be reached
+	h := optimizeHex(t, MaxOptimization, code)
+	goutil.Assert(t, h == "00", h)
+}
+
+func TestOptimizeDeadCode(t *testing.T) {
+	e := NewVM()
+	used, unused, end := e.newLabel(), e.newLabel(), e.newLabel()
+	var code vmgen.Bytecode
+	code.Add("CALLVALUE")
+	code.Concat(pushLabel(used))
+	code.Add("JUMPI")
+	code.Add("STOP")
+	code.Add("CALLER")
+	code.Concat(jumpdest(unused))
+	code.Add("ADDRESS")
+	code.Add("STOP")
+	code.Concat(jumpdest(used))
+	code.Add("INVALID")
+	code.Concat(codeLabel(end))
+	code.Concat(pushLabel(end))
+	h := optimizeHex(t, MaxOptimization, code)
+	goutil.Assert(t, h == "3461000657005bfe", h)
+}
+
+func TestOptimizeAcrossSections(t *testing.T) {
+	e := NewVM()
+	l := e.newLabel()
+	var creation, runtime vmgen.Bytecode
+	creation.Concat(pushLabel(l))
+	creation.Add("STOP")
+	runtime.Add("STOP")
+	runtime.Concat(jumpdest(l))
+	runtime.Add("STOP")
+	// the destination is pushed by the other section
+	optimized := Optimize(MaxOptimization, creation, runtime)
+	h := assembleHex(t, optimized[1])
+	goutil.Assert(t, h == "005b00", h)
+}
+
+func TestOptimizeIgnoresSources(t *testing.T) {
+	var plain, annotated vmgen.Bytecode
+	for i, m := range []string{"CALLER", "PUSH1", "POP", "SWAP1", "SWAP1", "PUSH1", "PUSH1", "ADD", "ADD"} {
+		var data []byte
+		if m == "PUSH1" {
+			data = []byte{byte(i)}
+		}
+		plain.Add(m, data...)
+		annotated.AddMarker("SOURCE", i)
+		annotated.Add(m, data...)
+	}
+	a := optimizeHex(t, MaxOptimization, plain)
+	b := optimizeHex(t, MaxOptimization, annotated)
+	goutil.Assert(t, a == b, fmt.Sprintf("%s != %s", a, b))
+	goutil.Assert(t, a == "33600b01", a)
+}
+
+const optimizerContract = `
+	contract Counter {
+		var count uint
+		event Set(n uint)
+
+		external func get() uint {
+			return count
+		}
+
+		external func add(a, b uint) uint {
+			return a + b
+		}
+
+		external func set(n uint) {
+			count = n
+			Set(n)
+		}
+
+		external func check(a uint) uint {
+			require(a == 5)
+			if a == 5 {
+				count = 7
+			} else {
+				count = 8
+			}
+			return a
+		}
+
+		external func loop(n uint) {
+			for i = 0; i < n; i++ {
+				count = count + i
+			}
+		}
+
+		external func choose(n uint) uint {
+			switch n {
+			case 1:
+				return 10
+			case 2:
+				return 20
+			}
+			return 3 + 4 * 2
+		}
+	}
+`
+
+// deployOptimized deploys a contract compiled at an optimization level, and
+// returns the size of its code
+func deployOptimized(t *testing.T, level OptimizationLevel, name, text string) (*interpreter.EVM, interpreter.Address, int) {
+	e := NewVM().Optimized(int(level)).(GuardianEVM)
+	scope, errs := validator.ValidateString(e, text)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c, ok := scope.GetDeclaration(name).(*ast.ContractDeclarationNode)
+	goutil.AssertNow(t, ok, "contract not found")
+	code, errs := e.Compile(c, false)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	chain := interpreter.New()
+	a, r := chain.Deploy(account, code, nil)
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	return chain, a, len(code)
+}
+
+func TestOptimizeExecution(t *testing.T) {
+	calls := []struct {
+		signature string
+		args      [][]byte
+	}{
+		{"get()", nil},
+		{"add(uint256,uint256)", [][]byte{uintWord(4), uintWord(5)}},
+		{"set(uint256)", [][]byte{uintWord(9)}},
+		{"get()", nil},
+		{"check(uint256)", [][]byte{uintWord(5)}},
+		{"check(uint256)", [][]byte{uintWord(4)}},
+		{"loop(uint256)", [][]byte{uintWord(4)}},
+		{"choose(uint256)", [][]byte{uintWord(1)}},
+		{"choose(uint256)", [][]byte{uintWord(2)}},
+		{"choose(uint256)", [][]byte{uintWord(3)}},
+		{"missing()", nil},
+	}
+	for level := OptimizePeephole; level <= MaxOptimization; level++ {
+		plain, plainAddress, plainSize := deployOptimized(t, NoOptimization, "Counter", optimizerContract)
+		chain, a, size := deployOptimized(t, level, "Counter", optimizerContract)
+		goutil.Assert(t, size < plainSize, fmt.Sprintf("level %d: %d bytes, not less than %d", level, size, plainSize))
+		for _, c := range calls {
+			expected := send(plain, plainAddress, c.signature, c.args...)
+			actual := send(chain, a, c.signature, c.args...)
+			name := fmt.Sprintf("level %d: %s", level, c.signature)
+			goutil.AssertNow(t, expected.Reverted() == actual.Reverted(), name+": wrong status")
+			goutil.Assert(t, bytes.Equal(expected.Output, actual.Output), fmt.Sprintf("%s: %x != %x", name, expected.Output, actual.Output))
+			goutil.Assert(t, actual.GasUsed <= expected.GasUsed, fmt.Sprintf("%s: used %d gas, not %d", name, actual.GasUsed, expected.GasUsed))
+			goutil.AssertNow(t, len(expected.Logs) == len(actual.Logs), name+": wrong log count")
+			for i, l := range expected.Logs {
+				goutil.Assert(t, bytes.Equal(l.Data, actual.Logs[i].Data), name+": wrong log data")
+			}
+			slot := big.NewInt(0)
+			goutil.Assert(t, plain.Storage(plainAddress, slot).Cmp(chain.Storage(a, slot)) == 0, name+": wrong storage")
+		}
+	}
+}
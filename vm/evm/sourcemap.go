@@ -0,0 +1,131 @@
+package evm
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/util"
+)
+
+// source maps follow the compressed format used by solc: each instruction is
+// described by s:l:f:j, separated by semicolons, where s is the byte offset
+// of its source in file f, l is the length of the source, and j is whether
+// it jumps into (i) or out of (o) a function or is a regular jump (-)
+// fields which are the same as those of the previous instruction are left
+// empty, and trailing empty fields are dropped
+// instructions which were not generated from any source have the file -1
+
+// SourceMaps map each instruction of the creation and runtime code of a
+// contract to its source
+type SourceMaps struct {
+	// the source files, indexed by the file of each instruction
+	Sources  []string `json:"sources"`
+	Creation string   `json:"srcmap"`
+	Runtime  string   `json:"srcmap-runtime"`
+}
+
+// SourceMap returns the JSON source maps of a validated contract
+func (evm GuardianEVM) SourceMap(n *ast.ContractDeclarationNode) ([]byte, error) {
+	maps, errs := evm.ContractSourceMaps(n)
+	if errs != nil {
+		return nil, errors.New(errs.Format())
+	}
+	return json.Marshal(maps)
+}
+
+// ContractSourceMaps maps the code generated for a validated contract to the
+// source of each statement, function, constructor and field initialiser
+func (evm GuardianEVM) ContractSourceMaps(n *ast.ContractDeclarationNode) (SourceMaps, util.Errors) {
+	evm.annotating = true
+	a, errs := evm.Deploy(n)
+	if errs != nil {
+		return SourceMaps{}, errs
+	}
+	sections, errs := assemble(a.Creation, a.Runtime)
+	if errs != nil {
+		return SourceMaps{}, errs
+	}
+	maps := SourceMaps{Sources: make([]string, 0)}
+	files := make(map[string]int)
+	maps.Creation = compressSourceMap(sourceMapEntries(sections[0], a.sources, &maps.Sources, files))
+	maps.Runtime = compressSourceMap(sourceMapEntries(sections[1], a.sources, &maps.Sources, files))
+	return maps, nil
+}
+
+type sourceMapEntry struct {
+	start, length, file int
+	jump                string
+}
+
+var jumpTypes = map[int]string{
+	regularJump:       "-",
+	jumpIntoFunction:  "i",
+	jumpOutOfFunction: "o",
+}
+
+// sourceMapEntries describes the source of each instruction in a section,
+// adding each file to the sources the first time it is used
+func sourceMapEntries(a assembly, nodes []ast.Node, sources *[]string, files map[string]int) []sourceMapEntry {
+	var entries []sourceMapEntry
+	marks := a.sources
+	current := -1
+	for _, o := range Disassemble(a.code) {
+		for len(marks) > 0 && marks[0].offset <= o.Offset {
+			current = marks[0].source
+			marks = marks[1:]
+		}
+		entry := sourceMapEntry{start: -1, length: -1, file: -1, jump: jumpTypes[regularJump]}
+		if current >= 0 {
+			begin, final := nodes[current].Start(), nodes[current].End()
+			file, ok := files[begin.Filename]
+			if !ok {
+				file = len(*sources)
+				files[begin.Filename] = file
+				*sources = append(*sources, begin.Filename)
+			}
+			entry.start = int(begin.Offset)
+			entry.length = int(final.Offset) - int(begin.Offset)
+			entry.file = file
+		}
+		if kind, ok := a.jumps[o.Offset]; ok {
+			entry.jump = jumpTypes[kind]
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func compressSourceMap(entries []sourceMapEntry) string {
+	items := make([]string, len(entries))
+	for i, entry := range entries {
+		fields := []string{
+			strconv.Itoa(entry.start),
+			strconv.Itoa(entry.length),
+			strconv.Itoa(entry.file),
+			entry.jump,
+		}
+		if i > 0 {
+			previous := entries[i-1]
+			if entry.start == previous.start {
+				fields[0] = ""
+			}
+			if entry.length == previous.length {
+				fields[1] = ""
+			}
+			if entry.file == previous.file {
+				fields[2] = ""
+			}
+			if entry.jump == previous.jump {
+				fields[3] = ""
+			}
+		}
+		for len(fields) > 0 && fields[len(fields)-1] == "" {
+			fields = fields[:len(fields)-1]
+		}
+		items[i] = strings.Join(fields, ":")
+	}
+	return strings.Join(items, ";")
+}
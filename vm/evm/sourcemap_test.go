@@ -0,0 +1,127 @@
+package evm
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
+)
+
+func sourceMaps(t *testing.T, name, text string) SourceMaps {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, text)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c, ok := scope.GetDeclaration(name).(*ast.ContractDeclarationNode)
+	goutil.AssertNow(t, ok, "contract not found")
+	maps, errs := e.ContractSourceMaps(c)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	return maps
+}
+
+// decompress expands each entry of a compressed source map
+func decompress(t *testing.T, m string) []sourceMapEntry {
+	var entries []sourceMapEntry
+	var previous sourceMapEntry
+	for _, item := range strings.Split(m, ";") {
+		entry := previous
+		for i, field := range strings.Split(item, ":") {
+			if field == "" {
+				continue
+			}
+			if i == 3 {
+				entry.jump = field
+				continue
+			}
+			v, err := strconv.Atoi(field)
+			goutil.AssertNow(t, err == nil, "invalid field "+field)
+			switch i {
+			case 0:
+				entry.start = v
+			case 1:
+				entry.length = v
+			case 2:
+				entry.file = v
+			}
+		}
+		entries = append(entries, entry)
+		previous = entry
+	}
+	return entries
+}
+
+func TestCompressSourceMap(t *testing.T) {
+	m := compressSourceMap([]sourceMapEntry{
+		{start: -1, length: -1, file: -1, jump: "-"},
+		{start: 10, length: 5, file: 0, jump: "-"},
+		{start: 10, length: 5, file: 0, jump: "-"},
+		{start: 12, length: 5, file: 0, jump: "i"},
+		{start: 12, length: 3, file: 0, jump: "-"},
+	})
+	goutil.Assert(t, m == "-1:-1:-1:-;10:5:0;;12:::i;:3::-", m)
+}
+
+func TestSourceMapStatements(t *testing.T) {
+	text := `contract Counter {
+	var count uint
+	external func increment() {
+		count = count + 1
+	}
+	external func get() uint {
+		return count
+	}
+}`
+	maps := sourceMaps(t, "Counter", text)
+	goutil.AssertNow(t, len(maps.Sources) == 1 && maps.Sources[0] == "input", "wrong sources")
+	raw, errs := NewVM().Assemble(deploy(t, "Counter", text).Runtime)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	entries := decompress(t, maps.Runtime)
+	goutil.AssertNow(t, len(entries) == len(Disassemble(raw)), "every instruction should be mapped")
+	mapped := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.file == 0 {
+			mapped[text[entry.start:entry.start+entry.length]] = true
+		}
+	}
+	goutil.Assert(t, mapped["count = count + 1"], "assignment should be mapped")
+	goutil.Assert(t, mapped["return count"], "return should be mapped")
+	goutil.Assert(t, entries[0].file == -1, "the dispatcher has no source")
+}
+
+func TestSourceMapFunctionJumps(t *testing.T) {
+	maps := sourceMaps(t, "Counter", `
+		contract Counter {
+			var count uint
+			global func get() uint {
+				return count
+			}
+		}
+	`)
+	jumps := make(map[string]int)
+	for _, entry := range decompress(t, maps.Runtime) {
+		jumps[entry.jump]++
+	}
+	goutil.Assert(t, jumps["i"] == 1, "the global entry should jump into the function")
+	goutil.Assert(t, jumps["o"] == 1, "the function should jump out to its caller")
+}
+
+func TestSourceMapJSON(t *testing.T) {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, `
+		contract Counter {
+			var count = 1
+		}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	data, err := e.SourceMap(scope.GetDeclaration("Counter").(*ast.ContractDeclarationNode))
+	goutil.AssertNow(t, err == nil, "source map should be generated")
+	var m map[string]interface{}
+	goutil.AssertNow(t, json.Unmarshal(data, &m) == nil, string(data))
+	for _, key := range []string{"sources", "srcmap", "srcmap-runtime"} {
+		_, ok := m[key]
+		goutil.Assert(t, ok, "missing "+key)
+	}
+}
@@ -1,6 +1,7 @@
 package evm
 
 import (
+	"github.com/end-r/guardian/token"
 	"github.com/end-r/guardian/typing"
 
 	"github.com/end-r/vmgen"
@@ -8,58 +9,57 @@ import (
 	"github.com/end-r/guardian/ast"
 )
 
+// switch statements test each case expression in turn, and jump to the body
+// of the first case which matches
+// cases without expressions are run if no other case matches
 func (e *GuardianEVM) traverseSwitchStatement(n *ast.SwitchStatementNode) (code vmgen.Bytecode) {
-	// always traverse the target
-	var generatedExpressions []vmgen.Bytecode
-	if n.Target != nil {
-		e.traverse(n.Target)
+	var cases []*ast.CaseStatementNode
+	if n.Cases != nil {
 		for _, c := range n.Cases.Sequence {
-			switch cas := c.(type) {
-			case *ast.CaseStatementNode:
-				for _, exp := range cas.Expressions {
-					generatedExpressions = append(generatedExpressions, e.traverseExpression(exp))
-				}
-				break
+			if cas, ok := c.(*ast.CaseStatementNode); ok {
+				cases = append(cases, cas)
 			}
 		}
-		sum := 0
-		for _, exp := range generatedExpressions {
-			sum += 1 // for duplicating the top of the stack
-			sum += exp.Length()
-			sum += 3 // for EQ, PUSH, JUMP
-		}
-		for _, exp := range generatedExpressions {
-			code.Concat(exp)
-			code.Concat(pushMarker(sum))
-			code.Add("EQ")
-			code.Add("JUMPI")
+	}
+	end := e.newLabel()
+	labels := make([]int, len(cases))
+	fallback := end
+	if n.Target != nil {
+		// the target stays on the stack until a case is chosen
+		code.Concat(e.traverseExpression(n.Target))
+	}
+	for i, cas := range cases {
+		labels[i] = e.newLabel()
+		if len(cas.Expressions) == 0 {
+			fallback = labels[i]
 		}
-	} else {
-		for _, c := range n.Cases.Sequence {
-			switch cas := c.(type) {
-			case *ast.CaseStatementNode:
-				for _, exp := range cas.Expressions {
-					generatedExpressions = append(generatedExpressions, e.traverseExpression(exp))
-				}
-				break
+		for _, exp := range cas.Expressions {
+			if n.Target != nil {
+				code.Add("DUP1")
+				code.Concat(e.traverseExpression(exp))
+				code.Add("EQ")
+			} else {
+				code.Concat(e.traverseExpression(exp))
 			}
-		}
-		sum := 0
-		for _, exp := range generatedExpressions {
-			sum += 1 // for duplicating the top of the stack
-			sum += exp.Length()
-			sum += 3 // for EQ, PUSH, JUMP
-		}
-		for _, exp := range generatedExpressions {
-			code.Concat(exp)
-			code.Concat(pushMarker(sum))
+			code.Concat(pushLabel(labels[i]))
 			code.Add("JUMPI")
 		}
 	}
-
-	// switch statements are implicitly converted to if statements
-	// may be a better way to do this
-	// Solidity doesn't have a switch so shrug
+	if n.Target != nil {
+		code.Add("POP")
+	}
+	code.Concat(pushLabel(fallback))
+	code.Add("JUMP")
+	for i, cas := range cases {
+		code.Concat(jumpdest(labels[i]))
+		if n.Target != nil && len(cas.Expressions) > 0 {
+			code.Add("POP")
+		}
+		code.Concat(e.traverseScope(cas.Block))
+		code.Concat(pushLabel(end))
+		code.Add("JUMP")
+	}
+	code.Concat(jumpdest(end))
 	return code
 }
 
@@ -68,82 +68,78 @@ func (e *GuardianEVM) traverseCaseStatement(n *ast.CaseStatementNode) (code vmge
 }
 
 func (e *GuardianEVM) traverseForStatement(n *ast.ForStatementNode) (code vmgen.Bytecode) {
+	top, end := e.newLabel(), e.newLabel()
 
-	// init statement
-	// jumpdest
-	// condition
-	// jump to end
-	// regular loop processes would occur here
-	// post statement
-	// jump back to the top of the loop
-	// jumpdest
-	// continue after the loop
-
-	init := e.traverse(n.Init)
-
-	cond := e.traverseExpression(n.Cond)
-
-	block := e.traverseScope(n.Block)
-
-	post := e.traverse(n.Post)
-
-	code.Concat(init)
-	code.Add("JUMPDEST")
-	top := code.Length()
-	code.Concat(cond)
-	code.Concat(pushMarker(block.Length() + post.Length() + 1))
-	code.Add("JUMPI")
-	code.Concat(block)
-	code.Concat(post)
-	bottom := code.Length()
-	code.Concat(pushMarker(bottom - top))
-	code.Add("JUMP")
-	code.Add("JUMPDEST")
+	// variables declared by the loop are scoped to it
+	e.enterScope()
+	defer e.exitScope()
 
+	if n.Init != nil {
+		code.Concat(e.traverse(n.Init))
+	}
+	code.Concat(jumpdest(top))
+	if n.Cond != nil {
+		// leave the loop once the condition fails
+		code.Concat(e.traverseExpression(n.Cond))
+		code.Add("ISZERO")
+		code.Concat(pushLabel(end))
+		code.Add("JUMPI")
+	}
+	code.Concat(e.traverseScope(n.Block))
+	if n.Post != nil {
+		code.Concat(e.traverse(n.Post))
+	}
+	code.Concat(pushLabel(top))
+	code.Add("JUMP")
+	code.Concat(jumpdest(end))
 	return code
 }
 
-func (evm *GuardianEVM) increment(varName string) (code vmgen.Bytecode) {
-	code.Concat(push([]byte(varName)))
-	code.Add("DUP1")
-	code.Add("MLOAD")
-	code.Concat(push(uintAsBytes(1)))
+// increment adds one to the word held in a memory block
+func increment(block *memoryBlock) (code vmgen.Bytecode) {
+	code.Concat(block.retrieve())
+	code.Concat(push(encodeUint(1)))
 	code.Add("ADD")
-	code.Add("MSTORE")
+	code.Concat(block.store())
 	return code
 }
 
-func (evm *GuardianEVM) traverseForEachStatement(n *ast.ForEachStatementNode) (code vmgen.Bytecode) {
-	// starting from index 0
-	// same for
-	// allocate memory for the index
+func (e *GuardianEVM) traverseForEachStatement(n *ast.ForEachStatementNode) (code vmgen.Bytecode) {
 	// NOTE:
 	// can potentially support dmaps by encoding a backing array as well
 	// would be more expensive - add a keyword?
 
-	switch n.ResolvedType.(type) {
+	switch a := typing.ResolveUnderlying(n.ResolvedType).(type) {
 	case *typing.Array:
-		// TODO: index size must be large enough for any vars
+		e.enterScope()
+		defer e.exitScope()
+		// the first variable holds the index, counting up from 0
 		name := n.Variables[0]
-		e.allocateMemory(name, 10)
-		memloc := e.lookupMemory(name).offset
-		increment := e.increment(name)
-		block := e.traverseScope(n.Block)
-		code.Concat(push(encodeUint(0)))
+		e.allocateMemory(name, wordSize)
+		index := e.lookupMemory(name)
+		top, end := e.newLabel(), e.newLabel()
 
-		code.Concat(push(encodeUint(memloc)))
-		code.Add("MSTORE")
-		code.Add("JUMPDEST")
-		code.Concat(push(encodeUint(memloc)))
-		code.Add("MLOAD")
+		code.Concat(push(encodeUint(0)))
+		code.Concat(index.store())
+		code.Concat(jumpdest(top))
+		// leave the loop once the index reaches the length
+		if a.Variable {
+			code.Concat(e.traverseExpression(n.Producer))
+			code.Add("MLOAD")
+		} else {
+			code.Concat(push(encodeUint(uint(a.Length))))
+		}
+		code.Concat(index.retrieve())
 		code.Add("LT")
-		code.Concat(pushMarker(1 + increment.Length() + block.Length()))
+		code.Add("ISZERO")
+		code.Concat(pushLabel(end))
 		code.Add("JUMPI")
 
-		code.Concat(block)
-		code.Concat(increment)
-
-		code.Add("JUMPDEST")
+		code.Concat(e.traverseScope(n.Block))
+		code.Concat(increment(index))
+		code.Concat(pushLabel(top))
+		code.Add("JUMP")
+		code.Concat(jumpdest(end))
 		break
 	case *typing.Map:
 		break
@@ -154,10 +150,15 @@ func (evm *GuardianEVM) traverseForEachStatement(n *ast.ForEachStatementNode) (c
 func (e *GuardianEVM) traverseReturnStatement(n *ast.ReturnStatementNode) (code vmgen.Bytecode) {
 	for _, r := range n.Results {
 		// leave each of them on the stack in turn
-		code.Concat(e.traverse(r))
+		code.Concat(e.traverseExpression(r))
+	}
+	if e.returnLabel == 0 {
+		// top of stack should now be return address
+		code.Add("JUMP")
+		return code
 	}
-	// jump back to somewhere
-	// top of stack should now be return address
+	// the function epilogue returns the results to the caller
+	code.Concat(pushLabel(e.returnLabel))
 	code.Add("JUMP")
 	return code
 }
@@ -166,46 +167,96 @@ func (e *GuardianEVM) traverseControlFlowStatement(n *ast.FlowStatementNode) (co
 	return code
 }
 
+// each condition is tested in turn, and the body of the first which holds is
+// run, or the else block if none of them do
 func (e *GuardianEVM) traverseIfStatement(n *ast.IfStatementNode) (code vmgen.Bytecode) {
-	conds := make([]vmgen.Bytecode, len(n.Conditions))
-	blocks := make([]vmgen.Bytecode, len(n.Conditions))
-	end := 0
-
-	for _, c := range n.Conditions {
-		cond := e.traverse(c.Condition)
-		conds = append(conds, cond)
-		body := e.traverseScope(c.Body)
-		blocks = append(blocks, body)
-		end += cond.Length() + body.Length() + 3 + 1
+	e.enterScope()
+	defer e.exitScope()
+	if n.Init != nil {
+		code.Concat(e.traverse(n.Init))
 	}
-
-	for i := range n.Conditions {
-		code.Concat(conds[i])
+	end := e.newLabel()
+	for _, c := range n.Conditions {
+		next := e.newLabel()
+		code.Concat(e.traverseExpression(c.Condition))
 		code.Add("ISZERO")
-		code.Concat(pushMarker(blocks[i].Length() + 1))
+		code.Concat(pushLabel(next))
 		code.Add("JUMPI")
-		code.Concat(blocks[i])
-		code.Concat(pushMarker(end))
+		code.Concat(e.traverseScope(c.Body))
+		code.Concat(pushLabel(end))
+		code.Add("JUMP")
+		code.Concat(jumpdest(next))
 	}
-
 	code.Concat(e.traverseScope(n.Else))
-
+	code.Concat(jumpdest(end))
 	return code
 }
 
 func (e *GuardianEVM) traverseAssignmentStatement(n *ast.AssignmentStatementNode) (code vmgen.Bytecode) {
+	if len(n.Right) == 1 && len(n.Left) > 1 {
+		// the results of a call are assigned from the last, which is on top
+		code.Concat(e.traverseExpression(n.Right[0]))
+		for i := len(n.Left) - 1; i >= 0; i-- {
+			code.Concat(e.storeValue(n.Left[i], e.inStorage))
+		}
+		return code
+	}
 	for i, l := range n.Left {
 		r := n.Right[i]
+		if n.Operator != token.Invalid {
+			// x op= y is assigned as x = x op y
+			r = &ast.BinaryExpressionNode{
+				Begin:    n.Begin,
+				Final:    n.Final,
+				Left:     l,
+				Right:    r,
+				Operator: n.Operator,
+				Resolved: l.ResolvedType(),
+			}
+		}
 		code.Concat(e.assign(l, r, e.inStorage))
 	}
 	return code
 }
 
 func (e *GuardianEVM) assign(l, r ast.ExpressionNode, inStorage bool) (code vmgen.Bytecode) {
+	if e.isStorageAppend(l, r) {
+		// storage arrays are appended to in place
+		code.Concat(e.traverseExpression(r))
+		code.Add("POP")
+		return code
+	}
 	// get the location
 	//code.Concat(e.traverseExpression(l))
 	// do the calculation
 	code.Concat(e.traverseExpression(r))
+	code.Concat(e.storeValue(l, inStorage))
+	return code
+}
+
+// storeValue stores the value on top of the stack in the location of an
+// expression
+func (e *GuardianEVM) storeValue(l ast.ExpressionNode, inStorage bool) (code vmgen.Bytecode) {
+	if i, ok := l.(*ast.IdentifierNode); ok {
+		code.Concat(e.assignIdentifier(i.Name))
+		return code
+	}
+	if location, s, ok := e.locateStorage(l); ok {
+		code.Concat(location)
+		code.Concat(s.store())
+		return code
+	}
+	if i, ok := l.(*ast.IndexExpressionNode); ok {
+		if element, m, ok := e.locateMemory(i); ok {
+			code.Concat(element)
+			if m.bytes {
+				code.Add("MSTORE8")
+			} else {
+				code.Add("MSTORE")
+			}
+			return code
+		}
+	}
 	if inStorage {
 		code.Add("SSTORE")
 	} else {
@@ -213,3 +264,38 @@ func (e *GuardianEVM) assign(l, r ast.ExpressionNode, inStorage bool) (code vmge
 	}
 	return code
 }
+
+// isStorageAppend reports whether an assignment appends to a dynamic array
+// in storage: xs = append(xs, x)
+func (e *GuardianEVM) isStorageAppend(l, r ast.ExpressionNode) bool {
+	call, ok := r.(*ast.CallExpressionNode)
+	if !ok || len(call.Arguments) != 2 {
+		return false
+	}
+	if i, ok := call.Call.(*ast.IdentifierNode); !ok || i.Name != "append" {
+		return false
+	}
+	_, a, ok := e.locateStorage(l)
+	return ok && a.field.Encoding == dynamicArrayEncoding
+}
+
+// assignIdentifier stores the value on top of the stack in a variable
+// parameters and local variables shadow storage, the first assignment to
+// any other name declares a local variable, and assignments to _ are dropped
+func (e *GuardianEVM) assignIdentifier(name string) (code vmgen.Bytecode) {
+	if name == "_" {
+		code.Add("POP")
+		return code
+	}
+	if m := e.lookupMemory(name); m != nil {
+		code.Concat(m.store())
+		return code
+	}
+	if s := e.lookupStorage(name); s != nil {
+		code.Concat(s.store())
+		return code
+	}
+	e.allocateMemory(name, wordSize)
+	code.Concat(e.lookupMemory(name).store())
+	return code
+}
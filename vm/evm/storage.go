@@ -2,7 +2,9 @@ package evm
 
 import (
 	"encoding/binary"
+	"math/big"
 
+	"github.com/end-r/guardian/ast"
 	"github.com/end-r/vmgen"
 )
 
@@ -11,6 +13,10 @@ type storageBlock struct {
 	size   uint
 	offset uint
 	slot   uint
+	// signed integers are sign-extended when they are retrieved
+	signed bool
+	// the layout of fields, which describes the values within them
+	field *StorageField
 }
 
 type memoryBlock struct {
@@ -19,7 +25,18 @@ type memoryBlock struct {
 }
 
 const (
-	wordSize = uint(256)
+	wordSize  = uint(256)
+	wordBytes = wordSize / 8
+)
+
+// the first words of memory are reserved: two words of scratch space, then
+// the free memory pointer, then a zero word
+// memory blocks are allocated after the reserved words, and dynamically
+// sized data is allocated on the heap beyond the last block
+const (
+	scratchSpace      = 0x00
+	freeMemoryPointer = 0x40
+	memoryStart       = 0x80
 )
 
 func (s storageBlock) retrieve() (code vmgen.Bytecode) {
@@ -50,101 +67,226 @@ func (s storageBlock) retrieve() (code vmgen.Bytecode) {
 	} else {
 		// all within 1 slot
 		code.Concat(getByteSectionOfSlot(s.slot, s.offset, s.size))
+		if s.signed {
+			code.Concat(truncate(s.size, true))
+		}
 	}
 	return code
 }
 
+// sections are shifted down to the low-order end of the word
 func getByteSectionOfSlot(slot, start, size uint) (code vmgen.Bytecode) {
-	code.Add("PUSH", uintAsBytes(slot)...)
+	code.Concat(push(encodeUint(slot)))
 	code.Add("SLOAD")
-	mask := ((1 << size) - 1) << start
-	code.Add("PUSH", uintAsBytes(uint(mask))...)
-	code.Add("AND")
+	if start > 0 {
+		code.Concat(push(encodeUint(start)))
+		code.Add("SHR")
+	}
+	if size < wordSize {
+		code.Concat(push(encodeBig(sectionMask(size))))
+		code.Add("AND")
+	}
 	return code
 }
 
+func sectionMask(size uint) *big.Int {
+	mask := new(big.Int).Lsh(big.NewInt(1), size)
+	return mask.Sub(mask, big.NewInt(1))
+}
+
 func uintAsBytes(a uint) []byte {
 	bs := make([]byte, 4)
 	binary.LittleEndian.PutUint32(bs, uint32(a))
 	return bs
 }
 
+// memory blocks hold a single word: either the value itself or, for
+// dynamically sized values, a pointer to the value on the heap
 func (m memoryBlock) retrieve() (code vmgen.Bytecode) {
-	code.Concat(push(uintAsBytes(m.offset)))
-	/*for size := m.size; size > wordSize; size -= wordSize {
-		loc := m.offset + (m.size - size)
-		code.Add("PUSH", uintAsBytes(loc)...)
-		code.Add("MLOAD")
-	}*/
+	code.Concat(push(encodeUint(m.offset)))
+	code.Add("MLOAD")
 	return code
 }
 
+// the value to be stored must be on top of the stack
+// values which share their slot are merged into it, leaving the rest of the
+// slot unchanged
 func (s storageBlock) store() (code vmgen.Bytecode) {
-	code.Concat(push(EncodeName(s.name)))
+	if s.offset == 0 && s.size >= wordSize {
+		code.Concat(push(encodeUint(s.slot)))
+		code.Add("SSTORE")
+		return code
+	}
+	mask := sectionMask(s.size)
+	code.Concat(push(encodeBig(mask)))
+	code.Add("AND")
+	if s.offset > 0 {
+		code.Concat(push(encodeUint(s.offset)))
+		code.Add("SHL")
+	}
+	// clear the section before merging
+	cleared := new(big.Int).Lsh(mask, s.offset)
+	cleared.Xor(cleared, sectionMask(wordSize))
+	code.Concat(push(encodeUint(s.slot)))
+	code.Add("SLOAD")
+	code.Concat(push(encodeBig(cleared)))
+	code.Add("AND")
+	code.Add("OR")
+	code.Concat(push(encodeUint(s.slot)))
 	code.Add("SSTORE")
 	return code
 }
 
+// the value to be stored must be on top of the stack
 func (m memoryBlock) store() (code vmgen.Bytecode) {
-	free := []byte{0x40}
-	code.Add("PUSH", free...)
-	code.Add("PUSH", uintAsBytes(m.offset)...)
+	code.Concat(push(encodeUint(m.offset)))
 	code.Add("MSTORE")
 	return code
 }
 
-func (evm *GuardianEVM) freeMemory(name string) {
-	m, ok := evm.memory[name]
-	if ok {
-		if evm.freedMemory == nil {
-			evm.freedMemory = make([]*memoryBlock, 0)
+// memoryScope holds the blocks of the variables declared in a scope, which
+// shadow those of enclosing scopes
+// when the scope ends its blocks are freed, and later scopes of the same
+// function reuse them
+type memoryScope struct {
+	parent *memoryScope
+	blocks map[string]*memoryBlock
+	// blocks in the order they were declared, so that reuse is deterministic
+	declared []*memoryBlock
+	// the outermost scope of a function, which holds its parameters
+	function bool
+}
+
+// enterScope begins a new scope within the current one
+func (evm *GuardianEVM) enterScope() {
+	evm.memory = &memoryScope{
+		parent: evm.memory,
+		blocks: make(map[string]*memoryBlock),
+	}
+}
+
+// exitScope ends the current scope and frees its blocks
+func (evm *GuardianEVM) exitScope() {
+	if evm.memory == nil {
+		return
+	}
+	evm.freedMemory = append(evm.freedMemory, evm.memory.declared...)
+	evm.memory = evm.memory.parent
+}
+
+// enterFunction begins the scope of a function's parameters
+func (evm *GuardianEVM) enterFunction() {
+	evm.enterScope()
+	evm.memory.function = true
+}
+
+// exitFunction ends the scope of a function
+// the blocks of a function are never reused by another function, which
+// could be called while they are still in use
+func (evm *GuardianEVM) exitFunction() {
+	evm.exitScope()
+	evm.freedMemory = nil
+}
+
+// inFunction reports whether variables are being declared within a function
+func (evm *GuardianEVM) inFunction() bool {
+	for s := evm.memory; s != nil; s = s.parent {
+		if s.function {
+			return true
 		}
-		evm.freedMemory = append(evm.freedMemory, m)
-		evm.memory[name] = nil
 	}
+	return false
+}
+
+// words is the number of words of memory a block of a size occupies
+func words(size uint) uint {
+	w := (size + wordSize - 1) / wordSize
+	if w == 0 {
+		return 1
+	}
+	return w
 }
 
+// allocateMemory declares a block in the current scope, reusing a freed block
+// if one is large enough
 func (evm *GuardianEVM) allocateMemory(name string, size uint) {
 	if evm.memory == nil {
-		evm.memory = make(map[string]*memoryBlock)
-	}
-	// try to use previously reclaimed memory
-	if evm.freedMemory != nil {
-		for i, m := range evm.freedMemory {
-			if m.size >= size {
-				// we can use this block
-				evm.memory[name] = m
-				// remove it from the freed list
-				// TODO: check remove function
-				evm.freedMemory = append(evm.freedMemory[i:], evm.freedMemory[:i]...)
-				return
-			}
+		evm.enterScope()
+	}
+	block := evm.reuseMemory(size)
+	if block == nil {
+		if evm.memoryCursor < memoryStart {
+			evm.memoryCursor = memoryStart
+		}
+		block = &memoryBlock{
+			size:   size,
+			offset: evm.memoryCursor,
 		}
+		// sizes are in bits, but blocks are word-aligned byte offsets
+		evm.memoryCursor += words(size) * wordBytes
 	}
+	evm.memory.blocks[name] = block
+	evm.memory.declared = append(evm.memory.declared, block)
+}
 
-	block := memoryBlock{
-		size:   size,
-		offset: evm.memoryCursor,
+// reuseMemory removes the first freed block which is large enough from the
+// freed list
+func (evm *GuardianEVM) reuseMemory(size uint) *memoryBlock {
+	for i, m := range evm.freedMemory {
+		if words(m.size) >= words(size) {
+			evm.freedMemory = append(evm.freedMemory[:i], evm.freedMemory[i+1:]...)
+			return m
+		}
 	}
-	evm.memoryCursor += size
-	evm.memory[name] = &block
+	return nil
 }
 
+// heapStart is the first byte of memory after every allocated block
+func (evm *GuardianEVM) heapStart() uint {
+	if evm.memoryCursor < memoryStart {
+		return memoryStart
+	}
+	return evm.memoryCursor
+}
+
+// layoutStorage allocates the fields of a contract in the positions given by
+// its storage layout, and continues allocating after them
+func (evm *GuardianEVM) layoutStorage(n *ast.ContractDeclarationNode) {
+	evm.storage = make(map[string]*storageBlock)
+	layout := evm.ContractStorageLayout(n)
+	for i, f := range layout.Storage {
+		t := parseABIType(f.Type)
+		evm.storage[f.Label] = &storageBlock{
+			name:   f.Label,
+			size:   f.Size * 8,
+			offset: f.Offset * 8,
+			slot:   f.Slot,
+			signed: t.kind == abiWord && t.signed,
+			field:  &layout.Storage[i],
+		}
+	}
+	evm.lastSlot, evm.lastOffset = layout.Slots, 0
+}
+
+// allocateStorage packs values into the current slot while they fit
+// values larger than a slot begin a new slot
 func (evm *GuardianEVM) allocateStorage(name string, size uint) {
-	// TODO: check whether there's a way to reduce storage using some weird bin packing algo
-	// with a modified heuristic to reduce the cost of extracting variables using bitshifts
-	// maybe?
 	if evm.storage == nil {
 		evm.storage = make(map[string]*storageBlock)
 	}
+	if evm.lastOffset > 0 && (size > wordSize || evm.lastOffset+size > wordSize) {
+		evm.lastSlot++
+		evm.lastOffset = 0
+	}
 	block := storageBlock{
+		name:   name,
 		size:   size,
 		offset: evm.lastOffset,
 		slot:   evm.lastSlot,
 	}
 	for size > wordSize {
 		size -= wordSize
-		evm.lastSlot += 1
+		evm.lastSlot++
 	}
 	evm.lastOffset += size
 	evm.storage[name] = &block
@@ -157,9 +299,12 @@ func (evm *GuardianEVM) lookupStorage(name string) *storageBlock {
 	return evm.storage[name]
 }
 
+// lookupMemory finds the block of the innermost declaration of a name
 func (evm *GuardianEVM) lookupMemory(name string) *memoryBlock {
-	if evm.memory == nil {
-		return nil
+	for s := evm.memory; s != nil; s = s.parent {
+		if m, ok := s.blocks[name]; ok {
+			return m
+		}
 	}
-	return evm.memory[name]
+	return nil
 }
@@ -0,0 +1,67 @@
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/end-r/goutil"
+)
+
+func TestMemoryScopes(t *testing.T) {
+	var e GuardianEVM
+	e.enterFunction()
+	e.allocateMemory("a", wordSize)
+	outer := e.lookupMemory("a")
+	goutil.AssertNow(t, outer.offset == memoryStart, "blocks should begin after the reserved words")
+	e.enterScope()
+	e.allocateMemory("a", wordSize)
+	inner := e.lookupMemory("a")
+	goutil.Assert(t, inner.offset != outer.offset, "an inner declaration should have its own block")
+	e.exitScope()
+	goutil.Assert(t, e.lookupMemory("a") == outer, "the outer declaration should be visible again")
+	e.enterScope()
+	e.allocateMemory("b", wordSize)
+	goutil.Assert(t, e.lookupMemory("b").offset == inner.offset, "the block of an ended scope should be reused")
+	e.exitScope()
+	e.exitFunction()
+	goutil.Assert(t, e.lookupMemory("a") == nil, "declarations should end with their function")
+	e.enterFunction()
+	e.allocateMemory("a", wordSize)
+	goutil.Assert(t, e.lookupMemory("a").offset == memoryStart+2*wordBytes, "the blocks of another function should not be reused")
+	e.exitFunction()
+	goutil.Assert(t, e.heapStart() == memoryStart+3*wordBytes, "wrong heap start")
+}
+
+func TestMemoryLocals(t *testing.T) {
+	chain, a := execute(t, "Locals", `
+		contract Locals {
+			external func sum(n uint) uint {
+				var total uint
+				var i uint
+				for i = 0; i < n; i++ {
+					var step uint
+					step += i
+					total += step
+				}
+				if total > 0 {
+					var bonus uint
+					bonus += 1
+					total += bonus
+				}
+				return total
+			}
+			external func other(n uint) uint {
+				var total = n * 2
+				return total
+			}
+		}
+	`)
+	// step is reset on every iteration
+	r := send(chain, a, "sum(uint256)", uintWord(4))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(7)), fmt.Sprintf("wrong sum %x", r.Output))
+	r = send(chain, a, "other(uint256)", uintWord(5))
+	goutil.AssertNow(t, r.Err == nil, fmt.Sprint(r.Err))
+	goutil.Assert(t, bytes.Equal(r.Output, uintWord(10)), fmt.Sprintf("wrong result %x", r.Output))
+}
@@ -0,0 +1,168 @@
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"path/filepath"
+
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/util"
+	"github.com/end-r/guardian/vm/evm/interpreter"
+	"github.com/end-r/vmgen"
+)
+
+// tests are run by deploying the contract which declares them, with the test
+// added as an external function, and then calling it
+// in test builds, failed calls to require and assert revert with an
+// Error(string) message holding their location and source
+
+// tester sends every transaction in a test
+var tester = interpreter.BytesToAddress([]byte{0x01})
+
+const errorSignature = "Error(string)"
+
+// RunTest runs a validated test function against a new in-memory chain, and
+// returns why it failed, or nothing if it passed
+// tests declared at the top level of a package, for which contract is nil,
+// are run in a contract of their own, which can call the functions of the
+// package it is given with InPackage
+// failed checks are described with their source, which is read from disk
+// unless it is given in files, keyed by filename
+func (evm GuardianEVM) RunTest(contract *ast.ContractDeclarationNode, test *ast.FuncDeclarationNode, files map[string][]byte) (string, util.Errors) {
+	code, errs := evm.compileTest(contract, test, files)
+	if errs != nil {
+		return "", errs
+	}
+	chain := interpreter.New()
+	a, r := chain.Deploy(tester, code, nil)
+	if r.Err != nil {
+		return "deployment failed: " + describeFailure(r), nil
+	}
+	r = chain.Call(tester, a, Selector(evm.funcSignature(test)), nil)
+	if r.Err != nil {
+		return describeFailure(r), nil
+	}
+	return "", nil
+}
+
+func (evm GuardianEVM) compileTest(contract *ast.ContractDeclarationNode, test *ast.FuncDeclarationNode, files map[string][]byte) ([]byte, util.Errors) {
+	if len(parameters(test)) > 0 {
+		return nil, util.Errors{util.Error{
+			Location: test.Start(),
+			Message:  fmt.Sprintf("Cannot run test %s, as it has parameters", test.Signature.Identifier),
+			Stage:    util.Generation,
+		}}
+	}
+	if contract == nil {
+		contract = &ast.ContractDeclarationNode{
+			Begin:      test.Start(),
+			Final:      test.End(),
+			Identifier: test.Signature.Identifier,
+			Body:       new(ast.ScopeNode),
+		}
+		contract.Body.AddDeclaration(test.Signature.Identifier, test)
+	}
+	if len(constructorParameters(contract)) > 0 {
+		return nil, util.Errors{util.Error{
+			Location: contract.Start(),
+			Message:  fmt.Sprintf("Cannot deploy %s to test it, as its constructor has parameters", contract.Identifier),
+			Stage:    util.Generation,
+		}}
+	}
+	evm.test = test
+	evm.files = newSourceFiles(files)
+	return evm.Compile(contract, false)
+}
+
+// describeFailure returns the message a test reverted with, or the error
+// which ended it
+func describeFailure(r interpreter.Result) string {
+	if r.Reverted() {
+		if reason, ok := revertReason(r.Output); ok {
+			return reason
+		}
+	}
+	return r.Err.Error()
+}
+
+// check reverts with a message describing the current call to require or
+// assert if its condition, which is on top of the stack, does not hold
+func (e *GuardianEVM) check() (code vmgen.Bytecode) {
+	holds := e.newLabel()
+	code.Concat(pushLabel(holds))
+	code.Add("JUMPI")
+	code.Concat(revertWithReason(e.failure(e.expression.(*ast.CallExpressionNode))))
+	code.Concat(jumpdest(holds))
+	return code
+}
+
+// failure describes a failed call in the style of a Go test failure
+func (e *GuardianEVM) failure(n *ast.CallExpressionNode) string {
+	loc := n.Start()
+	text := e.files.text(n)
+	if text == "" {
+		text = "check"
+		if i, ok := n.Call.(*ast.IdentifierNode); ok {
+			text = i.Name
+		}
+	}
+	return fmt.Sprintf("%s:%d: %s failed", filepath.Base(loc.Filename), loc.Line, text)
+}
+
+// revertWithReason reverts with the ABI encoding of Error(reason), which is
+// written to the start of memory
+func revertWithReason(reason string) (code vmgen.Bytecode) {
+	data := encodeRevertReason(reason)
+	for offset := 0; offset < len(data); offset += int(wordBytes) {
+		end := offset + int(wordBytes)
+		if end > len(data) {
+			end = len(data)
+		}
+		word := make([]byte, wordBytes)
+		copy(word, data[offset:end])
+		code.Concat(push(encodeBig(new(big.Int).SetBytes(word))))
+		code.Concat(push(encodeUint(uint(offset))))
+		code.Add("MSTORE")
+	}
+	code.Concat(push(encodeUint(uint(len(data)))))
+	code.Concat(push([]byte{0}))
+	code.Add("REVERT")
+	return code
+}
+
+func encodeRevertReason(reason string) []byte {
+	data := append([]byte(nil), Selector(errorSignature)...)
+	data = append(data, wordOf(int(wordBytes))...)
+	data = append(data, wordOf(len(reason))...)
+	data = append(data, reason...)
+	for (len(data)-selectorSize)%int(wordBytes) != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// revertReason decodes the message of revert data holding Error(string)
+func revertReason(data []byte) (string, bool) {
+	if len(data) < selectorSize || !bytes.Equal(data[:selectorSize], Selector(errorSignature)) {
+		return "", false
+	}
+	data = data[selectorSize:]
+	if len(data) < 2*int(wordBytes) {
+		return "", false
+	}
+	offset := new(big.Int).SetBytes(data[:wordBytes])
+	if !offset.IsUint64() || offset.Uint64()+uint64(wordBytes) > uint64(len(data)) {
+		return "", false
+	}
+	start := offset.Uint64() + uint64(wordBytes)
+	length := new(big.Int).SetBytes(data[start-uint64(wordBytes) : start])
+	if !length.IsUint64() || start+length.Uint64() > uint64(len(data)) {
+		return "", false
+	}
+	return string(data[start : start+length.Uint64()]), true
+}
+
+func wordOf(n int) []byte {
+	return big.NewInt(int64(n)).FillBytes(make([]byte, wordBytes))
+}
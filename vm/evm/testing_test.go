@@ -0,0 +1,141 @@
+package evm
+
+import (
+	"testing"
+
+	"github.com/end-r/goutil"
+	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/validator"
+)
+
+// runTest validates a source file and runs one of its tests, which is
+// declared in a contract unless contract is empty
+func runTest(t *testing.T, text, contract, name string) (string, bool) {
+	e := NewVM()
+	file, errs := validator.ValidateString(e, text)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	scope := file
+	var c *ast.ContractDeclarationNode
+	if contract != "" {
+		c = scope.GetDeclaration(contract).(*ast.ContractDeclarationNode)
+		scope = c.Body
+	}
+	test, ok := scope.GetDeclaration(name).(*ast.FuncDeclarationNode)
+	goutil.AssertNow(t, ok, "test not found")
+	pkg := e.InPackage([]*ast.ScopeNode{file}).(GuardianEVM)
+	failure, errs := pkg.RunTest(c, test, map[string][]byte{"input": []byte(text)})
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	return failure, failure == ""
+}
+
+func TestRunTestPasses(t *testing.T) {
+	failure, passed := runTest(t, `
+		test func Sum() {
+			a = 2
+			b = a + 3
+			assert(b == 5)
+			require(a == 2)
+		}
+	`, "", "Sum")
+	goutil.Assert(t, passed, failure)
+}
+
+func TestRunTestAssertFails(t *testing.T) {
+	failure, passed := runTest(t, `test func Sum() {
+			a = 2
+			assert(a == 3)
+		}
+	`, "", "Sum")
+	goutil.AssertNow(t, !passed, "test should fail")
+	goutil.Assert(t, failure == "input:3: assert(a == 3) failed", failure)
+}
+
+func TestRunTestRequireFails(t *testing.T) {
+	failure, passed := runTest(t, `test func Sum() {
+			require(1 == 2)
+		}
+	`, "", "Sum")
+	goutil.AssertNow(t, !passed, "test should fail")
+	goutil.Assert(t, failure == "input:2: require(1 == 2) failed", failure)
+}
+
+func TestRunTestInContract(t *testing.T) {
+	text := `contract Counter {
+			var count = 3
+
+			test func Initial() {
+				assert(count == 3)
+			}
+
+			test func Changed() {
+				count = 4
+				assert(count == 3)
+			}
+		}
+	`
+	failure, passed := runTest(t, text, "Counter", "Initial")
+	goutil.Assert(t, passed, failure)
+	// each test is run against a new deployment
+	failure, passed = runTest(t, text, "Counter", "Changed")
+	goutil.Assert(t, failure == "input:10: assert(count == 3) failed", failure)
+	failure, passed = runTest(t, text, "Counter", "Initial")
+	goutil.Assert(t, passed, failure)
+}
+
+func TestRunTestConstructorParameters(t *testing.T) {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, `
+		contract Counter {
+			var count uint
+
+			constructor(start uint) {
+				count = start
+			}
+
+			test func Initial() {
+				assert(count == 0)
+			}
+		}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	c := scope.GetDeclaration("Counter").(*ast.ContractDeclarationNode)
+	test := c.Body.GetDeclaration("Initial").(*ast.FuncDeclarationNode)
+	_, errs = e.RunTest(c, test, nil)
+	goutil.AssertLength(t, len(errs), 1)
+}
+
+func TestRunTestPackageFunctions(t *testing.T) {
+	failure, passed := runTest(t, `
+		test func Squares() {
+			assert(square(3) == 9)
+		}
+
+		internal func square(x uint) uint {
+			return x * x
+		}
+	`, "", "Squares")
+	goutil.Assert(t, passed, failure)
+}
+
+func TestRunTestParameters(t *testing.T) {
+	e := NewVM()
+	scope, errs := validator.ValidateString(e, `
+		test func Sum(a uint) {
+			assert(a + 1 > a)
+		}
+	`)
+	goutil.AssertNow(t, errs == nil, errs.Format())
+	test := scope.GetDeclaration("Sum").(*ast.FuncDeclarationNode)
+	_, errs = e.RunTest(nil, test, nil)
+	goutil.AssertLength(t, len(errs), 1)
+}
+
+func TestRevertReason(t *testing.T) {
+	reason, ok := revertReason(encodeRevertReason("counter_test.grd:4: assert(count == 3) failed"))
+	goutil.AssertNow(t, ok, "reason should decode")
+	goutil.Assert(t, reason == "counter_test.grd:4: assert(count == 3) failed", reason)
+	_, ok = revertReason([]byte{0x08, 0xc3, 0x79, 0xa0})
+	goutil.Assert(t, !ok, "truncated data should not decode")
+	_, ok = revertReason(nil)
+	goutil.Assert(t, !ok, "empty data should not decode")
+}
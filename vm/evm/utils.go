@@ -1,25 +1,27 @@
 package evm
 
+import "math/big"
+
+// encodeUint returns the shortest big-endian encoding of i
 func encodeUint(i uint) []byte {
-	return nil
+	bs := []byte{byte(i)}
+	for i >>= 8; i > 0; i >>= 8 {
+		bs = append([]byte{byte(i)}, bs...)
+	}
+	return bs
 }
 
-// EncodeName ...
-func EncodeName(name string) []byte {
-	/*
-		// ethereum uses the 'original keccak' (disbyte of 1 rather than 6)
-		hasher := &state{rate: 136, outputLen: 32, dsbyte: 0x01}
-
-		hasher.Write([]byte(name))
-
-		// get the output hash
-		hash := hasher.Sum(nil)
-		// take the leftmost 4 bytes of the hash
-		limited := hash[:4]
+// encodeBig returns the shortest big-endian encoding of i
+func encodeBig(i *big.Int) []byte {
+	if i.Sign() == 0 {
+		return []byte{0}
+	}
+	return i.Bytes()
+}
 
-		return limited
-	*/
-	return []byte(name)
+// EncodeName returns the leftmost 4 bytes of the hash of a name
+func EncodeName(name string) []byte {
+	return Selector(name)
 }
 
 func hasModifier(mods []string, modifier string) bool {
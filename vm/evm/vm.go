@@ -1,21 +1,28 @@
 package evm
 
 import (
+	_ "embed"
 	"fmt"
 
 	"github.com/end-r/guardian/util"
 
 	"github.com/end-r/guardian/ast"
+	"github.com/end-r/guardian/lexer"
 	"github.com/end-r/guardian/parser"
 	"github.com/end-r/guardian/token"
 	"github.com/end-r/guardian/typing"
 	"github.com/end-r/guardian/validator"
 )
 
+// builtins are compiled into the package so that the VM can be used
+// from outside this directory
+//
+//go:embed builtins.grd
+var builtinSource []byte
+
+// Builtins are parsed afresh each time, as validation resolves types in place
 func (evm GuardianEVM) Builtins() *ast.ScopeNode {
-	if builtinScope == nil {
-		builtinScope, _ = parser.ParseFile("builtins.grd")
-	}
+	builtinScope, _ := parser.Parse(lexer.Lex("builtins.grd", builtinSource))
 	return builtinScope
 }
 
@@ -41,7 +48,7 @@ func resolveIntegerLiteral(v *validator.Validator, data string) typing.Type {
 		// this might be an address
 	}
 	x := typing.BitsNeeded(len(data))
-	return v.SmallestNumericType(x, false)
+	return v.SmallestInteger(x, false)
 }
 
 func resolveFloatLiteral(v *validator.Validator, data string) typing.Type {
@@ -49,7 +56,7 @@ func resolveFloatLiteral(v *validator.Validator, data string) typing.Type {
 	return typing.Unknown()
 }
 
-func (evm *GuardianEVM) Primitives() map[string]typing.Type {
+func (evm GuardianEVM) Primitives() map[string]typing.Type {
 
 	const maxSize = 256
 	m := map[string]typing.Type{}
@@ -69,7 +76,7 @@ func (evm GuardianEVM) ValidDeclarations() []ast.NodeType {
 	return ast.AllDeclarations
 }
 
-func (evm *uardianEVM) ValidExpressions() []ast.NodeType {
+func (evm GuardianEVM) ValidExpressions() []ast.NodeType {
 	return ast.AllExpressions
 }
 
@@ -91,14 +98,21 @@ var mods = []*validator.ModifierGroup{
 		Name:       "Payable",
 		Modifiers:  []string{"payable", "nonpayable"},
 		RequiredOn: []ast.NodeType{},
-		AllowedOn:  []ast.NodeType{ast.FuncDeclaration},
+		AllowedOn:  []ast.NodeType{ast.FuncDeclaration, ast.LifecycleDeclaration},
 		Maximum:    1,
 	},
 	&validator.ModifierGroup{
-		Name:       "Payable",
-		Modifiers:  []string{"payable", "nonpayable"},
+		Name:       "Indexed",
+		Modifiers:  []string{"indexed"},
 		RequiredOn: []ast.NodeType{},
-		AllowedOn:  []ast.NodeType{ast.FuncDeclaration},
+		AllowedOn:  []ast.NodeType{ast.EventDeclaration, ast.ExplicitVarDeclaration},
+		Maximum:    1,
+	},
+	&validator.ModifierGroup{
+		Name:       "Arithmetic",
+		Modifiers:  []string{checkedModifier, uncheckedModifier},
+		RequiredOn: []ast.NodeType{},
+		AllowedOn:  []ast.NodeType{ast.FuncDeclaration, ast.LifecycleDeclaration, ast.ContractDeclaration},
 		Maximum:    1,
 	},
 }
@@ -111,7 +125,7 @@ func (evm GuardianEVM) Annotations() []*typing.Annotation {
 	return nil
 }
 
-func (evm *GuardianEVM) Assignable(val *validator.Validator, left, right typing.Type, fromExpression ast.ExpressionNode) bool {
+func (evm GuardianEVM) Assignable(val *validator.Validator, left, right typing.Type, fromExpression ast.ExpressionNode) bool {
 	t, _ := val.IsTypeVisible("address")
 	if t.Compare(right) {
 		switch left.(type) {
@@ -157,6 +171,11 @@ func (evm GuardianEVM) Castable(val *validator.Validator, to, from typing.Type,
 			}
 		}
 	}
+	// integers can be converted to any other integer type, which truncates
+	// or sign-extends them, but literals must still fit
+	if _, ok := fromExpression.(*ast.LiteralNode); !ok && isInteger(to) && isInteger(from) {
+		return true
+	}
 	if typing.AssignableTo(to, from, false) {
 		return true
 	}